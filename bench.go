@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/prep/pubsubc/provision"
+)
+
+var (
+	benchProject     string
+	benchTopics      int
+	benchSubs        int
+	benchMessages    int
+	benchSize        int
+	benchConcurrency int
+	benchKeep        bool
+)
+
+// benchFlags registers the flags of the bench command.
+func benchFlags(fs *flag.FlagSet) {
+	fs.StringVar(&benchProject, "project", "bench", "Project to create the benchmark topics and subscriptions in")
+	fs.IntVar(&benchTopics, "topic-count", 10, "Number of topics to create")
+	fs.IntVar(&benchSubs, "subscription-count", 1, "Number of subscriptions to create on every topic")
+	fs.IntVar(&benchMessages, "messages", 1000, "Number of messages to publish to every topic")
+	fs.IntVar(&benchSize, "message-size", 100, "Size of every message in bytes")
+	fs.IntVar(&benchConcurrency, "publish-concurrency", 16, "Maximum number of messages to publish concurrently")
+	fs.BoolVar(&benchKeep, "keep", false, "Keep the benchmark topics and subscriptions instead of deleting them afterwards")
+	createFlags(fs)
+}
+
+// runBench creates topics and subscriptions and publishes messages to them,
+// and prints how long that took.
+func runBench(ctx context.Context, _ []provision.Config) error {
+	if err := validateCreateFlags(); err != nil {
+		return err
+	}
+
+	switch {
+	case benchTopics < 1:
+		return fmt.Errorf("-topic-count must be at least 1")
+	case benchSubs < 0:
+		return fmt.Errorf("-subscription-count must not be negative")
+	case benchMessages < 0:
+		return fmt.Errorf("-messages must not be negative")
+	case benchConcurrency < 1:
+		return fmt.Errorf("-publish-concurrency must be at least 1")
+	case check, dryRun, printHealthcheck, watch, randomize:
+		return fmt.Errorf("-check, -dry-run, -print-healthcheck, -watch and -randomize-projects are not supported by bench")
+	}
+
+	project := benchConfig().WithPrefix(prefix)
+
+	clients := newClients()
+	defer closeClients(clients)
+
+	// The benchmark resources are deleted even if the run is cancelled.
+	if !benchKeep {
+		defer func() {
+			if err := deleteProjects(context.WithoutCancel(ctx), clients, []provision.Config{project}); err != nil {
+				warnf("%s", err)
+			}
+		}()
+	}
+
+	start := time.Now()
+	created, err := createProjects(ctx, clients, []provision.Config{project})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %d resource(s) in %s\n", len(created), time.Since(start).Round(time.Millisecond))
+
+	if benchMessages == 0 {
+		return nil
+	}
+
+	client, err := clients.Client(ctx, project.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	latencies, err := benchPublish(ctx, client, project.Topics)
+	elapsed := time.Since(start)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Published %d message(s) of %d byte(s) in %s, %.0f message(s)/s\n", len(latencies), benchSize, elapsed.Round(time.Millisecond), float64(len(latencies))/elapsed.Seconds())
+	fmt.Printf("Publish latency p50 %s, p90 %s, p99 %s, max %s\n", percentile(latencies, 0.5), percentile(latencies, 0.9), percentile(latencies, 0.99), percentile(latencies, 1))
+
+	return nil
+}
+
+// benchConfig returns the config of the benchmark topics and subscriptions,
+// whose names share a random run suffix so that benchmarks don't collide.
+func benchConfig() provision.Config {
+	run := randomSuffix()
+
+	project := provision.Config{ProjectID: benchProject}
+	for i := 1; i <= benchTopics; i++ {
+		topic := provision.Topic{ID: fmt.Sprintf("bench-%s-topic-%d", run, i)}
+		for j := 1; j <= benchSubs; j++ {
+			topic.Subscriptions = append(topic.Subscriptions, provision.Subscription{ID: fmt.Sprintf("bench-%s-sub-%d-%d", run, i, j)})
+		}
+
+		project.Topics = append(project.Topics, topic)
+	}
+
+	return project
+}
+
+// benchPublish publishes -messages messages to every topic, with at most
+// -publish-concurrency publishes in flight, and returns the latency of every
+// publish in ascending order. The first failed publish stops the benchmark.
+func benchPublish(ctx context.Context, client *pubsub.Client, topics []provision.Topic) ([]time.Duration, error) {
+	data := []byte(strings.Repeat("x", benchSize))
+
+	jobs := make(chan *pubsub.Topic)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		latencies []time.Duration
+		firstErr  error
+	)
+
+	for i := 0; i < benchConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for topic := range jobs {
+				start := time.Now()
+				_, err := topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+				latency := time.Since(start)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("Unable to publish to topic %q: %s", topic.ID(), err)
+					cancel()
+				} else if err == nil {
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var pubTopics []*pubsub.Topic
+	for _, t := range topics {
+		pubTopics = append(pubTopics, client.Topic(t.ID))
+	}
+
+	// The messages are spread over the topics round-robin.
+send:
+	for i := 0; i < benchMessages; i++ {
+		for _, topic := range pubTopics {
+			select {
+			case jobs <- topic:
+			case <-ctx.Done():
+				break send
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, topic := range pubTopics {
+		topic.Stop()
+	}
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return latencies, firstErr
+}
+
+// percentile returns the latency at the specified percentile, as a fraction,
+// of the sorted latencies.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	return latencies[int(p*float64(len(latencies)-1))].Round(time.Microsecond)
+}
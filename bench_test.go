@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// setBenchFlags is like setFlags, for the flags of the bench command.
+func setBenchFlags(t *testing.T, args ...string) {
+	t.Helper()
+
+	setFlags(t)
+
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	benchFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("unable to parse the flags %q: %s", args, err)
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// failPublish makes every publish fail.
+		failPublish bool
+		// want are the patterns of the lines of the output.
+		want []string
+		// kept is the number of resources that are left afterwards, and
+		// published the number of published messages.
+		kept, published int
+		err             string
+	}{
+		{
+			name:      "tiny",
+			args:      []string{"-project", "perf", "-topic-count", "2", "-subscription-count", "2", "-messages", "5", "-message-size", "32"},
+			want:      []string{`^Created 6 resource\(s\) in \S+$`, `^Published 10 message\(s\) of 32 byte\(s\) in \S+, \d+ message\(s\)/s$`, `^Publish latency p50 \S+, p90 \S+, p99 \S+, max \S+$`},
+			published: 10,
+		},
+		{
+			name:      "kept",
+			args:      []string{"-project", "soak", "-topic-count", "3", "-subscription-count", "0", "-messages", "4", "-publish-concurrency", "1", "-keep"},
+			want:      []string{`^Created 3 resource\(s\)`, `^Published 12 message\(s\) of 100 byte\(s\)`, `^Publish latency`},
+			kept:      3,
+			published: 12,
+		},
+		{
+			name: "without messages",
+			args: []string{"-project", "ci-bench", "-topic-count", "1", "-messages", "0", "-keep"},
+			want: []string{`^Created 2 resource\(s\)`},
+			kept: 2,
+		},
+		{
+			name:        "failed publish",
+			args:        []string{"-project", "flaky", "-topic-count", "1", "-messages", "3"},
+			failPublish: true,
+			want:        []string{`^Created 2 resource\(s\)`},
+			err:         "Unable to publish to topic",
+		},
+		{name: "no topics", args: []string{"-topic-count", "0"}, err: "-topic-count must be at least 1"},
+		{name: "no publishers", args: []string{"-publish-concurrency", "0"}, err: "-publish-concurrency must be at least 1"},
+		{name: "dry run", args: []string{"-dry-run"}, err: "not supported by bench"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []pstest.ServerReactorOption
+			if tt.failPublish {
+				opts = append(opts, pstest.ServerReactorOption{FuncName: "Publish", Reactor: reactorFunc(func(req interface{}) (bool, interface{}, error) {
+					if _, ok := req.(*pubsubpb.PublishRequest); ok {
+						return true, nil, status.Error(codes.InvalidArgument, "message rejected")
+					}
+					return false, nil, nil
+				})})
+			}
+
+			srv := newTestServer(t, opts...)
+			setBenchFlags(t, tt.args...)
+
+			var err error
+			out := captureStdout(t, func() { err = runBench(context.Background(), nil) })
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+			if out == "" {
+				lines = nil
+			}
+			if len(lines) != len(tt.want) {
+				t.Fatalf("expected %d line(s), got %q", len(tt.want), out)
+			}
+			for i, pattern := range tt.want {
+				if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+					t.Errorf("expected line %d to match %q, got %q", i+1, pattern, lines[i])
+				}
+			}
+
+			if tt.err == "" {
+				if published := len(srv.Messages()); published != tt.published {
+					t.Errorf("expected %d published message(s), got %d", tt.published, published)
+				}
+			}
+
+			// The benchmark resources are deleted afterwards, unless -keep is
+			// set.
+			clients := &provision.ClientCache{ShareConnection: true}
+			t.Cleanup(func() { clients.Close() })
+
+			live, err := liveProject(context.Background(), clients, benchProject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept int
+			for _, topic := range live.Topics {
+				kept += 1 + len(topic.Subscriptions)
+			}
+			if kept != tt.kept {
+				t.Errorf("expected %d resource(s) to be kept, got %d", tt.kept, kept)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond, 4 * time.Millisecond, 50 * time.Millisecond}
+
+	tests := []struct {
+		latencies []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{latencies: nil, p: 0.5, want: 0},
+		{latencies: latencies[:1], p: 0.99, want: time.Millisecond},
+		{latencies: latencies, p: 0, want: time.Millisecond},
+		{latencies: latencies, p: 0.5, want: 3 * time.Millisecond},
+		{latencies: latencies, p: 0.9, want: 4 * time.Millisecond},
+		{latencies: latencies, p: 1, want: 50 * time.Millisecond},
+		{latencies: []time.Duration{1234567 * time.Nanosecond}, p: 1, want: 1235 * time.Microsecond},
+	}
+
+	for _, tt := range tests {
+		if got := percentile(tt.latencies, tt.p); got != tt.want {
+			t.Errorf("%v at %.2f: expected %s, got %s", tt.latencies, tt.p, tt.want, got)
+		}
+	}
+}
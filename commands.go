@@ -0,0 +1,710 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/uuid"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// command describes a pubsubc subcommand.
+type command struct {
+	name        string
+	description string
+
+	// projects is true if the command operates on the projects defined by
+	// the PUBSUB_PROJECT environment variables.
+	projects bool
+
+	// flags registers the flags of the command.
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, projects []provision.Config) error
+}
+
+var commands = []*command{
+	{name: "create", description: "Create the configured topics and subscriptions (default)", projects: true, flags: createFlags, run: runCreate},
+	{name: "delete", description: "Delete the configured topics and subscriptions", projects: true, flags: deleteFlags, run: runDelete},
+	{name: "reset", description: "Delete and recreate the configured topics and subscriptions", projects: true, flags: createFlags, run: runReset},
+	{name: "list", description: "List the topics and subscriptions of the configured projects", projects: true, run: runList},
+	{name: "export", description: "Print the live state of the configured projects as PUBSUB_PROJECT variables", projects: true, run: runExport},
+	{name: "serve", description: "Run an HTTP server that creates projects on request", flags: serveFlags, run: runServe},
+	{name: "bench", description: "Create topics and subscriptions and publish messages to them, and report the throughput and latency", flags: benchFlags, run: runBench},
+}
+
+// lookupCommand returns the command with the specified name, or nil if it
+// doesn't exist.
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+// parseCommand returns the command that the arguments start with, and the
+// remaining arguments. Without a command, pubsubc creates the configured
+// projects.
+func parseCommand(args []string) (*command, []string) {
+	if len(args) > 0 {
+		if cmd := lookupCommand(args[0]); cmd != nil {
+			return cmd, args[1:]
+		}
+	}
+
+	return lookupCommand("create"), args
+}
+
+var (
+	backoff             string
+	backoffBase         time.Duration
+	backoffMax          time.Duration
+	check               bool
+	continueOnError     bool
+	createDLQProject    bool
+	dryRun              bool
+	ensure              bool
+	labels              string
+	maxSubscriptions    int
+	maxTopics           int
+	opTimeout           time.Duration
+	outputFile          string
+	planFormat          string
+	postHook            string
+	printHealthcheck    bool
+	projectConcurrency  int
+	prune               bool
+	pruneScope          string
+	quietOnSuccess      bool
+	randomize           bool
+	receiveSettings     pubsub.ReceiveSettings
+	resourceConcurrency int
+	retries             int
+	rollbackOnError     bool
+	runID               string
+	seedConcurrency     int
+	seedErrors          string
+	seedOrphansOnly     bool
+	seedStateFile       string
+	skipExistingSubs    bool
+	skipExistingTopics  bool
+	strict              bool
+	strictFilters       bool
+	summaryOnly         bool
+	topicsMode          string
+	verifyOrder         bool
+	verifyOrderCount    int
+	verifyOrderTimeout  time.Duration
+	verifyRetention     bool
+	watch               bool
+	yes                 bool
+)
+
+// createFlags registers the flags that control how projects are created.
+func createFlags(fs *flag.FlagSet) {
+	fs.StringVar(&backoff, "backoff", "exponential-jitter", "Backoff between the -retries: constant, exponential, or exponential-jitter")
+	fs.DurationVar(&backoffBase, "backoff-base", 250*time.Millisecond, "First backoff between the -retries, which exponential backoffs double up to -backoff-max")
+	fs.DurationVar(&backoffMax, "backoff-max", 5*time.Second, "Maximum backoff between the -retries")
+	fs.BoolVar(&check, "check", false, "Exit with code 2 if a configured topic or subscription is missing or drifted from the config, without changing anything")
+	fs.BoolVar(&continueOnError, "continue-on-error", false, "Keep creating the other topics and subscriptions when one fails, and report the timeouts and other failures at the end")
+	fs.BoolVar(&createDLQProject, "create-dlq-project", false, "Create dead-letter topics in projects that are not configured")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the changes between the config and the live state instead of applying them")
+	fs.BoolVar(&ensure, "ensure", false, "Only create or update the topics and subscriptions whose config changed since they were created")
+	fs.StringVar(&labels, "labels", "", "Comma-separated key=value labels to apply to every created topic and subscription, unless it defines the same label")
+	fs.IntVar(&maxSubscriptions, "max-subscriptions", 0, "Fail before creating anything if the projects define more subscriptions than this (default no limit)")
+	fs.IntVar(&maxTopics, "max-topics", 0, "Fail before creating anything if the projects define more topics than this (default no limit)")
+	fs.DurationVar(&opTimeout, "op-timeout", 0, "Maximum duration of every single topic or subscription creation")
+	fs.StringVar(&outputFile, "output-file", "", "Write the created topics and subscriptions as JSON to this file")
+	fs.StringVar(&planFormat, "plan-format", "text", "Deprecated: use -output-format, which overrides it")
+	fs.StringVar(&postHook, "post-hook", "", "Shell command to run once the topics and subscriptions are created")
+	fs.BoolVar(&printHealthcheck, "print-healthcheck", false, "Print a shell command for a docker-compose healthcheck, which exits 0 only if all configured topics and subscriptions exist")
+	fs.BoolVar(&quietOnSuccess, "quiet-on-success", false, "Only print the progress messages if the command fails, and otherwise a single line once it succeeds")
+	fs.IntVar(&projectConcurrency, "project-concurrency", 1, "Maximum number of projects to create concurrently; the default of 1 creates them in order")
+	fs.BoolVar(&prune, "prune", false, "Delete the live topics and subscriptions of the configured projects that are within -prune-scope but not in the config, once the config is applied")
+	fs.StringVar(&pruneScope, "prune-scope", "", "Resources that -prune may delete: prefix:<prefix> for the names with the prefix, or label:<key>=<value> for the resources with the label")
+	fs.BoolVar(&randomize, "randomize-projects", false, "Append a random suffix to every project ID, which the output file maps the configured project IDs to")
+	fs.IntVar(&receiveSettings.MaxOutstandingMessages, "receive-max-outstanding", 100, "Maximum number of unacknowledged messages of the subscribers that verify messages")
+	fs.IntVar(&receiveSettings.NumGoroutines, "receive-goroutines", 1, "Number of goroutines of the subscribers that verify messages")
+	fs.IntVar(&resourceConcurrency, "resource-concurrency", 1, "Maximum number of topics or subscriptions to create concurrently within each project; the default of 1 creates them in order")
+	fs.IntVar(&retries, "retries", 0, "Number of times to retry a topic or subscription creation that times out or fails with a transient error")
+	fs.BoolVar(&rollbackOnError, "rollback-on-error", false, "Delete the topics and subscriptions that were created so far if the creation fails")
+	fs.StringVar(&runID, "run-id", "", "ID of the run to label the created topics and subscriptions with (default a generated UUID)")
+	fs.IntVar(&seedConcurrency, "seed-concurrency", 1, "Maximum number of seed messages to publish concurrently")
+	fs.StringVar(&seedErrors, "seed-errors", "fail-fast", "Seed publish failures: fail-fast stops the run, best-effort skips the failed seed messages")
+	fs.BoolVar(&seedOrphansOnly, "seed-orphan-topics-only", false, "Only publish the seed messages of topics without subscriptions")
+	fs.StringVar(&seedStateFile, "seed-state", "", "Only publish the seed messages that are not recorded in this state file, and record them")
+	fs.BoolVar(&skipExistingSubs, "skip-existing-subscriptions", false, "Use subscriptions that already exist instead of failing")
+	fs.BoolVar(&skipExistingTopics, "skip-existing-topics", false, "Use topics that already exist instead of failing")
+	fs.BoolVar(&strict, "strict", false, "Fail if a configured option has no effect on the emulator")
+	fs.BoolVar(&strictFilters, "strict-filters", false, "Warn about subscription filters that refer to attributes which are not fields of the topic's schema")
+	fs.BoolVar(&summaryOnly, "summary-only", false, "Print a single JSON line with the counts and operation durations of the run instead of the progress messages, which are only printed if it fails")
+	fs.StringVar(&topicsMode, "topics", "create", "Topic handling: create topics, or skip creation and use existing ones")
+	fs.BoolVar(&verifyOrder, "verify-order", false, "Verify that the seed messages with an ordering key are delivered in order")
+	fs.IntVar(&verifyOrderCount, "verify-order-count", 0, "Number of seed messages that must arrive in order (default all of them)")
+	fs.DurationVar(&verifyOrderTimeout, "verify-order-timeout", 10*time.Second, "Maximum time to wait for the seed messages to arrive in order")
+	fs.BoolVar(&verifyRetention, "verify-retention", false, "Verify that the seed messages of topics with retain can be replayed")
+	fs.BoolVar(&watch, "watch", false, "Keep running and apply the projects with -ensure every time that the -config file changes")
+	fs.BoolVar(&yes, "yes", false, "Reset or -prune without asking for confirmation when the PubSub service is not a local emulator")
+}
+
+// validateCreateFlags checks the values of the flags that control how
+// projects are created.
+func validateCreateFlags() error {
+	if topicsMode != "create" && topicsMode != "skip" {
+		return fmt.Errorf("-topics: expected create or skip, got %q", topicsMode)
+	}
+
+	if _, err := provision.ParseBackoff(backoff, backoffBase, backoffMax); err != nil {
+		return fmt.Errorf("-backoff: %s", err)
+	}
+
+	if planFormat != "text" && planFormat != "json" && planFormat != "yaml" && planFormat != "env" {
+		return fmt.Errorf("-plan-format: expected text, json, yaml or env, got %q", planFormat)
+	}
+
+	if seedErrors != "fail-fast" && seedErrors != "best-effort" {
+		return fmt.Errorf("-seed-errors: expected fail-fast or best-effort, got %q", seedErrors)
+	}
+
+	// The labels are checked before the PubSub service is contacted, which
+	// would reject them with a less specific error.
+	parsed, err := parseLabels(labels)
+	if err != nil {
+		return err
+	}
+	if err := provision.CheckLabels(parsed); err != nil {
+		return fmt.Errorf("-labels: %s", err)
+	}
+
+	if runID != "" {
+		if err := provision.CheckLabels(map[string]string{provision.RunIDLabel: runID}); err != nil {
+			return fmt.Errorf("-run-id: the run ID must be a valid label value of at most 63 lowercase letters, digits, underscores and dashes, got %q", runID)
+		}
+	}
+
+	if watch && (configFile == "" || isConfigURL(configFile)) {
+		return fmt.Errorf("-watch requires -config to be a file")
+	}
+
+	if quietOnSuccess && watch {
+		return fmt.Errorf("-quiet-on-success is not supported with -watch")
+	}
+
+	if prune {
+		if pruneScope == "" {
+			return fmt.Errorf("-prune requires -prune-scope, to limit what it deletes")
+		}
+		if _, err := provision.ParsePruneScope(pruneScope); err != nil {
+			return fmt.Errorf("-prune-scope: %s", err)
+		}
+		if watch {
+			return fmt.Errorf("-prune is not supported with -watch")
+		}
+	}
+
+	switch {
+	case summaryOnly && watch:
+		return fmt.Errorf("-summary-only is not supported with -watch")
+	case summaryOnly && quietOnSuccess:
+		return fmt.Errorf("-summary-only and -quiet-on-success are mutually exclusive")
+	case summaryOnly && (check || dryRun):
+		return fmt.Errorf("-summary-only is not supported with -check or -dry-run")
+	}
+
+	if randomize && (check || dryRun) {
+		return fmt.Errorf("-randomize-projects is not supported with -check or -dry-run")
+	}
+
+	return nil
+}
+
+// parseLabels parses the -labels flag.
+func parseLabels(value string) (map[string]string, error) {
+	var labels map[string]string
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("-labels: expected key=value, got %q", pair)
+		}
+
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = val
+	}
+
+	return labels, nil
+}
+
+func runCreate(ctx context.Context, projects []provision.Config) error {
+	if err := validateCreateFlags(); err != nil {
+		return err
+	}
+
+	// The caps also apply to -check, -dry-run and -print-healthcheck.
+	if err := checkCaps(projects); err != nil {
+		return err
+	}
+
+	if printHealthcheck {
+		fmt.Println(healthcheckCommand(projects))
+		return nil
+	}
+
+	clients := newClients()
+	defer closeClients(clients)
+
+	switch {
+	case check:
+		return checkDrift(ctx, clients, projects)
+	case dryRun:
+		return printDiff(ctx, clients, projects)
+	}
+
+	if randomize {
+		projects = randomizeProjects(projects)
+	}
+
+	// Every reconcile of -watch is part of the same run.
+	if watch && runID == "" {
+		runID = uuid.NewString()
+	}
+
+	start := time.Now()
+	created, err := createProjects(ctx, clients, projects)
+	if summaryOnly {
+		if err := printSummary(projects, created, err, time.Since(start)); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		reportFailures(err, created)
+		return err
+	}
+
+	if err := finish(ctx, created); err != nil {
+		return err
+	}
+
+	if prune {
+		if err := pruneProjects(ctx, clients, projects); err != nil {
+			return err
+		}
+	}
+
+	if watch {
+		ensure = true
+		return watchConfig(ctx, clients, projects)
+	}
+
+	return nil
+}
+
+// pruneProjects deletes the live topics and subscriptions of the projects that
+// are within -prune-scope but not in the config, once they are confirmed.
+func pruneProjects(ctx context.Context, clients provision.Clients, projects []provision.Config) error {
+	scope, _ := provision.ParsePruneScope(pruneScope)
+
+	p := &provision.Provisioner{Clients: clients, Logger: newLogger()}
+	resources, err := p.Prunable(ctx, projects, scope)
+	if err != nil {
+		return err
+	}
+
+	if len(resources) == 0 {
+		debugf("Nothing to prune")
+		return nil
+	}
+
+	if err := confirmDeletion(resourceNames(resources)); err != nil {
+		return err
+	}
+
+	if err := p.DeleteResources(ctx, resources); err != nil {
+		return err
+	}
+
+	infof("Pruned %d resource(s) that are not in the config", len(resources))
+	return nil
+}
+
+// finish writes the created resources to the output file and runs the post
+// hook, if they are set.
+func finish(ctx context.Context, created []provision.Resource) error {
+	if outputFile != "" {
+		if err := writeOutputFile(outputFile, created, randomProjectIDs); err != nil {
+			return err
+		}
+	}
+
+	if postHook != "" {
+		return runPostHook(ctx, postHook, created)
+	}
+
+	return nil
+}
+
+// reportFailures logs the resources that failed with -continue-on-error,
+// telling the timeouts apart from the other failures, and the resources that
+// were created regardless.
+func reportFailures(err error, created []provision.Resource) {
+	var partial *provision.PartialError
+	if !errors.As(err, &partial) {
+		return
+	}
+
+	for _, f := range partial.Failures {
+		if f.TimedOut {
+			warnf("Timed out: %s %s: %s", f.Type, f.Name, f.Err)
+		} else {
+			warnf("Failed: %s %s: %s", f.Type, f.Name, f.Err)
+		}
+	}
+
+	infof("Created %d resource(s), %d timed out and %d failed otherwise", len(created), partial.TimedOut(), len(partial.Failures)-partial.TimedOut())
+}
+
+// logger prints the progress messages of the provisioner.
+type logger struct{}
+
+func (logger) Debugf(format string, params ...interface{}) { debugf(format, params...) }
+func (logger) Infof(format string, params ...interface{})  { infof(format, params...) }
+func (logger) Warnf(format string, params ...interface{})  { warnf(format, params...) }
+
+// closeClients closes the clients and logs the errors to close them. It runs
+// deferred, so that the clients are also closed when the context is cancelled.
+func closeClients(clients *provision.ClientCache) {
+	if err := clients.Close(); err != nil {
+		warnf("%s", err)
+	}
+}
+
+// newClients returns a client cache that, with -wait, checks that the PubSub
+// service is ready before the first client is used. The clients always use
+// gRPC, because the pubsub.Client has no REST transport.
+func newClients() *provision.ClientCache {
+	var ready bool
+
+	opts := []option.ClientOption{option.WithUserAgent(userAgent)}
+	dialOpts := []grpc.DialOption{grpc.WithUserAgent(userAgent)}
+
+	// Compression trades CPU time for bandwidth, which only pays off on a
+	// slow link to a remote PubSub service.
+	if compress {
+		compressor := grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name))
+		opts = append(opts, option.WithGRPCDialOption(compressor))
+		dialOpts = append(dialOpts, compressor)
+	}
+
+	return &provision.ClientCache{
+		Options:         opts,
+		ShareConnection: shareConn,
+		DialOptions:     dialOpts,
+		OnConnect: func(ctx context.Context, client *pubsub.Client) error {
+			debugf("Client connected with project ID %q", client.Project())
+
+			if wait > 0 && !ready {
+				if err := waitReady(ctx, client, wait); err != nil {
+					return err
+				}
+
+				ready = true
+			}
+
+			return nil
+		},
+	}
+}
+
+// checkCaps checks that the projects don't define more topics or
+// subscriptions than -max-topics and -max-subscriptions allow, after the range
+// templates are expanded.
+func checkCaps(projects []provision.Config) error {
+	var topics, subscriptions int
+	for _, project := range projects {
+		topics += len(project.Topics)
+		for _, t := range project.Topics {
+			subscriptions += len(t.Subscriptions)
+		}
+	}
+
+	switch {
+	case maxTopics > 0 && topics > maxTopics:
+		return fmt.Errorf("-max-topics: the projects define %d topics, more than the maximum of %d", topics, maxTopics)
+	case maxSubscriptions > 0 && subscriptions > maxSubscriptions:
+		return fmt.Errorf("-max-subscriptions: the projects define %d subscriptions, more than the maximum of %d", subscriptions, maxSubscriptions)
+	}
+
+	return nil
+}
+
+// createProjects creates all the topics and subscriptions of the specified
+// projects, and returns the resources that it created.
+func createProjects(ctx context.Context, clients provision.Clients, projects []provision.Config) ([]provision.Resource, error) {
+	if err := checkCaps(projects); err != nil {
+		return nil, err
+	}
+
+	p := &provision.Provisioner{
+		Clients:                   clients,
+		Logger:                    newLogger(),
+		SkipTopics:                topicsMode == "skip",
+		Ensure:                    ensure,
+		SkipExistingTopics:        skipExistingTopics,
+		SkipExistingSubscriptions: skipExistingSubs,
+		Strict:                    strict,
+		StrictFilters:             strictFilters,
+		CreateDeadLetterProjects:  createDLQProject,
+		ProjectConcurrency:        projectConcurrency,
+		ResourceConcurrency:       resourceConcurrency,
+		SeedConcurrency:           seedConcurrency,
+		SeedBestEffort:            seedErrors == "best-effort",
+		SeedOrphanTopicsOnly:      seedOrphansOnly,
+		VerifyOrder:               verifyOrder,
+		VerifyOrderTimeout:        verifyOrderTimeout,
+		VerifyOrderCount:          verifyOrderCount,
+		VerifyRetention:           verifyRetention,
+		ReceiveSettings:           receiveSettings,
+		RunID:                     runID,
+		OpTimeout:                 opTimeout,
+		Retries:                   retries,
+		RollbackOnError:           rollbackOnError,
+		OnRollback:                recordRollback,
+		ContinueOnError:           continueOnError,
+		OnSkip:                    recordSkipped,
+		OnUpdate:                  recordUpdated,
+		OnOperation:               recordOperation,
+	}
+
+	p.Labels, _ = parseLabels(labels)
+	p.Backoff, _ = provision.ParseBackoff(backoff, backoffBase, backoffMax)
+
+	if p.RunID == "" {
+		p.RunID = uuid.NewString()
+	}
+	debugf("Run ID %q", p.RunID)
+
+	if seedStateFile != "" {
+		state, err := provision.LoadSeedState(seedStateFile)
+		if err != nil {
+			return nil, err
+		}
+
+		p.SeedState = state
+	}
+
+	return p.Create(ctx, projects)
+}
+
+// deleteFlags registers the flags of the delete command.
+func deleteFlags(fs *flag.FlagSet) {
+	fs.StringVar(&runID, "run-id", "", "Only delete the topics and subscriptions of the configured projects that were created by this run")
+	fs.BoolVar(&yes, "yes", false, "Delete without asking for confirmation when the PubSub service is not a local emulator")
+}
+
+func runDelete(ctx context.Context, projects []provision.Config) error {
+	clients := newClients()
+	defer closeClients(clients)
+
+	if runID != "" {
+		projectIDs := make([]string, 0, len(projects))
+		deletions := make([]string, 0, len(projects))
+		for _, project := range projects {
+			projectIDs = append(projectIDs, project.ProjectID)
+			deletions = append(deletions, fmt.Sprintf("the topics and subscriptions of run %q in project %q", runID, project.ProjectID))
+		}
+
+		if err := confirmDeletion(deletions); err != nil {
+			return err
+		}
+
+		p := &provision.Provisioner{Clients: clients, Logger: newLogger()}
+		return p.DeleteRun(ctx, projectIDs, runID)
+	}
+
+	if err := confirmDeletion(resourceNames(provision.Deletions(projects))); err != nil {
+		return err
+	}
+
+	return deleteProjects(ctx, clients, projects)
+}
+
+// deleteProjects deletes all the topics and subscriptions of the specified
+// projects. Resources that don't exist are skipped.
+func deleteProjects(ctx context.Context, clients provision.Clients, projects []provision.Config) error {
+	p := &provision.Provisioner{Clients: clients, Logger: newLogger()}
+	return p.Delete(ctx, projects)
+}
+
+func runReset(ctx context.Context, projects []provision.Config) error {
+	if err := validateCreateFlags(); err != nil {
+		return err
+	}
+
+	// Check the caps before anything else, and before anything is deleted.
+	if err := checkCaps(projects); err != nil {
+		return err
+	}
+
+	switch {
+	case check:
+		return fmt.Errorf("-check is not supported by reset")
+	case dryRun:
+		return fmt.Errorf("-dry-run is not supported by reset")
+	case printHealthcheck:
+		return fmt.Errorf("-print-healthcheck is not supported by reset")
+	case watch:
+		return fmt.Errorf("-watch is not supported by reset")
+	case randomize:
+		return fmt.Errorf("-randomize-projects is not supported by reset")
+	}
+
+	if err := confirmDeletion(resourceNames(provision.Deletions(projects))); err != nil {
+		return err
+	}
+
+	clients := newClients()
+	defer closeClients(clients)
+
+	if err := deleteProjects(ctx, clients, projects); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	created, err := createProjects(ctx, clients, projects)
+	if summaryOnly {
+		if err := printSummary(projects, created, err, time.Since(start)); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		reportFailures(err, created)
+		return err
+	}
+
+	if err := finish(ctx, created); err != nil {
+		return err
+	}
+
+	if prune {
+		return pruneProjects(ctx, clients, projects)
+	}
+
+	return nil
+}
+
+func runList(ctx context.Context, projects []provision.Config) error {
+	clients := newClients()
+	defer closeClients(clients)
+
+	configs, err := liveProjects(ctx, clients, projects)
+	if err != nil {
+		return err
+	}
+
+	return printFormatted(newLiveOutput(configs), "text")
+}
+
+func runExport(ctx context.Context, projects []provision.Config) error {
+	clients := newClients()
+	defer closeClients(clients)
+
+	configs, err := liveProjects(ctx, clients, projects)
+	if err != nil {
+		return err
+	}
+
+	return printFormatted(newLiveOutput(configs), "env")
+}
+
+// liveProjects reads the live state of every one of the specified projects.
+func liveProjects(ctx context.Context, clients provision.Clients, projects []provision.Config) ([]provision.Config, error) {
+	configs := make([]provision.Config, 0, len(projects))
+	for _, project := range projects {
+		live, err := liveProject(ctx, clients, project.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+
+		configs = append(configs, live)
+	}
+
+	return configs, nil
+}
+
+// liveProject reads the topics and subscriptions that currently exist in the
+// specified project.
+func liveProject(ctx context.Context, clients provision.Clients, projectID string) (provision.Config, error) {
+	client, err := clients.Client(ctx, projectID)
+	if err != nil {
+		return provision.Config{}, err
+	}
+
+	return provision.Live(ctx, client)
+}
+
+// printDiff prints the changes between the config and the live state of the
+// specified projects. In the json and yaml formats, it prints the plans of the
+// projects, and in the env format their resolved config.
+func printDiff(ctx context.Context, clients provision.Clients, projects []provision.Config) error {
+	out := planOutput{configs: projects}
+	for _, project := range projects {
+		live, err := liveProject(ctx, clients, project.ProjectID)
+		if err != nil {
+			return err
+		}
+
+		out.Projects = append(out.Projects, provision.NewPlan(project, live))
+	}
+
+	return printFormatted(out, planFormat)
+}
+
+// driftError is returned by checkDrift if resources are missing or drifted
+// from the config, which pubsubc exits with exitDrift for.
+type driftError struct {
+	count int
+}
+
+func (e *driftError) Error() string {
+	return fmt.Sprintf("%d resource(s) are missing or drifted from the config", e.count)
+}
+
+// checkDrift prints the configured topics and subscriptions that are missing
+// or drifted from the config, and returns a *driftError if there are any.
+func checkDrift(ctx context.Context, clients provision.Clients, projects []provision.Config) error {
+	var drifted int
+	for _, project := range projects {
+		live, err := liveProject(ctx, clients, project.ProjectID)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range provision.Diff(project, live) {
+			if c.Action == provision.ActionCreate || c.Action == provision.ActionUpdate {
+				fmt.Println(c)
+				drifted++
+			}
+		}
+	}
+
+	if drifted > 0 {
+		return &driftError{count: drifted}
+	}
+
+	return nil
+}
@@ -0,0 +1,842 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{args: nil, name: "create"},
+		{args: []string{"-debug"}, name: "create", rest: []string{"-debug"}},
+		{args: []string{"delete"}, name: "delete"},
+		{args: []string{"reset", "-yes", "-debug"}, name: "reset", rest: []string{"-yes", "-debug"}},
+		{args: []string{"list", "export"}, name: "list", rest: []string{"export"}},
+		{args: []string{"-debug", "delete"}, name: "create", rest: []string{"-debug", "delete"}},
+		{args: []string{"destroy"}, name: "create", rest: []string{"destroy"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			cmd, rest := parseCommand(tt.args)
+			if cmd.name != tt.name {
+				t.Errorf("expected command %q, got %q", tt.name, cmd.name)
+			}
+			if !slices.Equal(rest, tt.rest) {
+				t.Errorf("expected arguments %q, got %q", tt.rest, rest)
+			}
+		})
+	}
+}
+
+func TestReceiveSettingsFlags(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		maxOutstanding int
+		goroutines     int
+	}{
+		{name: "defaults", maxOutstanding: 100, goroutines: 1},
+		{name: "small emulator", args: []string{"-receive-max-outstanding=10", "-receive-goroutines=1"}, maxOutstanding: 10, goroutines: 1},
+		{name: "more goroutines", args: []string{"-receive-goroutines=3"}, maxOutstanding: 100, goroutines: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.args...)
+
+			if receiveSettings.MaxOutstandingMessages != tt.maxOutstanding {
+				t.Errorf("expected %d outstanding messages, got %d", tt.maxOutstanding, receiveSettings.MaxOutstandingMessages)
+			}
+			if receiveSettings.NumGoroutines != tt.goroutines {
+				t.Errorf("expected %d goroutines, got %d", tt.goroutines, receiveSettings.NumGoroutines)
+			}
+		})
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	tests := []struct {
+		name    string
+		live    string
+		config  string
+		drifted int
+	}{
+		{name: "in sync", live: "project1,payments:settle;ack=30s", config: "project1,payments:settle;ack=30s"},
+		{name: "live extras", live: "project1,payments:settle:reconcile,payouts", config: "project1,payments:settle"},
+		{name: "missing subscription", live: "project1,payments", config: "project1,payments:settle", drifted: 1},
+		{name: "missing project", config: "project1,refunds:ledger:audit", drifted: 3},
+		{name: "drifted ack deadline", live: "project1,payments:settle;ack=20s", config: "project1,payments:settle;ack=45s", drifted: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newTestClients(t)
+
+			parse := func(definition string) provision.Config {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				return cfg
+			}
+
+			ctx := context.Background()
+			if tt.live != "" {
+				if _, err := (&provision.Provisioner{Clients: clients}).Create(ctx, []provision.Config{parse(tt.live)}); err != nil {
+					t.Fatalf("unable to create %q: %s", tt.live, err)
+				}
+			}
+
+			before, err := liveProject(ctx, clients, "project1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkDrift(ctx, clients, []provision.Config{parse(tt.config)})
+
+			var drift *driftError
+			switch {
+			case tt.drifted == 0 && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.drifted > 0 && !errors.As(err, &drift):
+				t.Errorf("expected a drift error, got %v", err)
+			case tt.drifted > 0 && drift.count != tt.drifted:
+				t.Errorf("expected %d drifted resource(s), got %d", tt.drifted, drift.count)
+			}
+
+			// Checking never changes the live state.
+			after, err := liveProject(ctx, clients, "project1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range provision.Diff(before, after) {
+				if c.Action != provision.ActionNoop {
+					t.Errorf("expected the live state to be unchanged, got %s", c)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected map[string]string
+		err      string
+	}{
+		{value: ""},
+		{value: "team=core,owner=me", expected: map[string]string{"team": "core", "owner": "me"}},
+		{value: " team=core , cost-center= ", expected: map[string]string{"team": "core", "cost-center": ""}},
+		{value: "team=core,team=search", expected: map[string]string{"team": "search"}},
+		{value: "team", err: `-labels: expected key=value, got "team"`},
+		{value: "=core", err: `-labels: expected key=value, got "=core"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			labels, err := parseLabels(tt.value)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case !maps.Equal(labels, tt.expected):
+				t.Errorf("expected %v, got %v", tt.expected, labels)
+			}
+		})
+	}
+}
+
+func TestValidateCreateFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: nil},
+		{args: []string{"-seed-errors=fail-fast"}},
+		{args: []string{"-seed-errors=best-effort"}},
+		{args: []string{"-seed-errors=ignore"}, err: `-seed-errors: expected fail-fast or best-effort, got "ignore"`},
+		{args: []string{"-labels=team=core,owner=me"}},
+		{args: []string{"-labels=Team=core"}, err: `-labels: invalid label key "Team"`},
+		{args: []string{"-run-id=ci-4711"}},
+		{args: []string{"-run-id=CI_4711"}, err: `-run-id: the run ID must be a valid label value of at most 63 lowercase letters, digits, underscores and dashes, got "CI_4711"`},
+		{args: []string{"-run-id=" + strings.Repeat("a", 64)}, err: `-run-id: the run ID must be a valid label value of at most 63 lowercase letters, digits, underscores and dashes, got "` + strings.Repeat("a", 64) + `"`},
+		{args: []string{"-backoff=constant", "-backoff-base=1s"}},
+		{args: []string{"-backoff=exponential", "-backoff-base=2s", "-backoff-max=1s"}, err: "-backoff: the max delay 1s is below the base delay 2s"},
+		{args: []string{"-quiet-on-success", "-watch", "-config=topics.conf"}, err: "-quiet-on-success is not supported with -watch"},
+		{args: []string{"-watch", "-config=https://config.example.com/pubsub.conf"}, err: "-watch requires -config to be a file"},
+		{args: []string{"-watch"}, err: "-watch requires -config to be a file"},
+		{args: []string{"-summary-only"}},
+		{args: []string{"-summary-only", "-watch", "-config=topics.conf"}, err: "-summary-only is not supported with -watch"},
+		{args: []string{"-summary-only", "-quiet-on-success"}, err: "-summary-only and -quiet-on-success are mutually exclusive"},
+		{args: []string{"-summary-only", "-dry-run"}, err: "-summary-only is not supported with -check or -dry-run"},
+		{args: []string{"-summary-only", "-check"}, err: "-summary-only is not supported with -check or -dry-run"},
+		{args: []string{"-prune", "-prune-scope=prefix:ci-"}},
+		{args: []string{"-prune", "-prune-scope=label:owner=ci"}},
+		{args: []string{"-prune"}, err: "-prune requires -prune-scope, to limit what it deletes"},
+		{args: []string{"-prune", "-prune-scope=ci-"}, err: `-prune-scope: expected prefix:<prefix> or label:<key>=<value>, got "ci-"`},
+		{args: []string{"-prune", "-prune-scope=prefix:ci-", "-watch", "-config=topics.conf"}, err: "-prune is not supported with -watch"},
+		{args: []string{"-backoff=fibonacci"}, err: `-backoff: expected constant, exponential or exponential-jitter, got "fibonacci"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			setFlags(t, tt.args...)
+
+			err := validateCreateFlags()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Errorf("expected the error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// TestCommandFlags checks that the flags of every command can be registered
+// along with the common flags, which panics on a duplicate flag.
+func TestCommandFlags(t *testing.T) {
+	for _, cmd := range commands {
+		t.Run(cmd.name, func(t *testing.T) {
+			fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+			commonFlags(fs)
+			if cmd.flags != nil {
+				cmd.flags(fs)
+			}
+
+			if fs.Lookup("debug") == nil {
+				t.Error("expected the common flags to be registered")
+			}
+		})
+	}
+}
+
+func TestNewClientsUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "default", want: "pubsubc/" + Revision},
+		{name: "custom", args: []string{"-user-agent", "ci-fixtures/2.1"}, want: "ci-fixtures/2.1"},
+		{name: "separate connections", args: []string{"-user-agent", "e2e-runner", "-share-connection=false"}, want: "e2e-runner"},
+		{name: "compressed", args: []string{"-user-agent", "loadgen", "-compress"}, want: "loadgen"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu         sync.Mutex
+				userAgents []string
+			)
+
+			// Serve the fake with an interceptor that records the user agent
+			// of every request.
+			srv := pstest.NewServer()
+			t.Cleanup(func() { srv.Close() })
+
+			gsrv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+
+				mu.Lock()
+				userAgents = append(userAgents, strings.Join(md.Get("user-agent"), " "))
+				mu.Unlock()
+
+				return handler(ctx, req)
+			}))
+			pubsubpb.RegisterPublisherServer(gsrv, &srv.GServer)
+			pubsubpb.RegisterSubscriberServer(gsrv, &srv.GServer)
+
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go gsrv.Serve(lis)
+			t.Cleanup(gsrv.Stop)
+
+			t.Setenv("PUBSUB_EMULATOR_HOST", lis.Addr().String())
+			setFlags(t, tt.args...)
+
+			clients := newClients()
+			t.Cleanup(func() { clients.Close() })
+
+			cfg, err := (&provision.Parser{}).Parse("fixtures,uploads:uploads-scanner")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := (&provision.Provisioner{Clients: clients}).Create(context.Background(), []provision.Config{cfg}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if len(userAgents) == 0 {
+				t.Fatal("expected requests to the emulator")
+			}
+
+			// The gRPC library appends its own user agent to ours.
+			for _, userAgent := range userAgents {
+				if !strings.HasPrefix(userAgent, tt.want+" ") {
+					t.Errorf("expected user agent %q, got %q", tt.want, userAgent)
+				}
+			}
+		})
+	}
+}
+
+// compressionRecorder is a gRPC server stats handler that records the
+// compression of the requests to every method.
+type compressionRecorder struct {
+	mu      sync.Mutex
+	methods map[string][]string
+}
+
+func (r *compressionRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		method := h.FullMethod[strings.LastIndex(h.FullMethod, "/")+1:]
+
+		r.mu.Lock()
+		r.methods[method] = append(r.methods[method], h.Compression)
+		r.mu.Unlock()
+	}
+}
+
+func (r *compressionRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestNewClientsCompression(t *testing.T) {
+	seeds := filepath.Join(t.TempDir(), "seeds.jsonl")
+	if err := os.WriteFile(seeds, []byte(`{"data":"`+strings.Repeat("frame-", 512)+`"}`+"\n"+`{"data":"keyframe"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		config string
+		want   string
+		// methods must all have been called.
+		methods []string
+	}{
+		{
+			name:    "default",
+			config:  "media,frames:frames-encoder",
+			methods: []string{"CreateTopic", "CreateSubscription"},
+		},
+		{
+			name:    "compressed",
+			args:    []string{"-compress"},
+			config:  "media,frames:frames-encoder",
+			want:    "gzip",
+			methods: []string{"CreateTopic", "CreateSubscription"},
+		},
+		{
+			name:    "compressed separate connections",
+			args:    []string{"-compress", "-share-connection=false"},
+			config:  "media,frames:frames-encoder",
+			want:    "gzip",
+			methods: []string{"CreateTopic", "CreateSubscription"},
+		},
+		{
+			name:    "compressed seeds",
+			args:    []string{"-compress"},
+			config:  "media,frames[seed=" + seeds + "]:frames-encoder",
+			want:    "gzip",
+			methods: []string{"CreateTopic", "CreateSubscription", "Publish"},
+		},
+		{
+			name:    "uncompressed seeds",
+			config:  "media,frames[seed=" + seeds + "]",
+			methods: []string{"CreateTopic", "Publish"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &compressionRecorder{methods: make(map[string][]string)}
+
+			srv := pstest.NewServer()
+			t.Cleanup(func() { srv.Close() })
+
+			gsrv := grpc.NewServer(grpc.StatsHandler(recorder))
+			pubsubpb.RegisterPublisherServer(gsrv, &srv.GServer)
+			pubsubpb.RegisterSubscriberServer(gsrv, &srv.GServer)
+
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go gsrv.Serve(lis)
+			t.Cleanup(gsrv.Stop)
+
+			t.Setenv("PUBSUB_EMULATOR_HOST", lis.Addr().String())
+			setFlags(t, tt.args...)
+
+			clients := newClients()
+			t.Cleanup(func() { clients.Close() })
+
+			cfg, err := (&provision.Parser{}).Parse(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := (&provision.Provisioner{Clients: clients}).Create(context.Background(), []provision.Config{cfg}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+
+			for _, method := range tt.methods {
+				if len(recorder.methods[method]) == 0 {
+					t.Errorf("expected %s to be called, got %v", method, recorder.methods)
+				}
+			}
+			for method, compressions := range recorder.methods {
+				for _, compression := range compressions {
+					if compression != tt.want {
+						t.Errorf("%s: expected the compression %q, got %q", method, tt.want, compression)
+					}
+				}
+			}
+		})
+	}
+}
+
+// captureStdout returns what the function writes to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	return capture(t, &os.Stdout, fn)
+}
+
+// capture returns what the function writes to the file, like os.Stdout or
+// os.Stderr.
+func capture(t *testing.T, f **os.File, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := *f
+	*f = w
+	defer func() { *f = orig }()
+
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+
+	fn()
+	w.Close()
+
+	return string(<-done)
+}
+
+func TestPrintDiffPlanFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "text",
+			want: "no-op  topic        projects/warehouse/topics/stock\n" +
+				"update subscription projects/warehouse/subscriptions/stock-sync (stock:stock-sync -> stock:stock-sync;ack=40s)\n" +
+				"create topic        projects/warehouse/topics/pallets\n",
+		},
+		{
+			name: "json",
+			args: []string{"-plan-format", "json"},
+			want: `{"projects":[{"project":"warehouse","topics":[
+				{"id":"stock","options":{},"subscriptions":[{"id":"stock-sync","options":{"ack":"40s","expire":"744h0m0s"}}]},
+				{"id":"pallets","options":{},"subscriptions":[]}
+			],"changes":[
+				{"action":"no-op","kind":"topic","name":"projects/warehouse/topics/stock"},
+				{"action":"update","kind":"subscription","name":"projects/warehouse/subscriptions/stock-sync","detail":"stock:stock-sync -> stock:stock-sync;ack=40s"},
+				{"action":"create","kind":"topic","name":"projects/warehouse/topics/pallets"}
+			]}]}`,
+		},
+		{
+			name: "yaml",
+			args: []string{"-output-format", "yaml"},
+			want: `projects:
+  - project: warehouse
+    topics:
+      - id: stock
+        options: {}
+        subscriptions:
+          - id: stock-sync
+            options:
+              ack: 40s
+              expire: 744h0m0s
+      - id: pallets
+        options: {}
+        subscriptions: []
+    changes:
+      - action: no-op
+        kind: topic
+        name: projects/warehouse/topics/stock
+      - action: update
+        kind: subscription
+        name: projects/warehouse/subscriptions/stock-sync
+        detail: stock:stock-sync -> stock:stock-sync;ack=40s
+      - action: create
+        kind: topic
+        name: projects/warehouse/topics/pallets
+`,
+		},
+		{
+			name: "output format overrides",
+			args: []string{"-plan-format", "json", "-output-format", "env"},
+			want: "PUBSUB_PROJECT1=\"warehouse,stock:stock-sync;ack=40s,pallets\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newTestClients(t)
+
+			parse := func(definition string) provision.Config {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				return cfg
+			}
+
+			ctx := context.Background()
+			if _, err := (&provision.Provisioner{Clients: clients}).Create(ctx, []provision.Config{parse("warehouse,stock:stock-sync")}); err != nil {
+				t.Fatal(err)
+			}
+
+			setFlags(t, append([]string{"-dry-run"}, tt.args...)...)
+
+			var err error
+			got := captureStdout(t, func() {
+				err = printDiff(ctx, clients, []provision.Config{parse("warehouse,stock:stock-sync;ack=40s,pallets")})
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if strings.HasPrefix(tt.want, "{") {
+				if !jsonEqual(t, got, tt.want) {
+					t.Errorf("expected %s, got %s", tt.want, got)
+				}
+			} else if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckCaps(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		definitions []string
+		err         string
+	}{
+		{
+			name:        "no caps",
+			definitions: []string{"fleet,truck-[1-50]:gps-[1-50]:brakes"},
+		},
+		{
+			name:        "at the caps",
+			args:        []string{"-max-topics", "3", "-max-subscriptions", "6"},
+			definitions: []string{"fleet,truck-[1-3]:gps-[1-3]:brakes"},
+		},
+		{
+			name:        "range expands over the topic cap",
+			args:        []string{"-max-topics", "10"},
+			definitions: []string{"fleet,truck-[1-11]"},
+			err:         "-max-topics: the projects define 11 topics, more than the maximum of 10",
+		},
+		{
+			name:        "subscriptions over the cap",
+			args:        []string{"-max-subscriptions", "4"},
+			definitions: []string{"fleet,truck-[1-2]:gps:brakes:doors"},
+			err:         "-max-subscriptions: the projects define 6 subscriptions, more than the maximum of 4",
+		},
+		{
+			name:        "counted across projects",
+			args:        []string{"-max-topics", "4"},
+			definitions: []string{"fleet,trucks,vans", "depot,docks,gates,forklifts"},
+			err:         "-max-topics: the projects define 5 topics, more than the maximum of 4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.args...)
+
+			var projects []provision.Config
+			for _, definition := range tt.definitions {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				projects = append(projects, cfg)
+			}
+
+			err := checkCaps(projects)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Errorf("expected the error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCreateProjectsOverCaps(t *testing.T) {
+	clients := newTestClients(t)
+
+	setFlags(t, "-max-subscriptions", "8")
+
+	cfg, err := (&provision.Parser{}).Parse("ledger,entries-[1-3]:audit-[1-3]:archive:replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := createProjects(context.Background(), clients, []provision.Config{cfg})
+	if err == nil || !strings.HasPrefix(err.Error(), "-max-subscriptions:") {
+		t.Fatalf("expected the subscription cap to trigger, got %v", err)
+	}
+	if len(created) != 0 {
+		t.Errorf("expected nothing to be created, got %v", created)
+	}
+
+	client, err := clients.Client(context.Background(), "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := client.Topic("entries-1").Exists(context.Background()); err != nil || ok {
+		t.Errorf("expected topic entries-1 not to exist, got %t (%v)", ok, err)
+	}
+}
+
+func TestRunOverCaps(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(context.Context, []provision.Config) error
+		args []string
+	}{
+		{name: "create", run: runCreate},
+		{name: "create -check", run: runCreate, args: []string{"-check"}},
+		{name: "create -dry-run", run: runCreate, args: []string{"-dry-run"}},
+		{name: "create -print-healthcheck", run: runCreate, args: []string{"-print-healthcheck"}},
+		{name: "reset", run: runReset},
+		{name: "reset -check", run: runReset, args: []string{"-check"}},
+		{name: "reset -dry-run", run: runReset, args: []string{"-dry-run"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestServer(t)
+			setFlags(t, append([]string{"-max-topics", "2"}, tt.args...)...)
+
+			cfg, err := (&provision.Parser{}).Parse("kiosk,sales-[1-3]:sales-[1-3]-ledger")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var runErr error
+			out := captureStdout(t, func() { runErr = tt.run(context.Background(), []provision.Config{cfg}) })
+			if want := "-max-topics: the projects define 3 topics, more than the maximum of 2"; runErr == nil || runErr.Error() != want {
+				t.Errorf("expected the error %q, got %v", want, runErr)
+			}
+			if out != "" {
+				t.Errorf("expected no output, got %q", out)
+			}
+		})
+	}
+}
+
+func TestReportFailures(t *testing.T) {
+	created := []provision.Resource{
+		{Type: "topic", Project: "plant", Name: "projects/plant/topics/sensors"},
+		{Type: "subscription", Project: "plant", Name: "projects/plant/subscriptions/sensors-log"},
+	}
+
+	tests := []struct {
+		name   string
+		err    error
+		stderr []string
+		stdout string
+	}{
+		{
+			name: "partial",
+			err: &provision.PartialError{Failures: []provision.Failure{
+				{Resource: provision.Resource{Type: "topic", Name: "projects/plant/topics/cameras"}, Err: errors.New("operation timed out after 100ms"), TimedOut: true},
+				{Resource: provision.Resource{Type: "subscription", Name: "projects/plant/subscriptions/doors-audit"}, Err: errors.New("permission denied")},
+			}},
+			stderr: []string{
+				"warning: Timed out: topic projects/plant/topics/cameras: operation timed out after 100ms",
+				"warning: Failed: subscription projects/plant/subscriptions/doors-audit: permission denied",
+			},
+			stdout: "Created 2 resource(s), 1 timed out and 1 failed otherwise\n",
+		},
+		{
+			name: "wrapped",
+			err: fmt.Errorf("project plant: %w", &provision.PartialError{Failures: []provision.Failure{
+				{Resource: provision.Resource{Type: "topic", Name: "projects/plant/topics/doors"}, Err: errors.New("permission denied")},
+			}}),
+			stderr: []string{"warning: Failed: topic projects/plant/topics/doors: permission denied"},
+			stdout: "Created 2 resource(s), 0 timed out and 1 failed otherwise\n",
+		},
+		{
+			name: "other error",
+			err:  errors.New(`Unable to create topic "cameras" for project "plant": permission denied`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout string
+			stderr := capture(t, &os.Stderr, func() {
+				stdout = captureStdout(t, func() { reportFailures(tt.err, created) })
+			})
+
+			if stdout != tt.stdout {
+				t.Errorf("expected stdout %q, got %q", tt.stdout, stdout)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+			if stderr == "" {
+				lines = nil
+			}
+			if len(lines) != len(tt.stderr) {
+				t.Fatalf("expected %d warning(s), got %q", len(tt.stderr), stderr)
+			}
+			for i, want := range tt.stderr {
+				if !strings.HasSuffix(lines[i], want) {
+					t.Errorf("expected warning %q, got %q", want, lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunPrune(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(ctx context.Context, projects []provision.Config) error
+		args   []string
+		live   string
+		config string
+		want   []string
+	}{
+		{
+			name:   "create with a prefix scope",
+			run:    runCreate,
+			args:   []string{"-ensure", "-prune", "-prune-scope=prefix:qa-"},
+			live:   "staging,qa-orders:qa-orders-old:qa-orders-audit,qa-scratch,legacy-orders:qa-legacy-tap",
+			config: "staging,qa-orders:qa-orders-audit,qa-invoices",
+			want:   []string{"topic legacy-orders", "topic qa-invoices", "topic qa-orders", "subscription qa-orders-audit"},
+		},
+		{
+			name:   "create with a label scope",
+			run:    runCreate,
+			args:   []string{"-ensure", "-prune", "-prune-scope=label:env=qa"},
+			live:   `staging,carts[labels=env:qa]:carts-sync;labels="env:qa",wishlists[labels=env:prod]:wishlists-mail;labels="env:qa"`,
+			config: "staging,carts",
+			want:   []string{"topic carts", "topic wishlists"},
+		},
+		{
+			name:   "reset",
+			run:    runReset,
+			args:   []string{"-prune", "-prune-scope=prefix:qa-", "-yes"},
+			live:   "staging,qa-orders:qa-orders-old,qa-scratch,shared",
+			config: "staging,qa-orders:qa-orders-audit",
+			want:   []string{"topic qa-orders", "subscription qa-orders-audit", "topic shared"},
+		},
+		{
+			name:   "without -prune",
+			run:    runCreate,
+			args:   []string{"-prune-scope=prefix:qa-"},
+			live:   "staging,qa-scratch:qa-scratch-tap",
+			config: "staging,qa-orders",
+			want:   []string{"topic qa-orders", "topic qa-scratch", "subscription qa-scratch-tap"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newTestClients(t)
+			t.Cleanup(func() { existing.updated, existing.skipped = nil, nil })
+			setFlags(t, tt.args...)
+
+			parse := func(definition string) provision.Config {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				return cfg
+			}
+
+			ctx := context.Background()
+			if _, err := (&provision.Provisioner{Clients: clients}).Create(ctx, []provision.Config{parse(tt.live)}); err != nil {
+				t.Fatalf("unable to create %q: %s", tt.live, err)
+			}
+
+			if err := tt.run(ctx, []provision.Config{parse(tt.config)}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			live, err := liveProject(ctx, clients, "staging")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, topic := range live.Topics {
+				got = append(got, "topic "+topic.ID)
+				for _, s := range topic.Subscriptions {
+					got = append(got, "subscription "+s.ID)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the live resources %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
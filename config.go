@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// loadProjects returns the projects that are defined by the environment
+// variables, followed by the projects of the -config file if it is set, as
+// selected by -only and -exclude and with their prefix applied.
+func loadProjects() ([]provision.Config, error) {
+	projects, err := projectsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if configFile != "" {
+		fileProjects, err := readConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, project := range fileProjects {
+			projects = append(projects, sourcedProject{Config: project, source: configFile})
+		}
+	}
+
+	return selectProjects(projects), nil
+}
+
+// readConfigFile parses the projects of the config file. A config file with
+// the .hcl or .tf extension is parsed as Terraform. The config file can also
+// be an http(s) URL.
+func readConfigFile(filename string) ([]provision.Config, error) {
+	if isConfigURL(filename) {
+		return loadConfigURL(filename)
+	}
+
+	if ext := filepath.Ext(filename); ext == ".hcl" || ext == ".tf" {
+		return readHCLProjects(filename)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open config file %q: %s", filename, err)
+	}
+	defer f.Close()
+
+	projects, err := readProjects(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return projects, nil
+}
+
+// readHCLProjects parses the projects of a Terraform file.
+func readHCLProjects(filename string) ([]provision.Config, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config file %q: %s", filename, err)
+	}
+
+	return parseHCLProjects(filename, src)
+}
+
+// parseHCLProjects parses the projects of a Terraform config.
+func parseHCLProjects(filename string, src []byte) ([]provision.Config, error) {
+	return newParser().ParseHCL(filename, src)
+}
+
+// readProjects parses one project definition per non-empty line. Lines that
+// start with a # are comments.
+func readProjects(r io.Reader) ([]provision.Config, error) {
+	var projects []provision.Config
+
+	parser := newParser()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		project, err := parser.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, project)
+	}
+
+	return projects, scanner.Err()
+}
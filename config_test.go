@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadProjectsSelection(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "projects.conf")
+	content := "# shared projects\nanalytics,pageviews\n\nwarehouse,loads:loads-bq\n"
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"PUBSUB_PROJECT1": "frontend,clicks",
+		"PUBSUB_PROJECT2": "backend,jobs",
+		"PUBSUB_PREFIX_2": "be-",
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "all", args: []string{"-config", configFile}, want: []string{"frontend/clicks", "backend/be-jobs", "analytics/pageviews", "warehouse/loads"}},
+		{name: "only by key", args: []string{"-config", configFile, "-only", "2"}, want: []string{"backend/be-jobs"}},
+		{name: "only by project ID", args: []string{"-config", configFile, "-only", "frontend, warehouse"}, want: []string{"frontend/clicks", "warehouse/loads"}},
+		{name: "exclude", args: []string{"-config", configFile, "-exclude", "1,analytics"}, want: []string{"backend/be-jobs", "warehouse/loads"}},
+		{name: "exclude overrides only", args: []string{"-config", configFile, "-only", "backend,analytics", "-exclude", "analytics"}, want: []string{"backend/be-jobs"}},
+		{name: "global prefix for config file", args: []string{"-config", configFile, "-prefix", "ci-", "-only", "2,warehouse"}, want: []string{"backend/be-jobs", "warehouse/ci-loads"}},
+		{name: "nothing selected", args: []string{"-only", "analytics"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.args...)
+			for name, value := range env {
+				t.Setenv(name, value)
+			}
+
+			projects, err := loadProjects()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var got []string
+			for _, project := range projects {
+				got = append(got, project.ProjectID+"/"+project.Topics[0].ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileFormats(t *testing.T) {
+	const terraform = `
+provider "google" {
+  project = "media"
+}
+
+resource "google_pubsub_topic" "uploads" {
+  name = "uploads"
+}
+`
+
+	tests := []struct {
+		filename string
+		content  string
+		want     []string
+		err      string
+	}{
+		{filename: "infra.tf", content: terraform, want: []string{"media/uploads"}},
+		{filename: "pubsub.hcl", content: terraform, want: []string{"media/uploads"}},
+		{filename: "projects.conf", content: "media,thumbnails\n", want: []string{"media/thumbnails"}},
+		{filename: "projects.conf", content: terraform, err: "projects.conf:"},
+		{filename: "projects.tf", content: "media,thumbnails\n", err: "Unable to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.filename)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			projects, err := readConfigFile(path)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var got []string
+			for _, project := range projects {
+				got = append(got, project.ProjectID+"/"+project.Topics[0].ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+const (
+	// configURLTimeout bounds every attempt to fetch a config URL, and
+	// configURLAttempts is the number of attempts.
+	configURLTimeout  = 10 * time.Second
+	configURLAttempts = 3
+
+	// maxConfigSize is the maximum size of a config that is fetched from a
+	// URL.
+	maxConfigSize = 10 << 20
+)
+
+// configHTTPClient fetches the config URLs. It verifies the certificates of
+// https URLs with the system roots, and requires TLS 1.2 or later.
+var configHTTPClient = &http.Client{
+	Timeout: configURLTimeout,
+	Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	},
+}
+
+// isConfigURL reports whether the -config refers to an http(s) URL instead of
+// a file.
+func isConfigURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
+// loadConfigURL fetches the config from the URL and parses its projects. It
+// is parsed as Terraform if its content type or the extension of its path
+// says so.
+func loadConfigURL(rawURL string) ([]provision.Config, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse config URL %q: %s", rawURL, err)
+	}
+
+	src, contentType, err := fetchConfig(u)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext := path.Ext(u.Path); ext == ".hcl" || ext == ".tf" || strings.Contains(mediaType, "hcl") || strings.Contains(mediaType, "terraform") {
+		return parseHCLProjects(u.Redacted(), src)
+	}
+
+	projects, err := readProjects(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", u.Redacted(), err)
+	}
+
+	return projects, nil
+}
+
+// fetchConfig fetches the config at the URL, and returns it with its content
+// type. Network errors, 429 and 5xx statuses are retried.
+func fetchConfig(u *url.URL) ([]byte, string, error) {
+	var err error
+	for attempt := 1; attempt <= configURLAttempts; attempt++ {
+		if attempt > 1 {
+			delay := time.Duration(attempt-1) * time.Second
+			debugf("Fetching config URL %s failed, retrying in %s: %s", u.Redacted(), delay, err)
+			time.Sleep(delay)
+		}
+
+		var (
+			src         []byte
+			contentType string
+			retry       bool
+		)
+		src, contentType, retry, err = fetchConfigOnce(u)
+		if err == nil {
+			return src, contentType, nil
+		}
+		if !retry {
+			break
+		}
+	}
+
+	return nil, "", fmt.Errorf("Unable to fetch config URL %s: %s", u.Redacted(), err)
+}
+
+// fetchConfigOnce makes a single attempt to fetch the config at the URL. If it
+// fails, it reports whether the error might be transient.
+func fetchConfigOnce(u *url.URL) (src []byte, contentType string, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configURLTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := configHTTPClient.Do(req)
+	if err != nil {
+		return nil, "", true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, "", retry, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if resp.ContentLength > maxConfigSize {
+		return nil, "", false, fmt.Errorf("the config of %d bytes exceeds the maximum of %d bytes", resp.ContentLength, maxConfigSize)
+	}
+
+	src, err = io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	switch {
+	case err != nil:
+		return nil, "", true, err
+	case len(src) > maxConfigSize:
+		return nil, "", false, fmt.Errorf("the config exceeds the maximum of %d bytes", maxConfigSize)
+	}
+
+	return src, resp.Header.Get("Content-Type"), false, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// projectPaths returns the project/topic/subscription paths of the projects.
+func projectPaths(projects []provision.Config) []string {
+	var paths []string
+	for _, project := range projects {
+		for _, topic := range project.Topics {
+			path := project.ProjectID + "/" + topic.ID
+			for _, sub := range topic.Subscriptions {
+				path += "/" + sub.ID
+			}
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// configServer serves the responses in order, repeating the last one, and
+// records the User-Agent of every request.
+type configServer struct {
+	responses []func(w http.ResponseWriter)
+
+	mu         sync.Mutex
+	userAgents []string
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.userAgents = append(s.userAgents, r.UserAgent())
+	respond := s.responses[min(len(s.userAgents), len(s.responses))-1]
+	s.mu.Unlock()
+
+	respond(w)
+}
+
+func serveBody(contentType, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Write([]byte(body))
+	}
+}
+
+func serveStatus(code int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func TestIsConfigURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://config.example.com/pubsub.conf": true,
+		"http://localhost:8080/topics":           true,
+		"topics.conf":                            false,
+		"/etc/pubsubc/https.conf":                false,
+		"ftp://config.example.com/pubsub.conf":   false,
+		"HTTPS-topics.conf":                      false,
+	}
+
+	for value, want := range tests {
+		if got := isConfigURL(value); got != want {
+			t.Errorf("%q: expected %t, got %t", value, want, got)
+		}
+	}
+}
+
+func TestLoadConfigURL(t *testing.T) {
+	const terraform = `
+provider "google" {
+  project = "media"
+}
+
+resource "google_pubsub_topic" "uploads" {
+  name = "uploads"
+}
+
+resource "google_pubsub_subscription" "uploads_thumbnailer" {
+  name  = "uploads-thumbnailer"
+  topic = google_pubsub_topic.uploads.name
+}
+`
+
+	tests := []struct {
+		name      string
+		path      string
+		userinfo  *url.Userinfo
+		responses []func(w http.ResponseWriter)
+		tls       bool
+		want      []string
+		requests  int
+		err       string
+	}{
+		{
+			name:      "plain config",
+			path:      "/pubsub/topics.conf",
+			responses: []func(w http.ResponseWriter){serveBody("text/plain; charset=utf-8", "# billing\nbilling,invoices:invoices-pdf\n\nledger,entries\n")},
+			want:      []string{"billing/invoices/invoices-pdf", "ledger/entries"},
+			requests:  1,
+		},
+		{
+			name:      "terraform by extension",
+			path:      "/infra/pubsub.tf",
+			responses: []func(w http.ResponseWriter){serveBody("text/plain", terraform)},
+			want:      []string{"media/uploads/uploads-thumbnailer"},
+			requests:  1,
+		},
+		{
+			name:      "terraform by content type",
+			path:      "/configs/media",
+			responses: []func(w http.ResponseWriter){serveBody("application/hcl; charset=utf-8", terraform)},
+			want:      []string{"media/uploads/uploads-thumbnailer"},
+			requests:  1,
+		},
+		{
+			name: "transient statuses are retried",
+			path: "/pubsub/flaky.conf",
+			responses: []func(w http.ResponseWriter){
+				serveStatus(http.StatusTooManyRequests),
+				serveStatus(http.StatusBadGateway),
+				serveBody("", "search,queries:queries-log\n"),
+			},
+			want:     []string{"search/queries/queries-log"},
+			requests: 3,
+		},
+		{
+			name:      "gives up after three attempts",
+			path:      "/pubsub/down.conf",
+			responses: []func(w http.ResponseWriter){serveStatus(http.StatusServiceUnavailable)},
+			requests:  3,
+			err:       "unexpected status 503 Service Unavailable",
+		},
+		{
+			name:      "not found is not retried",
+			path:      "/pubsub/missing.conf",
+			responses: []func(w http.ResponseWriter){serveStatus(http.StatusNotFound)},
+			requests:  1,
+			err:       "unexpected status 404 Not Found",
+		},
+		{
+			name:      "credentials are redacted",
+			path:      "/private/pubsub.conf",
+			userinfo:  url.UserPassword("deployer", "hunter2"),
+			responses: []func(w http.ResponseWriter){serveStatus(http.StatusForbidden)},
+			requests:  1,
+			err:       "deployer:xxxxx@",
+		},
+		{
+			name: "content length over the maximum",
+			path: "/pubsub/huge.conf",
+			responses: []func(w http.ResponseWriter){func(w http.ResponseWriter) {
+				w.Header().Set("Content-Length", strconv.Itoa(maxConfigSize+1))
+				w.Write(bytes.Repeat([]byte("#"), maxConfigSize+1))
+			}},
+			requests: 1,
+			err:      "the config of 10485761 bytes exceeds the maximum of 10485760 bytes",
+		},
+		{
+			name: "streamed body over the maximum",
+			path: "/pubsub/stream.conf",
+			responses: []func(w http.ResponseWriter){func(w http.ResponseWriter) {
+				w.(http.Flusher).Flush()
+				line := []byte("# padding\n")
+				for n := 0; n <= maxConfigSize; n += len(line) {
+					if _, err := w.Write(line); err != nil {
+						return
+					}
+				}
+			}},
+			requests: 1,
+			err:      "the config exceeds the maximum of 10485760 bytes",
+		},
+		{
+			name:      "invalid config is prefixed with the URL",
+			path:      "/pubsub/broken.conf",
+			responses: []func(w http.ResponseWriter){serveBody("", "warehouse,loads:\n")},
+			requests:  1,
+			err:       "/pubsub/broken.conf: ",
+		},
+		{
+			name:      "untrusted certificate",
+			path:      "/pubsub/secure.conf",
+			responses: []func(w http.ResponseWriter){serveBody("", "vault,secrets\n")},
+			tls:       true,
+			requests:  0,
+			err:       "certificate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &configServer{responses: tt.responses}
+			var srv *httptest.Server
+			if tt.tls {
+				srv = httptest.NewUnstartedServer(handler)
+				srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+				srv.StartTLS()
+			} else {
+				srv = httptest.NewServer(handler)
+			}
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u.User = tt.userinfo
+
+			projects, err := loadConfigURL(u.String())
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error with %q, got %v", tt.err, err)
+			}
+			if err != nil && tt.userinfo != nil {
+				if password, _ := tt.userinfo.Password(); strings.Contains(err.Error(), password) {
+					t.Errorf("expected the password to be redacted from %q", err)
+				}
+			}
+
+			if got := projectPaths(projects); !slices.Equal(got, tt.want) {
+				t.Errorf("expected the projects %v, got %v", tt.want, got)
+			}
+
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			if len(handler.userAgents) != tt.requests {
+				t.Errorf("expected %d requests, got %d", tt.requests, len(handler.userAgents))
+			}
+			for _, ua := range handler.userAgents {
+				if ua != userAgent {
+					t.Errorf("expected the User-Agent %q, got %q", userAgent, ua)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigURLTrustedCertificate(t *testing.T) {
+	handler := &configServer{responses: []func(w http.ResponseWriter){serveBody("", "vault,secrets:secrets-rotate\n")}}
+	srv := httptest.NewTLSServer(handler)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	transport := configHTTPClient.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.RootCAs = roots
+
+	saved := configHTTPClient
+	configHTTPClient = &http.Client{Timeout: saved.Timeout, Transport: transport}
+	t.Cleanup(func() { configHTTPClient = saved })
+
+	projects, err := loadConfigURL(srv.URL + "/pubsub/secure.conf")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := projectPaths(projects), []string{"vault/secrets/secrets-rotate"}; !slices.Equal(got, want) {
+		t.Errorf("expected the projects %v, got %v", want, got)
+	}
+
+	if min := transport.TLSClientConfig.MinVersion; min != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 as the minimum version, got %x", min)
+	}
+}
+
+func TestLoadProjectsConfigURL(t *testing.T) {
+	srv := httptest.NewServer(&configServer{responses: []func(w http.ResponseWriter){
+		serveBody("text/plain", "payments,refunds:refunds-audit\nnotifications,sms\n"),
+	}})
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "after the environment", args: []string{"-config", srv.URL + "/topics"}, want: []string{"frontend/clicks", "payments/refunds/refunds-audit", "notifications/sms"}},
+		{name: "only from the URL", args: []string{"-config", srv.URL + "/topics", "-only", "notifications"}, want: []string{"notifications/sms"}},
+		{name: "with a prefix", args: []string{"-config", srv.URL + "/topics", "-prefix", "pr-12-", "-exclude", "1"}, want: []string{"payments/pr-12-refunds/pr-12-refunds-audit", "notifications/pr-12-sms"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.args...)
+			t.Setenv("PUBSUB_PROJECT1", "frontend,clicks")
+
+			if got := projectPaths(mustLoadProjects(t)); !slices.Equal(got, tt.want) {
+				t.Errorf("expected the projects %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
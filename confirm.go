@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// confirmDeletion lists what is about to be deleted and asks for confirmation
+// on stdin, unless -yes is set or the PubSub service is a local emulator. It
+// returns an error if the deletion is not confirmed, or if stdin is not a
+// terminal to ask on.
+func confirmDeletion(deletions []string) error {
+	if yes || localEmulator() || len(deletions) == 0 {
+		return nil
+	}
+
+	endpoint := "the PubSub service"
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		endpoint = fmt.Sprintf("the emulator at %s", host)
+	}
+
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("Refusing to delete from %s without confirmation, use -yes to delete anyway", endpoint)
+	}
+
+	fmt.Printf("This deletes from %s:\n", endpoint)
+	for _, deletion := range deletions {
+		fmt.Printf("  %s\n", deletion)
+	}
+	fmt.Print("Continue? [y/N] ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("Unable to read the confirmation: %s", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+
+	return fmt.Errorf("The deletion was not confirmed")
+}
+
+// localEmulator reports whether PUBSUB_EMULATOR_HOST refers to an emulator on
+// the local machine.
+func localEmulator() bool {
+	host := os.Getenv("PUBSUB_EMULATOR_HOST")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isTerminal reports whether the file is a terminal. Tests replace it to
+// answer the confirmation from a file.
+var isTerminal = func(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// resourceNames returns the type and name of every resource.
+func resourceNames(resources []provision.Resource) []string {
+	names := make([]string, 0, len(resources))
+	for _, r := range resources {
+		names = append(names, r.Type+" "+r.Name)
+	}
+
+	return names
+}
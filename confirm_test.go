@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/grpc"
+)
+
+// scriptStdin replaces stdin with a terminal that answers with the specified
+// input.
+func scriptStdin(t *testing.T, input string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, terminal := os.Stdin, isTerminal
+	os.Stdin, isTerminal = f, func(*os.File) bool { return true }
+	t.Cleanup(func() {
+		os.Stdin, isTerminal = stdin, terminal
+		f.Close()
+	})
+}
+
+func TestConfirmDeletion(t *testing.T) {
+	deletions := []string{"subscription projects/billing/subscriptions/invoices-pdf", "topic projects/billing/topics/invoices"}
+
+	tests := []struct {
+		name  string
+		host  string
+		yes   bool
+		input string
+		// terminal is false if stdin is not a terminal.
+		terminal bool
+		prompt   bool
+		err      string
+	}{
+		{name: "confirmed", host: "pubsub.staging.internal:8085", terminal: true, input: "y\n", prompt: true},
+		{name: "confirmed in full", host: "pubsub.staging.internal:8085", terminal: true, input: "  YES \n", prompt: true},
+		{name: "confirmed without a newline", host: "10.20.0.4:8085", terminal: true, input: "y", prompt: true},
+		{name: "denied", host: "pubsub.staging.internal:8085", terminal: true, input: "n\n", prompt: true, err: "The deletion was not confirmed"},
+		{name: "denied by default", host: "pubsub.staging.internal:8085", terminal: true, input: "\n", prompt: true, err: "The deletion was not confirmed"},
+		{name: "no answer", host: "pubsub.staging.internal:8085", terminal: true, prompt: true, err: "Unable to read the confirmation: EOF"},
+		{name: "not a terminal", host: "pubsub.staging.internal:8085", err: "Refusing to delete from the emulator at pubsub.staging.internal:8085 without confirmation, use -yes to delete anyway"},
+		{name: "production", err: "Refusing to delete from the PubSub service without confirmation"},
+		{name: "yes", host: "pubsub.staging.internal:8085", yes: true},
+		{name: "localhost", host: "localhost:8085"},
+		{name: "loopback", host: "127.0.0.1:8681"},
+		{name: "IPv6 loopback", host: "[::1]:8085"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PUBSUB_EMULATOR_HOST", tt.host)
+			if tt.host == "" {
+				os.Unsetenv("PUBSUB_EMULATOR_HOST")
+			}
+
+			yes = tt.yes
+			t.Cleanup(func() { yes = false })
+
+			if tt.terminal {
+				scriptStdin(t, tt.input)
+			} else {
+				terminal := isTerminal
+				isTerminal = func(*os.File) bool { return false }
+				t.Cleanup(func() { isTerminal = terminal })
+			}
+
+			var err error
+			out := captureStdout(t, func() { err = confirmDeletion(deletions) })
+
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("expected an error starting with %q, got %v", tt.err, err)
+			}
+
+			want := "This deletes from the emulator at " + tt.host + ":\n" +
+				"  subscription projects/billing/subscriptions/invoices-pdf\n" +
+				"  topic projects/billing/topics/invoices\n" +
+				"Continue? [y/N] "
+			if !tt.prompt {
+				want = ""
+			}
+			if out != want {
+				t.Errorf("expected the prompt %q, got %q", want, out)
+			}
+		})
+	}
+}
+
+func TestRunDeleteConfirmation(t *testing.T) {
+	// Serve the emulator on an address that is not a loopback address, so
+	// that the deletion must be confirmed.
+	var host string
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ip, ok := addr.(*net.IPNet); ok && !ip.IP.IsLoopback() && ip.IP.To4() != nil {
+			host = ip.IP.String()
+			break
+		}
+	}
+	if host == "" {
+		t.Skip("no address that is not a loopback address")
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		deleted bool
+	}{
+		{name: "confirmed", input: "yes\n", deleted: true},
+		{name: "denied", input: "no\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := pstest.NewServer()
+			t.Cleanup(func() { srv.Close() })
+
+			gsrv := grpc.NewServer()
+			pubsubpb.RegisterPublisherServer(gsrv, &srv.GServer)
+			pubsubpb.RegisterSubscriberServer(gsrv, &srv.GServer)
+
+			lis, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+			if err != nil {
+				t.Skipf("unable to listen on %s: %s", host, err)
+			}
+			go gsrv.Serve(lis)
+			t.Cleanup(gsrv.Stop)
+			t.Setenv("PUBSUB_EMULATOR_HOST", lis.Addr().String())
+
+			setFlags(t)
+			cfg, err := (&provision.Parser{}).Parse("billing,invoices:invoices-pdf")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			clients := &provision.ClientCache{ShareConnection: true}
+			t.Cleanup(func() { clients.Close() })
+			if _, err := (&provision.Provisioner{Clients: clients}).Create(ctx, []provision.Config{cfg}); err != nil {
+				t.Fatal(err)
+			}
+
+			scriptStdin(t, tt.input)
+			out := captureStdout(t, func() { err = runDelete(ctx, []provision.Config{cfg}) })
+			if tt.deleted != (err == nil) {
+				t.Errorf("expected the deletion to succeed to be %t, got %v", tt.deleted, err)
+			}
+			if !strings.Contains(out, "topic projects/billing/topics/invoices\n") {
+				t.Errorf("expected the prompt to list the topic, got %q", out)
+			}
+
+			client, err := clients.Client(ctx, "billing")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := client.Topic("invoices").Exists(ctx); err != nil || ok == tt.deleted {
+				t.Errorf("expected the topic to exist to be %t, got %t (%v)", !tt.deleted, ok, err)
+			}
+		})
+	}
+}
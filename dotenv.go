@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadEnvFile sets the environment variables that are defined in a dotenv
+// file. Variables that are already set in the environment take precedence.
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Unable to open env file: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		key, value, ok, err := parseEnvLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if _, exists := os.LookupEnv(key); exists {
+			debugf("Env file %s: %s is already set", path, key)
+			continue
+		}
+
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("%s:%d: Unable to set %s: %s", path, line, key, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Unable to read env file: %s", err)
+	}
+
+	return nil
+}
+
+// parseEnvLine parses a line of a dotenv file of the form
+// "[export] KEY=value". The value is either unquoted, in which case a # that
+// follows whitespace starts a comment, single quoted, which is taken
+// literally, or double quoted, which supports \n, \t, \" and \\ escapes. It
+// returns false for empty lines and comments.
+func parseEnvLine(line string) (key, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false, nil
+	}
+
+	line = strings.TrimPrefix(line, "export ")
+
+	key, value, found := strings.Cut(line, "=")
+	if !found {
+		return "", "", false, fmt.Errorf("expected KEY=value, got %q", line)
+	}
+
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false, fmt.Errorf("invalid variable name %q", key)
+	}
+
+	value = strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", "", false, fmt.Errorf("%s: unterminated single quote", key)
+		}
+
+		return key, value[1 : end+1], true, nil
+
+	case strings.HasPrefix(value, `"`):
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == '"':
+				return key, b.String(), true, nil
+
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[i])
+				}
+
+			default:
+				b.WriteByte(c)
+			}
+		}
+
+		return "", "", false, fmt.Errorf("%s: unterminated double quote", key)
+	}
+
+	// Strip a trailing comment from an unquoted value.
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			value = strings.TrimSpace(value[:i])
+			break
+		}
+	}
+
+	return key, value, true, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		key   string
+		value string
+		skip  bool
+		err   string
+	}{
+		{line: "PUBSUB_PROJECT1=orders,created", key: "PUBSUB_PROJECT1", value: "orders,created"},
+		{line: "  export PUBSUB_EMULATOR_HOST = localhost:8085  ", key: "PUBSUB_EMULATOR_HOST", value: "localhost:8085"},
+		{line: "PUBSUB_PREFIX=ci- # per branch", key: "PUBSUB_PREFIX", value: "ci-"},
+		{line: "PUBSUB_DEFAULT_FILTER=attributes.tag#1", key: "PUBSUB_DEFAULT_FILTER", value: "attributes.tag#1"},
+		{line: `KEY='it''s # literal \n'`, key: "KEY", value: "it"},
+		{line: `KEY='a # b \n'`, key: "KEY", value: `a # b \n`},
+		{line: `KEY="line1\nline2\t\"quoted\" \\"`, key: "KEY", value: "line1\nline2\t\"quoted\" \\"},
+		{line: "EMPTY=", key: "EMPTY", value: ""},
+		{line: "", skip: true},
+		{line: "   # a comment", skip: true},
+		{line: "NOVALUE", err: `expected KEY=value, got "NOVALUE"`},
+		{line: "MY KEY=value", err: `invalid variable name "MY KEY"`},
+		{line: "=value", err: `invalid variable name ""`},
+		{line: "KEY='open", err: "KEY: unterminated single quote"},
+		{line: `KEY="open`, err: "KEY: unterminated double quote"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			key, value, ok, err := parseEnvLine(tt.line)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case ok == tt.skip:
+				t.Fatalf("expected the line to be skipped to be %t", tt.skip)
+			case key != tt.key || value != tt.value:
+				t.Errorf("expected %s=%q, got %s=%q", tt.key, tt.value, key, value)
+			}
+		})
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "# pubsubc\nPUBSUB_PROJECT1=orders,created\nexport PUBSUB_PROJECT2=\"refunds,issued\"\n\nPUBSUB_PREFIX=from-file-\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The variables that the file sets are restored once the test ends.
+	setFlags(t)
+	for _, name := range []string{"PUBSUB_PROJECT1", "PUBSUB_PROJECT2"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("PUBSUB_PREFIX", "from-env-")
+
+	if err := loadEnvFile(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for name, want := range map[string]string{
+		"PUBSUB_PROJECT1": "orders,created",
+		"PUBSUB_PROJECT2": "refunds,issued",
+		"PUBSUB_PREFIX":   "from-env-",
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("expected %s=%q, got %q", name, want, got)
+		}
+	}
+
+	// The line number of an invalid line is reported.
+	if err := os.WriteFile(path, []byte("A=1\nB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadEnvFile(path); err == nil || !strings.Contains(err.Error(), path+":2: expected KEY=value") {
+		t.Errorf("expected an error for line 2, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// probeInterval is the time between two attempts to reach the emulator.
+const probeInterval = 500 * time.Millisecond
+
+// waitForEmulator resolves the emulator host and probes its TCP port before
+// any gRPC connection is attempted. It keeps retrying until the specified
+// timeout expires, which makes it possible to start pubsubc alongside the
+// emulator in docker-compose.
+func waitForEmulator(ctx context.Context, host string, timeout time.Duration) error {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return fmt.Errorf("PUBSUB_EMULATOR_HOST: invalid host %q: %s", host, err)
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for attempt := 1; ; attempt++ {
+		err := probeEmulator(ctx, hostname, port)
+		if err == nil {
+			debugf("Emulator reachable at %s", host)
+			return nil
+		}
+
+		if !time.Now().Add(probeInterval).Before(deadline) {
+			return fmt.Errorf("Emulator not reachable at %s, -wait of %s exhausted after %d attempt(s) in %s: %s", host, timeout, attempt, since(start), err)
+		}
+
+		debugf("Emulator not reachable at %s yet: %s", host, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Emulator not reachable at %s, %s after %d attempt(s) in %s: %s", host, ctx.Err(), attempt, since(start), err)
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// since returns the time since start, rounded to milliseconds.
+func since(start time.Time) time.Duration {
+	return time.Since(start).Round(time.Millisecond)
+}
+
+// probeEmulator resolves the hostname and opens a TCP connection to the port.
+func probeEmulator(ctx context.Context, hostname, port string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, hostname)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %q: %s", hostname, err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// waitReady waits until the PubSub service behind the client serves requests,
+// retrying until the specified timeout expires. The emulator accepts
+// connections before it is fully ready, so this performs an actual request
+// instead of only connecting.
+func waitReady(ctx context.Context, client *pubsub.Client, timeout time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for attempt := 1; ; attempt++ {
+		err := probeReady(ctx, client)
+		if err == nil {
+			debugf("Readiness probe %d succeeded", attempt)
+			return nil
+		}
+
+		if !time.Now().Add(probeInterval).Before(deadline) {
+			return fmt.Errorf("PubSub service not ready, -wait of %s exhausted after %d readiness probe(s) in %s: %s", timeout, attempt, since(start), err)
+		}
+
+		debugf("Readiness probe %d failed: %s", attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("PubSub service not ready, %s after %d readiness probe(s) in %s: %s", ctx.Err(), attempt, since(start), err)
+		case <-time.After(probeInterval):
+		}
+	}
+}
+
+// probeReady lists the topics of the client's project.
+func probeReady(ctx context.Context, client *pubsub.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := client.Topics(ctx).Next(); err != nil && err != iterator.Done {
+		return err
+	}
+
+	return nil
+}
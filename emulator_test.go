@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestWaitForEmulator(t *testing.T) {
+	// closedAddr returns an address that nothing listens on.
+	closedAddr := func(t *testing.T) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		return addr
+	}
+
+	tests := []struct {
+		name string
+		// host returns the emulator host, and starts listening on it after
+		// the delay, if it is not negative.
+		host  func(t *testing.T) string
+		delay time.Duration
+		wait  time.Duration
+		err   string
+	}{
+		{name: "reachable", host: closedAddr, delay: 0},
+		{name: "reachable by name", host: func(t *testing.T) string {
+			_, port, _ := net.SplitHostPort(closedAddr(t))
+			return net.JoinHostPort("localhost", port)
+		}, delay: 0},
+		{name: "reachable later", host: closedAddr, delay: 700 * time.Millisecond, wait: 5 * time.Second},
+		{name: "unreachable", host: closedAddr, delay: -1, err: "-wait of 0s exhausted after 1 attempt(s)"},
+		{name: "unreachable with wait", host: closedAddr, delay: -1, wait: 1200 * time.Millisecond, err: "-wait of 1.2s exhausted after"},
+		{name: "invalid host", host: func(*testing.T) string { return "emulator" }, delay: -1, err: `PUBSUB_EMULATOR_HOST: invalid host "emulator"`},
+		{name: "unknown name", host: func(*testing.T) string { return "pubsub-emulator.invalid:8085" }, delay: -1, err: `unable to resolve "pubsub-emulator.invalid"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := tt.host(t)
+			if tt.delay >= 0 {
+				listener := make(chan net.Listener, 1)
+				t.Cleanup(func() {
+					select {
+					case l := <-listener:
+						l.Close()
+					default:
+					}
+				})
+
+				go func() {
+					time.Sleep(tt.delay)
+					_, port, _ := net.SplitHostPort(host)
+					if l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port)); err == nil {
+						listener <- l
+					}
+				}()
+				if tt.delay == 0 {
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
+
+			err := waitForEmulator(context.Background(), host, tt.wait)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// reactorFunc adapts a function to a pstest.Reactor.
+type reactorFunc func(req interface{}) (bool, interface{}, error)
+
+func (f reactorFunc) React(req interface{}) (bool, interface{}, error) {
+	return f(req)
+}
+
+// newTestServer starts a pstest server with the specified reactors, which the
+// clients of pubsubc connect to through PUBSUB_EMULATOR_HOST.
+func newTestServer(t *testing.T, opts ...pstest.ServerReactorOption) *pstest.Server {
+	t.Helper()
+
+	srv := pstest.NewServer(opts...)
+	t.Cleanup(func() { srv.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+	return srv
+}
+
+// newTestClients is like newTestServer, but returns the clients of the server.
+func newTestClients(t *testing.T, opts ...pstest.ServerReactorOption) *provision.ClientCache {
+	t.Helper()
+
+	newTestServer(t, opts...)
+
+	clients := &provision.ClientCache{ShareConnection: true}
+	t.Cleanup(func() { clients.Close() })
+
+	return clients
+}
+
+func TestWaitReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		wait     time.Duration
+		err      string
+	}{
+		{name: "ready", failures: 0, wait: time.Second},
+		{name: "ready after probes", failures: 2, wait: 5 * time.Second},
+		{name: "never ready", failures: 100, wait: 800 * time.Millisecond, err: "PubSub service not ready, -wait of 800ms exhausted after 2 readiness probe(s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server fails the first probes, like an emulator that
+			// accepts connections before it serves requests.
+			var probes int
+			notReady := reactorFunc(func(interface{}) (bool, interface{}, error) {
+				if probes++; probes <= tt.failures {
+					return true, nil, status.Error(codes.FailedPrecondition, "starting")
+				}
+				return false, nil, nil
+			})
+
+			srv := pstest.NewServer(pstest.ServerReactorOption{FuncName: "ListTopics", Reactor: notReady})
+			defer srv.Close()
+
+			conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := pubsub.NewClient(context.Background(), "project1", option.WithGRPCConn(conn))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			err = waitReady(context.Background(), client, tt.wait)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			case tt.err == "" && probes != tt.failures+1:
+				t.Errorf("expected %d readiness probes, got %d", tt.failures+1, probes)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// envVar is an environment variable whose name matched a pattern.
+type envVar struct {
+	name  string
+	value string
+
+	// key is the part of the name that matched the first wildcard or the
+	// first capture group of the pattern.
+	key string
+}
+
+// wildcardRegexp translates a pattern in which * matches any sequence of
+// characters to an anchored regular expression.
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, "(.*)") + "$")
+}
+
+// defaultMatch selects the numbered PUBSUB_PROJECT<n> variables, unless -match
+// or -match-regex select others.
+var defaultMatch = regexp.MustCompile(`^PUBSUB_PROJECT(\d+)$`)
+
+// matchRegexp returns the regular expression that selects the environment
+// variables that define projects.
+func matchRegexp() (*regexp.Regexp, error) {
+	if matchRegex == "" {
+		if match == "" {
+			return defaultMatch, nil
+		}
+
+		return wildcardRegexp(match), nil
+	}
+
+	re, err := regexp.Compile(matchRegex)
+	if err != nil {
+		return nil, fmt.Errorf("-match-regex: %s", err)
+	}
+
+	return re, nil
+}
+
+// getEnvWithWildcard returns the non-empty environment variables whose name
+// matches the regular expression. The variables are sorted by their key, with
+// numeric keys in numerical order.
+func getEnvWithWildcard(re *regexp.Regexp) []envVar {
+	var vars []envVar
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if value == "" {
+			continue
+		}
+
+		m := re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+
+		key := name
+		if len(m) > 1 {
+			key = strings.TrimPrefix(m[1], "_")
+		}
+
+		vars = append(vars, envVar{name: name, value: value, key: key})
+	}
+
+	sort.Slice(vars, func(i, j int) bool {
+		a, errA := strconv.Atoi(vars[i].key)
+		b, errB := strconv.Atoi(vars[j].key)
+		if errA == nil && errB == nil && a != b {
+			return a < b
+		}
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+
+		return vars[i].name < vars[j].name
+	})
+
+	return vars
+}
+
+// sourcedProject is a parsed project along with where it was defined.
+type sourcedProject struct {
+	provision.Config
+
+	// source is the environment variable or config file of the project, and
+	// key the key of its environment variable, if it has one.
+	source string
+	key    string
+}
+
+// projectsFromEnv parses the projects that are defined by the environment
+// variables that match the -match wildcard or -match-regex expression.
+func projectsFromEnv() ([]sourcedProject, error) {
+	re, err := matchRegexp()
+	if err != nil {
+		return nil, err
+	}
+
+	parser := newParser()
+
+	var projects []sourcedProject
+	for _, env := range getEnvWithWildcard(re) {
+		project, err := parser.Parse(env.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", env.name, err)
+		}
+
+		projects = append(projects, sourcedProject{Config: project, source: env.name, key: env.key})
+	}
+
+	return projects, nil
+}
+
+// selectProjects returns the projects that -only and -exclude select, with
+// their prefix applied. With -only, the projects whose key or project ID is
+// not listed are skipped. Projects that -exclude lists are skipped, even if
+// -only lists them as well. The names of a project's resources are prefixed
+// by the PUBSUB_PREFIX_<key> environment variable that matches its key, or by
+// the global prefix if that isn't set.
+func selectProjects(projects []sourcedProject) []provision.Config {
+	selected := listSet(only)
+	excluded := listSet(exclude)
+
+	var configs []provision.Config
+	for _, project := range projects {
+		listed := func(set map[string]bool) bool {
+			return (project.key != "" && set[project.key]) || set[project.ProjectID]
+		}
+
+		if selected != nil && !listed(selected) {
+			debugf("Skipping project %q of %s", project.ProjectID, project.source)
+			continue
+		}
+		if listed(excluded) {
+			debugf("Excluding project %q of %s", project.ProjectID, project.source)
+			continue
+		}
+
+		projectPrefix := prefix
+		if project.key != "" {
+			if p, ok := os.LookupEnv("PUBSUB_PREFIX_" + project.key); ok {
+				projectPrefix = p
+			}
+		}
+
+		configs = append(configs, project.WithPrefix(projectPrefix))
+	}
+
+	return configs
+}
+
+// subscriptionDefaults are the subscription options of the PUBSUB_DEFAULT_*
+// environment variables.
+var subscriptionDefaults []string
+
+// loadDefaults loads the subscription options that the PUBSUB_DEFAULT_*
+// environment variables define, like "ack=30s" for PUBSUB_DEFAULT_ACK=30s.
+// An option of the subscription itself takes precedence over its default,
+// which takes precedence over the default of the PubSub service.
+func loadDefaults() error {
+	for _, env := range getEnvWithWildcard(wildcardRegexp("PUBSUB_DEFAULT_*")) {
+		key := strings.ToLower(env.key)
+		if err := provision.CheckSubscriptionOption(key); err != nil {
+			return fmt.Errorf("%s: %s", env.name, err)
+		}
+
+		subscriptionDefaults = append(subscriptionDefaults, key+"="+env.value)
+	}
+
+	return nil
+}
+
+// newParser returns a config parser that is configured by the flags.
+func newParser() *provision.Parser {
+	return &provision.Parser{AllowEmpty: allowEmpty, ClampDurations: clampDurations, MaxExpansion: maxExpansion, NoExpire: noExpire, SubscriptionDefaults: subscriptionDefaults, Logger: logger{}}
+}
+
+// listSet returns the set of the values in a comma-separated list, or nil if
+// the list is empty.
+func listSet(list string) map[string]bool {
+	var set map[string]bool
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[value] = true
+	}
+
+	return set
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// topicIDs returns the project IDs mapped to the IDs of their topics and their
+// subscriptions, in order.
+func topicIDs(projects []provision.Config) map[string][]string {
+	ids := make(map[string][]string)
+	for _, project := range projects {
+		for _, topic := range project.Topics {
+			ids[project.ProjectID] = append(ids[project.ProjectID], topic.ID)
+			for _, s := range topic.Subscriptions {
+				ids[project.ProjectID] = append(ids[project.ProjectID], s.ID)
+			}
+		}
+	}
+
+	return ids
+}
+
+func TestProjectPrefixes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want map[string][]string
+	}{
+		{
+			name: "no prefix",
+			env:  map[string]string{"PUBSUB_PROJECT1": "billing,invoices:invoices-mailer"},
+			want: map[string][]string{"billing": {"invoices", "invoices-mailer"}},
+		},
+		{
+			name: "global prefix",
+			args: []string{"-prefix", "ci-"},
+			env:  map[string]string{"PUBSUB_PROJECT1": "billing,invoices:invoices-mailer", "PUBSUB_PROJECT2": "shipping,parcels"},
+			want: map[string][]string{"billing": {"ci-invoices", "ci-invoices-mailer"}, "shipping": {"ci-parcels"}},
+		},
+		{
+			name: "per-project prefix",
+			args: []string{"-prefix", "ci-"},
+			env:  map[string]string{"PUBSUB_PROJECT1": "billing,invoices:invoices-mailer", "PUBSUB_PROJECT2": "shipping,parcels", "PUBSUB_PREFIX_2": "team-"},
+			want: map[string][]string{"billing": {"ci-invoices", "ci-invoices-mailer"}, "shipping": {"team-parcels"}},
+		},
+		{
+			name: "empty per-project prefix",
+			args: []string{"-prefix", "ci-"},
+			env:  map[string]string{"PUBSUB_PROJECT1": "billing,invoices", "PUBSUB_PREFIX_1": ""},
+			want: map[string][]string{"billing": {"invoices"}},
+		},
+		{
+			name: "wildcard key",
+			args: []string{"-match", "PUBSUB_PROJECT_*"},
+			env:  map[string]string{"PUBSUB_PROJECT_BILLING": "billing,invoices", "PUBSUB_PREFIX_BILLING": "b-"},
+			want: map[string][]string{"billing": {"b-invoices"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.args...)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			projects, err := loadProjects()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got := topicIDs(projects)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for projectID, ids := range tt.want {
+				if !slices.Equal(got[projectID], ids) {
+					t.Errorf("expected %v for project %q, got %v", ids, projectID, got[projectID])
+				}
+			}
+		})
+	}
+}
+
+func TestProjectsFromEnvMatch(t *testing.T) {
+	env := map[string]string{
+		"PUBSUB_PROJECT1":         "orders,created",
+		"PUBSUB_PROJECT10":        "refunds,issued",
+		"PUBSUB_PROJECT2":         "carts,abandoned",
+		"PUBSUB_PROJECT_LEGACY":   "legacy,events",
+		"PUBSUB_PROJECT_EU_WEST1": "eu,events",
+		"PUBSUB_PROJECTS":         "not,a-project",
+		"APP_PUBSUB_PROJECT":      "app,events",
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		keys []string
+		err  string
+	}{
+		{name: "default", want: []string{"orders", "carts", "refunds"}, keys: []string{"1", "2", "10"}},
+		{name: "wildcard", args: []string{"-match", "PUBSUB_PROJECT*"}, want: []string{"orders", "carts", "refunds", "not", "eu", "legacy"}, keys: []string{"1", "2", "10", "S", "EU_WEST1", "LEGACY"}},
+		{name: "named wildcard", args: []string{"-match", "PUBSUB_PROJECT_*"}, want: []string{"eu", "legacy"}, keys: []string{"EU_WEST1", "LEGACY"}},
+		{name: "regex", args: []string{"-match-regex", `^PUBSUB_PROJECT_([A-Z]+)$`}, want: []string{"legacy"}, keys: []string{"LEGACY"}},
+		{name: "regex without group", args: []string{"-match-regex", `PUBSUB_PROJECT$`}, want: []string{"app"}, keys: []string{"APP_PUBSUB_PROJECT"}},
+		{name: "regex overrides wildcard", args: []string{"-match", "PUBSUB_PROJECT*", "-match-regex", `^PUBSUB_PROJECT(1\d*)$`}, want: []string{"orders", "refunds"}, keys: []string{"1", "10"}},
+		{name: "invalid regex", args: []string{"-match-regex", `PUBSUB_(`}, err: "-match-regex: error parsing regexp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.args...)
+			for name, value := range env {
+				t.Setenv(name, value)
+			}
+
+			projects, err := projectsFromEnv()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var ids, keys []string
+			for _, project := range projects {
+				ids = append(ids, project.ProjectID)
+				keys = append(keys, project.key)
+			}
+			if !slices.Equal(ids, tt.want) || !slices.Equal(keys, tt.keys) {
+				t.Errorf("expected projects %v with keys %v, got %v with %v", tt.want, tt.keys, ids, keys)
+			}
+		})
+	}
+}
+
+func TestSelectProjectsExclude(t *testing.T) {
+	projects := []sourcedProject{
+		{Config: provision.Config{ProjectID: "tenant-a"}, source: "PUBSUB_PROJECT_A", key: "A"},
+		{Config: provision.Config{ProjectID: "tenant-b"}, source: "PUBSUB_PROJECT_B", key: "B"},
+		{Config: provision.Config{ProjectID: "tenant-c"}, source: "tenants.hcl"},
+	}
+
+	tests := []struct {
+		exclude string
+		want    []string
+	}{
+		{exclude: "", want: []string{"tenant-a", "tenant-b", "tenant-c"}},
+		{exclude: "B", want: []string{"tenant-a", "tenant-c"}},
+		{exclude: "tenant-a", want: []string{"tenant-b", "tenant-c"}},
+		{exclude: " A , ,tenant-c ", want: []string{"tenant-b"}},
+		{exclude: "C", want: []string{"tenant-a", "tenant-b", "tenant-c"}},
+		{exclude: "b", want: []string{"tenant-a", "tenant-b", "tenant-c"}},
+		{exclude: "A,B,tenant-c", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exclude, func(t *testing.T) {
+			setFlags(t, "-exclude", tt.exclude)
+
+			var got []string
+			for _, project := range selectProjects(projects) {
+				got = append(got, project.ProjectID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProjectsFromEnvAllowEmpty(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		value  string
+		topics int
+		err    string
+	}{
+		{name: "rejected by default", value: "sandbox", err: "Expected at least 1 topic to be defined"},
+		{name: "allowed", args: []string{"-allow-empty-project"}, value: "sandbox"},
+		{name: "allowed with topics", args: []string{"-allow-empty-project"}, value: "sandbox,scratch,drafts", topics: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, tt.args...)
+			t.Setenv("PUBSUB_PROJECT1", tt.value)
+
+			projects, err := projectsFromEnv()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(projects) != 1 || projects[0].ProjectID != "sandbox" || len(projects[0].Topics) != tt.topics {
+				t.Errorf("expected project %q with %d topic(s), got %+v", "sandbox", tt.topics, projects)
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		project string
+		// want are the ack deadlines and minimum retry backoffs of the
+		// subscriptions, by ID.
+		want map[string][2]time.Duration
+		err  string
+	}{
+		{
+			name:    "library defaults",
+			project: "crm,leads:leads-scorer:leads-sync;ack=45s",
+			want:    map[string][2]time.Duration{"leads-scorer": {0, 0}, "leads-sync": {45 * time.Second, 0}},
+		},
+		{
+			name:    "environment defaults",
+			env:     map[string]string{"PUBSUB_DEFAULT_ACK": "30s", "PUBSUB_DEFAULT_RETRYMIN": "2s"},
+			project: "crm,leads:leads-scorer:leads-sync;ack=45s",
+			want:    map[string][2]time.Duration{"leads-scorer": {30 * time.Second, 2 * time.Second}, "leads-sync": {45 * time.Second, 2 * time.Second}},
+		},
+		{
+			name:    "retry preset overrides the retry defaults",
+			env:     map[string]string{"PUBSUB_DEFAULT_RETRYMIN": "2s", "PUBSUB_DEFAULT_RETRYMAX": "20s"},
+			project: "support,tickets:tickets-router;retrypreset=lenient:tickets-archiver",
+			want:    map[string][2]time.Duration{"tickets-router": {0, time.Minute}, "tickets-archiver": {0, 2 * time.Second}},
+		},
+		{
+			name:    "misspelled option",
+			env:     map[string]string{"PUBSUB_DEFAULT_AKC": "30s"},
+			project: "crm,leads:leads-scorer",
+			err:     `PUBSUB_DEFAULT_AKC: unknown option "akc", did you mean "ack"?`,
+		},
+		{
+			name:    "invalid default",
+			env:     map[string]string{"PUBSUB_DEFAULT_ACK": "2s"},
+			project: "crm,leads:leads-scorer",
+			err:     "ack: 2s below minimum 10s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			t.Setenv("PUBSUB_PROJECT1", tt.project)
+			t.Cleanup(func() { subscriptionDefaults = nil })
+
+			err := loadDefaults()
+			var projects []sourcedProject
+			if err == nil {
+				projects, err = projectsFromEnv()
+			}
+
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got := make(map[string][2]time.Duration)
+			for _, topic := range projects[0].Topics {
+				for _, s := range topic.Subscriptions {
+					got[s.ID] = [2]time.Duration{s.AckDeadline, s.RetryMinBackoff}
+				}
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
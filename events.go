@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// eventLogger prints the progress messages of the provisioner like logger
+// does, and writes every event as a JSON line. When the events go to stdout,
+// the info messages go to stderr, so that stdout only has events.
+type eventLogger struct {
+	logger
+
+	stdout bool
+	mu     sync.Mutex
+	enc    *json.Encoder
+}
+
+func (l *eventLogger) Infof(format string, params ...interface{}) {
+	if l.stdout {
+		logf(os.Stderr, format, params...)
+		return
+	}
+
+	infof(format, params...)
+}
+
+func (l *eventLogger) Event(e provision.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.enc.Encode(e); err != nil {
+		warnf("Unable to write event: %s", err)
+	}
+}
+
+// events receives the events of -events, or is nil if it is not set.
+var events *eventLogger
+
+// openEvents starts writing the events to the specified file, or to stdout if
+// it is "-".
+func openEvents(path string) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Unable to open events file: %s", err)
+		}
+		w = f
+	}
+
+	events = &eventLogger{stdout: path == "-", enc: json.NewEncoder(w)}
+	return nil
+}
+
+// newLogger returns the logger of the provisioner, which also writes the
+// events with -events.
+func newLogger() provision.Logger {
+	if events != nil {
+		return events
+	}
+
+	return logger{}
+}
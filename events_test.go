@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestEventsFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []string
+		// existing is written to the events file before the run.
+		existing string
+		want     []string
+	}{
+		{
+			name:        "one line per created resource",
+			definitions: []string{"kitchen,orders:orders-grill:orders-bar", "frontdesk,checkins"},
+			want: []string{
+				"create subscription projects/kitchen/subscriptions/orders-bar",
+				"create subscription projects/kitchen/subscriptions/orders-grill",
+				"create topic projects/frontdesk/topics/checkins",
+				"create topic projects/kitchen/topics/orders",
+			},
+		},
+		{
+			name:        "appends to an existing file",
+			definitions: []string{"kitchen,tickets"},
+			existing:    `{"time":"2026-10-01T08:00:00Z","action":"create","type":"topic","project":"kitchen","name":"projects/kitchen/topics/orders"}` + "\n",
+			want: []string{
+				"create topic projects/kitchen/topics/orders",
+				"create topic projects/kitchen/topics/tickets",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newTestClients(t)
+
+			setFlags(t)
+			t.Cleanup(func() { events = nil })
+
+			path := filepath.Join(t.TempDir(), "events.jsonl")
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := openEvents(path); err != nil {
+				t.Fatal(err)
+			}
+
+			var projects []provision.Config
+			for _, definition := range tt.definitions {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				projects = append(projects, cfg)
+			}
+
+			if _, err := createProjects(context.Background(), clients, projects); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var got []string
+			for scanner := bufio.NewScanner(f); scanner.Scan(); {
+				var e provision.Event
+				if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+					t.Fatalf("unable to parse the event %s: %s", scanner.Text(), err)
+				}
+				if e.Time.IsZero() {
+					t.Errorf("%s: expected a time", scanner.Text())
+				}
+				got = append(got, e.Action+" "+e.Type+" "+e.Name)
+			}
+			slices.Sort(got)
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the events %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
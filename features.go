@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// featuresOutput is the output of -list-features.
+type featuresOutput struct {
+	Topic        []provision.Option `json:"topic"`
+	Subscription []provision.Option `json:"subscription"`
+}
+
+func newFeaturesOutput() featuresOutput {
+	return featuresOutput{Topic: provision.TopicOptions(), Subscription: provision.SubscriptionOptions()}
+}
+
+func (o featuresOutput) text() string {
+	var sb strings.Builder
+	for _, section := range []struct {
+		title   string
+		options []provision.Option
+	}{
+		{"Topic options, like topic[key=value,key=value]:", o.Topic},
+		{"Subscription options, like topic:subscription;key=value;key=value:", o.Subscription},
+	} {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(section.title + "\n")
+
+		var table strings.Builder
+		w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+		for _, option := range section.options {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", option.Key, option.Value, option.Constraints)
+		}
+		w.Flush()
+
+		// The options without constraints have trailing padding.
+		for _, line := range strings.SplitAfter(table.String(), "\n") {
+			if line != "" {
+				sb.WriteString(strings.TrimRight(line, " \n") + "\n")
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// env lists the PUBSUB_DEFAULT_<option> variables of the subscription
+// options.
+func (o featuresOutput) env() string {
+	var sb strings.Builder
+	for _, option := range o.Subscription {
+		fmt.Fprintf(&sb, "PUBSUB_DEFAULT_%s=%q\n", strings.ToUpper(option.Key), option.Value)
+	}
+
+	return sb.String()
+}
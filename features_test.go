@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestListFeatures(t *testing.T) {
+	tests := []struct {
+		format string
+		// check checks the output against the options of topics and
+		// subscriptions.
+		check func(t *testing.T, out string, topic, subscription []provision.Option)
+	}{
+		{
+			format: "text",
+			check: func(t *testing.T, out string, topic, subscription []provision.Option) {
+				topics, subscriptions, ok := strings.Cut(out, "\nSubscription options")
+				if !ok || !strings.HasPrefix(topics, "Topic options") {
+					t.Fatalf("expected a topic and a subscription section, got:\n%s", out)
+				}
+
+				for _, section := range []struct {
+					text    string
+					options []provision.Option
+				}{{topics, topic}, {subscriptions, subscription}} {
+					lines := strings.Split(strings.TrimSpace(section.text), "\n")[1:]
+					if len(lines) != len(section.options) {
+						t.Errorf("expected %d options, got %d lines", len(section.options), len(lines))
+						continue
+					}
+					for i, option := range section.options {
+						if fields := strings.Fields(lines[i]); len(fields) == 0 || fields[0] != option.Key {
+							t.Errorf("expected line %q to describe %q", lines[i], option.Key)
+						}
+						if !strings.HasSuffix(lines[i], option.Constraints) || strings.HasSuffix(lines[i], " ") {
+							t.Errorf("expected line %q to end with the constraints %q", lines[i], option.Constraints)
+						}
+					}
+				}
+			},
+		},
+		{
+			format: "json",
+			check: func(t *testing.T, out string, topic, subscription []provision.Option) {
+				var got featuresOutput
+				if err := json.Unmarshal([]byte(out), &got); err != nil {
+					t.Fatalf("unable to decode %s: %s", out, err)
+				}
+				if !slices.Equal(got.Topic, topic) || !slices.Equal(got.Subscription, subscription) {
+					t.Errorf("expected %+v and %+v, got %+v", topic, subscription, got)
+				}
+				if strings.Contains(out, `\u003c`) {
+					t.Errorf("expected unescaped value formats, got %s", out)
+				}
+			},
+		},
+		{
+			format: "env",
+			check: func(t *testing.T, out string, _, subscription []provision.Option) {
+				lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+				if len(lines) != len(subscription) {
+					t.Fatalf("expected %d lines, got %d", len(subscription), len(lines))
+				}
+				for i, option := range subscription {
+					if want := "PUBSUB_DEFAULT_" + strings.ToUpper(option.Key) + "="; !strings.HasPrefix(lines[i], want) {
+						t.Errorf("expected line %q to start with %q", lines[i], want)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			setFlags(t, "-list-features", "-output-format", tt.format)
+
+			var err error
+			out := captureStdout(t, func() { err = printFormatted(newFeaturesOutput(), "text") })
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			tt.check(t, out, provision.TopicOptions(), provision.SubscriptionOptions())
+		})
+	}
+
+	// Every option that the subscription defaults accept is listed.
+	for _, option := range provision.SubscriptionOptions() {
+		if err := provision.CheckSubscriptionOption(option.Key); err != nil {
+			t.Errorf("option %q is listed, but not accepted: %s", option.Key, err)
+		}
+	}
+}
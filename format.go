@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prep/pubsubc/provision"
+	"gopkg.in/yaml.v3"
+)
+
+// checkOutputFormat checks the value of -output-format.
+func checkOutputFormat() error {
+	switch outputFormat {
+	case "", "text", "json", "yaml", "env":
+		return nil
+	}
+
+	return fmt.Errorf("-output-format: expected text, json, yaml or env, got %q", outputFormat)
+}
+
+// formattable is the output of a command. It is encoded as is in the json and
+// yaml formats, and formats itself in the text and env formats.
+type formattable interface {
+	text() string
+	env() string
+}
+
+// printFormatted prints the output in the -output-format, or in the default
+// format of the command if it is not set.
+func printFormatted(v formattable, defaultFormat string) error {
+	format := outputFormat
+	if format == "" {
+		format = defaultFormat
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case "yaml":
+		b, err := marshalYAML(v)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(b))
+
+	case "env":
+		fmt.Print(v.env())
+
+	default:
+		fmt.Print(v.text())
+	}
+
+	return nil
+}
+
+// marshalYAML encodes the value as YAML with the keys and order of its JSON
+// encoding.
+func marshalYAML(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+
+	var sb strings.Builder
+	enc := yaml.NewEncoder(&sb)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return []byte(sb.String()), nil
+}
+
+// blockStyle replaces the flow style of the decoded JSON by the block style.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// envConfigs formats the configs as PUBSUB_PROJECT environment variables.
+func envConfigs(configs []provision.Config) string {
+	var sb strings.Builder
+	for i, cfg := range configs {
+		fmt.Fprintf(&sb, "PUBSUB_PROJECT%d=%q\n", i+1, provision.FormatConfig(cfg))
+	}
+
+	return sb.String()
+}
+
+// liveOutput is the live state of the projects of list and export.
+type liveOutput struct {
+	Projects []provision.Plan `json:"projects"`
+
+	configs []provision.Config
+}
+
+func newLiveOutput(configs []provision.Config) liveOutput {
+	out := liveOutput{Projects: make([]provision.Plan, 0, len(configs)), configs: configs}
+	for _, cfg := range configs {
+		out.Projects = append(out.Projects, provision.Describe(cfg))
+	}
+
+	return out
+}
+
+func (o liveOutput) text() string {
+	var sb strings.Builder
+	for _, cfg := range o.configs {
+		fmt.Fprintf(&sb, "Project %q\n", cfg.ProjectID)
+		for _, t := range cfg.Topics {
+			fmt.Fprintf(&sb, "  Topic %q\n", t.ID)
+			for _, s := range t.Subscriptions {
+				fmt.Fprintf(&sb, "    Subscription %q\n", s.ID)
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+func (o liveOutput) env() string { return envConfigs(o.configs) }
+
+// planOutput is the resolved config and the changes of the projects of
+// -dry-run.
+type planOutput struct {
+	Projects []provision.Plan `json:"projects"`
+
+	configs []provision.Config
+}
+
+func (o planOutput) text() string {
+	var sb strings.Builder
+	for _, plan := range o.Projects {
+		for _, c := range plan.Changes {
+			fmt.Fprintln(&sb, c)
+		}
+	}
+
+	return sb.String()
+}
+
+func (o planOutput) env() string { return envConfigs(o.configs) }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestCheckOutputFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		err    string
+	}{
+		{format: ""},
+		{format: "text"},
+		{format: "json"},
+		{format: "yaml"},
+		{format: "env"},
+		{format: "yml", err: `-output-format: expected text, json, yaml or env, got "yml"`},
+		{format: "JSON", err: `-output-format: expected text, json, yaml or env, got "JSON"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			outputFormat = tt.format
+			t.Cleanup(func() { outputFormat = "" })
+
+			err := checkOutputFormat()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Errorf("expected the error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	// The live state of the projects in every format.
+	const (
+		text = "Project \"archive\"\n" +
+			"  Topic \"scans\"\n" +
+			"    Subscription \"scans-ocr\"\n" +
+			"Project \"mail\"\n" +
+			"  Topic \"inbox\"\n"
+		env = "PUBSUB_PROJECT1=\"archive,scans:scans-ocr;ack=30s;ordered\"\n" +
+			"PUBSUB_PROJECT2=\"mail,inbox[retain=2h0m0s]\"\n"
+		yaml = `projects:
+  - project: archive
+    topics:
+      - id: scans
+        options: {}
+        subscriptions:
+          - id: scans-ocr
+            options:
+              ack: 30s
+              expire: 744h0m0s
+              ordered: "true"
+  - project: mail
+    topics:
+      - id: inbox
+        options:
+          retain: 2h0m0s
+        subscriptions: []
+`
+		json = `{"projects":[
+			{"project":"archive","topics":[{"id":"scans","options":{},"subscriptions":[{"id":"scans-ocr","options":{"ack":"30s","expire":"744h0m0s","ordered":"true"}}]}]},
+			{"project":"mail","topics":[{"id":"inbox","options":{"retain":"2h0m0s"},"subscriptions":[]}]}
+		]}`
+	)
+
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, projects []provision.Config) error
+		args []string
+		want string
+	}{
+		{name: "list", run: runList, want: text},
+		{name: "list as json", run: runList, args: []string{"-output-format", "json"}, want: json},
+		{name: "list as yaml", run: runList, args: []string{"-output-format", "yaml"}, want: yaml},
+		{name: "list as env", run: runList, args: []string{"-output-format", "env"}, want: env},
+		{name: "export", run: runExport, want: env},
+		{name: "export as text", run: runExport, args: []string{"-output-format", "text"}, want: text},
+		{name: "export as json", run: runExport, args: []string{"-output-format", "json"}, want: json},
+		{name: "export as yaml", run: runExport, args: []string{"-output-format", "yaml"}, want: yaml},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newTestClients(t)
+
+			setFlags(t, tt.args...)
+
+			var projects []provision.Config
+			for _, definition := range []string{"archive,scans:scans-ocr;ack=30s;ordered", "mail,inbox[retain=2h]"} {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatal(err)
+				}
+				projects = append(projects, cfg)
+			}
+
+			ctx := context.Background()
+			if _, err := (&provision.Provisioner{Clients: clients}).Create(ctx, projects); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			got := captureStdout(t, func() { err = tt.run(ctx, projects) })
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tt.want == json {
+				if !jsonEqual(t, got, tt.want) {
+					t.Errorf("expected %s, got %s", tt.want, got)
+				}
+			} else if got != tt.want {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// healthcheckVar is the name of the environment variables that pass the
+// projects to the healthcheck command.
+const healthcheckVar = "PUBSUBC_HEALTHCHECK"
+
+// healthcheckCommand returns a shell command that runs pubsubc with -check on
+// the specified projects, which exits 0 only if all their topics and
+// subscriptions exist. The projects are passed with their prefix applied, so
+// the command unsets PUBSUB_PREFIX to not apply it twice. Inline push service
+// accounts are kept, because the command can't parse a redacted one.
+func healthcheckCommand(projects []provision.Config) string {
+	args := []string{"env", "-u", "PUBSUB_PREFIX"}
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		args = append(args, shellQuote("PUBSUB_EMULATOR_HOST="+host))
+	}
+
+	for i, project := range projects {
+		args = append(args, shellQuote(fmt.Sprintf("%s%d=%s", healthcheckVar, i+1, provision.FormatConfigUnredacted(project))))
+	}
+
+	args = append(args, shellQuote(os.Args[0]), "create", "-check", "-match-regex", shellQuote("^"+healthcheckVar+"[0-9]+$"))
+	if wait > 0 {
+		args = append(args, "-wait", wait.String())
+	}
+
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes a value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
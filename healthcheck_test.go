@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestHealthcheckCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		live   []string
+		config []string
+		prefix string
+		exit   int
+	}{
+		{
+			name:   "all resources exist",
+			live:   []string{"project1,builds:builds-notifier", "project2,artifacts"},
+			config: []string{"project1,builds:builds-notifier", "project2,artifacts"},
+		},
+		{
+			name:   "missing subscription",
+			live:   []string{"project1,builds"},
+			config: []string{"project1,builds:builds-notifier"},
+			exit:   exitDrift,
+		},
+		{
+			name:   "missing project",
+			live:   []string{"project1,builds:builds-notifier"},
+			config: []string{"project1,builds:builds-notifier", "project2,artifacts"},
+			exit:   exitDrift,
+		},
+		{
+			name:   "prefix",
+			live:   []string{"project1,ci-builds:ci-builds-notifier"},
+			config: []string{"project1,builds:builds-notifier"},
+			prefix: "ci-",
+		},
+		{
+			name:   "unprefixed resources",
+			live:   []string{"project1,builds:builds-notifier"},
+			config: []string{"project1,builds:builds-notifier"},
+			prefix: "ci-",
+			exit:   exitDrift,
+		},
+		{
+			name:   "inline push service account",
+			live:   []string{`project1,deploys:deploys-hook;push="https://hooks.example.com/deploys";pushsa=pusher@acme.iam.gserviceaccount.com`},
+			config: []string{`project1,deploys:deploys-hook;push="https://hooks.example.com/deploys";pushsa=pusher@acme.iam.gserviceaccount.com`},
+		},
+		{
+			name:   "referenced push service account",
+			live:   []string{`project1,deploys:deploys-hook;push="https://hooks.example.com/deploys";pushsa=$HEALTHCHECK_PUSH_SA`},
+			config: []string{`project1,deploys:deploys-hook;push="https://hooks.example.com/deploys";pushsa=$HEALTHCHECK_PUSH_SA`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+
+			clients := newTestClients(t)
+			t.Setenv("HEALTHCHECK_PUSH_SA", "deployer@acme.iam.gserviceaccount.com")
+
+			parse := func(definitions []string, prefix string) []provision.Config {
+				var projects []provision.Config
+				for _, definition := range definitions {
+					cfg, err := (&provision.Parser{}).Parse(definition)
+					if err != nil {
+						t.Fatalf("unable to parse %q: %s", definition, err)
+					}
+					projects = append(projects, cfg.WithPrefix(prefix))
+				}
+				return projects
+			}
+
+			if _, err := (&provision.Provisioner{Clients: clients}).Create(context.Background(), parse(tt.live, "")); err != nil {
+				t.Fatalf("unable to create %q: %s", tt.live, err)
+			}
+
+			// The printed command runs this test binary, which runs pubsubc.
+			t.Setenv("PUBSUBC_TEST_MAIN", "1")
+			t.Setenv("PUBSUB_PREFIX", "ignored-")
+
+			cmd := exec.Command("sh", "-c", healthcheckCommand(parse(tt.config, tt.prefix)))
+			out, err := cmd.CombinedOutput()
+
+			var exit int
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exit = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if exit != tt.exit {
+				t.Errorf("expected exit code %d, got %d:\n%s", tt.exit, exit, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// runPostHook runs the -post-hook command with a shell once the resources are
+// created. The names of the created topics and subscriptions are passed in
+// the PUBSUBC_TOPICS and PUBSUBC_SUBSCRIPTIONS environment variables, as
+// comma-separated lists.
+func runPostHook(ctx context.Context, hook string, created []provision.Resource) error {
+	var topics, subscriptions []string
+	for _, r := range created {
+		switch r.Type {
+		case "topic":
+			topics = append(topics, r.Name)
+		case "subscription":
+			subscriptions = append(subscriptions, r.Name)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook)
+	cmd.Env = append(os.Environ(),
+		"PUBSUBC_TOPICS="+strings.Join(topics, ","),
+		"PUBSUBC_SUBSCRIPTIONS="+strings.Join(subscriptions, ","),
+	)
+
+	debugf("Running post hook %q", hook)
+	out, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" {
+			infof("post-hook: %s", line)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Post hook %q failed: %s", hook, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestRunPostHook(t *testing.T) {
+	created := []provision.Resource{
+		{Type: "topic", Project: "shop", Name: "projects/shop/topics/carts"},
+		{Type: "subscription", Project: "shop", Name: "projects/shop/subscriptions/carts-abandoned"},
+		{Type: "topic", Project: "shop", Name: "projects/shop/topics/checkouts"},
+	}
+
+	tests := []struct {
+		name    string
+		hook    string
+		created []provision.Resource
+		// env is what the hook writes to $OUT, if it is set.
+		env string
+		err string
+	}{
+		{
+			name:    "created resources",
+			hook:    `printf '%s\n%s\n' "$PUBSUBC_TOPICS" "$PUBSUBC_SUBSCRIPTIONS" > "$OUT"`,
+			created: created,
+			env:     "projects/shop/topics/carts,projects/shop/topics/checkouts\nprojects/shop/subscriptions/carts-abandoned\n",
+		},
+		{
+			name: "nothing created",
+			hook: `printf '[%s][%s]' "$PUBSUBC_TOPICS" "$PUBSUBC_SUBSCRIPTIONS" > "$OUT"`,
+			env:  "[][]",
+		},
+		{
+			name:    "pipeline",
+			hook:    `echo "$PUBSUBC_TOPICS" | tr ',' ' ' > "$OUT"`,
+			created: created,
+			env:     "projects/shop/topics/carts projects/shop/topics/checkouts\n",
+		},
+		{
+			name: "non-zero exit",
+			hook: "echo 'emulator not seeded' >&2; exit 3",
+			err:  `Post hook "echo 'emulator not seeded' >&2; exit 3" failed: exit status 3`,
+		},
+		{
+			name: "unknown command",
+			hook: "pubsubc-missing-hook",
+			err:  `Post hook "pubsubc-missing-hook" failed: exit status 127`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "hook.out")
+			t.Setenv("OUT", out)
+
+			err := runPostHook(context.Background(), tt.hook, tt.created)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			b, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatalf("expected the hook to write %q: %s", out, err)
+			}
+			if env := string(b); env != tt.env {
+				t.Errorf("expected the hook to write %q, got %q", tt.env, env)
+			}
+		})
+	}
+}
@@ -2,19 +2,52 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
-	"strings"
+	"syscall"
+	"time"
 
-	"cloud.google.com/go/pubsub"
+	"github.com/prep/pubsubc/provision"
 )
 
 var (
-	debug   = flag.Bool("debug", false, "Enable debug logging")
-	help    = flag.Bool("help", false, "Display usage information")
-	version = flag.Bool("version", false, "Display version information")
+	allowEmpty     bool
+	clampDurations bool
+	compress       bool
+	configFile     string
+	debug          bool
+	envFile        string
+	eventsFile     string
+	exclude        string
+	help           bool
+	listFeatures   bool
+	match          string
+	noExpire       bool
+	noOpOnEmpty    bool
+	matchRegex     string
+	maxExpansion   int
+	only           string
+	otelEndpoint   string
+	outputFormat   string
+	prefix         string
+	sanitizeNames  bool
+	shareConn      bool
+	timeout        time.Duration
+	userAgent      string
+	version        bool
+	wait           time.Duration
+)
+
+// The exit codes of pubsubc. A pipeline can tell drift, that -check detects,
+// apart from other failures.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitDrift = 2
 )
 
 // The CommitHash and Revision variables are set during building.
@@ -23,104 +56,222 @@ var (
 	Revision   = "<not set>"
 )
 
-// Topics describes a PubSub topic and its subscriptions.
-type Topics map[string][]string
-
 func versionString() string {
 	return fmt.Sprintf("pubsubc - build %s (%s) running on %s", Revision, CommitHash, runtime.Version())
 }
 
 // debugf prints debugging information.
 func debugf(format string, params ...interface{}) {
-	if *debug {
-		fmt.Printf(format+"\n", params...)
+	if debug {
+		logf(os.Stdout, format, params...)
 	}
 }
 
-// fatalf prints an error to stderr and exits.
-func fatalf(format string, params ...interface{}) {
-	fmt.Fprintf(os.Stderr, os.Args[0]+": "+format+"\n", params...)
-	os.Exit(1)
+// infof prints informational messages.
+func infof(format string, params ...interface{}) {
+	logf(os.Stdout, format, params...)
 }
 
-// create a connection to the PubSub service and create topics and subscriptions
-// for the specified project ID.
-func create(ctx context.Context, projectID string, topics Topics) error {
-	client, err := pubsub.NewClient(ctx, projectID)
-	if err != nil {
-		return fmt.Errorf("Unable to create client to project %q: %s", projectID, err)
-	}
-	defer client.Close()
+// warnf prints a warning to stderr.
+func warnf(format string, params ...interface{}) {
+	logf(os.Stderr, os.Args[0]+": warning: "+format, params...)
+}
 
-	debugf("Client connected with project ID %q", projectID)
+// fatalf prints the buffered log messages of -quiet-on-success, followed by
+// an error to stderr, and exits.
+func fatalf(format string, params ...interface{}) {
+	exitf(exitError, format, params...)
+}
 
-	for topicID, subscriptions := range topics {
-		debugf("  Creating topic %q", topicID)
-		topic, err := client.CreateTopic(ctx, topicID)
-		if err != nil {
-			return fmt.Errorf("Unable to create topic %q for project %q: %s", topicID, projectID, err)
-		}
+// exitf is like fatalf, but exits with the specified exit code.
+func exitf(code int, format string, params ...interface{}) {
+	flushLogs()
+	fmt.Fprintf(os.Stderr, os.Args[0]+": "+format+"\n", params...)
+	os.Exit(code)
+}
 
-		for _, subscriptionID := range subscriptions {
-			debugf("    Creating subscription %q", subscriptionID)
-			_, err = client.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{Topic: topic})
-			if err != nil {
-				return fmt.Errorf("Unable to create subscription %q on topic %q for project %q: %s", subscriptionID, topicID, projectID, err)
-			}
-		}
+// commonFlags registers the flags that every command supports.
+func commonFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&allowEmpty, "allow-empty-project", false, "Accept project definitions without topics, which only connect to the project")
+	fs.BoolVar(&clampDurations, "clamp-durations", false, "Clamp out-of-range durations to the nearest valid bound instead of failing")
+	fs.BoolVar(&compress, "compress", false, "Compress the gRPC requests with gzip, which the PubSub service must support; it saves bandwidth on a slow link to a remote emulator, like for large seed publishes, at the cost of CPU time")
+	fs.StringVar(&configFile, "config", "", "Read additional project definitions from this file or http(s) URL, one per line")
+	fs.BoolVar(&debug, "debug", false, "Enable debug logging")
+	fs.StringVar(&envFile, "env-file", "", "Load environment variables from a dotenv file")
+	fs.StringVar(&eventsFile, "events", "", "Write a JSON line to this file, or to stdout if it is -, for every topic or subscription as it is created, updated, skipped or deleted")
+	fs.StringVar(&exclude, "exclude", "", "Comma-separated keys or project IDs of the projects to skip, even if -only lists them")
+	fs.BoolVar(&help, "help", false, "Display usage information")
+	fs.BoolVar(&listFeatures, "list-features", false, "Print the topic and subscription options with their values and constraints, in the -output-format")
+	fs.StringVar(&match, "match", "", "Wildcard for the names of the environment variables that define projects, like PUBSUB_PROJECT* (default only the numbered PUBSUB_PROJECT<n>)")
+	fs.StringVar(&matchRegex, "match-regex", "", "Regular expression for the names of the environment variables that define projects, instead of -match")
+	fs.IntVar(&maxExpansion, "max-expansion", 1000, "Maximum number of topic definitions that the range templates of a project expand to")
+	fs.BoolVar(&noExpire, "no-expire", false, "Make subscriptions without an expire option, and dead-letter subscriptions without a dlqsubnoexpire option, never expire")
+	fs.BoolVar(&noOpOnEmpty, "no-op-on-empty", false, "Exit successfully instead of failing when no projects are configured")
+	fs.StringVar(&only, "only", "", "Comma-separated keys or project IDs of the only projects to process")
+	fs.StringVar(&otelEndpoint, "otel-endpoint", "", "Export OpenTelemetry traces to this OTLP gRPC endpoint")
+	fs.StringVar(&outputFormat, "output-format", "", "Format of the -dry-run plans, and of the list and export output: text, json, yaml, or env for PUBSUB_PROJECT variables (default text, and env for export)")
+	fs.StringVar(&prefix, "prefix", "", "Prefix for all topic and subscription names, unless overridden by PUBSUB_PREFIX_<key> (default $PUBSUB_PREFIX)")
+	fs.BoolVar(&sanitizeNames, "sanitize-names", false, "Prefix the names of topics and subscriptions that start with the reserved goog prefix with x- instead of failing")
+	fs.BoolVar(&shareConn, "share-connection", true, "Share a single gRPC connection to the emulator between the clients of all projects")
+	fs.DurationVar(&timeout, "timeout", 0, "Maximum duration of the whole run, including retries")
+	fs.StringVar(&userAgent, "user-agent", "pubsubc/"+Revision, "User agent of the PubSub clients, to tell pubsubc apart in the emulator logs")
+	fs.BoolVar(&version, "version", false, "Display version information")
+	fs.DurationVar(&wait, "wait", 0, "Time to wait for the emulator in PUBSUB_EMULATOR_HOST to become reachable and ready")
+}
+
+// usage prints the usage information of the specified command.
+func usage(cmd *command, fs *flag.FlagSet) {
+	fmt.Printf(`Usage: env PUBSUB_PROJECT1="project1,topic1[seed=seeds.jsonl],topic2:subscription1;ack=30s;dlq=topic1" %s [command] [flags]`+"\n\n", os.Args[0])
+	fmt.Print("Subscription options that a subscription doesn't set default to PUBSUB_DEFAULT_<option>, like PUBSUB_DEFAULT_ACK=30s.\n\n")
+	fmt.Println("Commands:")
+	for _, c := range commands {
+		fmt.Printf("  %-8s %s\n", c.name, c.description)
 	}
 
-	return nil
+	fmt.Printf("\nFlags for %s:\n", cmd.name)
+	fs.PrintDefaults()
+
+	fmt.Println("\nExit codes:")
+	fmt.Printf("  %-8d %s\n", exitOK, "Success, and with -check the projects are in sync with the config")
+	fmt.Printf("  %-8d %s\n", exitError, "An error occurred")
+	fmt.Printf("  %-8d %s\n", exitDrift, "With -check, a topic or subscription is missing or drifted from the config")
 }
 
 func main() {
-	flag.Parse()
-	flag.Usage = func() {
-		fmt.Printf(`Usage: env PUBSUB_PROJECT1="project1,topic1,topic2:subscription1" %s`+"\n", os.Args[0])
-		flag.PrintDefaults()
+	cmd, args := parseCommand(os.Args[1:])
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() { usage(cmd, fs) }
+	commonFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
 	}
+	fs.Parse(args)
 
-	if *help {
-		flag.Usage()
+	if help {
+		fs.Usage()
 		return
 	}
 
-	if *version {
+	if version {
 		fmt.Println(versionString())
 		return
 	}
 
-	// Cycle over the numbered PUBSUB_PROJECT environment variables.
-	for i := 1; ; i++ {
-		// Fetch the enviroment variable. If it doesn't exist, break out.
-		currentEnv := fmt.Sprintf("PUBSUB_PROJECT%d", i)
-		env := os.Getenv(currentEnv)
-		if env == "" {
-			// If this is the first environment variable, print the usage info.
-			if i == 1 {
-				flag.Usage()
-				os.Exit(1)
+	if quietOnSuccess || summaryOnly {
+		quietLog = &bufferedLog{}
+	}
+
+	if err := checkOutputFormat(); err != nil {
+		fatalf(err.Error())
+	}
+
+	if listFeatures {
+		if err := printFormatted(newFeaturesOutput(), "text"); err != nil {
+			fatalf(err.Error())
+		}
+		return
+	}
+
+	if envFile != "" {
+		if err := loadEnvFile(envFile); err != nil {
+			fatalf(err.Error())
+		}
+	}
+
+	if prefix == "" {
+		prefix = os.Getenv("PUBSUB_PREFIX")
+	}
+
+	if err := loadDefaults(); err != nil {
+		fatalf(err.Error())
+	}
+
+	if eventsFile != "" {
+		if err := openEvents(eventsFile); err != nil {
+			fatalf(err.Error())
+		}
+	}
+
+	var projects []provision.Config
+	if cmd.projects {
+		var err error
+		if projects, err = loadProjects(); err != nil {
+			fatalf(err.Error())
+		}
+
+		// Without any projects, print the usage info, unless that is
+		// expected.
+		if len(projects) == 0 {
+			if noOpOnEmpty {
+				infof("No projects are configured, nothing to do")
+				return
 			}
 
-			break
+			fs.Usage()
+			os.Exit(1)
 		}
 
-		// Separate the projectID from the topic definitions.
-		parts := strings.Split(env, ",")
-		if len(parts) < 2 {
-			fatalf("%s: Expected at least 1 topic to be defined", currentEnv)
+		// Invalid names are rejected before the PubSub service is contacted,
+		// which would reject them with a less specific error.
+		for i, project := range projects {
+			if sanitizeNames {
+				project = project.SanitizeNames(func(kind, from, to string) {
+					warnf("Renamed %s %q of project %q to %q, because the goog prefix is reserved", kind, from, project.ProjectID, to)
+				})
+				projects[i] = project
+			}
+
+			if err := provision.CheckNames(project); err != nil {
+				fatalf("%s", err)
+			}
 		}
+	}
 
-		// Separate the topicID from the subscription IDs.
-		topics := make(Topics)
-		for _, part := range parts[1:] {
-			topicParts := strings.Split(part, ":")
-			topics[topicParts[0]] = topicParts[1:]
+	// Cancel the context on an interrupt, so that pending work is flushed
+	// before pubsubc exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Probe the emulator, so that an unreachable emulator produces a clearer
+	// error than the gRPC default.
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		if err := waitForEmulator(ctx, host, wait); err != nil {
+			fatalf(err.Error())
 		}
+	}
 
-		// Create the project and all its topics and subscriptions.
-		if err := create(context.Background(), parts[0], topics); err != nil {
+	shutdown := func(context.Context) error { return nil }
+	if otelEndpoint != "" {
+		var err error
+		if shutdown, err = setupTracing(ctx, otelEndpoint); err != nil {
 			fatalf(err.Error())
 		}
 	}
+
+	err := cmd.run(ctx, projects)
+
+	if err := shutdown(context.WithoutCancel(ctx)); err != nil {
+		warnf("Unable to flush traces: %s", err)
+	}
+
+	if err != nil {
+		stop()
+
+		var drift *driftError
+		if errors.As(err, &drift) {
+			exitf(exitDrift, "%s", err)
+		}
+		fatalf(err.Error())
+	}
+
+	if quietOnSuccess {
+		fmt.Printf("%s: %s succeeded\n", os.Args[0], cmd.name)
+	}
 }
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestMain runs pubsubc instead of the tests if PUBSUBC_TEST_MAIN is set, so
+// that tests can run the commands that pubsubc prints for itself.
+func TestMain(m *testing.M) {
+	if os.Getenv("PUBSUBC_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// setFlags resets the flags of the create command to their defaults, and then
+// parses the specified arguments. It also clears the PUBSUB_ environment
+// variables that define projects, so that only those of the test apply.
+func setFlags(t *testing.T, args ...string) {
+	t.Helper()
+
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(name, "PUBSUB_") && name != "PUBSUB_EMULATOR_HOST" {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	commonFlags(fs)
+	createFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("unable to parse the flags %q: %s", args, err)
+	}
+}
+
+func TestMainWithoutProjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		exit    int
+		output  string
+		created bool
+	}{
+		{name: "fatal by default", exit: 1, output: "Usage: "},
+		{name: "no-op", args: []string{"-no-op-on-empty"}, output: "No projects are configured, nothing to do"},
+		{name: "no-op for reset", args: []string{"reset", "-no-op-on-empty"}, output: "No projects are configured, nothing to do"},
+		{
+			name:   "nothing selected",
+			env:    map[string]string{"PUBSUB_PROJECT1": "staging,deploys"},
+			args:   []string{"-only", "production", "-no-op-on-empty"},
+			output: "No projects are configured, nothing to do",
+		},
+		{
+			name:    "projects configured",
+			env:     map[string]string{"PUBSUB_PROJECT1": "staging,deploys"},
+			args:    []string{"-no-op-on-empty"},
+			created: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+
+			clients := newTestClients(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			// Run this test binary, which runs pubsubc.
+			cmd := exec.Command(os.Args[0], tt.args...)
+			cmd.Env = append(os.Environ(), "PUBSUBC_TEST_MAIN=1")
+			out, err := cmd.CombinedOutput()
+
+			var exit int
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exit = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if exit != tt.exit {
+				t.Errorf("expected exit code %d, got %d:\n%s", tt.exit, exit, out)
+			}
+			if !strings.Contains(string(out), tt.output) {
+				t.Errorf("expected the output to contain %q, got:\n%s", tt.output, out)
+			}
+
+			client, err := clients.Client(context.Background(), "staging")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := client.Topic("deploys").Exists(context.Background()); err != nil || ok != tt.created {
+				t.Errorf("expected the topic to exist to be %t, got %t (%v)", tt.created, ok, err)
+			}
+		})
+	}
+}
+
+func TestMainInvalidNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		output string
+	}{
+		{
+			name:   "subscription",
+			env:    map[string]string{"PUBSUB_PROJECT1": "releases,builds:goog-watcher"},
+			output: `Subscription "goog-watcher" for project "releases": the name must not start with goog`,
+		},
+		{
+			name:   "topic in the second project",
+			env:    map[string]string{"PUBSUB_PROJECT1": "releases,builds", "PUBSUB_PROJECT2": "artifacts,7z-uploads"},
+			output: `Topic "7z-uploads" for project "artifacts": the name must start with a letter`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+
+			var requests atomic.Int32
+			newTestServer(t, pstest.ServerReactorOption{
+				FuncName: "CreateTopic",
+				Reactor: reactorFunc(func(interface{}) (bool, interface{}, error) {
+					requests.Add(1)
+					return false, nil, nil
+				}),
+			})
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cmd := exec.Command(os.Args[0])
+			cmd.Env = append(os.Environ(), "PUBSUBC_TEST_MAIN=1")
+			out, err := cmd.CombinedOutput()
+
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+				t.Fatalf("expected exit code 1, got %v:\n%s", err, out)
+			}
+			if !strings.Contains(string(out), tt.output) {
+				t.Errorf("expected the output to contain %q, got:\n%s", tt.output, out)
+			}
+
+			// Nothing is created, not even the valid topics.
+			if n := requests.Load(); n > 0 {
+				t.Errorf("expected no topics to be created, got %d request(s)", n)
+			}
+		})
+	}
+}
+
+func TestMainCheckExitCodes(t *testing.T) {
+	denyList := pstest.ServerReactorOption{
+		FuncName: "ListTopics",
+		Reactor: reactorFunc(func(interface{}) (bool, interface{}, error) {
+			return true, nil, status.Error(codes.PermissionDenied, "pubsub.topics.list denied")
+		}),
+	}
+
+	tests := []struct {
+		name    string
+		live    string
+		config  string
+		reactor *pstest.ServerReactorOption
+		exit    int
+		output  []string
+	}{
+		{
+			name:   "in sync",
+			live:   "inventory,stock:stock-sync;ack=30s:stock-audit",
+			config: "inventory,stock:stock-sync;ack=30s:stock-audit",
+			exit:   exitOK,
+		},
+		{
+			name:   "live extras are not drift",
+			live:   "inventory,stock:stock-sync:stock-legacy,restock",
+			config: "inventory,stock:stock-sync",
+			exit:   exitOK,
+		},
+		{
+			name:   "missing subscription",
+			live:   "inventory,stock:stock-sync",
+			config: "inventory,stock:stock-sync:stock-audit",
+			exit:   exitDrift,
+			output: []string{"stock-audit", "pubsubc: 1 resource(s) are missing or drifted from the config"},
+		},
+		{
+			name:   "drifted and missing",
+			live:   "inventory,stock:stock-sync;ack=10s",
+			config: "inventory,stock:stock-sync;ack=60s,restock:restock-mailer",
+			exit:   exitDrift,
+			output: []string{"stock-sync", "restock-mailer", "pubsubc: 3 resource(s) are missing or drifted from the config"},
+		},
+		{
+			name:    "error",
+			config:  "inventory,stock:stock-sync",
+			reactor: &denyList,
+			exit:    exitError,
+			output:  []string{"pubsub.topics.list denied"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+
+			var opts []pstest.ServerReactorOption
+			if tt.reactor != nil {
+				opts = append(opts, *tt.reactor)
+			}
+			newTestServer(t, opts...)
+
+			if tt.live != "" {
+				live, err := (&provision.Parser{}).Parse(tt.live)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				clients := &provision.ClientCache{}
+				t.Cleanup(func() { clients.Close() })
+				if _, err := (&provision.Provisioner{Clients: clients}).Create(context.Background(), []provision.Config{live}); err != nil {
+					t.Fatalf("unable to create %q: %s", tt.live, err)
+				}
+			}
+
+			cmd := exec.Command(os.Args[0], "-check")
+			cmd.Args[0] = "pubsubc"
+			cmd.Env = append(os.Environ(), "PUBSUBC_TEST_MAIN=1", "PUBSUB_PROJECT1="+tt.config)
+			out, err := cmd.CombinedOutput()
+
+			var exitErr *exec.ExitError
+			switch {
+			case tt.exit == exitOK && err != nil:
+				t.Fatalf("expected exit code 0, got %v:\n%s", err, out)
+			case tt.exit != exitOK && (!errors.As(err, &exitErr) || exitErr.ExitCode() != tt.exit):
+				t.Fatalf("expected exit code %d, got %v:\n%s", tt.exit, err, out)
+			}
+			for _, want := range tt.output {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("expected the output to contain %q, got:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestUsageExitCodes(t *testing.T) {
+	for _, name := range []string{"create", "delete"} {
+		t.Run(name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], name, "-help")
+			cmd.Env = append(os.Environ(), "PUBSUBC_TEST_MAIN=1")
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			_, section, ok := strings.Cut(string(out), "\nExit codes:\n")
+			if !ok {
+				t.Fatalf("expected the usage to document the exit codes, got:\n%s", out)
+			}
+			for _, code := range []string{"  0 ", "  1 ", "  2 "} {
+				if !strings.Contains(section, code) {
+					t.Errorf("expected the exit code %q to be documented, got:\n%s", code, section)
+				}
+			}
+		})
+	}
+}
+
+func TestMainSanitizeNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		project string
+		exit    int
+		topics  []string
+		output  []string
+	}{
+		{
+			name:    "reserved prefix fails",
+			args:    []string{"-prefix", "goog-"},
+			project: "media,renders:renders-preview",
+			exit:    1,
+			output:  []string{`Topic "goog-renders" for project "media": the name must not start with goog, which is reserved`},
+		},
+		{
+			name:    "reserved prefix sanitized",
+			args:    []string{"-prefix", "goog-", "-sanitize-names"},
+			project: "media,renders:renders-preview",
+			topics:  []string{"projects/media/topics/x-goog-renders"},
+			output: []string{
+				`pubsubc: warning: Renamed topic "goog-renders" of project "media" to "x-goog-renders", because the goog prefix is reserved`,
+				`pubsubc: warning: Renamed subscription "goog-renders-preview" of project "media" to "x-goog-renders-preview", because the goog prefix is reserved`,
+			},
+		},
+		{
+			name:    "reserved names sanitized",
+			args:    []string{"-sanitize-names"},
+			project: "media,googlers:transcodes;dlq=googdead,clips",
+			topics:  []string{"projects/media/topics/x-googdead", "projects/media/topics/x-googlers", "projects/media/topics/clips"},
+			output: []string{
+				`Renamed topic "googlers" of project "media" to "x-googlers"`,
+				`Renamed dead-letter topic "googdead" of project "media" to "x-googdead"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+
+			var (
+				mu     sync.Mutex
+				topics []string
+			)
+			newTestServer(t, pstest.ServerReactorOption{
+				FuncName: "CreateTopic",
+				Reactor: reactorFunc(func(req interface{}) (bool, interface{}, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					topics = append(topics, req.(*pubsubpb.Topic).Name)
+					return false, nil, nil
+				}),
+			})
+			t.Setenv("PUBSUB_PROJECT1", tt.project)
+
+			cmd := exec.Command(os.Args[0], tt.args...)
+			cmd.Args[0] = "pubsubc"
+			cmd.Env = append(os.Environ(), "PUBSUBC_TEST_MAIN=1")
+			out, err := cmd.CombinedOutput()
+
+			var exitErr *exec.ExitError
+			switch {
+			case tt.exit == 0 && err != nil:
+				t.Fatalf("expected exit code 0, got %v:\n%s", err, out)
+			case tt.exit != 0 && (!errors.As(err, &exitErr) || exitErr.ExitCode() != tt.exit):
+				t.Fatalf("expected exit code %d, got %v:\n%s", tt.exit, err, out)
+			}
+			for _, want := range tt.output {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("expected the output to contain %q, got:\n%s", want, out)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			slices.Sort(topics)
+			want := slices.Sorted(slices.Values(tt.topics))
+			if !slices.Equal(topics, want) {
+				t.Errorf("expected the topics %q to be created, got %q", want, topics)
+			}
+		})
+	}
+}
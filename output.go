@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// existing records the existing resources that the run updated or skipped
+// because they were unchanged or already existed.
+var existing struct {
+	mu      sync.Mutex
+	updated []provision.Resource
+	skipped []provision.Resource
+}
+
+// recordUpdated and recordSkipped add a resource to the existing resources.
+func recordUpdated(r provision.Resource) {
+	existing.mu.Lock()
+	defer existing.mu.Unlock()
+
+	existing.updated = append(existing.updated, r)
+}
+
+func recordSkipped(r provision.Resource) {
+	existing.mu.Lock()
+	defer existing.mu.Unlock()
+
+	existing.skipped = append(existing.skipped, r)
+}
+
+// writeOutputFile writes the created resources as JSON to the specified file,
+// along with the updated and skipped existing resources, and the randomized
+// project IDs of -randomize-projects.
+func writeOutputFile(path string, resources []provision.Resource, projectIDs map[string]string) error {
+	existing.mu.Lock()
+	defer existing.mu.Unlock()
+
+	b, err := json.MarshalIndent(struct {
+		Resources  []provision.Resource `json:"resources"`
+		Updated    []provision.Resource `json:"updated"`
+		Skipped    []provision.Resource `json:"skipped"`
+		ProjectIDs map[string]string    `json:"projects,omitempty"`
+	}{nonNil(resources), nonNil(existing.updated), nonNil(existing.skipped), projectIDs}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("Unable to write output file: %s", err)
+	}
+
+	return nil
+}
+
+// nonNil returns the resources, or an empty list if there are none, so that
+// they are written as [] instead of null.
+func nonNil(resources []provision.Resource) []provision.Resource {
+	if resources == nil {
+		return []provision.Resource{}
+	}
+
+	return resources
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestWriteOutputFile(t *testing.T) {
+	topic := provision.Resource{Type: "topic", Project: "search", Name: "projects/search/topics/queries"}
+	sub := provision.Resource{Type: "subscription", Project: "search", Name: "projects/search/subscriptions/queries-indexer"}
+
+	tests := []struct {
+		name       string
+		resources  []provision.Resource
+		projectIDs map[string]string
+		want       string
+	}{
+		{
+			name: "nothing created",
+			want: `{"resources":[],"updated":[],"skipped":[]}`,
+		},
+		{
+			name:      "created",
+			resources: []provision.Resource{topic, sub},
+			want:      `{"resources":[{"type":"topic","project":"search","name":"projects/search/topics/queries"},{"type":"subscription","project":"search","name":"projects/search/subscriptions/queries-indexer"}],"updated":[],"skipped":[]}`,
+		},
+		{
+			name:       "randomized projects",
+			resources:  []provision.Resource{{Type: "topic", Project: "search-x1y2", Name: "projects/search-x1y2/topics/queries"}},
+			projectIDs: map[string]string{"search": "search-x1y2"},
+			want:       `{"resources":[{"type":"topic","project":"search-x1y2","name":"projects/search-x1y2/topics/queries"}],"updated":[],"skipped":[],"projects":{"search":"search-x1y2"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "created.json")
+			if err := writeOutputFile(path, tt.resources, tt.projectIDs); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// The file is indented, which the comparison ignores.
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(string(b), "}\n") || !json.Valid(b) {
+				t.Fatalf("expected a JSON document that ends with a newline, got %q", b)
+			}
+
+			if !jsonEqual(t, string(b), tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, b)
+			}
+		})
+	}
+
+	if err := writeOutputFile(filepath.Join(t.TempDir(), "missing", "created.json"), nil, nil); err == nil || !strings.HasPrefix(err.Error(), "Unable to write output file") {
+		t.Errorf("expected an error for a missing directory, got %v", err)
+	}
+}
+
+// jsonEqual returns true if both JSON documents have the same content.
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+
+	var va, vb interface{}
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatalf("invalid JSON %s: %s", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatalf("invalid JSON %s: %s", b, err)
+	}
+
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
+
+func TestOutputFileExisting(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		args     []string
+		config   string
+		want     string
+	}{
+		{
+			name:     "ensure",
+			existing: "inventory,stock:stock-sync;ack=20s,prices",
+			args:     []string{"-ensure"},
+			config:   "inventory,stock:stock-sync;ack=40s:stock-audit,prices",
+			want: `{"resources":[{"type":"subscription","project":"inventory","name":"projects/inventory/subscriptions/stock-audit"}],
+				"updated":[{"type":"subscription","project":"inventory","name":"projects/inventory/subscriptions/stock-sync"}],
+				"skipped":[{"type":"topic","project":"inventory","name":"projects/inventory/topics/stock"},{"type":"topic","project":"inventory","name":"projects/inventory/topics/prices"}]}`,
+		},
+		{
+			name:     "skip existing",
+			existing: "inventory,stock:stock-sync",
+			args:     []string{"-skip-existing-topics", "-skip-existing-subscriptions"},
+			config:   "inventory,stock:stock-sync,warehouses",
+			want: `{"resources":[{"type":"topic","project":"inventory","name":"projects/inventory/topics/warehouses"}],
+				"updated":[],
+				"skipped":[{"type":"topic","project":"inventory","name":"projects/inventory/topics/stock"},{"type":"subscription","project":"inventory","name":"projects/inventory/subscriptions/stock-sync"}]}`,
+		},
+		{
+			name:   "fresh",
+			config: "inventory,stock",
+			want:   `{"resources":[{"type":"topic","project":"inventory","name":"projects/inventory/topics/stock"}],"updated":[],"skipped":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestServer(t)
+			t.Cleanup(func() { existing.updated, existing.skipped = nil, nil })
+
+			parse := func(definition string) provision.Config {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				return cfg
+			}
+
+			ctx := context.Background()
+			if tt.existing != "" {
+				setFlags(t)
+				if err := runCreate(ctx, []provision.Config{parse(tt.existing)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			path := filepath.Join(t.TempDir(), "created.json")
+			setFlags(t, append(tt.args, "-output-file", path)...)
+			if err := runCreate(ctx, []provision.Config{parse(tt.config)}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonEqual(t, string(b), tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, b)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// attempt runs a create operation, and retries it up to Retries times if it
+// fails with a transient error, waiting between the attempts according to the
+// Backoff. Every attempt is bounded by OpTimeout. If the
+// operation keeps failing, the error states whether the retries were exhausted
+// or the context ended them, after how many attempts and how much time.
+//
+// An attempt that fails with AlreadyExists after an earlier attempt timed out
+// succeeds, because the timed-out attempt may have created the resource
+// regardless.
+func (c *creator) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	start := time.Now()
+	if c.OnOperation != nil {
+		defer func() { c.OnOperation(time.Since(start)) }()
+	}
+
+	var strategy Backoff = defaultBackoff
+	if c.Backoff != nil {
+		strategy = c.Backoff
+	}
+
+	var timedOut bool
+	for n := 1; ; n++ {
+		opCtx, cancel := c.opContext(ctx)
+		err := op(opCtx)
+		err = c.opError(ctx, opCtx, err)
+		c.trackTimeout(ctx, err)
+		cancel()
+
+		switch {
+		case err == nil:
+			return nil
+		case timedOut && status.Code(err) == codes.AlreadyExists:
+			c.debugf("  Attempt %d found the resource that a timed-out attempt created", n)
+			return nil
+		case ctx.Err() != nil:
+			return fmt.Errorf("%s after %d attempt(s) in %s: %w", contextReason(ctx), n, since(start), err)
+		case !isTransient(err):
+			return err
+		case n > c.Retries:
+			if c.Retries == 0 {
+				return err
+			}
+
+			return fmt.Errorf("retries exhausted after %d attempt(s) in %s: %w", n, since(start), err)
+		}
+
+		if _, ok := err.(*timeoutError); ok {
+			timedOut = true
+		}
+
+		backoff := strategy.Delay(n)
+		c.debugf("  Attempt %d failed, retrying in %s: %s", n, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s after %d attempt(s) in %s: %w", contextReason(ctx), n, since(start), err)
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// opContext returns the context of a single create operation, which is
+// bounded by OpTimeout if that is set.
+func (c *creator) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.OpTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, c.OpTimeout)
+}
+
+// opError returns the error of an operation, which states the operation
+// timeout if the operation's context expired but the parent context did not.
+func (c *creator) opError(ctx, opCtx context.Context, err error) error {
+	if err != nil && opCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return &timeoutError{timeout: c.OpTimeout, err: err}
+	}
+
+	return err
+}
+
+// timeoutError is the error of an operation that exceeded the OpTimeout.
+type timeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("operation timed out after %s: %s", e.timeout, e.err)
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
+// isTransient returns true if an operation that failed with the error might
+// succeed when it is retried.
+func isTransient(err error) bool {
+	if _, ok := err.(*timeoutError); ok {
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+
+	return false
+}
+
+// contextReason describes why the context ended.
+func contextReason(ctx context.Context) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return "deadline exceeded"
+	}
+
+	return "cancelled"
+}
+
+// since returns the time since start, rounded to milliseconds.
+func since(start time.Time) time.Duration {
+	return time.Since(start).Round(time.Millisecond)
+}
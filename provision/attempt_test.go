@@ -0,0 +1,335 @@
+package provision
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateOpTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// slow is the name of the resource whose creation takes a second.
+		slow      string
+		opTimeout time.Duration
+		timeout   time.Duration
+		err       string
+		notErr    string
+	}{
+		{
+			name:      "slow topic",
+			slow:      "projects/project1/topics/exports",
+			opTimeout: 200 * time.Millisecond,
+			err:       `Unable to create topic "exports" for project "project1": operation timed out after 200ms`,
+		},
+		{
+			name:      "slow subscription",
+			slow:      "projects/project1/subscriptions/exports-warehouse",
+			opTimeout: 250 * time.Millisecond,
+			err:       `Unable to create subscription "exports-warehouse" on topic "exports" for project "project1": operation timed out after 250ms`,
+		},
+		{
+			name:      "within the operation timeout",
+			slow:      "projects/project1/topics/exports",
+			opTimeout: 3 * time.Second,
+		},
+		{
+			name: "without an operation timeout",
+			slow: "projects/project1/subscriptions/exports-warehouse",
+		},
+		{
+			name:      "run timeout first",
+			slow:      "projects/project1/topics/exports",
+			opTimeout: 5 * time.Second,
+			timeout:   200 * time.Millisecond,
+			err:       "deadline exceeded after 1 attempt(s)",
+			notErr:    "operation timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				if r, ok := req.(interface{ GetName() string }); ok && r.GetName() == tt.slow {
+					time.Sleep(time.Second)
+				}
+
+				return false, nil, nil
+			})
+
+			p, _ := newTestProvisioner(t,
+				pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: delay},
+				pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: delay},
+			)
+			p.OpTimeout = tt.opTimeout
+
+			cfg, err := (&Parser{}).Parse("project1,exports:exports-warehouse")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			_, err = p.Create(ctx, []Config{cfg})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			case tt.notErr != "" && strings.Contains(err.Error(), tt.notErr):
+				t.Fatalf("expected an error without %q, got %s", tt.notErr, err)
+			}
+		})
+	}
+}
+
+func TestCreateRetryReporting(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    codes.Code
+		fails   int
+		retries int
+		timeout time.Duration
+		cancel  time.Duration
+		calls   int
+		// err is a regular expression that the error must match.
+		err string
+	}{
+		{
+			name:    "retries exhausted",
+			code:    codes.ResourceExhausted,
+			fails:   100,
+			retries: 2,
+			calls:   3,
+			err:     `^Unable to create topic "audit-log" for project "project1": retries exhausted after 3 attempt\(s\) in [0-9.]+m?s: `,
+		},
+		{
+			name:  "without retries",
+			code:  codes.ResourceExhausted,
+			fails: 100,
+			calls: 1,
+			err:   `^Unable to create topic "audit-log" for project "project1": rpc error: code = ResourceExhausted`,
+		},
+		{
+			name:    "recovered",
+			code:    codes.Aborted,
+			fails:   2,
+			retries: 3,
+			calls:   3,
+		},
+		{
+			name:    "deadline first",
+			code:    codes.ResourceExhausted,
+			fails:   100,
+			retries: 50,
+			timeout: 250 * time.Millisecond,
+			err:     `deadline exceeded after [0-9]+ attempt\(s\) in [0-9.]+m?s: `,
+		},
+		{
+			name:    "cancelled",
+			code:    codes.ResourceExhausted,
+			fails:   100,
+			retries: 50,
+			cancel:  250 * time.Millisecond,
+			err:     `cancelled after [0-9]+ attempt\(s\) in [0-9.]+m?s: `,
+		},
+		{
+			name:    "not transient",
+			code:    codes.PermissionDenied,
+			fails:   100,
+			retries: 5,
+			calls:   1,
+			err:     `^Unable to create topic "audit-log" for project "project1": rpc error: code = PermissionDenied`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls int
+			fail := reactorFunc(func(interface{}) (bool, interface{}, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if calls++; calls <= tt.fails {
+					return true, nil, status.Error(tt.code, "try again")
+				}
+				return false, nil, nil
+			})
+
+			p, _ := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: fail})
+			p.Retries = tt.retries
+			p.Backoff = ConstantBackoff{Base: 20 * time.Millisecond}
+
+			cfg, err := (&Parser{}).Parse("project1,audit-log")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+
+			_, err = p.Create(ctx, []Config{cfg})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !regexp.MustCompile(tt.err).MatchString(err.Error())):
+				t.Fatalf("expected an error matching %q, got %v", tt.err, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if tt.calls > 0 && calls != tt.calls {
+				t.Errorf("expected %d attempt(s), got %d", tt.calls, calls)
+			}
+		})
+	}
+}
+
+func TestCreateAlreadyExistsAfterTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// slow is the name of the resource whose first creation takes
+		// longer than the operation timeout, but succeeds on the server.
+		slow     string
+		existing string
+		retries  int
+		err      string
+		// created are the resources that Create returns.
+		created []string
+	}{
+		{
+			name:    "timed-out topic",
+			slow:    "projects/project1/topics/invoices",
+			retries: 1,
+			created: []string{"projects/project1/subscriptions/invoices-archive", "projects/project1/topics/invoices"},
+		},
+		{
+			name:    "timed-out subscription",
+			slow:    "projects/project1/subscriptions/invoices-archive",
+			retries: 2,
+			created: []string{"projects/project1/subscriptions/invoices-archive", "projects/project1/topics/invoices"},
+		},
+		{
+			name:     "existing without a timeout",
+			existing: "project1,invoices",
+			retries:  1,
+			err:      `Unable to create topic "invoices" for project "project1": rpc error: code = AlreadyExists`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := make(map[string]int)
+			delay := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				r, ok := req.(interface{ GetName() string })
+				if !ok {
+					return false, nil, nil
+				}
+
+				mu.Lock()
+				calls[r.GetName()]++
+				first := calls[r.GetName()] == 1
+				mu.Unlock()
+
+				if first && r.GetName() == tt.slow {
+					time.Sleep(500 * time.Millisecond)
+				}
+				return false, nil, nil
+			})
+
+			p, _ := newTestProvisioner(t,
+				pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: delay},
+				pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: delay},
+			)
+			if tt.existing != "" {
+				if err := create(t, p, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p.OpTimeout = 100 * time.Millisecond
+			p.Retries = tt.retries
+			// The retry waits until the slow creation is done.
+			p.Backoff = ConstantBackoff{Base: 600 * time.Millisecond}
+
+			cfg, err := (&Parser{}).Parse("project1,invoices:invoices-archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			created, err := p.Create(context.Background(), []Config{cfg})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Fatalf("expected an error starting with %q, got %v", tt.err, err)
+			}
+
+			var names []string
+			for _, r := range created {
+				names = append(names, r.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.created) {
+				t.Errorf("expected the created resources %q, got %q", tt.created, names)
+			}
+		})
+	}
+}
+
+func TestAttemptWrapsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		cancel  bool
+		err     string
+	}{
+		{name: "retries exhausted", retries: 2, err: "retries exhausted after 3 attempt(s)"},
+		{name: "cancelled", retries: 5, cancel: true, err: "cancelled after 1 attempt(s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &creator{Provisioner: &Provisioner{Retries: tt.retries, Backoff: ConstantBackoff{Base: time.Millisecond}}}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			err := c.attempt(ctx, func(context.Context) error {
+				if tt.cancel {
+					cancel()
+				}
+				return status.Error(codes.Unavailable, "try again")
+			})
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Fatalf("expected an error starting with %q, got %v", tt.err, err)
+			}
+			if code := status.Code(err); code != codes.Unavailable {
+				t.Errorf("expected the wrapped code Unavailable, got %s", code)
+			}
+		})
+	}
+}
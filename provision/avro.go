@@ -0,0 +1,332 @@
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// avroSchema is a parsed Avro schema that validates JSON encoded data.
+type avroSchema struct {
+	root interface{}
+
+	// named contains the record, enum and fixed types by their name and
+	// their full name.
+	named map[string]map[string]interface{}
+}
+
+// parseAvroSchema parses the JSON definition of an Avro schema.
+func parseAvroSchema(definition string) (*avroSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal([]byte(definition), &root); err != nil {
+		return nil, fmt.Errorf("Unable to decode Avro schema: %s", err)
+	}
+
+	s := &avroSchema{root: root, named: make(map[string]map[string]interface{})}
+	s.register(root, "")
+
+	return s, nil
+}
+
+// register adds the named types of the schema to the named types, with the
+// namespace that they inherit.
+func (s *avroSchema) register(schema interface{}, namespace string) {
+	switch schema := schema.(type) {
+	case []interface{}:
+		for _, branch := range schema {
+			s.register(branch, namespace)
+		}
+
+	case map[string]interface{}:
+		typ, _ := schema["type"].(string)
+		switch typ {
+		case "record", "error", "enum", "fixed":
+			name, _ := schema["name"].(string)
+			if ns, ok := schema["namespace"].(string); ok {
+				namespace = ns
+			}
+			if i := strings.LastIndexByte(name, '.'); i >= 0 {
+				namespace, name = name[:i], name[i+1:]
+			}
+
+			s.named[name] = schema
+			if namespace != "" {
+				s.named[namespace+"."+name] = schema
+			}
+		}
+
+		if fields, ok := schema["fields"].([]interface{}); ok {
+			for _, field := range fields {
+				if field, ok := field.(map[string]interface{}); ok {
+					s.register(field["type"], namespace)
+				}
+			}
+		}
+		if items, ok := schema["items"]; ok {
+			s.register(items, namespace)
+		}
+		if values, ok := schema["values"]; ok {
+			s.register(values, namespace)
+		}
+		if inner, ok := schema["type"].(map[string]interface{}); ok {
+			s.register(inner, namespace)
+		}
+	}
+}
+
+// validateJSON checks that the data is a JSON encoded value of the schema.
+func (s *avroSchema) validateJSON(data string) error {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("invalid JSON: %s", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid JSON: unexpected data after the value")
+	}
+
+	return s.validate(s.root, value, "")
+}
+
+// validate checks that the value matches the schema. The path is the field
+// that the value is of, for the errors.
+func (s *avroSchema) validate(schema, value interface{}, path string) error {
+	switch schema := schema.(type) {
+	case string:
+		if named, ok := s.named[schema]; ok {
+			return s.validate(named, value, path)
+		}
+
+		return s.validatePrimitive(schema, value, path)
+
+	case []interface{}:
+		return s.validateUnion(schema, value, path)
+
+	case map[string]interface{}:
+		switch typ := schema["type"].(type) {
+		case []interface{}, map[string]interface{}:
+			return s.validate(typ, value, path)
+
+		case string:
+			switch typ {
+			case "record", "error":
+				return s.validateRecord(schema, value, path)
+
+			case "enum":
+				symbol, ok := value.(string)
+				if !ok {
+					return avroMismatch(path, "an enum symbol", value)
+				}
+
+				symbols, _ := schema["symbols"].([]interface{})
+				for _, valid := range symbols {
+					if valid == symbol {
+						return nil
+					}
+				}
+
+				return fmt.Errorf("%s: %q is not a symbol of the enum", avroPath(path), symbol)
+
+			case "array":
+				items, ok := value.([]interface{})
+				if !ok {
+					return avroMismatch(path, "an array", value)
+				}
+
+				for i, item := range items {
+					if err := s.validate(schema["items"], item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+						return err
+					}
+				}
+
+				return nil
+
+			case "map":
+				values, ok := value.(map[string]interface{})
+				if !ok {
+					return avroMismatch(path, "a map", value)
+				}
+
+				for key, val := range values {
+					if err := s.validate(schema["values"], val, fmt.Sprintf("%s[%q]", path, key)); err != nil {
+						return err
+					}
+				}
+
+				return nil
+
+			case "fixed":
+				size, _ := schema["size"].(float64)
+				if str, ok := value.(string); !ok || utf8.RuneCountInString(str) != int(size) {
+					return avroMismatch(path, fmt.Sprintf("a fixed of %d byte(s)", int(size)), value)
+				}
+
+				return nil
+
+			default:
+				// Logical types, like a date, annotate a primitive type.
+				return s.validate(typ, value, path)
+			}
+		}
+	}
+
+	return fmt.Errorf("%s: unsupported schema %v", avroPath(path), schema)
+}
+
+// validatePrimitive checks that the value is of the primitive type.
+func (s *avroSchema) validatePrimitive(typ string, value interface{}, path string) error {
+	switch typ {
+	case "null":
+		if value != nil {
+			return avroMismatch(path, "null", value)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return avroMismatch(path, "a boolean", value)
+		}
+
+	case "int", "long":
+		n, ok := value.(json.Number)
+		if !ok {
+			return avroMismatch(path, "an integer", value)
+		}
+
+		i, err := n.Int64()
+		if err != nil || (typ == "int" && (i < math.MinInt32 || i > math.MaxInt32)) {
+			return avroMismatch(path, "an "+typ, value)
+		}
+
+	case "float", "double":
+		if _, ok := value.(json.Number); !ok {
+			return avroMismatch(path, "a number", value)
+		}
+
+	case "bytes", "string":
+		if _, ok := value.(string); !ok {
+			return avroMismatch(path, "a string", value)
+		}
+
+	default:
+		return fmt.Errorf("%s: unknown type %q", avroPath(path), typ)
+	}
+
+	return nil
+}
+
+// validateUnion checks that the value matches a branch of the union. In the
+// JSON encoding, a value other than null is wrapped in an object with the
+// name of its branch as the only key.
+func (s *avroSchema) validateUnion(branches []interface{}, value interface{}, path string) error {
+	if value == nil {
+		for _, branch := range branches {
+			if branch == "null" {
+				return nil
+			}
+		}
+
+		return avroMismatch(path, "a union value", value)
+	}
+
+	wrapped, ok := value.(map[string]interface{})
+	if !ok || len(wrapped) != 1 {
+		return avroMismatch(path, `a union value like {"<type>": <value>}`, value)
+	}
+
+	for name, val := range wrapped {
+		for _, branch := range branches {
+			// Named types are wrapped with their full name.
+			if typeName := avroTypeName(branch); typeName == name || strings.HasSuffix(name, "."+typeName) {
+				return s.validate(branch, val, path)
+			}
+		}
+
+		return fmt.Errorf("%s: %q is not a branch of the union", avroPath(path), name)
+	}
+
+	return nil
+}
+
+// validateRecord checks that the value has the fields of the record, except
+// for the fields with a default, and no other fields.
+func (s *avroSchema) validateRecord(schema map[string]interface{}, value interface{}, path string) error {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return avroMismatch(path, "a record", value)
+	}
+
+	known := make(map[string]bool)
+	fields, _ := schema["fields"].([]interface{})
+	for _, field := range fields {
+		field, _ := field.(map[string]interface{})
+		name, _ := field["name"].(string)
+		known[name] = true
+
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+
+		val, ok := object[name]
+		if !ok {
+			if _, ok := field["default"]; ok {
+				continue
+			}
+
+			return fmt.Errorf("field %q is missing", fieldPath)
+		}
+
+		if err := s.validate(field["type"], val, fieldPath); err != nil {
+			return err
+		}
+	}
+
+	for name := range object {
+		if !known[name] {
+			if path != "" {
+				name = path + "." + name
+			}
+
+			return fmt.Errorf("field %q is not part of the schema", name)
+		}
+	}
+
+	return nil
+}
+
+// avroTypeName returns the name of a type in a union.
+func avroTypeName(schema interface{}) string {
+	switch schema := schema.(type) {
+	case string:
+		return schema
+	case map[string]interface{}:
+		if name, ok := schema["name"].(string); ok {
+			return name
+		}
+		if typ, ok := schema["type"].(string); ok {
+			return typ
+		}
+	}
+
+	return ""
+}
+
+// avroPath describes the path of a value for the errors.
+func avroPath(path string) string {
+	if path == "" {
+		return "the message"
+	}
+
+	return fmt.Sprintf("field %q", path)
+}
+
+// avroMismatch returns the error for a value that is not of the expected
+// type.
+func avroMismatch(path, expected string, value interface{}) error {
+	b, _ := json.Marshal(value)
+	return fmt.Errorf("%s: expected %s, got %s", avroPath(path), expected, b)
+}
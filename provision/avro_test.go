@@ -0,0 +1,118 @@
+package provision
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAvroSchemaValidateJSON(t *testing.T) {
+	const order = `{
+  "type": "record",
+  "name": "Order",
+  "namespace": "com.example.shop",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "quantity", "type": "int"},
+    {"name": "total", "type": "double"},
+    {"name": "gift", "type": "boolean", "default": false},
+    {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["PLACED", "SHIPPED"]}},
+    {"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [{"name": "sku", "type": "string"}]}}},
+    {"name": "tags", "type": {"type": "map", "values": "string"}},
+    {"name": "coupon", "type": ["null", "string"]},
+    {"name": "shipping", "type": ["null", {"type": "record", "name": "Address", "fields": [{"name": "zip", "type": "string"}]}]},
+    {"name": "placed", "type": {"type": "int", "logicalType": "date"}},
+    {"name": "checksum", "type": {"type": "fixed", "name": "MD5", "size": 4}},
+    {"name": "previous", "type": ["null", "Item"], "default": null}
+  ]
+}`
+
+	// valid is a valid order, which the tests modify with replacements.
+	const valid = `{"id":9007199254740993,"quantity":2,"total":19.5,"status":"PLACED","items":[{"sku":"mug-blue"}],"tags":{"channel":"web"},"coupon":null,"shipping":{"com.example.shop.Address":{"zip":"1017"}},"placed":19500,"checksum":"a1b2"}`
+
+	tests := []struct {
+		name string
+		// old and new are replaced in the valid order.
+		old, new string
+		data     string
+		err      string
+	}{
+		{name: "valid"},
+		{name: "optional field set", old: `"coupon":null`, new: `"coupon":{"string":"SPRING10"},"gift":true`},
+		{name: "short union name", old: `"com.example.shop.Address"`, new: `"Address"`},
+		{name: "missing field", old: `"quantity":2,`, err: `field "quantity" is missing`},
+		{name: "unknown field", old: `"total":19.5`, new: `"total":19.5,"currency":"EUR"`, err: `field "currency" is not part of the schema`},
+		{name: "int out of range", old: `"quantity":2`, new: `"quantity":3000000000`, err: `field "quantity": expected an int, got 3000000000`},
+		{name: "long as a string", old: `"id":9007199254740993`, new: `"id":"9007199254740993"`, err: `field "id": expected an integer, got "9007199254740993"`},
+		{name: "fraction as an integer", old: `"quantity":2`, new: `"quantity":2.5`, err: `field "quantity": expected an int, got 2.5`},
+		{name: "unknown enum symbol", old: `"PLACED"`, new: `"LOST"`, err: `field "status": "LOST" is not a symbol of the enum`},
+		{name: "nested record", old: `{"sku":"mug-blue"}`, new: `{"sku":42}`, err: `field "items[0].sku": expected a string, got 42`},
+		{name: "map value", old: `{"channel":"web"}`, new: `{"channel":true}`, err: `field "tags[\"channel\"]": expected a string, got true`},
+		{name: "unwrapped union", old: `"coupon":null`, new: `"coupon":"SPRING10"`, err: `field "coupon": expected a union value like {"<type>": <value>}, got "SPRING10"`},
+		{name: "wrong union branch", old: `"coupon":null`, new: `"coupon":{"int":10}`, err: `field "coupon": "int" is not a branch of the union`},
+		{name: "logical type", old: `"placed":19500`, new: `"placed":"2023-05-24"`, err: `field "placed": expected an integer`},
+		{name: "fixed size", old: `"a1b2"`, new: `"a1b2c3"`, err: `field "checksum": expected a fixed of 4 byte(s), got "a1b2c3"`},
+		{name: "named type reference", old: `"checksum":"a1b2"`, new: `"checksum":"a1b2","previous":{"com.example.shop.Item":{"sku":7}}`, err: `field "previous.sku": expected a string, got 7`},
+		{name: "not a record", data: `["PLACED"]`, err: `the message: expected a record, got ["PLACED"]`},
+		{name: "invalid JSON", data: `{"id":`, err: "invalid JSON"},
+		{name: "trailing data", data: valid + `{}`, err: "invalid JSON: unexpected data after the value"},
+	}
+
+	schema, err := parseAvroSchema(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			if data == "" {
+				data = valid
+				if tt.old != "" {
+					if !strings.Contains(data, tt.old) {
+						t.Fatalf("the order does not contain %q", tt.old)
+					}
+					data = strings.Replace(data, tt.old, tt.new, 1)
+				}
+			}
+
+			err := schema.validateJSON(data)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParseAvroSchema(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		data       string
+		err        string
+	}{
+		{name: "primitive", definition: `"string"`, data: `"hello"`},
+		{name: "primitive mismatch", definition: `{"type":"long"}`, data: `"hello"`, err: `the message: expected an integer, got "hello"`},
+		{name: "top-level union", definition: `["null","double"]`, data: `{"double":1.5}`},
+		{name: "unknown type", definition: `{"type":"record","name":"Reading","fields":[{"name":"at","type":"instant"}]}`, data: `{"at":1}`, err: `field "at": unknown type "instant"`},
+		{name: "invalid definition", definition: `{"type":`, err: "Unable to decode Avro schema"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := parseAvroSchema(tt.definition)
+			if err == nil {
+				err = schema.validateJSON(tt.data)
+			}
+
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package provision
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Backoff is a strategy for the delays between the attempts of an operation.
+type Backoff interface {
+	// Delay returns the delay before the next attempt after the specified
+	// number of failed attempts, starting at 1.
+	Delay(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same delay between all attempts.
+type ConstantBackoff struct {
+	Base time.Duration
+}
+
+func (b ConstantBackoff) Delay(int) time.Duration {
+	return b.Base
+}
+
+// ExponentialBackoff starts at the base delay and doubles it with every
+// attempt, up to the max delay. With Jitter, every delay is randomized
+// between half of it and all of it, so that concurrent retries spread out.
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter bool
+}
+
+func (b ExponentialBackoff) Delay(attempt int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+
+	if b.Jitter && d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+
+	return d
+}
+
+// defaultBackoff is the backoff of a Provisioner without one.
+var defaultBackoff = ExponentialBackoff{Base: 250 * time.Millisecond, Max: 5 * time.Second, Jitter: true}
+
+// ParseBackoff returns the backoff strategy with the specified name, which is
+// constant, exponential or exponential-jitter.
+func ParseBackoff(name string, base, max time.Duration) (Backoff, error) {
+	if base <= 0 {
+		return nil, fmt.Errorf("the base delay must be positive, got %s", base)
+	}
+
+	switch name {
+	case "constant":
+		return ConstantBackoff{Base: base}, nil
+	case "exponential", "exponential-jitter":
+		if max < base {
+			return nil, fmt.Errorf("the max delay %s is below the base delay %s", max, base)
+		}
+
+		return ExponentialBackoff{Base: base, Max: max, Jitter: name == "exponential-jitter"}, nil
+	}
+
+	return nil, fmt.Errorf("expected constant, exponential or exponential-jitter, got %q", name)
+}
@@ -0,0 +1,168 @@
+package provision
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name    string
+		backoff Backoff
+		want    []time.Duration
+	}{
+		{
+			name:    "constant",
+			backoff: ConstantBackoff{Base: 300 * ms},
+			want:    []time.Duration{300 * ms, 300 * ms, 300 * ms, 300 * ms},
+		},
+		{
+			name:    "exponential",
+			backoff: ExponentialBackoff{Base: 100 * ms, Max: 2 * time.Second},
+			want:    []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 1600 * ms, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:    "exponential capped at the base",
+			backoff: ExponentialBackoff{Base: time.Second, Max: time.Second},
+			want:    []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:    "default",
+			backoff: ExponentialBackoff{Base: defaultBackoff.Base, Max: defaultBackoff.Max},
+			want:    []time.Duration{250 * ms, 500 * ms, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []time.Duration
+			for attempt := 1; attempt <= len(tt.want); attempt++ {
+				got = append(got, tt.backoff.Delay(attempt))
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the delays %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+	b := ExponentialBackoff{Base: 40 * time.Millisecond, Max: 300 * time.Millisecond, Jitter: true}
+	ceilings := []time.Duration{40, 80, 160, 300, 300}
+
+	for attempt, ceiling := range ceilings {
+		ceiling *= time.Millisecond
+
+		seen := make(map[time.Duration]bool)
+		for range 200 {
+			d := b.Delay(attempt + 1)
+			if d < ceiling/2 || d > ceiling {
+				t.Fatalf("attempt %d: expected a delay between %s and %s, got %s", attempt+1, ceiling/2, ceiling, d)
+			}
+			seen[d] = true
+		}
+
+		if len(seen) < 2 {
+			t.Errorf("attempt %d: expected randomized delays, got only %v", attempt+1, seen)
+		}
+	}
+}
+
+func TestParseBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		base, max time.Duration
+		want      Backoff
+		err       string
+	}{
+		{name: "constant", base: time.Second, want: ConstantBackoff{Base: time.Second}},
+		{name: "exponential", base: 50 * time.Millisecond, max: time.Second, want: ExponentialBackoff{Base: 50 * time.Millisecond, Max: time.Second}},
+		{name: "exponential-jitter", base: time.Second, max: 10 * time.Second, want: ExponentialBackoff{Base: time.Second, Max: 10 * time.Second, Jitter: true}},
+		{name: "exponential", base: 2 * time.Second, max: time.Second, err: "the max delay 1s is below the base delay 2s"},
+		{name: "constant", err: "the base delay must be positive, got 0s"},
+		{name: "linear", base: time.Second, max: time.Second, err: `expected constant, exponential or exponential-jitter, got "linear"`},
+		{name: "Exponential", base: time.Second, max: time.Second, err: `got "Exponential"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBackoff(tt.name, tt.base, tt.max)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case got != tt.want:
+				t.Errorf("expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
+
+// recordedBackoff is a constant backoff that records the attempts it is asked
+// the delay of.
+type recordedBackoff struct {
+	mu       sync.Mutex
+	attempts []int
+}
+
+func (b *recordedBackoff) Delay(attempt int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.attempts = append(b.attempts, attempt)
+	return time.Millisecond
+}
+
+func TestCreateBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		retries  int
+		want     []int
+	}{
+		{name: "succeeds after two failures", failures: 2, retries: 5, want: []int{1, 2}},
+		{name: "retries exhausted", failures: 10, retries: 3, want: []int{1, 2, 3}},
+		{name: "first attempt", retries: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failures int
+			p, _ := newTestProvisioner(t, pstest.ServerReactorOption{
+				FuncName: "CreateTopic",
+				Reactor: reactorFunc(func(interface{}) (bool, interface{}, error) {
+					if failures < tt.failures {
+						failures++
+						return true, nil, status.Error(codes.ResourceExhausted, "quota exceeded")
+					}
+					return false, nil, nil
+				}),
+			})
+
+			b := &recordedBackoff{}
+			p.Backoff, p.Retries = b, tt.retries
+
+			err := create(t, p, "project1,shipments")
+			if exhausted := tt.failures > tt.retries; exhausted != (err != nil) {
+				t.Fatalf("expected an error %t, got %v", exhausted, err)
+			}
+
+			if !slices.Equal(b.attempts, tt.want) {
+				t.Errorf("expected delays after the attempts %v, got %v", tt.want, b.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package provision
+
+import (
+	"fmt"
+	"os"
+)
+
+// capability describes an option that the emulator accepts, but does not act
+// on.
+type capability struct {
+	option string
+	note   string
+}
+
+// emulatorNoops is the capability matrix of the emulator. It lists the options
+// that have no effect when running against the emulator.
+var emulatorNoops = map[string]capability{
+	"kms":       {option: "kms", note: "the emulator does not encrypt messages with KMS keys"},
+	"bqtable":   {option: "bqtable", note: "the emulator does not write messages to BigQuery"},
+	"gcsbucket": {option: "gcsbucket", note: "the emulator does not write messages to Cloud Storage"},
+	"regions":   {option: "regions", note: "the emulator does not restrict where messages are stored"},
+	"ingest":    {option: "ingest", note: "the emulator does not ingest messages from external sources"},
+}
+
+// onEmulator returns true if the clients talk to the emulator.
+func onEmulator() bool {
+	return os.Getenv("PUBSUB_EMULATOR_HOST") != ""
+}
+
+// checkCapability reports the option of the specified resource if it is a
+// no-op on the emulator.
+func (c *creator) checkCapability(resource, option string) error {
+	if !onEmulator() {
+		return nil
+	}
+
+	capability, ok := emulatorNoops[option]
+	if !ok {
+		return nil
+	}
+
+	return c.noop(resource, capability.option, capability.note)
+}
+
+// noop reports an option of the specified resource that has no effect. In
+// strict mode this is an error, otherwise it is a warning.
+func (c *creator) noop(resource, option, note string) error {
+	if c.Strict {
+		return fmt.Errorf("%s: option %s has no effect: %s (disable strict mode to ignore)", resource, option, note)
+	}
+
+	c.warnf("%s: option %s has no effect: %s", resource, option, note)
+	return nil
+}
@@ -0,0 +1,64 @@
+package provision
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+func TestCreateStrict(t *testing.T) {
+	// dropFilter drops the filter of new subscriptions, like emulator
+	// versions without filter support do.
+	dropFilter := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+		req.(*pubsubpb.Subscription).Filter = ""
+		return false, nil, nil
+	})
+
+	tests := []struct {
+		name    string
+		config  string
+		warning string
+	}{
+		{name: "kms", config: "project1,audit[kms=projects/p/locations/l/keyRings/r/cryptoKeys/k]", warning: `Topic "audit": option kms has no effect: the emulator does not encrypt messages with KMS keys`},
+		{name: "bigquery", config: "project1,audit:audit-bq;bqtable=p.d.t", warning: `Subscription "audit-bq": option bqtable has no effect: the emulator does not write messages to BigQuery`},
+		{name: "dropped filter", config: `project1,audit:audit-eu;filter=attributes.region = "eu"`, warning: `Subscription "audit-eu": option filter has no effect: the subscription was created without the filter`},
+		{name: "extension periods", config: "project1,audit:audit-slow;maxextension=5m", warning: `Subscription "audit-slow": option minextension/maxextension has no effect`},
+		{name: "supported", config: "project1,audit:audit-all;ack=30s"},
+	}
+
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			name := tt.name
+			if strict {
+				name += " strict"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				p, _ := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: dropFilter})
+				logger := &testLogger{}
+				p.Logger, p.Strict = logger, strict
+
+				err := create(t, p, tt.config)
+				switch {
+				case tt.warning == "":
+					if err != nil || len(logger.warnings) > 0 {
+						t.Fatalf("expected no error or warnings, got %v and %q", err, logger.warnings)
+					}
+				case strict:
+					if err == nil || !strings.Contains(err.Error(), tt.warning) || !strings.Contains(err.Error(), "disable strict mode to ignore") {
+						t.Fatalf("expected an error containing %q, got %v", tt.warning, err)
+					}
+				default:
+					if err != nil {
+						t.Fatalf("unexpected error: %s", err)
+					}
+					if len(logger.warnings) != 1 || !strings.HasPrefix(logger.warnings[0], tt.warning) {
+						t.Errorf("expected the warning %q, got %q", tt.warning, logger.warnings)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ClientCache hands out a single PubSub client per project ID. It is safe for
+// concurrent use, and calls OnConnect for one client at a time.
+type ClientCache struct {
+	// OnConnect is called with every new client before it is handed out. If
+	// it returns an error, the client is closed and the error returned.
+	OnConnect func(ctx context.Context, client *pubsub.Client) error
+
+	// Options are passed to every new client.
+	Options []option.ClientOption
+
+	// ShareConnection makes the clients of all projects share a single gRPC
+	// connection to the emulator of PUBSUB_EMULATOR_HOST, which is dialed
+	// with DialOptions, instead of opening connections per project. It has
+	// no effect without the emulator.
+	ShareConnection bool
+	DialOptions     []grpc.DialOption
+
+	mu      sync.Mutex
+	conn    *grpc.ClientConn
+	clients map[string]*pubsub.Client
+}
+
+// Client returns the client for the specified project ID, connecting to the
+// PubSub service if no client exists yet.
+func (c *ClientCache) Client(ctx context.Context, projectID string) (*pubsub.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[projectID]; ok {
+		return client, nil
+	}
+
+	opts := c.Options
+	if c.ShareConnection && onEmulator() {
+		if c.conn == nil {
+			dialOpts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, c.DialOptions...)
+
+			conn, err := grpc.NewClient(os.Getenv("PUBSUB_EMULATOR_HOST"), dialOpts...)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to connect to the emulator: %s", err)
+			}
+			c.conn = conn
+		}
+
+		opts = append(opts[:len(opts):len(opts)], option.WithGRPCConn(c.conn))
+	}
+
+	client, err := pubsub.NewClient(ctx, projectID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create client to project %q: %s", projectID, err)
+	}
+
+	if c.OnConnect != nil {
+		if err := c.OnConnect(ctx, client); err != nil {
+			c.closeClient(client)
+			return nil, err
+		}
+	}
+
+	if c.clients == nil {
+		c.clients = make(map[string]*pubsub.Client)
+	}
+	c.clients[projectID] = client
+
+	return client, nil
+}
+
+// Close all the clients in the cache. All clients are closed, even if some of
+// them fail to, and the errors are returned together.
+func (c *ClientCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for projectID, client := range c.clients {
+		if err := c.closeClient(client); err != nil {
+			errs = append(errs, fmt.Errorf("Unable to close client to project %q: %s", projectID, err))
+		}
+	}
+	c.clients = nil
+
+	if c.conn != nil {
+		if err := c.conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("Unable to close the connection to the emulator: %s", err))
+		}
+		c.conn = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeClient closes the client, unless it uses the shared connection, which
+// closing a client would close for all of them. The shared connection is
+// closed by Close instead.
+func (c *ClientCache) closeClient(client *pubsub.Client) error {
+	if c.conn != nil {
+		return nil
+	}
+
+	return client.Close()
+}
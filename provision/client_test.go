@@ -0,0 +1,156 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestClientCacheShareConnection(t *testing.T) {
+	tests := []struct {
+		name       string
+		projectIDs []string
+		onConnect  func(ctx context.Context, client *pubsub.Client) error
+		err        bool
+	}{
+		{name: "single project", projectIDs: []string{"project1"}},
+		{name: "multiple projects", projectIDs: []string{"project1", "project2", "project3"}},
+		{
+			name:       "failed connect",
+			projectIDs: []string{"project1"},
+			onConnect:  func(context.Context, *pubsub.Client) error { return errors.New("not ready") },
+			err:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := pstest.NewServer()
+			defer srv.Close()
+			t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+			c := &ClientCache{ShareConnection: true, OnConnect: tt.onConnect}
+			for _, projectID := range tt.projectIDs {
+				if _, err := c.Client(context.Background(), projectID); (err != nil) != tt.err {
+					t.Fatalf("unexpected error for project %q: %v", projectID, err)
+				}
+			}
+
+			conn := c.conn
+			if conn == nil {
+				t.Fatal("expected a shared connection")
+			}
+
+			if err := c.Close(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if state := conn.GetState(); state != connectivity.Shutdown {
+				t.Errorf("expected the shared connection to be closed, got %s", state)
+			}
+			if c.conn != nil || c.clients != nil {
+				t.Error("expected the cache to be empty after Close")
+			}
+
+			// A closed cache can be used again, with a new connection.
+			if tt.onConnect == nil {
+				if _, err := c.Client(context.Background(), tt.projectIDs[0]); err != nil {
+					t.Fatalf("unexpected error after Close: %s", err)
+				}
+				if c.conn == nil || c.conn == conn {
+					t.Error("expected a new shared connection after Close")
+				}
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestClientCacheCloseAfterCancel(t *testing.T) {
+	tests := []struct {
+		name  string
+		share bool
+		// cancel cancels the context of the creation, before it starts if
+		// it is "before", or once the named call reaches the emulator.
+		cancel string
+		// closed are the projects whose clients are closed before the cache
+		// is, which the cache reports the errors of.
+		closed []string
+		err    string
+	}{
+		{name: "completed", share: true},
+		{name: "cancelled before creating", share: true, cancel: "before"},
+		{name: "cancelled while creating subscriptions", share: true, cancel: "CreateSubscription"},
+		{name: "cancelled with a connection per project", cancel: "CreateTopic"},
+		{
+			name:   "client already closed",
+			closed: []string{"ledger"},
+			err:    `Unable to close client to project "ledger"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var opts []pstest.ServerReactorOption
+			if tt.cancel != "" && tt.cancel != "before" {
+				opts = append(opts, pstest.ServerReactorOption{FuncName: tt.cancel, Reactor: reactorFunc(func(interface{}) (bool, interface{}, error) {
+					cancel()
+					return false, nil, nil
+				})})
+			}
+
+			srv := pstest.NewServer(opts...)
+			defer srv.Close()
+			t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+			c := &ClientCache{ShareConnection: tt.share}
+			if tt.cancel == "before" {
+				cancel()
+			}
+
+			var configs []Config
+			for _, definition := range []string{"ledger,entries:entries-auditor", "payouts,batches:batches-sender"} {
+				cfg, err := (&Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatal(err)
+				}
+				configs = append(configs, cfg)
+			}
+
+			_, err := (&Provisioner{Clients: c}).Create(ctx, configs)
+			if tt.cancel != "" && err == nil {
+				t.Error("expected the creation to fail")
+			}
+
+			conn := c.conn
+			for _, projectID := range tt.closed {
+				if err := c.clients[projectID].Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = c.Close()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+
+			// The clients are closed, also after a cancellation.
+			if c.clients != nil || c.conn != nil {
+				t.Error("expected the cache to be empty after Close")
+			}
+			if conn != nil && conn.GetState() != connectivity.Shutdown {
+				t.Errorf("expected the shared connection to be closed, got %s", conn.GetState())
+			}
+		})
+	}
+}
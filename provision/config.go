@@ -0,0 +1,1441 @@
+package provision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes the topics and subscriptions of a PubSub project.
+type Config struct {
+	// ProjectID is the project that the resources are created in. The
+	// package level functions default it to the project of the client.
+	ProjectID string
+	Topics    []Topic
+}
+
+// Topic describes a PubSub topic and its subscriptions.
+type Topic struct {
+	// ID is either a topic ID in the project, or the fully qualified
+	// "projects/<project>/topics/<topic>" name of a topic in another
+	// project, like a shared core project. Such a topic is not created or
+	// deleted, only its subscriptions are, and it must already exist unless
+	// its own project configures it.
+	ID            string
+	Subscriptions []Subscription
+
+	// SeedFile is a file with one JSON encoded seed message per line that
+	// are published once the topic and its subscriptions are created.
+	SeedFile string
+
+	// SeedCSVFile is a CSV file with a seed message per row, whose first
+	// column is the data and whose other columns are the attributes named by
+	// the header.
+	SeedCSVFile string
+
+	// KMSKeyName is the Cloud KMS key that protects access to the messages.
+	KMSKeyName string
+
+	// SeedOrderKey derives the ordering key of the seed messages that don't
+	// have one, either from an attribute, like "attr:customer", or from a
+	// round-robin of a number of keys, like "roundrobin:4".
+	SeedOrderKey string
+
+	// SeedDefaultKey is the ordering key of the seed messages that neither
+	// have one nor get one from SeedOrderKey, so that they are published in
+	// order.
+	SeedDefaultKey string
+
+	// SeedAttributes are added to every seed message, unless the message
+	// sets the attribute itself.
+	SeedAttributes map[string]string
+
+	// SeedCount repeats the seed messages this many times, as templates in
+	// which {i} is replaced by the number of the message, starting at 1, and
+	// {ts} by the time it was expanded at. Zero means that the seed messages
+	// are published once, as is.
+	SeedCount int
+
+	// Retention is how long the topic retains messages, including the ones
+	// that were acknowledged. Zero means the topic doesn't retain messages.
+	Retention time.Duration
+
+	// Schema is either a schema ID in the same project or a fully qualified
+	// "projects/<project>/schemas/<schema>" name that messages must match.
+	Schema string
+
+	// Regions are the regions that messages may be stored in. If empty, the
+	// organization policy decides.
+	Regions []string
+
+	// SchemaEncoding is the encoding of the messages, either "json" or
+	// "binary".
+	SchemaEncoding string
+
+	// Labels are the labels of the topic, which override the labels that
+	// the Provisioner applies to all resources.
+	Labels map[string]string
+
+	// Ingestion is the external source that the topic ingests messages
+	// from, if any.
+	Ingestion *Ingestion
+
+	// IAMFile is a JSON file with the IAM policy of the topic.
+	IAMFile string
+}
+
+// Subscription describes a PubSub subscription and its options.
+type Subscription struct {
+	ID          string
+	AckDeadline time.Duration
+
+	// Disabled subscriptions are not created, but their topic still is.
+	Disabled bool
+
+	// Retention is how long the subscription retains unacknowledged
+	// messages. Zero means the PubSub service default of 7 days. RetainAcked
+	// also retains the acknowledged messages for that long. Either can be set
+	// without the other.
+	Retention   time.Duration
+	RetainAcked bool
+
+	// MinExtensionPeriod and MaxExtensionPeriod are client-side receive
+	// settings. They are validated against the ack deadline, but the PubSub
+	// service does not store them with the subscription.
+	MinExtensionPeriod time.Duration
+	MaxExtensionPeriod time.Duration
+
+	// DeadLetterTopic is either a topic ID in the same project or a fully
+	// qualified "projects/<project>/topics/<topic>" name.
+	DeadLetterTopic     string
+	MaxDeliveryAttempts int
+
+	// DeadLetterSubscription is a subscription on the dead-letter topic, in
+	// the project of that topic, to inspect the dead-lettered messages with.
+	// It is created before the subscription itself.
+	DeadLetterSubscription string
+
+	// DeadLetterSubscriptionRetention and DeadLetterSubscriptionNoExpiration
+	// configure the dead-letter subscription independently from the
+	// subscription, so that dead-lettered messages stay around.
+	DeadLetterSubscriptionRetention    time.Duration
+	DeadLetterSubscriptionNoExpiration bool
+
+	// RetryMinBackoff and RetryMaxBackoff configure the retry policy. If
+	// both are zero, the subscription has no retry policy.
+	RetryMinBackoff time.Duration
+	RetryMaxBackoff time.Duration
+
+	ExactlyOnceDelivery bool
+
+	// Expiration is how long the subscription may be inactive before it is
+	// deleted. If NoExpiration is set, the subscription never expires. If
+	// neither is set, the PubSub service defaults to 31 days.
+	Expiration   time.Duration
+	NoExpiration bool
+
+	// Ordered subscriptions deliver the messages with the same ordering key
+	// in the order they were published in.
+	Ordered bool
+
+	// Filter is the expression that messages must match to be delivered.
+	Filter string
+
+	// BigQueryTable is the "project.dataset.table" to write messages to.
+	// BigQueryDropUnknownFields drops the fields of the messages that the
+	// table doesn't have, instead of failing to write those messages.
+	BigQueryTable             string
+	BigQueryDropUnknownFields bool
+
+	// CloudStorageBucket is the bucket to write messages to. A new file is
+	// started once CloudStorageMaxBytes or CloudStorageMaxDuration is
+	// reached. Zero means the PubSub service defaults apply.
+	CloudStorageBucket      string
+	CloudStorageMaxBytes    int64
+	CloudStorageMaxDuration time.Duration
+
+	// PushEndpoint is the URL to push messages to. Without it, the
+	// subscription is a pull subscription.
+	PushEndpoint string
+
+	// PushServiceAccount is the service account whose OIDC token
+	// authenticates the pushes. PushServiceAccountRef is the file or
+	// environment variable reference that it was resolved from, if any,
+	// which the printed configs show instead of the resolved value.
+	PushServiceAccount    string
+	PushServiceAccountRef string
+
+	// TransformFile is a file with a JavaScript UDF that transforms the
+	// messages before they are delivered.
+	TransformFile string
+
+	// IAMFile is a JSON file with the IAM policy of the subscription.
+	IAMFile string
+
+	// Labels are the labels of the subscription, which override the labels
+	// that the Provisioner applies to all resources.
+	Labels map[string]string
+}
+
+// WithPrefix returns a copy of the config with the prefix applied to the
+// names of its topics and subscriptions, and to the dead-letter topics that
+// refer to the project itself.
+func (p Config) WithPrefix(prefix string) Config {
+	if prefix == "" {
+		return p
+	}
+
+	topics := make([]Topic, len(p.Topics))
+	for i, topic := range p.Topics {
+		if !topic.external() {
+			topic.ID = prefix + topic.ID
+		}
+
+		subscriptions := make([]Subscription, len(topic.Subscriptions))
+		for j, subscription := range topic.Subscriptions {
+			subscription.ID = prefix + subscription.ID
+
+			if ref := subscription.DeadLetterTopic; ref != "" {
+				if projectID, topicID := splitTopicName(p.ProjectID, ref); projectID == p.ProjectID {
+					if subscription.DeadLetterSubscription != "" {
+						subscription.DeadLetterSubscription = prefix + subscription.DeadLetterSubscription
+					}
+
+					if ref == topicID {
+						subscription.DeadLetterTopic = prefix + topicID
+					} else {
+						subscription.DeadLetterTopic = fmt.Sprintf("projects/%s/topics/%s%s", projectID, prefix, topicID)
+					}
+				}
+			}
+
+			subscriptions[j] = subscription
+		}
+
+		topic.Subscriptions = subscriptions
+		topics[i] = topic
+	}
+
+	p.Topics = topics
+	return p
+}
+
+// WithProjectIDs returns a copy of the config in which its project ID, and the
+// project IDs of its fully qualified topic, dead-letter topic and schema
+// references, are replaced by the project IDs that they map to.
+func (p Config) WithProjectIDs(projectIDs map[string]string) Config {
+	rename := func(projectID string) string {
+		if renamed, ok := projectIDs[projectID]; ok {
+			return renamed
+		}
+
+		return projectID
+	}
+
+	topics := make([]Topic, len(p.Topics))
+	for i, topic := range p.Topics {
+		if topic.external() {
+			projectID, topicID := splitTopicName(p.ProjectID, topic.ID)
+			topic.ID = fmt.Sprintf("projects/%s/topics/%s", rename(projectID), topicID)
+		}
+		if parts := strings.Split(topic.Schema, "/"); len(parts) == 4 && parts[0] == "projects" && parts[2] == "schemas" {
+			topic.Schema = fmt.Sprintf("projects/%s/schemas/%s", rename(parts[1]), parts[3])
+		}
+
+		subscriptions := make([]Subscription, len(topic.Subscriptions))
+		for j, subscription := range topic.Subscriptions {
+			if projectID, topicID := splitTopicName(p.ProjectID, subscription.DeadLetterTopic); topicID != subscription.DeadLetterTopic {
+				subscription.DeadLetterTopic = fmt.Sprintf("projects/%s/topics/%s", rename(projectID), topicID)
+			}
+
+			subscriptions[j] = subscription
+		}
+
+		topic.Subscriptions = subscriptions
+		topics[i] = topic
+	}
+
+	p.ProjectID = rename(p.ProjectID)
+	p.Topics = topics
+	return p
+}
+
+// ParseConfig parses a definition of the form
+// "project,topic1[option=value],topic2:subscription1;option=value", which is
+// the form of the PUBSUB_PROJECT environment variables.
+func ParseConfig(value string) (Config, error) {
+	return (&Parser{}).Parse(value)
+}
+
+// Parser parses config definitions.
+type Parser struct {
+	// ClampDurations clamps durations that are out of range to the nearest
+	// valid bound instead of failing.
+	ClampDurations bool
+
+	// NoExpire makes the subscriptions without an expire option never
+	// expire, along with their dead-letter subscriptions.
+	NoExpire bool
+
+	// AllowEmpty accepts definitions with only a project ID and no topics.
+	AllowEmpty bool
+
+	// MaxExpansion is the maximum number of topic definitions that the range
+	// templates of a config expand to. It defaults to 1000.
+	MaxExpansion int
+
+	// SubscriptionDefaults are options like "ack=30s" that apply to every
+	// subscription that doesn't set the same option itself. An explicit
+	// retrypreset also overrides the retrymin and retrymax defaults.
+	SubscriptionDefaults []string
+
+	// Logger receives the adjustments of the clamped durations, or is nil to
+	// discard them.
+	Logger Logger
+}
+
+// errExpansionLimit is returned when range templates expand to too many
+// definitions.
+var errExpansionLimit = errors.New("expansion limit exceeded")
+
+// rangeRegexp matches a range template like "[1-100]".
+var rangeRegexp = regexp.MustCompile(`\[(\d+)-(\d+)\]`)
+
+// Parse parses a config definition like ParseConfig does. A topic definition
+// can contain range templates, like "topic-[1-3]:sub-[1-3]", which expands to
+// a topic definition per number in the range, with every range template
+// replaced by that number.
+func (p *Parser) Parse(value string) (Config, error) {
+	// Separate the projectID from the topic definitions.
+	parts := splitOutside(value, ',')
+	if len(parts) < 2 && !(p.AllowEmpty && len(parts) == 1 && parts[0] != "") {
+		return Config{}, fmt.Errorf("Expected at least 1 topic to be defined")
+	}
+
+	maxExpansion := p.MaxExpansion
+	if maxExpansion <= 0 {
+		maxExpansion = 1000
+	}
+
+	var expanded int
+
+	project := Config{ProjectID: parts[0]}
+	for _, part := range parts[1:] {
+		definitions, err := expandRanges(part, maxExpansion-expanded)
+		switch {
+		case err == errExpansionLimit:
+			return Config{}, fmt.Errorf("Range templates expand to more than %d topic definitions", maxExpansion)
+		case err != nil:
+			return Config{}, err
+		}
+
+		if len(definitions) > 1 {
+			expanded += len(definitions)
+		}
+
+		for _, definition := range definitions {
+			topic, err := p.parseTopic(definition)
+			if err != nil {
+				return Config{}, err
+			}
+
+			project.Topics = append(project.Topics, topic)
+		}
+	}
+
+	return project, nil
+}
+
+// expandRanges expands the range templates of a topic definition to at most
+// limit definitions. All the range templates of a definition must have the
+// same range. A range whose start has leading zeros expands to numbers of the
+// same width.
+func expandRanges(value string, limit int) ([]string, error) {
+	matches := rangeRegexp.FindAllStringSubmatch(value, -1)
+	if matches == nil {
+		return []string{value}, nil
+	}
+
+	for _, m := range matches[1:] {
+		if m[0] != matches[0][0] {
+			return nil, fmt.Errorf("Topic %q: range templates %s and %s differ", value, matches[0][0], m[0])
+		}
+	}
+
+	start, _ := strconv.Atoi(matches[0][1])
+	end, err := strconv.Atoi(matches[0][2])
+	if err != nil || start > end {
+		return nil, fmt.Errorf("Topic %q: invalid range template %s", value, matches[0][0])
+	}
+
+	if end-start+1 > limit {
+		return nil, errExpansionLimit
+	}
+
+	width := 0
+	if digits := matches[0][1]; len(digits) > 1 && digits[0] == '0' {
+		width = len(digits)
+	}
+
+	definitions := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		definitions = append(definitions, rangeRegexp.ReplaceAllLiteralString(value, fmt.Sprintf("%0*d", width, i)))
+	}
+
+	return definitions, nil
+}
+
+// parseTopic parses a topic definition of the form
+// "topic1[option1=value1,option2=value2]:subscription1:subscription2", in
+// which subscriptions that share their options can be grouped, like
+// ":(subscription1,subscription2);option1=value1".
+func (p *Parser) parseTopic(value string) (Topic, error) {
+	// Separate the topicID from the subscription definitions.
+	parts := splitOutside(value, ':')
+
+	topic := Topic{ID: parts[0]}
+	if i := strings.IndexByte(parts[0], '['); i >= 0 {
+		if !strings.HasSuffix(parts[0], "]") {
+			return Topic{}, fmt.Errorf("Topic %q: expected the options to end with ]", parts[0])
+		}
+
+		topic.ID = parts[0][:i]
+		for _, option := range splitOutside(parts[0][i+1:len(parts[0])-1], ',') {
+			if option == "" {
+				continue
+			}
+
+			key, val, _ := strings.Cut(option, "=")
+			if err := checkOptionKey(key, topicOptionKeys); err != nil {
+				return Topic{}, fmt.Errorf("Topic %q: %s", topic.ID, err)
+			}
+
+			switch key {
+			case "seed":
+				if val == "" {
+					return Topic{}, fmt.Errorf("Topic %q: seed must not be empty", topic.ID)
+				}
+				topic.SeedFile = val
+
+			case "seedcsv":
+				if val == "" {
+					return Topic{}, fmt.Errorf("Topic %q: seedcsv must not be empty", topic.ID)
+				}
+				topic.SeedCSVFile = val
+
+			case "kms":
+				if !strings.HasPrefix(val, "projects/") {
+					return Topic{}, fmt.Errorf("Topic %q: kms must be of the form projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>, got %q", topic.ID, val)
+				}
+				topic.KMSKeyName = val
+
+			case "seedorderkey":
+				if err := validateSeedOrderKey(val); err != nil {
+					return Topic{}, fmt.Errorf("Topic %q: seedorderkey: %s", topic.ID, err)
+				}
+				topic.SeedOrderKey = val
+
+			case "seedcount":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 1 || n > maxSeedCount {
+					return Topic{}, fmt.Errorf("Topic %q: seedcount must be between 1 and %d, got %q", topic.ID, maxSeedCount, val)
+				}
+				topic.SeedCount = n
+
+			case "seeddefaultkey":
+				if val == "" {
+					return Topic{}, fmt.Errorf("Topic %q: seeddefaultkey must not be empty", topic.ID)
+				}
+				topic.SeedDefaultKey = val
+
+			case "labels":
+				labels, err := parseLabels(val)
+				if err != nil {
+					return Topic{}, fmt.Errorf("Topic %q: labels: %s", topic.ID, err)
+				}
+				topic.Labels = labels
+
+			case "seedattrs":
+				attrs, err := parseAttributes(val)
+				if err != nil {
+					return Topic{}, fmt.Errorf("Topic %q: seedattrs: %s", topic.ID, err)
+				}
+				topic.SeedAttributes = attrs
+
+			case "retain":
+				d, err := p.duration(fmt.Sprintf("Topic %q", topic.ID), key, val, 10*time.Minute, 31*24*time.Hour)
+				if err != nil {
+					return Topic{}, fmt.Errorf("Topic %q: %s", topic.ID, err)
+				}
+				topic.Retention = d
+
+			case "schema":
+				if val == "" {
+					return Topic{}, fmt.Errorf("Topic %q: schema must not be empty", topic.ID)
+				}
+				topic.Schema = val
+
+			case "regions":
+				for _, region := range strings.Split(val, ";") {
+					if region == "" {
+						return Topic{}, fmt.Errorf("Topic %q: regions must be of the form region1;region2, got %q", topic.ID, val)
+					}
+					topic.Regions = append(topic.Regions, region)
+				}
+
+			case "iamfile":
+				if val == "" {
+					return Topic{}, fmt.Errorf("Topic %q: iamfile must not be empty", topic.ID)
+				}
+				topic.IAMFile = val
+
+			case "ingest":
+				ingestion, err := parseIngestion(val)
+				if err != nil {
+					return Topic{}, fmt.Errorf("Topic %q: ingest: %s", topic.ID, err)
+				}
+				topic.Ingestion = ingestion
+
+			case "encoding":
+				if _, ok := schemaEncodings[val]; !ok {
+					return Topic{}, fmt.Errorf("Topic %q: encoding must be json or binary, got %q", topic.ID, val)
+				}
+				topic.SchemaEncoding = val
+			}
+		}
+
+		if topic.SchemaEncoding != "" && topic.Schema == "" {
+			return Topic{}, fmt.Errorf("Topic %q: encoding requires schema", topic.ID)
+		}
+
+		if topic.SeedCount > 0 && topic.SeedFile == "" && topic.SeedCSVFile == "" {
+			return Topic{}, fmt.Errorf("Topic %q: seedcount requires seed or seedcsv", topic.ID)
+		}
+	}
+
+	// Topics in other projects are only subscribed to, so they can't have
+	// options of their own.
+	if topic.external() {
+		if _, topicID := splitTopicName("", topic.ID); topicID == topic.ID {
+			return Topic{}, fmt.Errorf("Topic %q: expected a topic in another project to be of the form projects/<project>/topics/<topic>", topic.ID)
+		}
+		if len(parts[0]) > len(topic.ID) {
+			return Topic{}, fmt.Errorf("Topic %q: the options of a topic in another project cannot be set", topic.ID)
+		}
+	}
+
+	for _, subscriptionPart := range parts[1:] {
+		definitions, err := expandGroup(subscriptionPart)
+		if err != nil {
+			return Topic{}, fmt.Errorf("Topic %q: %s", topic.ID, err)
+		}
+
+		for _, definition := range definitions {
+			subscription, err := p.parseSubscription(definition)
+			if err != nil {
+				return Topic{}, fmt.Errorf("Topic %q: %s", topic.ID, err)
+			}
+
+			topic.Subscriptions = append(topic.Subscriptions, subscription)
+		}
+	}
+
+	p.checkOrderingRegions(topic)
+
+	return topic, nil
+}
+
+// checkOrderingRegions warns about ordered subscriptions on a topic that may
+// store messages in multiple regions. Messages are only delivered in order if
+// they are published in the same region.
+func (p *Parser) checkOrderingRegions(topic Topic) {
+	if len(topic.Regions) < 2 || p.Logger == nil {
+		return
+	}
+
+	for _, s := range topic.Subscriptions {
+		if s.Ordered {
+			p.Logger.Warnf("Subscription %q: topic %q stores messages in regions %s, and only messages that are published in the same region are delivered in order", s.ID, topic.ID, strings.Join(topic.Regions, ", "))
+		}
+	}
+}
+
+// expandGroup expands a group of subscriptions that share their options, of
+// the form "(subscription1,subscription2);option1=value1", to a definition per
+// subscription. Other definitions are returned as they are.
+func expandGroup(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "(") {
+		return []string{value}, nil
+	}
+
+	end := strings.IndexByte(value, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("Subscription group %q: expected the group to end with )", value)
+	}
+
+	options := value[end+1:]
+	if options != "" && options[0] != ';' {
+		return nil, fmt.Errorf("Subscription group %q: expected ; after the group, got %q", value, options)
+	}
+
+	var definitions []string
+	for _, id := range strings.Split(value[1:end], ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			return nil, fmt.Errorf("Subscription group %q: subscription IDs must not be empty", value)
+		}
+
+		definitions = append(definitions, id+options)
+	}
+
+	return definitions, nil
+}
+
+// withDefaults returns the subscription options, preceded by the
+// SubscriptionDefaults that the options don't override.
+func (p *Parser) withDefaults(options []string) []string {
+	if len(p.SubscriptionDefaults) == 0 {
+		return options
+	}
+
+	explicit := make(map[string]bool)
+	for _, option := range options {
+		key, _, _ := strings.Cut(option, "=")
+		explicit[key] = true
+	}
+	if explicit["retrypreset"] {
+		explicit["retrymin"], explicit["retrymax"] = true, true
+	}
+	if explicit["filter"] || explicit["filterfile"] {
+		explicit["filter"], explicit["filterfile"] = true, true
+	}
+
+	var merged []string
+	for _, option := range p.SubscriptionDefaults {
+		if key, _, _ := strings.Cut(option, "="); !explicit[key] {
+			merged = append(merged, option)
+		}
+	}
+
+	return append(merged, options...)
+}
+
+// parseSubscription parses a subscription definition of the form
+// "subscription1;option1=value1;option2=value2".
+func (p *Parser) parseSubscription(value string) (Subscription, error) {
+	parts := splitOutside(value, ';')
+
+	subscription := Subscription{ID: parts[0]}
+
+	var preset *retryPreset
+	var dlqNoExpireSet bool
+	for _, option := range p.withDefaults(parts[1:]) {
+		if option == "" {
+			continue
+		}
+
+		key, val, _ := strings.Cut(option, "=")
+		if err := checkOptionKey(key, subscriptionOptionKeys); err != nil {
+			return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+		}
+
+		switch key {
+		case "ack":
+			d, err := p.duration(fmt.Sprintf("Subscription %q", subscription.ID), key, val, 10*time.Second, 600*time.Second)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+			subscription.AckDeadline = d
+
+		case "enabled":
+			b, err := parseBool(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: enabled: %s", subscription.ID, err)
+			}
+			subscription.Disabled = !b
+
+		case "minextension", "maxextension":
+			d, err := p.duration(fmt.Sprintf("Subscription %q", subscription.ID), key, val, 10*time.Second, 600*time.Second)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+
+			if key == "minextension" {
+				subscription.MinExtensionPeriod = d
+			} else {
+				subscription.MaxExtensionPeriod = d
+			}
+
+		case "dlq":
+			subscription.DeadLetterTopic = val
+
+		case "dlqsub":
+			if val == "" {
+				return Subscription{}, fmt.Errorf("Subscription %q: dlqsub must not be empty", subscription.ID)
+			}
+			subscription.DeadLetterSubscription = val
+
+		case "dlqsubretain":
+			d, err := p.duration(fmt.Sprintf("Subscription %q", subscription.ID), key, retentionBound(val, minSubscriptionRetention, maxSubscriptionRetention), minSubscriptionRetention, maxSubscriptionRetention)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+			subscription.DeadLetterSubscriptionRetention = d
+
+		case "dlqsubnoexpire":
+			b, err := parseBool(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: dlqsubnoexpire: %s", subscription.ID, err)
+			}
+			subscription.DeadLetterSubscriptionNoExpiration = b
+			dlqNoExpireSet = true
+
+		case "maxdelivery":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 5 || n > 100 {
+				return Subscription{}, fmt.Errorf("Subscription %q: maxdelivery must be between 5 and 100, got %q", subscription.ID, val)
+			}
+			subscription.MaxDeliveryAttempts = n
+
+		case "filter", "filterfile":
+			if subscription.Filter != "" {
+				return Subscription{}, fmt.Errorf("Subscription %q: filter and filterfile are mutually exclusive", subscription.ID)
+			}
+
+			if key == "filterfile" {
+				filter, err := readFilterFile(val)
+				if err != nil {
+					return Subscription{}, fmt.Errorf("Subscription %q: filterfile: %s", subscription.ID, err)
+				}
+				val = filter
+			}
+
+			if err := validateFilter(val); err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s: %s", subscription.ID, key, err)
+			}
+			subscription.Filter = val
+
+		case "retrymin", "retrymax":
+			d, err := p.duration(fmt.Sprintf("Subscription %q", subscription.ID), key, val, 0, 600*time.Second)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+
+			if key == "retrymin" {
+				subscription.RetryMinBackoff = d
+			} else {
+				subscription.RetryMaxBackoff = d
+			}
+
+		case "retrypreset":
+			rp, err := lookupRetryPreset(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+			preset = &rp
+
+		case "retain":
+			d, err := p.duration(fmt.Sprintf("Subscription %q", subscription.ID), key, retentionBound(val, minSubscriptionRetention, maxSubscriptionRetention), minSubscriptionRetention, maxSubscriptionRetention)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+			subscription.Retention = d
+
+		case "retainacked":
+			b, err := parseBool(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: retainacked: %s", subscription.ID, err)
+			}
+			subscription.RetainAcked = b
+
+		case "exactlyonce":
+			b, err := parseBool(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: exactlyonce: %s", subscription.ID, err)
+			}
+			subscription.ExactlyOnceDelivery = b
+
+		case "expire":
+			if val == "never" {
+				subscription.NoExpiration = true
+				break
+			}
+
+			d, err := p.duration(fmt.Sprintf("Subscription %q", subscription.ID), key, val, 24*time.Hour, 0)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+			subscription.Expiration = d
+
+		case "ordered":
+			b, err := parseBool(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: ordered: %s", subscription.ID, err)
+			}
+			subscription.Ordered = b
+
+		case "bqtable":
+			if strings.Count(val, ".") != 2 {
+				return Subscription{}, fmt.Errorf("Subscription %q: bqtable must be of the form project.dataset.table, got %q", subscription.ID, val)
+			}
+			subscription.BigQueryTable = val
+
+		case "bqdropunknown":
+			b, err := parseBool(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: bqdropunknown: %s", subscription.ID, err)
+			}
+			subscription.BigQueryDropUnknownFields = b
+
+		case "gcsbucket":
+			if val == "" {
+				return Subscription{}, fmt.Errorf("Subscription %q: gcsbucket must not be empty", subscription.ID)
+			}
+			subscription.CloudStorageBucket = val
+
+		case "gcsmaxbytes":
+			size, err := parseSize(key, val)
+			if err == nil {
+				err = checkSize(key, size, 1000, 10<<30)
+			}
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+			subscription.CloudStorageMaxBytes = size
+
+		case "gcsmaxduration":
+			d, err := p.duration(fmt.Sprintf("Subscription %q", subscription.ID), key, val, time.Minute, 10*time.Minute)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: %s", subscription.ID, err)
+			}
+			subscription.CloudStorageMaxDuration = d
+
+		case "push":
+			endpoint, err := unquote(val)
+			if err != nil || !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+				return Subscription{}, fmt.Errorf("Subscription %q: push must be a quoted http(s) URL, like push=\"https://host/path\", got %q", subscription.ID, val)
+			}
+			subscription.PushEndpoint = endpoint
+
+		case "pushsa":
+			if val == redacted {
+				return Subscription{}, fmt.Errorf("Subscription %q: pushsa: the value is redacted, set the service account or a reference to it instead", subscription.ID)
+			}
+
+			account, err := resolveIndirection(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: pushsa: %s", subscription.ID, err)
+			}
+			subscription.PushServiceAccount = account
+			if account != val {
+				subscription.PushServiceAccountRef = val
+			}
+
+		case "transform":
+			if val == "" {
+				return Subscription{}, fmt.Errorf("Subscription %q: transform must not be empty", subscription.ID)
+			}
+			subscription.TransformFile = val
+
+		case "iamfile":
+			if val == "" {
+				return Subscription{}, fmt.Errorf("Subscription %q: iamfile must not be empty", subscription.ID)
+			}
+			subscription.IAMFile = val
+
+		case "labels":
+			// The labels must be quoted, because their colons would
+			// separate the subscriptions otherwise.
+			if !strings.HasPrefix(val, `"`) {
+				return Subscription{}, fmt.Errorf("Subscription %q: labels must be quoted, like labels=\"key1:value1;key2:value2\"", subscription.ID)
+			}
+
+			value, err := unquote(val)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: labels: %s", subscription.ID, err)
+			}
+
+			labels, err := parseLabels(value)
+			if err != nil {
+				return Subscription{}, fmt.Errorf("Subscription %q: labels: %s", subscription.ID, err)
+			}
+			subscription.Labels = labels
+		}
+	}
+
+	// Explicit retry backoffs override the ones of the preset.
+	if preset != nil {
+		if subscription.RetryMinBackoff == 0 {
+			subscription.RetryMinBackoff = preset.minBackoff
+		}
+		if subscription.RetryMaxBackoff == 0 {
+			subscription.RetryMaxBackoff = preset.maxBackoff
+		}
+	}
+
+	if min, max := subscription.RetryMinBackoff, subscription.RetryMaxBackoff; max > 0 && min > max {
+		return Subscription{}, fmt.Errorf("Subscription %q: retrymin %s exceeds retrymax %s", subscription.ID, min, max)
+	}
+
+	if p.NoExpire && subscription.Expiration == 0 {
+		subscription.NoExpiration = true
+	}
+	if p.NoExpire && subscription.DeadLetterSubscription != "" && !dlqNoExpireSet {
+		subscription.DeadLetterSubscriptionNoExpiration = true
+	}
+
+	if subscription.BigQueryDropUnknownFields && subscription.BigQueryTable == "" {
+		return Subscription{}, fmt.Errorf("Subscription %q: bqdropunknown requires bqtable", subscription.ID)
+	}
+
+	if subscription.PushServiceAccount != "" && subscription.PushEndpoint == "" {
+		return Subscription{}, fmt.Errorf("Subscription %q: pushsa requires push", subscription.ID)
+	}
+
+	switch dlqsub := subscription.DeadLetterSubscription; {
+	case dlqsub != "" && subscription.DeadLetterTopic == "":
+		return Subscription{}, fmt.Errorf("Subscription %q: dlqsub requires dlq", subscription.ID)
+	case dlqsub == subscription.ID:
+		return Subscription{}, fmt.Errorf("Subscription %q: dlqsub must differ from the subscription itself", subscription.ID)
+	case dlqsub == "" && (subscription.DeadLetterSubscriptionRetention > 0 || subscription.DeadLetterSubscriptionNoExpiration):
+		return Subscription{}, fmt.Errorf("Subscription %q: dlqsubretain and dlqsubnoexpire require dlqsub", subscription.ID)
+	}
+
+	if subscription.BigQueryTable != "" && subscription.CloudStorageBucket != "" {
+		return Subscription{}, fmt.Errorf("Subscription %q: bqtable and gcsbucket are mutually exclusive", subscription.ID)
+	}
+
+	if (subscription.CloudStorageMaxBytes > 0 || subscription.CloudStorageMaxDuration > 0) && subscription.CloudStorageBucket == "" {
+		return Subscription{}, fmt.Errorf("Subscription %q: gcsmaxbytes and gcsmaxduration require gcsbucket", subscription.ID)
+	}
+
+	if min, max := subscription.MinExtensionPeriod, subscription.MaxExtensionPeriod; min > 0 && max > 0 && min > max {
+		return Subscription{}, fmt.Errorf("Subscription %q: minextension %s exceeds maxextension %s", subscription.ID, min, max)
+	}
+
+	return subscription, nil
+}
+
+// FormatConfig formats a config in the form that ParseConfig accepts.
+func FormatConfig(project Config) string {
+	return formatConfig(project, redactSubscription)
+}
+
+// FormatConfigUnredacted is like FormatConfig, but keeps the push service
+// accounts that were set inline, so that the formatted config parses again.
+// An account resolved from a reference is still formatted as the reference.
+func FormatConfigUnredacted(project Config) string {
+	return formatConfig(project, unredactSubscription)
+}
+
+// formatConfig formats a config with the subscriptions prepared by prepare.
+func formatConfig(project Config, prepare func(Subscription) Subscription) string {
+	parts := []string{project.ProjectID}
+	for _, topic := range project.Topics {
+		part := topic.ID
+		if options := allTopicOptions(topic); len(options) > 0 {
+			part += "[" + strings.Join(options, ",") + "]"
+		}
+
+		for _, subscription := range topic.Subscriptions {
+			part += ":" + formatSubscription(prepare(subscription))
+		}
+
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// redacted replaces the secret values of printed configs whose reference is
+// unknown.
+const redacted = "<redacted>"
+
+// redactSubscription returns the subscription with its push service account
+// replaced by the reference it was resolved from, so that printing it doesn't
+// reveal a secret. A service account without a reference, like that of a live
+// subscription, is redacted.
+func redactSubscription(s Subscription) Subscription {
+	switch {
+	case s.PushServiceAccountRef != "":
+		s.PushServiceAccount = s.PushServiceAccountRef
+	case s.PushServiceAccount != "":
+		s.PushServiceAccount = redacted
+	}
+
+	s.PushServiceAccountRef = ""
+	return s
+}
+
+// unredactSubscription returns the subscription with its push service account
+// replaced by the reference it was resolved from, if any.
+func unredactSubscription(s Subscription) Subscription {
+	if s.PushServiceAccountRef != "" {
+		s.PushServiceAccount = s.PushServiceAccountRef
+	}
+
+	s.PushServiceAccountRef = ""
+	return s
+}
+
+// allTopicOptions returns all the options of the topic.
+func allTopicOptions(t Topic) []string {
+	options := append(topicOptions(t), seedOptions(t)...)
+	if len(t.Labels) > 0 {
+		options = append(options, "labels="+formatAttributes(t.Labels))
+	}
+	if t.IAMFile != "" {
+		options = append(options, "iamfile="+t.IAMFile)
+	}
+
+	return options
+}
+
+// topicOptions returns the options that configure the topic itself.
+func topicOptions(t Topic) []string {
+	var options []string
+	if t.KMSKeyName != "" {
+		options = append(options, "kms="+t.KMSKeyName)
+	}
+	if t.Retention > 0 {
+		options = append(options, "retain="+t.Retention.String())
+	}
+	if len(t.Regions) > 0 {
+		options = append(options, "regions="+strings.Join(t.Regions, ";"))
+	}
+	if t.Schema != "" {
+		options = append(options, "schema="+t.Schema)
+	}
+	if t.SchemaEncoding != "" {
+		options = append(options, "encoding="+t.SchemaEncoding)
+	}
+	if t.Ingestion != nil {
+		options = append(options, "ingest="+t.Ingestion.String())
+	}
+
+	return options
+}
+
+// seedOptions returns the options that configure the seeding of the topic.
+func seedOptions(t Topic) []string {
+	var options []string
+	if t.SeedFile != "" {
+		options = append(options, "seed="+t.SeedFile)
+	}
+	if t.SeedCSVFile != "" {
+		options = append(options, "seedcsv="+t.SeedCSVFile)
+	}
+	if t.SeedOrderKey != "" {
+		options = append(options, "seedorderkey="+t.SeedOrderKey)
+	}
+	if t.SeedCount > 0 {
+		options = append(options, "seedcount="+strconv.Itoa(t.SeedCount))
+	}
+	if t.SeedDefaultKey != "" {
+		options = append(options, "seeddefaultkey="+t.SeedDefaultKey)
+	}
+	if len(t.SeedAttributes) > 0 {
+		options = append(options, "seedattrs="+formatAttributes(t.SeedAttributes))
+	}
+
+	return options
+}
+
+// formatSubscription formats a subscription in the form that
+// parseSubscription accepts.
+func formatSubscription(s Subscription) string {
+	return strings.Join(append([]string{s.ID}, subscriptionOptions(s)...), ";")
+}
+
+// subscriptionOptions returns the options of the subscription.
+func subscriptionOptions(s Subscription) []string {
+	var parts []string
+	if s.Disabled {
+		parts = append(parts, "enabled=false")
+	}
+	if s.AckDeadline > 0 {
+		parts = append(parts, "ack="+s.AckDeadline.String())
+	}
+	if s.MinExtensionPeriod > 0 {
+		parts = append(parts, "minextension="+s.MinExtensionPeriod.String())
+	}
+	if s.MaxExtensionPeriod > 0 {
+		parts = append(parts, "maxextension="+s.MaxExtensionPeriod.String())
+	}
+	if s.DeadLetterTopic != "" {
+		parts = append(parts, "dlq="+s.DeadLetterTopic)
+	}
+	if s.DeadLetterSubscription != "" {
+		parts = append(parts, "dlqsub="+s.DeadLetterSubscription)
+	}
+	if s.DeadLetterSubscriptionRetention > 0 {
+		parts = append(parts, "dlqsubretain="+s.DeadLetterSubscriptionRetention.String())
+	}
+	if s.DeadLetterSubscriptionNoExpiration {
+		parts = append(parts, "dlqsubnoexpire")
+	}
+	if s.MaxDeliveryAttempts > 0 {
+		parts = append(parts, "maxdelivery="+strconv.Itoa(s.MaxDeliveryAttempts))
+	}
+	if s.RetryMinBackoff > 0 {
+		parts = append(parts, "retrymin="+s.RetryMinBackoff.String())
+	}
+	if s.RetryMaxBackoff > 0 {
+		parts = append(parts, "retrymax="+s.RetryMaxBackoff.String())
+	}
+	if s.ExactlyOnceDelivery {
+		parts = append(parts, "exactlyonce")
+	}
+	if s.Retention > 0 {
+		parts = append(parts, "retain="+s.Retention.String())
+	}
+	if s.RetainAcked {
+		parts = append(parts, "retainacked")
+	}
+	if s.NoExpiration {
+		parts = append(parts, "expire=never")
+	} else if s.Expiration > 0 {
+		parts = append(parts, "expire="+s.Expiration.String())
+	}
+	if s.Ordered {
+		parts = append(parts, "ordered")
+	}
+	if s.Filter != "" {
+		parts = append(parts, "filter="+s.Filter)
+	}
+	if s.BigQueryTable != "" {
+		parts = append(parts, "bqtable="+s.BigQueryTable)
+	}
+	if s.BigQueryDropUnknownFields {
+		parts = append(parts, "bqdropunknown")
+	}
+	if s.CloudStorageBucket != "" {
+		parts = append(parts, "gcsbucket="+s.CloudStorageBucket)
+	}
+	if s.CloudStorageMaxBytes > 0 {
+		parts = append(parts, "gcsmaxbytes="+formatSize(s.CloudStorageMaxBytes))
+	}
+	if s.CloudStorageMaxDuration > 0 {
+		parts = append(parts, "gcsmaxduration="+s.CloudStorageMaxDuration.String())
+	}
+	if s.PushEndpoint != "" {
+		parts = append(parts, "push="+strconv.Quote(s.PushEndpoint))
+	}
+	if s.PushServiceAccount != "" {
+		parts = append(parts, "pushsa="+s.PushServiceAccount)
+	}
+	if s.TransformFile != "" {
+		parts = append(parts, "transform="+s.TransformFile)
+	}
+	if s.IAMFile != "" {
+		parts = append(parts, "iamfile="+s.IAMFile)
+	}
+	if len(s.Labels) > 0 {
+		parts = append(parts, "labels="+strconv.Quote(formatAttributes(s.Labels)))
+	}
+
+	return parts
+}
+
+// hashLabel is the label that stores the hash of the config that a resource
+// was created with.
+const hashLabel = "pubsubc-hash"
+
+// RunIDLabel is the label that stores the ID of the run that created a
+// resource.
+const RunIDLabel = "pubsubc-run-id"
+
+// hash returns a digest of the desired config of the topic, excluding its
+// subscriptions and seed messages.
+func (t Topic) hash() string {
+	return digest(t.ID + "[" + strings.Join(topicOptions(t), ",") + "]" + formatAttributes(t.Labels))
+}
+
+// hash returns a digest of the desired config of the subscription on the
+// specified topic.
+func (s Subscription) hash(topicID string) string {
+	return digest(topicID + ":" + formatSubscription(s))
+}
+
+// digest returns a hash of the string that is a valid label value.
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// parseAttributes parses attributes of the form "key1:value1;key2:value2".
+func parseAttributes(value string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, pair := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(pair, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key:value, got %q", pair)
+		}
+
+		attrs[key] = val
+	}
+
+	return attrs, nil
+}
+
+// formatAttributes formats attributes in the form that parseAttributes
+// accepts.
+func formatAttributes(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+":"+attrs[key])
+	}
+
+	return strings.Join(pairs, ";")
+}
+
+// splitOutside splits the string around each separator that is not enclosed
+// in brackets, parentheses or double quotes.
+func splitOutside(s string, sep byte) []string {
+	var (
+		parts  []string
+		depth  int
+		start  int
+		quoted bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+			continue
+		case quoted:
+			continue
+		}
+
+		switch s[i] {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			if depth > 0 {
+				depth--
+			}
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// minSubscriptionRetention and maxSubscriptionRetention are the bounds of the
+// message retention duration of a subscription.
+const (
+	minSubscriptionRetention = 10 * time.Minute
+	maxSubscriptionRetention = 7 * 24 * time.Hour
+)
+
+// retentionBound resolves the min and max keywords of a retention duration to
+// the bounds, and returns any other value as is.
+func retentionBound(value string, min, max time.Duration) string {
+	switch value {
+	case "min":
+		return min.String()
+	case "max":
+		return max.String()
+	}
+
+	return value
+}
+
+// parseDuration parses the duration of the named option and checks that it
+// is within the specified bounds. A max of zero means there is no maximum.
+func parseDuration(name, value string, min, max time.Duration) (time.Duration, error) {
+	d, err := parseDurationValue(name, value)
+	if err != nil {
+		return 0, err
+	}
+
+	return d, checkDuration(name, d, min, max)
+}
+
+// duration parses the duration of the named option of a resource like
+// parseDuration does. With ClampDurations, a duration that is out of bounds is
+// clamped to the nearest bound instead.
+func (p *Parser) duration(resource, name, value string, min, max time.Duration) (time.Duration, error) {
+	d, err := parseDurationValue(name, value)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkDuration(name, d, min, max); err == nil || !p.ClampDurations {
+		return d, err
+	}
+
+	clamped := min
+	if d > min {
+		clamped = max
+	}
+
+	if p.Logger != nil {
+		p.Logger.Warnf("%s: clamped %s from %s to %s", resource, name, d, clamped)
+	}
+
+	return clamped, nil
+}
+
+// parseDurationValue parses the duration of the named option. Besides the
+// units that time.ParseDuration accepts, the duration can be a number of days,
+// like "7d".
+func parseDurationValue(name, value string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		if n, err = strconv.Atoi(days); err == nil {
+			d = time.Duration(n) * 24 * time.Hour
+		}
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q", name, value)
+	}
+
+	return d, nil
+}
+
+// checkDuration checks that the duration of the named option is within the
+// specified bounds. A max of zero means there is no maximum.
+func checkDuration(name string, d, min, max time.Duration) error {
+	switch {
+	case d < min:
+		return fmt.Errorf("%s: %s below minimum %s", name, d, min)
+	case max > 0 && d > max:
+		return fmt.Errorf("%s: %s above maximum %s", name, d, max)
+	}
+
+	return nil
+}
+
+// unquote removes the double quotes around a value, if it is quoted.
+func unquote(value string) (string, error) {
+	if !strings.HasPrefix(value, `"`) {
+		return value, nil
+	}
+
+	return strconv.Unquote(value)
+}
+
+// readFilterFile returns the filter expression in the file, without the
+// surrounding whitespace.
+func readFilterFile(filename string) (string, error) {
+	if filename == "" {
+		return "", fmt.Errorf("must not be empty")
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read %q: %s", filename, err)
+	}
+
+	filter := strings.TrimSpace(string(b))
+	if filter == "" {
+		return "", fmt.Errorf("the file %q is empty", filename)
+	}
+
+	return filter, nil
+}
+
+// resolveIndirection resolves a value that refers to a file, like
+// "@/run/secrets/sa", or to an environment variable, like "$PUSH_SA", so that
+// secrets don't have to be part of the config. Other values are returned as
+// they are.
+func resolveIndirection(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "@"):
+		b, err := os.ReadFile(value[1:])
+		if err != nil {
+			return "", fmt.Errorf("Unable to read %q: %s", value[1:], err)
+		}
+
+		return strings.TrimSpace(string(b)), nil
+
+	case strings.HasPrefix(value, "$"):
+		env, ok := os.LookupEnv(value[1:])
+		if !ok || env == "" {
+			return "", fmt.Errorf("environment variable %s is not set", value[1:])
+		}
+
+		return env, nil
+
+	case value == "":
+		return "", fmt.Errorf("must not be empty")
+	}
+
+	return value, nil
+}
+
+// parseBool parses the value of a boolean option. An option without a value,
+// like ";exactlyonce", is true.
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("expected a boolean, got %q", value)
+	}
+
+	return b, nil
+}
+
+// schemaName returns the fully qualified name of a schema reference in the
+// specified project.
+func schemaName(projectID, ref string) string {
+	if strings.HasPrefix(ref, "projects/") {
+		return ref
+	}
+
+	return fmt.Sprintf("projects/%s/schemas/%s", projectID, ref)
+}
+
+// external reports whether the topic is a fully qualified reference to a
+// topic in another project.
+func (t Topic) external() bool {
+	return strings.HasPrefix(t.ID, "projects/")
+}
+
+// name returns the fully qualified name of the topic in the specified
+// project.
+func (t Topic) name(projectID string) string {
+	projectID, topicID := splitTopicName(projectID, t.ID)
+	return fmt.Sprintf("projects/%s/topics/%s", projectID, topicID)
+}
+
+// splitTopicName splits a topic reference into its project ID and topic ID. A
+// reference that is not of the form "projects/<project>/topics/<topic>" is
+// assumed to be a topic in the specified default project.
+func splitTopicName(defaultProjectID, name string) (projectID, topicID string) {
+	parts := strings.Split(name, "/")
+	if len(parts) == 4 && parts[0] == "projects" && parts[2] == "topics" {
+		return parts[1], parts[3]
+	}
+
+	return defaultProjectID, name
+}
@@ -0,0 +1,910 @@
+package provision
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseSubscription parses a project with a single topic and subscription, and
+// returns the subscription.
+func parseSubscription(t *testing.T, p *Parser, definition string) (Subscription, error) {
+	t.Helper()
+
+	cfg, err := p.Parse("project1,topic1:" + definition)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	return cfg.Topics[0].Subscriptions[0], nil
+}
+
+func TestParseBigQueryDropUnknown(t *testing.T) {
+	tests := []struct {
+		definition string
+		want       bool
+		err        string
+	}{
+		{definition: "sub1;bqtable=p.d.t", want: false},
+		{definition: "sub1;bqtable=p.d.t;bqdropunknown", want: true},
+		{definition: "sub1;bqtable=p.d.t;bqdropunknown=true", want: true},
+		{definition: "sub1;bqtable=p.d.t;bqdropunknown=false", want: false},
+		{definition: "sub1;bqtable=p.d.t;bqdropunknown=maybe", err: "bqdropunknown: expected a boolean"},
+		{definition: "sub1;bqdropunknown", err: "bqdropunknown requires bqtable"},
+		{definition: "sub1;bqdropunknown=false", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			s, err := parseSubscription(t, &Parser{}, tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case s.BigQueryDropUnknownFields != tt.want:
+				t.Errorf("expected bqdropunknown to be %t, got %t", tt.want, s.BigQueryDropUnknownFields)
+			}
+		})
+	}
+}
+
+func TestParseSubscriptionDefaults(t *testing.T) {
+	filterFile := filepath.Join(t.TempDir(), "filter.txt")
+	if err := os.WriteFile(filterFile, []byte(`attributes.region = "eu"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		defaults   []string
+		definition string
+		filter     string
+		ack        time.Duration
+		err        string
+	}{
+		{name: "default filter", defaults: []string{`filter=attributes.kind = "order"`}, definition: "sub1", filter: `attributes.kind = "order"`},
+		{name: "explicit filter", defaults: []string{`filter=attributes.kind = "order"`}, definition: `sub1;filter=attributes.kind = "refund"`, filter: `attributes.kind = "refund"`},
+		{name: "filterfile overrides filter", defaults: []string{`filter=attributes.kind = "order"`, "ack=20s"}, definition: "sub1;filterfile=" + filterFile, filter: `attributes.region = "eu"`, ack: 20 * time.Second},
+		{name: "filter overrides filterfile", defaults: []string{"filterfile=" + filterFile}, definition: `sub1;filter=attributes.kind = "refund"`, filter: `attributes.kind = "refund"`},
+		{name: "both explicit", definition: `sub1;filter=attributes.kind = "refund";filterfile=` + filterFile, err: "mutually exclusive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSubscription(t, &Parser{SubscriptionDefaults: tt.defaults}, tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case s.Filter != tt.filter:
+				t.Errorf("expected filter %q, got %q", tt.filter, s.Filter)
+			case s.AckDeadline != tt.ack:
+				t.Errorf("expected ack %s, got %s", tt.ack, s.AckDeadline)
+			}
+		})
+	}
+}
+
+func TestParseAckAndExtensionPeriods(t *testing.T) {
+	tests := []struct {
+		definition string
+		ack        time.Duration
+		min, max   time.Duration
+		err        string
+	}{
+		{definition: "worker1;ack=45s", ack: 45 * time.Second},
+		{definition: "worker1;ack=10m", ack: 10 * time.Minute},
+		{definition: "worker1;ack=5s", err: "ack: 5s below minimum 10s"},
+		{definition: "worker1;ack=11m", err: "ack: 11m0s above maximum 10m0s"},
+		{definition: "worker1;minextension=30s;maxextension=2m", min: 30 * time.Second, max: 2 * time.Minute},
+		{definition: "worker1;maxextension=90s", max: 90 * time.Second},
+		{definition: "worker1;minextension=3m;maxextension=1m", err: "minextension 3m0s exceeds maxextension 1m0s"},
+		{definition: "worker1;minextension=soon", err: "minextension"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			s, err := parseSubscription(t, &Parser{}, tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case s.AckDeadline != tt.ack || s.MinExtensionPeriod != tt.min || s.MaxExtensionPeriod != tt.max:
+				t.Errorf("expected ack %s and extensions %s-%s, got %s and %s-%s", tt.ack, tt.min, tt.max, s.AckDeadline, s.MinExtensionPeriod, s.MaxExtensionPeriod)
+			}
+		})
+	}
+}
+
+func TestParseSeedAttributes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]string
+		err   string
+	}{
+		{value: "team:search", want: map[string]string{"team": "search"}},
+		{value: "team:search;tier:", want: map[string]string{"team": "search", "tier": ""}},
+		{value: "url:http://example.com", want: map[string]string{"url": "http://example.com"}},
+		{value: "team", err: `expected key:value, got "team"`},
+		{value: ":search", err: `expected key:value, got ":search"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cfg, err := (&Parser{}).Parse("project1,queries[seedattrs=" + tt.value + "]")
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case !maps.Equal(cfg.Topics[0].SeedAttributes, tt.want):
+				t.Errorf("expected %v, got %v", tt.want, cfg.Topics[0].SeedAttributes)
+			}
+		})
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	tests := []struct {
+		definition string
+		want       time.Duration
+		field      func(cfg Config) time.Duration
+		err        string
+	}{
+		{definition: "project1,ledger[retain=7d]", want: 7 * 24 * time.Hour},
+		{definition: "project1,ledger[retain=90m]", want: 90 * time.Minute},
+		{definition: "project1,ledger[retain=1h30m]", want: 90 * time.Minute},
+		{definition: "project1,ledger[retain=5m]", err: `Topic "ledger": retain: 5m0s below minimum 10m0s`},
+		{definition: "project1,ledger[retain=32d]", err: `Topic "ledger": retain: 768h0m0s above maximum 744h0m0s`},
+		{definition: "project1,ledger[retain=7days]", err: `Topic "ledger": retain: invalid duration "7days"`},
+		{definition: "project1,ledger[retain=1.5d]", err: `retain: invalid duration "1.5d"`},
+		{definition: "project1,ledger:ledger-sink;expire=30d", want: 30 * 24 * time.Hour, field: func(cfg Config) time.Duration { return cfg.Topics[0].Subscriptions[0].Expiration }},
+		{definition: "project1,ledger:ledger-sink;expire=365d", want: 365 * 24 * time.Hour, field: func(cfg Config) time.Duration { return cfg.Topics[0].Subscriptions[0].Expiration }},
+		{definition: "project1,ledger:ledger-sink;expire=12h", err: `Subscription "ledger-sink": expire: 12h0m0s below minimum 24h0m0s`},
+		{definition: "project1,ledger:ledger-sink;retain=2d", want: 48 * time.Hour, field: func(cfg Config) time.Duration { return cfg.Topics[0].Subscriptions[0].Retention }},
+		{definition: "project1,ledger:ledger-sink;ack=1m", want: time.Minute, field: func(cfg Config) time.Duration { return cfg.Topics[0].Subscriptions[0].AckDeadline }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			cfg, err := (&Parser{}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			field := tt.field
+			if field == nil {
+				field = func(cfg Config) time.Duration { return cfg.Topics[0].Retention }
+			}
+			if got := field(cfg); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseClampDurations(t *testing.T) {
+	tests := []struct {
+		definition string
+		clamp      bool
+		ack        time.Duration
+		warning    string
+		err        string
+	}{
+		{definition: "indexer;ack=5s", err: "ack: 5s below minimum 10s"},
+		{definition: "indexer;ack=5s", clamp: true, ack: 10 * time.Second, warning: `Subscription "indexer": clamped ack from 5s to 10s`},
+		{definition: "indexer;ack=20m", clamp: true, ack: 10 * time.Minute, warning: `Subscription "indexer": clamped ack from 20m0s to 10m0s`},
+		{definition: "indexer;ack=1m", clamp: true, ack: time.Minute},
+		{definition: "indexer;ack=fast", clamp: true, err: `ack: invalid duration "fast"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s clamp=%t", tt.definition, tt.clamp), func(t *testing.T) {
+			logger := &testLogger{}
+			s, err := parseSubscription(t, &Parser{ClampDurations: tt.clamp, Logger: logger}, tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case s.AckDeadline != tt.ack:
+				t.Errorf("expected ack %s, got %s", tt.ack, s.AckDeadline)
+			}
+
+			var warnings []string
+			if tt.warning != "" {
+				warnings = []string{tt.warning}
+			}
+			if !slices.Equal(logger.warnings, warnings) {
+				t.Errorf("expected warnings %q, got %q", warnings, logger.warnings)
+			}
+		})
+	}
+}
+
+func TestParsePushServiceAccount(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "invoicing-pusher")
+	if err := os.WriteFile(secret, []byte("invoicing@acme.iam.gserviceaccount.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PUSHSA_WEBHOOKS", "webhooks@acme.iam.gserviceaccount.com")
+	t.Setenv("PUSHSA_EMPTY", "")
+
+	const push = `;push="https://hooks.acme.test/pubsub"`
+
+	tests := []struct {
+		name    string
+		value   string
+		account string
+		ref     string
+		err     string
+	}{
+		{name: "inline", value: "billing@acme.iam.gserviceaccount.com", account: "billing@acme.iam.gserviceaccount.com"},
+		{name: "file", value: "@" + secret, account: "invoicing@acme.iam.gserviceaccount.com", ref: "@" + secret},
+		{name: "environment variable", value: "$PUSHSA_WEBHOOKS", account: "webhooks@acme.iam.gserviceaccount.com", ref: "$PUSHSA_WEBHOOKS"},
+		{name: "missing file", value: "@" + filepath.Join(dir, "missing"), err: "pushsa: Unable to read"},
+		{name: "unset environment variable", value: "$PUSHSA_UNSET", err: "pushsa: environment variable PUSHSA_UNSET is not set"},
+		{name: "empty environment variable", value: "$PUSHSA_EMPTY", err: "pushsa: environment variable PUSHSA_EMPTY is not set"},
+		{name: "redacted", value: redacted, err: "pushsa: the value is redacted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSubscription(t, &Parser{}, "sub1"+push+";pushsa="+tt.value)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if s.PushServiceAccount != tt.account || s.PushServiceAccountRef != tt.ref {
+				t.Errorf("expected account %q with reference %q, got %q with %q", tt.account, tt.ref, s.PushServiceAccount, s.PushServiceAccountRef)
+			}
+
+			// Printing shows the reference instead of the resolved account.
+			shown := redactSubscription(s).PushServiceAccount
+			if expected := cmp.Or(tt.ref, redacted); shown != expected {
+				t.Errorf("expected the printed account %q, got %q", expected, shown)
+			}
+
+			// Unredacted, an inline account is kept so that it parses again.
+			formatted := FormatConfigUnredacted(Config{ProjectID: "project1", Topics: []Topic{{ID: "topic1", Subscriptions: []Subscription{s}}}})
+			cfg, err := (&Parser{}).Parse(formatted)
+			if err != nil {
+				t.Fatalf("unable to parse the unredacted %q: %s", formatted, err)
+			}
+			if got := cfg.Topics[0].Subscriptions[0]; got.PushServiceAccount != tt.account || got.PushServiceAccountRef != tt.ref {
+				t.Errorf("expected account %q with reference %q after formatting, got %q with %q", tt.account, tt.ref, got.PushServiceAccount, got.PushServiceAccountRef)
+			}
+		})
+	}
+}
+
+func TestParseSubscriptionGroups(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		// expected are the subscription IDs of the first topic, in order,
+		// and acks are their ack deadlines.
+		expected []string
+		acks     []time.Duration
+		err      string
+	}{
+		{
+			name:       "group",
+			definition: "project1,orders:(fulfilment,invoicing,analytics);ack=30s",
+			expected:   []string{"fulfilment", "invoicing", "analytics"},
+			acks:       []time.Duration{30 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+		{
+			name:       "group without options",
+			definition: "project1,orders:(fulfilment,invoicing)",
+			expected:   []string{"fulfilment", "invoicing"},
+			acks:       []time.Duration{0, 0},
+		},
+		{
+			name:       "mixed with single subscriptions",
+			definition: "project1,orders:audit;ack=20s:(fulfilment, invoicing);ack=60s:archive,returns:refunds",
+			expected:   []string{"audit", "fulfilment", "invoicing", "archive"},
+			acks:       []time.Duration{20 * time.Second, 60 * time.Second, 60 * time.Second, 0},
+		},
+		{
+			name:       "unterminated group",
+			definition: "project1,orders:(fulfilment,invoicing;ack=30s",
+			err:        "expected the group to end with )",
+		},
+		{
+			name:       "options without separator",
+			definition: "project1,orders:(fulfilment)ack=30s",
+			err:        `expected ; after the group, got "ack=30s"`,
+		},
+		{
+			name:       "empty subscription ID",
+			definition: "project1,orders:(fulfilment,,invoicing)",
+			err:        "subscription IDs must not be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := (&Parser{}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var ids []string
+			var acks []time.Duration
+			for _, s := range cfg.Topics[0].Subscriptions {
+				ids = append(ids, s.ID)
+				acks = append(acks, s.AckDeadline)
+			}
+
+			if !slices.Equal(ids, tt.expected) || !slices.Equal(acks, tt.acks) {
+				t.Errorf("expected %q with ack deadlines %v, got %q with %v", tt.expected, tt.acks, ids, acks)
+			}
+		})
+	}
+}
+
+func TestParseTopicSchemaEncoding(t *testing.T) {
+	tests := []struct {
+		definition string
+		encoding   string
+		err        string
+	}{
+		{definition: "project1,metrics[schema=sample,encoding=json]", encoding: "json"},
+		{definition: "project1,metrics[schema=sample,encoding=binary]", encoding: "binary"},
+		{definition: "project1,metrics[schema=sample]"},
+		{definition: "project1,metrics[schema=sample,encoding=avro]", err: `encoding must be json or binary, got "avro"`},
+		{definition: "project1,metrics[schema=sample,encoding=JSON]", err: `encoding must be json or binary, got "JSON"`},
+		{definition: "project1,metrics[encoding=json]", err: "encoding requires schema"},
+		{definition: "project1,metrics[schema=,encoding=json]", err: "schema must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			cfg, err := (&Parser{}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if encoding := cfg.Topics[0].SchemaEncoding; encoding != tt.encoding {
+				t.Errorf("expected encoding %q, got %q", tt.encoding, encoding)
+			}
+		})
+	}
+}
+
+func TestParseOrderingRegions(t *testing.T) {
+	const warning = `Subscription "ledger": topic "payments" stores messages in regions europe-west1, europe-west4, and only messages that are published in the same region are delivered in order`
+
+	tests := []struct {
+		name       string
+		definition string
+		regions    []string
+		warnings   []string
+		err        string
+	}{
+		{
+			name:       "single region",
+			definition: "project1,payments[regions=europe-west1]:ledger;ordered",
+			regions:    []string{"europe-west1"},
+		},
+		{
+			name:       "ordered across regions",
+			definition: "project1,payments[regions=europe-west1;europe-west4]:ledger;ordered:fraud",
+			regions:    []string{"europe-west1", "europe-west4"},
+			warnings:   []string{warning},
+		},
+		{
+			name:       "unordered across regions",
+			definition: "project1,payments[regions=europe-west1;europe-west4]:ledger:fraud",
+			regions:    []string{"europe-west1", "europe-west4"},
+		},
+		{
+			name:       "ordered without regions",
+			definition: "project1,payments:ledger;ordered",
+		},
+		{
+			name:       "empty region",
+			definition: "project1,payments[regions=europe-west1;]:ledger;ordered",
+			err:        `Topic "payments": regions must be of the form region1;region2, got "europe-west1;"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &testLogger{}
+			cfg, err := (&Parser{Logger: logger}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if regions := cfg.Topics[0].Regions; !slices.Equal(regions, tt.regions) {
+				t.Errorf("expected regions %q, got %q", tt.regions, regions)
+			}
+			if !slices.Equal(logger.warnings, tt.warnings) {
+				t.Errorf("expected the warnings %q, got %q", tt.warnings, logger.warnings)
+			}
+		})
+	}
+}
+
+func TestParseRangeTemplates(t *testing.T) {
+	tests := []struct {
+		name         string
+		definition   string
+		maxExpansion int
+		// expected are the topics, each followed by its subscriptions.
+		expected []string
+		err      string
+	}{
+		{
+			name:       "topics and subscriptions",
+			definition: "loadtest,orders-[1-3]:orders-[1-3]-worker",
+			expected:   []string{"orders-1 orders-1-worker", "orders-2 orders-2-worker", "orders-3 orders-3-worker"},
+		},
+		{
+			name:       "leading zeros",
+			definition: "loadtest,shard-[08-11]",
+			expected:   []string{"shard-08", "shard-09", "shard-10", "shard-11"},
+		},
+		{
+			name:       "options",
+			definition: "loadtest,bulk-[1-2][retain=1h]:reader-[1-2];ack=30s:audit",
+			expected:   []string{"bulk-1 reader-1 audit", "bulk-2 reader-2 audit"},
+		},
+		{
+			name:       "mixed with plain topics",
+			definition: "loadtest,control:control-plane,shard-[1-2]",
+			expected:   []string{"control control-plane", "shard-1", "shard-2"},
+		},
+		{
+			name:         "at the cap",
+			definition:   "loadtest,a-[1-3],b-[1-2]",
+			maxExpansion: 5,
+			expected:     []string{"a-1", "a-2", "a-3", "b-1", "b-2"},
+		},
+		{
+			name:         "above the cap",
+			definition:   "loadtest,t-[1-101]",
+			maxExpansion: 100,
+			err:          "Range templates expand to more than 100 topic definitions",
+		},
+		{
+			name:         "above the cap across topics",
+			definition:   "loadtest,a-[1-3],b-[1-3]",
+			maxExpansion: 5,
+			err:          "Range templates expand to more than 5 topic definitions",
+		},
+		{
+			name:       "above the default cap",
+			definition: "loadtest,t-[1-1001]",
+			err:        "Range templates expand to more than 1000 topic definitions",
+		},
+		{
+			name:       "different ranges",
+			definition: "loadtest,t-[1-3]:s-[1-4]",
+			err:        "range templates [1-3] and [1-4] differ",
+		},
+		{
+			name:       "descending range",
+			definition: "loadtest,t-[5-1]",
+			err:        "invalid range template [5-1]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := (&Parser{MaxExpansion: tt.maxExpansion}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var topics []string
+			for _, topic := range cfg.Topics {
+				names := []string{topic.ID}
+				for _, s := range topic.Subscriptions {
+					names = append(names, s.ID)
+				}
+				topics = append(topics, strings.Join(names, " "))
+			}
+
+			if !slices.Equal(topics, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, topics)
+			}
+		})
+	}
+}
+
+func TestConfigWithProjectIDs(t *testing.T) {
+	projectIDs := map[string]string{"orders": "orders-3f9a1c", "billing": "billing-7b20de"}
+
+	tests := []struct {
+		name string
+		cfg  Config
+		want Config
+	}{
+		{
+			name: "local references",
+			cfg: Config{ProjectID: "orders", Topics: []Topic{
+				{ID: "placed", Subscriptions: []Subscription{{ID: "placed-mailer", DeadLetterTopic: "placed-dead"}}},
+				{ID: "placed-dead"},
+			}},
+			want: Config{ProjectID: "orders-3f9a1c", Topics: []Topic{
+				{ID: "placed", Subscriptions: []Subscription{{ID: "placed-mailer", DeadLetterTopic: "placed-dead"}}},
+				{ID: "placed-dead"},
+			}},
+		},
+		{
+			name: "references to randomized projects",
+			cfg: Config{ProjectID: "billing", Topics: []Topic{
+				{ID: "invoices", Schema: "projects/orders/schemas/invoice", Subscriptions: []Subscription{
+					{ID: "invoices-ledger", DeadLetterTopic: "projects/orders/topics/dead"},
+				}},
+				{ID: "projects/orders/topics/placed", Subscriptions: []Subscription{{ID: "placed-biller"}}},
+			}},
+			want: Config{ProjectID: "billing-7b20de", Topics: []Topic{
+				{ID: "invoices", Schema: "projects/orders-3f9a1c/schemas/invoice", Subscriptions: []Subscription{
+					{ID: "invoices-ledger", DeadLetterTopic: "projects/orders-3f9a1c/topics/dead"},
+				}},
+				{ID: "projects/orders-3f9a1c/topics/placed", Subscriptions: []Subscription{{ID: "placed-biller"}}},
+			}},
+		},
+		{
+			name: "references to other projects",
+			cfg: Config{ProjectID: "orders", Topics: []Topic{
+				{ID: "refunds", Schema: "projects/shared/schemas/refund", Subscriptions: []Subscription{
+					{ID: "refunds-audit", DeadLetterTopic: "projects/shared/topics/dead"},
+				}},
+			}},
+			want: Config{ProjectID: "orders-3f9a1c", Topics: []Topic{
+				{ID: "refunds", Schema: "projects/shared/schemas/refund", Subscriptions: []Subscription{
+					{ID: "refunds-audit", DeadLetterTopic: "projects/shared/topics/dead"},
+				}},
+			}},
+		},
+		{
+			name: "project that isn't randomized",
+			cfg:  Config{ProjectID: "shared", Topics: []Topic{{ID: "dead", Schema: "refund"}}},
+			want: Config{ProjectID: "shared", Topics: []Topic{{ID: "dead", Schema: "refund"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := fmt.Sprintf("%+v", tt.cfg)
+
+			// Formatting ignores the difference between nil and empty lists.
+			if got, want := fmt.Sprintf("%+v", tt.cfg.WithProjectIDs(projectIDs)), fmt.Sprintf("%+v", tt.want); got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+
+			// The copy doesn't share its topics and subscriptions.
+			if got := fmt.Sprintf("%+v", tt.cfg); got != original {
+				t.Errorf("expected the config to be unchanged, got %s", got)
+			}
+		})
+	}
+}
+
+func TestParseSubscriptionRetention(t *testing.T) {
+	tests := []struct {
+		definition  string
+		retention   time.Duration
+		retainAcked bool
+		err         string
+	}{
+		{definition: "replayer"},
+		{definition: "replayer;retain=36h", retention: 36 * time.Hour},
+		{definition: "replayer;retainacked", retainAcked: true},
+		{definition: "replayer;retainacked=false"},
+		{definition: "replayer;retain=2h;retainacked", retention: 2 * time.Hour, retainAcked: true},
+		{definition: "replayer;retainacked;retain=7d", retention: 7 * 24 * time.Hour, retainAcked: true},
+		{definition: "replayer;retain=min;retainacked=true", retention: 10 * time.Minute, retainAcked: true},
+		{definition: "replayer;retain=max", retention: 7 * 24 * time.Hour},
+		{definition: "replayer;retain=5m", err: "retain: 5m0s below minimum 10m0s"},
+		{definition: "replayer;retain=8d;retainacked", err: "retain: 192h0m0s above maximum 168h0m0s"},
+		{definition: "replayer;retain=2h;retainacked=sometimes", err: "retainacked: expected a boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			s, err := parseSubscription(t, &Parser{}, tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case s.Retention != tt.retention:
+				t.Errorf("expected retention %s, got %s", tt.retention, s.Retention)
+			case s.RetainAcked != tt.retainAcked:
+				t.Errorf("expected retainacked to be %t, got %t", tt.retainAcked, s.RetainAcked)
+			}
+		})
+	}
+}
+
+func TestParseRetentionKeywords(t *testing.T) {
+	tests := []struct {
+		definition string
+		clamp      bool
+		want       []time.Duration
+		err        string
+	}{
+		{definition: "archive,orders:orders-replay;retain=min", want: []time.Duration{10 * time.Minute}},
+		{definition: "archive,orders:orders-replay;ack=45s;retain=max", want: []time.Duration{7 * 24 * time.Hour}},
+		{definition: "archive,orders:orders-replay;retain=max", clamp: true, want: []time.Duration{7 * 24 * time.Hour}},
+		{definition: "archive,orders:orders-hot;retain=min:orders-cold;retain=max", want: []time.Duration{10 * time.Minute, 7 * 24 * time.Hour}},
+		{definition: "archive,shard-[1-2]:shard-[1-2]-replay;retain=max", want: []time.Duration{7 * 24 * time.Hour, 7 * 24 * time.Hour}},
+		{definition: "archive,orders:orders-replay;retain=MAX", err: `retain: invalid duration "MAX"`},
+		{definition: "archive,orders:orders-replay;retain=maximum", err: `retain: invalid duration "maximum"`},
+		{definition: "archive,orders[retain=max]:orders-replay", err: `Topic "orders": retain: invalid duration "max"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s clamp=%t", tt.definition, tt.clamp), func(t *testing.T) {
+			logger := &testLogger{}
+			cfg, err := (&Parser{ClampDurations: tt.clamp, Logger: logger}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var got []time.Duration
+			for _, topic := range cfg.Topics {
+				for _, s := range topic.Subscriptions {
+					got = append(got, s.Retention)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the retentions %v, got %v", tt.want, got)
+			}
+			if len(logger.warnings) != 0 {
+				t.Errorf("expected no clamp warnings, got %q", logger.warnings)
+			}
+		})
+	}
+}
+
+func TestParseDeadLetterSubscriptionOptions(t *testing.T) {
+	tests := []struct {
+		definition string
+		retention  time.Duration
+		noExpire   bool
+		formatted  string
+		err        string
+	}{
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect"},
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect;dlqsubretain=7d", retention: 7 * 24 * time.Hour, formatted: "dlqsubretain=168h0m0s"},
+		{definition: "charges;retain=1h;dlq=charges-dead;dlqsub=charges-inspect;dlqsubretain=max", retention: 7 * 24 * time.Hour, formatted: "dlqsubretain=168h0m0s"},
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect;dlqsubretain=min;dlqsubnoexpire", retention: 10 * time.Minute, noExpire: true, formatted: "dlqsubretain=10m0s;dlqsubnoexpire"},
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect;dlqsubnoexpire=true", noExpire: true, formatted: "dlqsubnoexpire"},
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect;dlqsubnoexpire=false"},
+		{definition: "charges;dlq=charges-dead;dlqsubretain=7d", err: `Subscription "charges": dlqsubretain and dlqsubnoexpire require dlqsub`},
+		{definition: "charges;dlq=charges-dead;dlqsubnoexpire", err: `Subscription "charges": dlqsubretain and dlqsubnoexpire require dlqsub`},
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect;dlqsubretain=5m", err: "dlqsubretain: 5m0s below minimum 10m0s"},
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect;dlqsubretain=14d", err: "dlqsubretain: 336h0m0s above maximum 168h0m0s"},
+		{definition: "charges;dlq=charges-dead;dlqsub=charges-inspect;dlqsubnoexpire=maybe", err: "dlqsubnoexpire: expected a boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			s, err := parseSubscription(t, &Parser{}, tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if s.DeadLetterSubscriptionRetention != tt.retention {
+				t.Errorf("expected the dead-letter subscription retention %s, got %s", tt.retention, s.DeadLetterSubscriptionRetention)
+			}
+			if s.DeadLetterSubscriptionNoExpiration != tt.noExpire {
+				t.Errorf("expected the dead-letter subscription to never expire to be %t, got %t", tt.noExpire, s.DeadLetterSubscriptionNoExpiration)
+			}
+
+			// The options survive formatting the config.
+			formatted := FormatConfig(Config{ProjectID: "project1", Topics: []Topic{{ID: "topic1", Subscriptions: []Subscription{s}}}})
+			if tt.formatted != "" && !strings.Contains(formatted, tt.formatted) {
+				t.Errorf("expected the formatted config to contain %q, got %q", tt.formatted, formatted)
+			}
+			if tt.formatted == "" && (strings.Contains(formatted, "dlqsubretain") || strings.Contains(formatted, "dlqsubnoexpire")) {
+				t.Errorf("expected the formatted config to leave out the dead-letter subscription options, got %q", formatted)
+			}
+		})
+	}
+}
+
+func TestRetentionBound(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "min", want: "1m0s"},
+		{value: "max", want: "2h0m0s"},
+		{value: "90m", want: "90m"},
+		{value: "2d", want: "2d"},
+		{value: "Min", want: "Min"},
+		{value: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := retentionBound(tt.value, time.Minute, 2*time.Hour); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestParseCrossProjectTopic(t *testing.T) {
+	tests := []struct {
+		definition string
+		prefix     string
+		want       []Topic
+		err        string
+	}{
+		{
+			definition: "team-search,projects/core/topics/events:events-indexer:events-suggest",
+			want:       []Topic{{ID: "projects/core/topics/events", Subscriptions: []Subscription{{ID: "events-indexer"}, {ID: "events-suggest"}}}},
+		},
+		{
+			definition: "team-growth,signups:signups-crm,projects/platform-core/topics/clicks:clicks-funnel",
+			want: []Topic{
+				{ID: "signups", Subscriptions: []Subscription{{ID: "signups-crm"}}},
+				{ID: "projects/platform-core/topics/clicks", Subscriptions: []Subscription{{ID: "clicks-funnel"}}},
+			},
+		},
+		{
+			definition: "team-growth,signups,projects/platform-core/topics/clicks:clicks-funnel",
+			prefix:     "pr42-",
+			want: []Topic{
+				{ID: "pr42-signups"},
+				{ID: "projects/platform-core/topics/clicks", Subscriptions: []Subscription{{ID: "pr42-clicks-funnel"}}},
+			},
+		},
+		{
+			definition: "team-search,projects/core/events:events-indexer",
+			err:        `Topic "projects/core/events": expected a topic in another project to be of the form projects/<project>/topics/<topic>`,
+		},
+		{
+			definition: "team-search,projects/core/topics/events[retain=1h]:events-indexer",
+			err:        `Topic "projects/core/topics/events": the options of a topic in another project cannot be set`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			cfg, err := (&Parser{}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tt.prefix != "" {
+				cfg = cfg.WithPrefix(tt.prefix)
+			}
+
+			var got []Topic
+			for _, topic := range cfg.Topics {
+				subscriptions := make([]Subscription, 0, len(topic.Subscriptions))
+				for _, s := range topic.Subscriptions {
+					subscriptions = append(subscriptions, Subscription{ID: s.ID})
+				}
+				got = append(got, Topic{ID: topic.ID, Subscriptions: subscriptions})
+			}
+
+			if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", tt.want) {
+				t.Errorf("expected the topics %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSeedCount(t *testing.T) {
+	tests := []struct {
+		definition string
+		want       int
+		err        string
+	}{
+		{definition: "project1,jobs[seed=jobs.jsonl,seedcount=250]", want: 250},
+		{definition: "project1,jobs[seedcsv=jobs.csv,seedcount=1000000]", want: 1000000},
+		{definition: "project1,jobs[seed=jobs.jsonl]"},
+		{definition: "project1,jobs[seed=jobs.jsonl,seedcount=0]", err: `Topic "jobs": seedcount must be between 1 and 1000000, got "0"`},
+		{definition: "project1,jobs[seed=jobs.jsonl,seedcount=1000001]", err: `Topic "jobs": seedcount must be between 1 and 1000000, got "1000001"`},
+		{definition: "project1,jobs[seed=jobs.jsonl,seedcount=ten]", err: `Topic "jobs": seedcount must be between 1 and 1000000, got "ten"`},
+		{definition: "project1,jobs[seedcount=10]", err: `Topic "jobs": seedcount requires seed or seedcsv`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			cfg, err := (&Parser{}).Parse(tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := cfg.Topics[0].SeedCount; got != tt.want {
+				t.Errorf("expected seedcount %d, got %d", tt.want, got)
+			}
+			if tt.want > 0 && !strings.Contains(FormatConfig(cfg), fmt.Sprintf("seedcount=%d", tt.want)) {
+				t.Errorf("expected the formatted config to keep the seedcount, got %q", FormatConfig(cfg))
+			}
+		})
+	}
+}
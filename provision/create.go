@@ -0,0 +1,1145 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// creator holds the state of a single Provisioner.Create call.
+type creator struct {
+	*Provisioner
+
+	// configured contains the IDs of all the configured projects.
+	configured map[string]bool
+
+	// defined contains the fully qualified names of all configured topics.
+	defined map[string]bool
+
+	// deadLetterTopics contains the fully qualified names of the dead-letter
+	// topics that were created outside of the topic definitions, and
+	// deadLetterSubscriptions those of the subscriptions on dead-letter
+	// topics. The deadLetterMu serializes their creation.
+	deadLetterMu            sync.Mutex
+	deadLetterTopics        map[string]bool
+	deadLetterSubscriptions map[string]bool
+
+	// mu guards the state below, which concurrent steps share.
+	mu sync.Mutex
+
+	// created contains the resources that were created, in order.
+	created []Resource
+
+	// schemas contains the field names of the schemas that filters were
+	// checked against, and schemaConfigs the schemas that were read, by
+	// their fully qualified name.
+	schemas       map[string]map[string]bool
+	schemaConfigs map[string]*pubsub.SchemaConfig
+
+	// seedFailures is the number of seed messages that a best-effort seeding
+	// failed to publish.
+	seedFailures int
+
+	// seeded contains the number of seed messages of every seeded topic, by
+	// its fully qualified name.
+	seeded map[string]int
+
+	// failures are the steps that failed with ContinueOnError, and timedOut
+	// contains the fully qualified names of the steps whose last operation
+	// timed out.
+	failures []Failure
+	timedOut map[string]bool
+}
+
+// create the topics and subscriptions of the specified projects in
+// dependency order, and publish the seed messages once they all exist.
+func (c *creator) create(ctx context.Context, projects []Config) error {
+	steps := planSteps(projects)
+
+	c.defined = make(map[string]bool)
+	for _, s := range steps {
+		if s.subscription == nil {
+			c.defined[s.name()] = true
+		}
+	}
+
+	// Every project has a span that the spans of its resources are part of.
+	projectCtxs := make(map[string]context.Context)
+	for _, project := range projects {
+		projectCtx, span := startSpan(ctx, "pubsubc.project", attribute.String("pubsub.project", project.ProjectID))
+		defer span.End()
+
+		projectCtxs[project.ProjectID] = projectCtx
+
+		// Projects without topics only establish their client.
+		if len(project.Topics) == 0 {
+			if _, err := c.Clients.Client(projectCtx, project.ProjectID); err != nil {
+				return err
+			}
+
+			c.infof("Project %q has no topics, nothing to create", project.ProjectID)
+		}
+	}
+
+	// Subscriptions only depend on topics, so all topics are created before
+	// the subscriptions, and the steps of each phase can run concurrently.
+	var topicSteps, subscriptionSteps []step
+	for _, s := range steps {
+		if s.subscription == nil {
+			topicSteps = append(topicSteps, s)
+		} else {
+			subscriptionSteps = append(subscriptionSteps, s)
+		}
+	}
+
+	var topicsMu sync.Mutex
+	topics := make(map[string]*pubsub.Topic)
+
+	err := c.runSteps(topicSteps, func(s step) error {
+		projectCtx := withStep(projectCtxs[s.project.ProjectID], s)
+
+		client, err := c.Clients.Client(projectCtx, s.project.ProjectID)
+		if err != nil {
+			return err
+		}
+
+		topicCtx, span := startSpan(projectCtx, "pubsubc.topic",
+			attribute.String("pubsub.project", s.project.ProjectID),
+			attribute.String("pubsub.topic", s.topic.ID),
+		)
+
+		topic, err := c.topic(topicCtx, client, s.topic)
+		if err == nil && s.topic.IAMFile != "" {
+			err = c.applyPolicy(topicCtx, fmt.Sprintf("Topic %q", s.topic.ID), topic.IAM(), s.topic.IAMFile)
+		}
+		endSpan(span, err)
+		if err != nil {
+			return err
+		}
+
+		topicsMu.Lock()
+		topics[s.name()] = topic
+		topicsMu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The topics in other projects that are not configured must exist
+	// already.
+	for _, project := range projects {
+		for _, t := range project.Topics {
+			name := t.name(project.ProjectID)
+			if _, ok := topics[name]; ok || !t.external() {
+				continue
+			}
+
+			topic, err := c.externalTopic(projectCtxs[project.ProjectID], name)
+			if err != nil {
+				return err
+			}
+			topics[name] = topic
+		}
+	}
+
+	err = c.runSteps(subscriptionSteps, func(s step) error {
+		projectCtx := withStep(projectCtxs[s.project.ProjectID], s)
+
+		sub := *s.subscription
+		if sub.Disabled {
+			c.infof("Skipping disabled subscription %q on topic %q for project %q", sub.ID, s.topic.ID, s.project.ProjectID)
+			return nil
+		}
+
+		client, err := c.Clients.Client(projectCtx, s.project.ProjectID)
+		if err != nil {
+			return err
+		}
+
+		subscriptionCtx, span := startSpan(projectCtx, "pubsubc.subscription",
+			attribute.String("pubsub.project", s.project.ProjectID),
+			attribute.String("pubsub.topic", s.topic.ID),
+			attribute.String("pubsub.subscription", sub.ID),
+		)
+
+		if c.StrictFilters {
+			c.checkFilterSchema(subscriptionCtx, s.project, s.topic, sub)
+		}
+
+		// The topic is missing if it failed with ContinueOnError.
+		if topic := topics[s.topic.name(s.project.ProjectID)]; topic != nil {
+			err = c.subscription(subscriptionCtx, client, s.project, topic, sub)
+		} else {
+			err = fmt.Errorf("Unable to create subscription %q for project %q: topic %q was not created", sub.ID, s.project.ProjectID, s.topic.ID)
+		}
+		if err == nil && sub.IAMFile != "" {
+			err = c.applyPolicy(subscriptionCtx, fmt.Sprintf("Subscription %q", sub.ID), client.Subscription(sub.ID).IAM(), sub.IAMFile)
+		}
+		endSpan(span, err)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, project := range projects {
+		if err := c.seed(projectCtxs[project.ProjectID], project, topics); err != nil {
+			return err
+		}
+	}
+
+	if c.seedFailures > 0 {
+		c.warnf("Skipped %d seed message(s) that failed to publish", c.seedFailures)
+	}
+
+	if c.VerifyRetention {
+		for _, project := range projects {
+			client, err := c.Clients.Client(ctx, project.ProjectID)
+			if err != nil {
+				return err
+			}
+
+			for _, t := range project.Topics {
+				topic := topics[fmt.Sprintf("projects/%s/topics/%s", project.ProjectID, t.ID)]
+				if t.Retention == 0 || topic == nil {
+					continue
+				}
+
+				if err := c.verifyRetention(ctx, client, topic, t); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// record adds a resource to the created resources.
+func (c *creator) record(r Resource) {
+	c.mu.Lock()
+	c.created = append(c.created, r)
+	c.mu.Unlock()
+
+	c.event("create", r)
+}
+
+// skip reports an existing resource that is used as is.
+func (c *creator) skip(r Resource) {
+	if c.OnSkip != nil {
+		c.OnSkip(r)
+	}
+
+	c.event("skip", r)
+}
+
+// update reports an existing resource that was updated.
+func (c *creator) update(r Resource) {
+	if c.OnUpdate != nil {
+		c.OnUpdate(r)
+	}
+
+	c.event("update", r)
+}
+
+// runSteps runs the steps of every project, with at most ProjectConcurrency
+// projects and ResourceConcurrency steps of each project at a time. No new
+// steps are started after the first error, which is returned once the running
+// steps are done, as a *ResourceError. With ContinueOnError, the failed steps are recorded instead,
+// and the other steps still run.
+func (c *creator) runSteps(steps []step, run func(s step) error) error {
+	var projectIDs []string
+	byProject := make(map[string][]step)
+	for _, s := range steps {
+		if _, ok := byProject[s.project.ProjectID]; !ok {
+			projectIDs = append(projectIDs, s.project.ProjectID)
+		}
+		byProject[s.project.ProjectID] = append(byProject[s.project.ProjectID], s)
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	var wg sync.WaitGroup
+	projectSem := make(chan struct{}, max(c.ProjectConcurrency, 1))
+	for _, projectID := range projectIDs {
+		projectSem <- struct{}{}
+		if failed() {
+			break
+		}
+
+		wg.Add(1)
+		go func(steps []step) {
+			defer wg.Done()
+			defer func() { <-projectSem }()
+
+			var projectWG sync.WaitGroup
+			resourceSem := make(chan struct{}, max(c.ResourceConcurrency, 1))
+			for _, s := range steps {
+				resourceSem <- struct{}{}
+				if failed() {
+					break
+				}
+
+				projectWG.Add(1)
+				go func(s step) {
+					defer projectWG.Done()
+					defer func() { <-resourceSem }()
+
+					err := run(s)
+					if err != nil && c.ContinueOnError {
+						c.debugf("  Continuing after the failure of %s: %s", s.name(), err)
+						c.fail(s, err)
+						return
+					}
+					if err != nil {
+						f := c.failure(s, err)
+
+						mu.Lock()
+						if firstErr == nil {
+							firstErr = &ResourceError{Failure: f}
+						}
+						mu.Unlock()
+					}
+				}(s)
+			}
+
+			projectWG.Wait()
+		}(byProject[projectID])
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// seed publishes the seed messages of the topics of the specified project.
+func (c *creator) seed(ctx context.Context, project Config, topics map[string]*pubsub.Topic) error {
+	for _, t := range project.Topics {
+		if t.SeedFile == "" && t.SeedCSVFile == "" {
+			continue
+		}
+
+		// The topic is missing if it failed with ContinueOnError.
+		if topics[t.name(project.ProjectID)] == nil {
+			continue
+		}
+
+		if c.SeedOrphanTopicsOnly && hasEnabledSubscriptions(t) {
+			c.debugf("  Not seeding topic %q, because it has subscriptions", t.ID)
+			continue
+		}
+
+		seeds, err := loadTopicSeeds(t)
+		if err != nil {
+			return fmt.Errorf("Unable to seed topic %q for project %q: %s", t.ID, project.ProjectID, err)
+		}
+
+		// The seed attributes are merged before the seeds are expanded, so
+		// that they can be templates too.
+		for i := range seeds {
+			seeds[i].Attributes = mergeAttributes(t.SeedAttributes, seeds[i].Attributes)
+		}
+
+		// The identities of -seed-state are derived from the seeds as if
+		// {ts} expanded to the zero time, so that they match on the next
+		// run.
+		identitySeeds := seeds
+		if t.SeedCount > 0 {
+			if c.SeedState != nil {
+				identitySeeds = expandSeeds(seeds, t.SeedCount, time.Time{})
+			}
+			seeds = expandSeeds(seeds, t.SeedCount, time.Now())
+		}
+
+		if err := c.validateSeeds(ctx, project, t, seeds); err != nil {
+			return fmt.Errorf("%s for project %q", err, project.ProjectID)
+		}
+
+		orderSeeds(t, seeds)
+		if t.SeedCount > 0 && c.SeedState != nil {
+			orderSeeds(t, identitySeeds)
+		} else {
+			identitySeeds = seeds
+		}
+
+		name := fmt.Sprintf("projects/%s/topics/%s", project.ProjectID, t.ID)
+
+		if c.seeded == nil {
+			c.seeded = make(map[string]int)
+		}
+		c.seeded[name] = len(seeds)
+
+		// Skip the seed messages that were published by a previous run.
+		var ids []string
+		if c.SeedState != nil {
+			ids = seedIdentities(name, identitySeeds)
+
+			var pending []Seed
+			var pendingIDs []string
+			for i, id := range ids {
+				if !c.SeedState.has(id) {
+					pending = append(pending, seeds[i])
+					pendingIDs = append(pendingIDs, id)
+				}
+			}
+
+			if skipped := len(seeds) - len(pending); skipped > 0 {
+				c.debugf("  Skipping %d seed message(s) to topic %q that were published before", skipped, t.ID)
+			}
+
+			seeds, ids = pending, pendingIDs
+		}
+
+		var onConfirm func(int)
+		if c.SeedState != nil {
+			onConfirm = func(i int) { c.SeedState.add(ids[i]) }
+		}
+
+		// The order verifier subscribes before the seed messages are
+		// published, so that it receives all of them.
+		var verifier *pubsub.Subscription
+		if c.VerifyOrder && hasOrderingKeys(seeds) {
+			if verifier, err = c.orderVerifier(ctx, project, topics[name]); err != nil {
+				return err
+			}
+			defer c.deleteVerifier(ctx, verifier)
+		}
+
+		c.debugf("  Publishing %d seed message(s) to topic %q", len(seeds), t.ID)
+		err = c.publishSeeds(ctx, topics[name], seeds, onConfirm)
+
+		if err == nil && verifier != nil {
+			err = c.verifyOrder(ctx, verifier, t, seeds)
+		}
+
+		if c.SeedState != nil {
+			if err := c.SeedState.save(); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s for project %q", err, project.ProjectID)
+		}
+	}
+
+	return nil
+}
+
+// topic creates the specified topic. If topic creation is skipped, it returns
+// a reference to the existing topic instead.
+func (c *creator) topic(ctx context.Context, client *pubsub.Client, t Topic) (*pubsub.Topic, error) {
+	if c.SkipTopics {
+		c.debugf("  Using existing topic %q", t.ID)
+
+		topic := client.Topic(t.ID)
+		exists, err := topic.Exists(ctx)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("Unable to check topic %q for project %q: %s", t.ID, client.Project(), err)
+		case !exists:
+			return nil, fmt.Errorf("Topic %q does not exist in project %q", t.ID, client.Project())
+		}
+
+		c.skip(topicResource(client.Project(), t.ID))
+		return topic, nil
+	}
+
+	resource := fmt.Sprintf("Topic %q", t.ID)
+
+	cfg := &pubsub.TopicConfig{
+		Labels: c.labels(t.hash(), t.Labels),
+	}
+	if t.Retention > 0 {
+		cfg.RetentionDuration = t.Retention
+	}
+	if len(t.Regions) > 0 {
+		if err := c.checkCapability(resource, "regions"); err != nil {
+			return nil, err
+		}
+
+		cfg.MessageStoragePolicy = pubsub.MessageStoragePolicy{AllowedPersistenceRegions: t.Regions}
+	}
+	if t.Schema != "" {
+		cfg.SchemaSettings = &pubsub.SchemaSettings{
+			Schema:   schemaName(client.Project(), t.Schema),
+			Encoding: schemaEncodings[t.SchemaEncoding],
+		}
+	}
+	if t.KMSKeyName != "" {
+		if err := c.checkCapability(resource, "kms"); err != nil {
+			return nil, err
+		}
+
+		cfg.KMSKeyName = t.KMSKeyName
+	}
+	if t.Ingestion != nil {
+		if err := c.checkCapability(resource, "ingest"); err != nil {
+			return nil, err
+		}
+
+		cfg.IngestionDataSourceSettings = t.Ingestion.dataSourceSettings()
+	}
+
+	if c.Ensure {
+		topic := client.Topic(t.ID)
+
+		live, err := topic.Config(ctx)
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return nil, fmt.Errorf("Unable to read topic %q for project %q: %s", t.ID, client.Project(), err)
+		case live.Labels[hashLabel] == cfg.Labels[hashLabel]:
+			c.debugf("  Topic %q is unchanged", t.ID)
+			c.skip(topicResource(client.Project(), t.ID))
+			return topic, nil
+		default:
+			if err := c.updateTopic(ctx, topic, live, cfg); err != nil {
+				return topic, err
+			}
+
+			c.update(topicResource(client.Project(), t.ID))
+			return topic, nil
+		}
+	}
+
+	c.debugf("  Creating topic %q", t.ID)
+	var topic *pubsub.Topic
+	err := c.attempt(ctx, func(ctx context.Context) (err error) {
+		topic, err = client.CreateTopicWithConfig(ctx, t.ID, cfg)
+		return err
+	})
+
+	// Older emulator versions reject some topic config fields, in which case
+	// the topic is created with only its labels.
+	if status.Code(err) == codes.InvalidArgument {
+		if fields := topicConfigFields(cfg); len(fields) > 0 {
+			if err := c.noop(resource, strings.Join(fields, ", "), fmt.Sprintf("the emulator rejected the topic config, so the topic is created without it (%s)", err)); err != nil {
+				return nil, err
+			}
+
+			createErr := err
+			err = c.attempt(ctx, func(ctx context.Context) (err error) {
+				topic, err = client.CreateTopicWithConfig(ctx, t.ID, &pubsub.TopicConfig{Labels: cfg.Labels})
+				return err
+			})
+			if status.Code(err) == codes.InvalidArgument {
+				err = createErr
+			}
+		}
+	}
+
+	if status.Code(err) == codes.AlreadyExists && c.SkipExistingTopics {
+		c.debugf("  Topic %q already exists", t.ID)
+		c.skip(topicResource(client.Project(), t.ID))
+		return client.Topic(t.ID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create topic %q for project %q: %s", t.ID, client.Project(), err)
+	}
+
+	// A timed-out attempt created the topic.
+	if topic == nil {
+		topic = client.Topic(t.ID)
+	}
+
+	c.record(topicResource(client.Project(), t.ID))
+
+	return topic, nil
+}
+
+// externalTopic returns a reference to the existing topic with the specified
+// fully qualified name, using the client of its own project.
+func (c *creator) externalTopic(ctx context.Context, name string) (*pubsub.Topic, error) {
+	projectID, topicID := splitTopicName("", name)
+
+	client, err := c.Clients.Client(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.debugf("  Using topic %q of project %q", topicID, projectID)
+
+	topic := client.Topic(topicID)
+	exists, err := topic.Exists(ctx)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("Unable to check topic %q for project %q: %s", topicID, projectID, err)
+	case !exists:
+		return nil, fmt.Errorf("Topic %q does not exist in project %q", topicID, projectID)
+	}
+
+	return topic, nil
+}
+
+// updateTopic updates a topic whose config drifted from the desired config.
+func (c *creator) updateTopic(ctx context.Context, topic *pubsub.Topic, live pubsub.TopicConfig, cfg *pubsub.TopicConfig) error {
+	if live.KMSKeyName != cfg.KMSKeyName {
+		return fmt.Errorf("Topic %q: the KMS key cannot be changed, reset the topic instead", topic.ID())
+	}
+
+	update := pubsub.TopicConfigToUpdate{Labels: mergeLabels(live.Labels, cfg.Labels)}
+
+	// A negative retention removes the existing one.
+	liveRetention, _ := live.RetentionDuration.(time.Duration)
+	retention, _ := cfg.RetentionDuration.(time.Duration)
+	switch {
+	case retention > 0 && retention != liveRetention:
+		update.RetentionDuration = retention
+	case retention == 0 && liveRetention > 0:
+		update.RetentionDuration = time.Duration(-1)
+	}
+
+	if strings.Join(live.MessageStoragePolicy.AllowedPersistenceRegions, ",") != strings.Join(cfg.MessageStoragePolicy.AllowedPersistenceRegions, ",") {
+		update.MessageStoragePolicy = &cfg.MessageStoragePolicy
+	}
+
+	// Empty schema settings remove the existing ones.
+	switch {
+	case cfg.SchemaSettings != nil && (live.SchemaSettings == nil || live.SchemaSettings.Schema != cfg.SchemaSettings.Schema || live.SchemaSettings.Encoding != cfg.SchemaSettings.Encoding):
+		update.SchemaSettings = cfg.SchemaSettings
+	case cfg.SchemaSettings == nil && live.SchemaSettings != nil:
+		update.SchemaSettings = &pubsub.SchemaSettings{}
+	}
+
+	// The live settings also carry the state of the source, so they're
+	// compared by their options.
+	if desired := ingestionFromSettings(cfg.IngestionDataSourceSettings); desired != nil {
+		if current := ingestionFromSettings(live.IngestionDataSourceSettings); current == nil || current.String() != desired.String() {
+			update.IngestionDataSourceSettings = cfg.IngestionDataSourceSettings
+		}
+	}
+
+	c.debugf("  Updating topic %q", topic.ID())
+	if _, err := topic.Update(ctx, update); err != nil {
+		return fmt.Errorf("Unable to update topic %q: %s", topic.ID(), err)
+	}
+
+	return nil
+}
+
+// subscription creates the specified subscription on the topic.
+func (c *creator) subscription(ctx context.Context, client *pubsub.Client, project Config, topic *pubsub.Topic, s Subscription) error {
+	cfg, err := c.subscriptionConfig(ctx, project, topic, s)
+	if err != nil {
+		return err
+	}
+
+	if c.Ensure {
+		sub := client.Subscription(s.ID)
+
+		live, err := sub.Config(ctx)
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return fmt.Errorf("Unable to read subscription %q for project %q: %s", s.ID, project.ProjectID, err)
+		case live.Labels[hashLabel] == cfg.Labels[hashLabel]:
+			c.debugf("    Subscription %q is unchanged", s.ID)
+			c.skip(subscriptionResource(project.ProjectID, s.ID))
+			return nil
+		default:
+			if err := c.updateSubscription(ctx, sub, live, cfg); err != nil {
+				return err
+			}
+
+			c.update(subscriptionResource(project.ProjectID, s.ID))
+			return nil
+		}
+	}
+
+	c.debugf("    Creating subscription %q on topic %q", s.ID, topic.ID())
+	var sub *pubsub.Subscription
+	err = c.attempt(ctx, func(ctx context.Context) (err error) {
+		sub, err = client.CreateSubscription(ctx, s.ID, cfg)
+		return err
+	})
+	// Emulator versions without message transforms reject them, in which
+	// case the subscription is created without them.
+	if len(cfg.MessageTransforms) > 0 && isUnsupported(err) {
+		if err := c.noop(fmt.Sprintf("Subscription %q", s.ID), "transform", fmt.Sprintf("the emulator does not support message transforms (%s)", err)); err != nil {
+			return err
+		}
+
+		cfg.MessageTransforms = nil
+		err = c.attempt(ctx, func(ctx context.Context) (err error) {
+			sub, err = client.CreateSubscription(ctx, s.ID, cfg)
+			return err
+		})
+	}
+	if status.Code(err) == codes.AlreadyExists && c.SkipExistingSubscriptions {
+		c.debugf("    Subscription %q already exists", s.ID)
+		c.skip(subscriptionResource(project.ProjectID, s.ID))
+		return nil
+	}
+	if err != nil {
+		if fields := newerFields(cfg); len(fields) > 0 && isUnsupported(err) {
+			return fmt.Errorf("Unable to create subscription %q on topic %q for project %q: the emulator rejected %s, which older emulator versions do not support; upgrade the emulator image (%s)", s.ID, topic.ID(), project.ProjectID, strings.Join(fields, ", "), err)
+		}
+
+		return fmt.Errorf("Unable to create subscription %q on topic %q for project %q: %s", s.ID, topic.ID(), project.ProjectID, err)
+	}
+
+	// A timed-out attempt created the subscription.
+	if sub == nil {
+		sub = client.Subscription(s.ID)
+	}
+
+	c.record(subscriptionResource(project.ProjectID, s.ID))
+
+	return c.verifySubscription(ctx, sub, cfg)
+}
+
+// verifySubscription probes whether the PubSub service stored the fields of
+// the config that the emulator is known to drop.
+func (c *creator) verifySubscription(ctx context.Context, sub *pubsub.Subscription, cfg pubsub.SubscriptionConfig) error {
+	if cfg.AckDeadline == 0 && cfg.Filter == "" && cfg.DeadLetterPolicy == nil && len(cfg.MessageTransforms) == 0 {
+		return nil
+	}
+
+	live, err := sub.Config(ctx)
+	if err != nil {
+		c.debugf("    Unable to verify subscription %q: %s", sub.ID(), err)
+		return nil
+	}
+
+	resource := fmt.Sprintf("Subscription %q", sub.ID())
+
+	if cfg.AckDeadline > 0 && live.AckDeadline != cfg.AckDeadline {
+		if err := c.noop(resource, "ack", fmt.Sprintf("the subscription uses an ack deadline of %s", live.AckDeadline)); err != nil {
+			return err
+		}
+	}
+
+	// Emulator versions that don't support a field silently drop it, which
+	// also applies to filters and dead-letter policies.
+	if cfg.Filter != "" && live.Filter != cfg.Filter {
+		if err := c.noop(resource, "filter", "the subscription was created without the filter"); err != nil {
+			return err
+		}
+	}
+	if cfg.DeadLetterPolicy != nil && live.DeadLetterPolicy == nil {
+		if err := c.noop(resource, "dlq", "the subscription was created without the dead-letter policy"); err != nil {
+			return err
+		}
+	}
+	if len(cfg.MessageTransforms) > 0 && len(live.MessageTransforms) == 0 {
+		if err := c.noop(resource, "transform", "the subscription was created without the message transform"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subscriptionConfig returns the config to create the specified subscription
+// on the topic with.
+func (c *creator) subscriptionConfig(ctx context.Context, project Config, topic *pubsub.Topic, s Subscription) (pubsub.SubscriptionConfig, error) {
+	resource := fmt.Sprintf("Subscription %q", s.ID)
+
+	cfg := pubsub.SubscriptionConfig{
+		Topic:                     topic,
+		AckDeadline:               s.AckDeadline,
+		EnableExactlyOnceDelivery: s.ExactlyOnceDelivery,
+		EnableMessageOrdering:     s.Ordered,
+		Filter:                    s.Filter,
+		Labels:                    c.labels(s.hash(topic.ID()), s.Labels),
+		RetainAckedMessages:       s.RetainAcked,
+		RetentionDuration:         s.Retention,
+	}
+
+	if s.BigQueryTable != "" {
+		if err := c.checkCapability(resource, "bqtable"); err != nil {
+			return cfg, err
+		}
+
+		cfg.BigQueryConfig = pubsub.BigQueryConfig{Table: s.BigQueryTable, DropUnknownFields: s.BigQueryDropUnknownFields}
+	}
+	if s.CloudStorageBucket != "" {
+		if err := c.checkCapability(resource, "gcsbucket"); err != nil {
+			return cfg, err
+		}
+
+		cfg.CloudStorageConfig = pubsub.CloudStorageConfig{Bucket: s.CloudStorageBucket, MaxBytes: s.CloudStorageMaxBytes}
+		if s.CloudStorageMaxDuration > 0 {
+			cfg.CloudStorageConfig.MaxDuration = s.CloudStorageMaxDuration
+		}
+	}
+
+	// An expiration policy of zero means the subscription never expires.
+	switch {
+	case s.NoExpiration:
+		cfg.ExpirationPolicy = time.Duration(0)
+	case s.Expiration > 0:
+		cfg.ExpirationPolicy = s.Expiration
+	}
+
+	if s.PushEndpoint != "" {
+		cfg.PushConfig = pubsub.PushConfig{Endpoint: s.PushEndpoint}
+		if s.PushServiceAccount != "" {
+			cfg.PushConfig.AuthenticationMethod = &pubsub.OIDCToken{ServiceAccountEmail: s.PushServiceAccount}
+		}
+	}
+
+	if s.TransformFile != "" {
+		transform, err := loadTransform(s.TransformFile)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %s", resource, err)
+		}
+
+		cfg.MessageTransforms = []pubsub.MessageTransform{transform}
+	}
+
+	if s.MinExtensionPeriod > 0 || s.MaxExtensionPeriod > 0 {
+		if err := c.noop(resource, "minextension/maxextension", "extension periods are client-side receive settings and are not stored by the PubSub service"); err != nil {
+			return cfg, err
+		}
+	}
+
+	if s.RetryMinBackoff > 0 || s.RetryMaxBackoff > 0 {
+		cfg.RetryPolicy = &pubsub.RetryPolicy{}
+		if s.RetryMinBackoff > 0 {
+			cfg.RetryPolicy.MinimumBackoff = s.RetryMinBackoff
+		}
+		if s.RetryMaxBackoff > 0 {
+			cfg.RetryPolicy.MaximumBackoff = s.RetryMaxBackoff
+		}
+	}
+
+	if s.DeadLetterTopic != "" {
+		name, err := c.deadLetterTopic(ctx, project, s.DeadLetterTopic)
+		if err != nil {
+			return cfg, fmt.Errorf("Unable to create subscription %q on topic %q for project %q: %s", s.ID, topic.ID(), project.ProjectID, err)
+		}
+
+		// Dead-lettering to the subscription's own topic would redeliver the
+		// messages to the subscription that failed to process them.
+		if name == topic.String() {
+			return cfg, fmt.Errorf("Subscription %q: the dead-letter topic must differ from the topic of the subscription", s.ID)
+		}
+
+		cfg.DeadLetterPolicy = &pubsub.DeadLetterPolicy{
+			DeadLetterTopic:     name,
+			MaxDeliveryAttempts: s.MaxDeliveryAttempts,
+		}
+
+		if s.DeadLetterSubscription != "" {
+			if err := c.deadLetterSubscription(ctx, name, s); err != nil {
+				return cfg, err
+			}
+		}
+
+		if s.Filter != "" {
+			c.warnf("Subscription %q: messages that don't match the filter are acknowledged automatically and are never dead-lettered", s.ID)
+		}
+	}
+
+	return cfg, nil
+}
+
+// defaultSubscriptionRetention is how long the PubSub service retains the
+// messages of a subscription by default.
+const defaultSubscriptionRetention = 7 * 24 * time.Hour
+
+// updateSubscription updates a subscription whose config drifted from the
+// desired config. Fields that cannot be updated must not have drifted.
+func (c *creator) updateSubscription(ctx context.Context, sub *pubsub.Subscription, live pubsub.SubscriptionConfig, cfg pubsub.SubscriptionConfig) error {
+	switch {
+	case live.Topic.String() != cfg.Topic.String():
+		return fmt.Errorf("Subscription %q: the topic cannot be changed from %q, reset the subscription instead", sub.ID(), live.Topic.ID())
+	case live.Filter != cfg.Filter:
+		return fmt.Errorf("Subscription %q: the filter cannot be changed, reset the subscription instead", sub.ID())
+	case live.EnableMessageOrdering != cfg.EnableMessageOrdering:
+		return fmt.Errorf("Subscription %q: message ordering cannot be changed, reset the subscription instead", sub.ID())
+	}
+
+	update := pubsub.SubscriptionConfigToUpdate{
+		AckDeadline:               cfg.AckDeadline,
+		EnableExactlyOnceDelivery: cfg.EnableExactlyOnceDelivery,
+		Labels:                    mergeLabels(live.Labels, cfg.Labels),
+		RetainAckedMessages:       cfg.RetainAckedMessages,
+		RetentionDuration:         cfg.RetentionDuration,
+	}
+
+	// A retention of zero means no update, so reset it to the default.
+	if update.RetentionDuration == 0 {
+		update.RetentionDuration = defaultSubscriptionRetention
+	}
+
+	if live.BigQueryConfig.Table != cfg.BigQueryConfig.Table || live.BigQueryConfig.DropUnknownFields != cfg.BigQueryConfig.DropUnknownFields {
+		update.BigQueryConfig = &cfg.BigQueryConfig
+	}
+	liveMaxDuration, _ := live.CloudStorageConfig.MaxDuration.(time.Duration)
+	maxDuration, _ := cfg.CloudStorageConfig.MaxDuration.(time.Duration)
+	if live.CloudStorageConfig.Bucket != cfg.CloudStorageConfig.Bucket || live.CloudStorageConfig.MaxBytes != cfg.CloudStorageConfig.MaxBytes || liveMaxDuration != maxDuration {
+		update.CloudStorageConfig = &cfg.CloudStorageConfig
+	}
+
+	if cfg.ExpirationPolicy != nil {
+		update.ExpirationPolicy = cfg.ExpirationPolicy
+	}
+
+	// An empty list of message transforms removes the existing ones.
+	switch {
+	case len(cfg.MessageTransforms) > 0:
+		update.MessageTransforms = cfg.MessageTransforms
+	case len(live.MessageTransforms) > 0:
+		update.MessageTransforms = []pubsub.MessageTransform{}
+	}
+
+	// An empty push config turns the subscription into a pull subscription.
+	update.PushConfig = &cfg.PushConfig
+
+	// An ack deadline of zero means no update, so reset it to the default.
+	if update.AckDeadline == 0 {
+		update.AckDeadline = 10 * time.Second
+	}
+
+	// An empty retry policy removes the existing one.
+	update.RetryPolicy = cfg.RetryPolicy
+	if update.RetryPolicy == nil && live.RetryPolicy != nil {
+		update.RetryPolicy = &pubsub.RetryPolicy{}
+	}
+
+	// An empty dead-letter policy removes the existing one.
+	update.DeadLetterPolicy = cfg.DeadLetterPolicy
+	if update.DeadLetterPolicy == nil && live.DeadLetterPolicy != nil {
+		update.DeadLetterPolicy = &pubsub.DeadLetterPolicy{}
+	}
+
+	c.debugf("    Updating subscription %q", sub.ID())
+	if _, err := sub.Update(ctx, update); err != nil {
+		return fmt.Errorf("Unable to update subscription %q: %s", sub.ID(), err)
+	}
+
+	return nil
+}
+
+// schemaEncodings maps the encoding option values to schema encodings.
+var schemaEncodings = map[string]pubsub.SchemaEncoding{
+	"":       pubsub.EncodingUnspecified,
+	"json":   pubsub.EncodingJSON,
+	"binary": pubsub.EncodingBinary,
+}
+
+// labels returns the labels of a resource with the specified config hash and
+// labels. The labels of the resource override the global labels, and the
+// labels of pubsubc override both. The global labels are part of the hash, so
+// that a resource is updated when they change.
+func (c *creator) labels(hash string, resourceLabels map[string]string) map[string]string {
+	labels := mergeLabels(c.Labels, resourceLabels)
+	if hash != "" {
+		if len(c.Labels) > 0 {
+			hash = digest(hash + formatAttributes(c.Labels))
+		}
+
+		labels[hashLabel] = hash
+	}
+	if c.RunID != "" {
+		labels[RunIDLabel] = c.RunID
+	}
+
+	return labels
+}
+
+// mergeLabels returns the live labels overridden by the desired labels.
+func mergeLabels(live, desired map[string]string) map[string]string {
+	labels := make(map[string]string, len(live)+len(desired))
+	for key, val := range live {
+		labels[key] = val
+	}
+	for key, val := range desired {
+		labels[key] = val
+	}
+
+	return labels
+}
+
+// hasEnabledSubscriptions returns true if the topic has subscriptions that
+// are created, unlike the disabled ones.
+func hasEnabledSubscriptions(t Topic) bool {
+	for _, s := range t.Subscriptions {
+		if !s.Disabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// topicConfigFields returns the options that correspond to the fields of the
+// topic config that are set, other than the labels that every emulator
+// supports.
+func topicConfigFields(cfg *pubsub.TopicConfig) []string {
+	var fields []string
+	if cfg.RetentionDuration != nil {
+		fields = append(fields, "retain")
+	}
+	if len(cfg.MessageStoragePolicy.AllowedPersistenceRegions) > 0 {
+		fields = append(fields, "regions")
+	}
+	if cfg.SchemaSettings != nil {
+		fields = append(fields, "schema")
+	}
+	if cfg.KMSKeyName != "" {
+		fields = append(fields, "kms")
+	}
+	if cfg.IngestionDataSourceSettings != nil {
+		fields = append(fields, "ingest")
+	}
+
+	return fields
+}
+
+// newerFields returns the names of the subscription fields in the specified
+// config that older emulator versions do not support.
+func newerFields(cfg pubsub.SubscriptionConfig) []string {
+	var fields []string
+	if cfg.EnableExactlyOnceDelivery {
+		fields = append(fields, "exactly-once delivery")
+	}
+	if cfg.BigQueryConfig.Table != "" {
+		fields = append(fields, "the BigQuery config")
+	}
+	if cfg.CloudStorageConfig.Bucket != "" {
+		fields = append(fields, "the Cloud Storage config")
+	}
+
+	return fields
+}
+
+// isUnsupported returns true if the error is how the emulator responds to a
+// field it does not know about.
+func isUnsupported(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.Unimplemented:
+		return true
+	}
+
+	return false
+}
+
+// deadLetterTopic resolves a dead-letter topic reference of a subscription in
+// the specified project to its fully qualified name. Dead-letter topics that
+// are not defined by any configured project are created on demand.
+func (c *creator) deadLetterTopic(ctx context.Context, project Config, ref string) (string, error) {
+	c.deadLetterMu.Lock()
+	defer c.deadLetterMu.Unlock()
+
+	projectID, topicID := splitTopicName(project.ProjectID, ref)
+	name := fmt.Sprintf("projects/%s/topics/%s", projectID, topicID)
+
+	switch {
+	case c.defined[name]:
+		return name, nil
+	case !c.configured[projectID] && !c.CreateDeadLetterProjects:
+		return "", fmt.Errorf("Dead-letter topic %q is in project %q which is not configured (enable dead-letter project creation to create it)", topicID, projectID)
+	case c.deadLetterTopics[name]:
+		return name, nil
+	}
+
+	client, err := c.Clients.Client(ctx, projectID)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case c.SkipTopics:
+		if _, err := c.topic(ctx, client, Topic{ID: topicID}); err != nil {
+			return "", err
+		}
+
+	default:
+		if !c.configured[projectID] {
+			c.infof("Creating dead-letter topic %q in unconfigured project %q", topicID, projectID)
+		} else {
+			c.debugf("  Creating dead-letter topic %q", topicID)
+		}
+
+		err := c.attempt(ctx, func(ctx context.Context) error {
+			_, err := client.CreateTopicWithConfig(ctx, topicID, &pubsub.TopicConfig{Labels: c.labels("", nil)})
+			return err
+		})
+		switch {
+		case status.Code(err) == codes.AlreadyExists:
+		case err != nil:
+			return "", fmt.Errorf("Unable to create dead-letter topic %q for project %q: %s", topicID, projectID, err)
+		default:
+			c.record(topicResource(projectID, topicID))
+		}
+	}
+
+	if c.deadLetterTopics == nil {
+		c.deadLetterTopics = make(map[string]bool)
+	}
+	c.deadLetterTopics[name] = true
+
+	return name, nil
+}
+
+// deadLetterSubscription creates the dead-letter subscription of s on the
+// dead-letter topic with the fully qualified name, in the project of that
+// topic. A subscription that already exists is used as is.
+func (c *creator) deadLetterSubscription(ctx context.Context, topicName string, s Subscription) error {
+	subscriptionID := s.DeadLetterSubscription
+
+	c.deadLetterMu.Lock()
+	defer c.deadLetterMu.Unlock()
+
+	projectID, topicID := splitTopicName("", topicName)
+	name := fmt.Sprintf("projects/%s/subscriptions/%s", projectID, subscriptionID)
+	if c.deadLetterSubscriptions[name] {
+		return nil
+	}
+
+	client, err := c.Clients.Client(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	c.debugf("    Creating dead-letter subscription %q on topic %q", subscriptionID, topicID)
+	err = c.attempt(ctx, func(ctx context.Context) error {
+		cfg := pubsub.SubscriptionConfig{
+			Topic:             client.Topic(topicID),
+			Labels:            c.labels("", nil),
+			RetentionDuration: s.DeadLetterSubscriptionRetention,
+		}
+		if s.DeadLetterSubscriptionNoExpiration {
+			cfg.ExpirationPolicy = time.Duration(0)
+		}
+
+		_, err := client.CreateSubscription(ctx, subscriptionID, cfg)
+		return err
+	})
+	switch {
+	case status.Code(err) == codes.AlreadyExists:
+		c.skip(subscriptionResource(projectID, subscriptionID))
+	case err != nil:
+		return fmt.Errorf("Unable to create dead-letter subscription %q on topic %q for project %q: %s", subscriptionID, topicID, projectID, err)
+	default:
+		c.record(subscriptionResource(projectID, subscriptionID))
+	}
+
+	if c.deadLetterSubscriptions == nil {
+		c.deadLetterSubscriptions = make(map[string]bool)
+	}
+	c.deadLetterSubscriptions[name] = true
+
+	return nil
+}
@@ -0,0 +1,1523 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reactorFunc adapts a function to a pstest.Reactor.
+type reactorFunc func(req interface{}) (bool, interface{}, error)
+
+func (f reactorFunc) React(req interface{}) (bool, interface{}, error) {
+	return f(req)
+}
+
+// newTestProvisioner returns a Provisioner for a pstest server that is
+// started with the specified reactors, along with the client to project1.
+func newTestProvisioner(t *testing.T, opts ...pstest.ServerReactorOption) (*Provisioner, *pubsub.Client) {
+	t.Helper()
+
+	p, client, _ := newTestServer(t, opts...)
+	return p, client
+}
+
+// newTestServer is like newTestProvisioner, but also returns the server.
+func newTestServer(t *testing.T, opts ...pstest.ServerReactorOption) (*Provisioner, *pubsub.Client, *pstest.Server) {
+	t.Helper()
+
+	srv := pstest.NewServer(opts...)
+	t.Cleanup(func() { srv.Close() })
+	t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+	clients := &ClientCache{ShareConnection: true}
+	t.Cleanup(func() { clients.Close() })
+
+	client, err := clients.Client(context.Background(), "project1")
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+
+	return &Provisioner{Clients: clients}, client, srv
+}
+
+// create parses the definition and creates it with the provisioner.
+func create(t *testing.T, p *Provisioner, definition string) error {
+	t.Helper()
+
+	cfg, err := (&Parser{}).Parse(definition)
+	if err != nil {
+		t.Fatalf("unable to parse %q: %s", definition, err)
+	}
+
+	_, err = p.Create(context.Background(), []Config{cfg})
+	return err
+}
+
+func TestCreateTopicFallback(t *testing.T) {
+	// rejectRetention rejects the topics with a retention, like older
+	// emulator versions do, and rejectAll rejects every topic.
+	rejectRetention := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+		if req.(*pubsubpb.Topic).MessageRetentionDuration != nil {
+			return true, nil, status.Error(codes.InvalidArgument, "unknown field")
+		}
+		return false, nil, nil
+	})
+	rejectAll := reactorFunc(func(interface{}) (bool, interface{}, error) {
+		return true, nil, status.Error(codes.InvalidArgument, "invalid topic")
+	})
+
+	tests := []struct {
+		name       string
+		reactor    pstest.Reactor
+		strict     bool
+		definition string
+		err        string
+	}{
+		{name: "retention fallback", reactor: rejectRetention, definition: "project1,topic1[retain=2h]"},
+		{name: "labels fallback", reactor: rejectRetention, definition: "project1,topic1[retain=2h,labels=team:core]"},
+		{name: "strict", reactor: rejectRetention, strict: true, definition: "project1,topic1[retain=2h]", err: "option retain has no effect"},
+		{name: "no advanced fields", reactor: rejectAll, definition: "project1,topic1[labels=team:core]", err: "invalid topic"},
+		{name: "fallback rejected", reactor: rejectAll, definition: "project1,topic1[retain=2h]", err: "invalid topic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: tt.reactor})
+			p.Strict = tt.strict
+
+			err := create(t, p, tt.definition)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := client.Topic("topic1").Config(context.Background())
+			if err != nil {
+				t.Fatalf("unable to read topic: %s", err)
+			}
+			if cfg.RetentionDuration != nil {
+				t.Errorf("expected the fallback topic to have no retention, got %v", cfg.RetentionDuration)
+			}
+			if cfg.Labels[hashLabel] == "" {
+				t.Errorf("expected the fallback topic to keep its %s label, got %v", hashLabel, cfg.Labels)
+			}
+			if strings.Contains(tt.definition, "team=core") && cfg.Labels["team"] != "core" {
+				t.Errorf("expected the fallback topic to keep its team label, got %v", cfg.Labels)
+			}
+		})
+	}
+}
+
+func TestCreateDeadLetterSubscriptionExpiration(t *testing.T) {
+	tests := []struct {
+		name         string
+		noExpire     bool
+		definition   string
+		neverExpires bool
+	}{
+		{name: "default", definition: "project1,topic1:sub1;dlq=dead1;dlqsub=deadsub1"},
+		{name: "no-expire", noExpire: true, definition: "project1,topic1:sub1;dlq=dead1;dlqsub=deadsub1", neverExpires: true},
+		{name: "dlqsubnoexpire", definition: "project1,topic1:sub1;dlq=dead1;dlqsub=deadsub1;dlqsubnoexpire", neverExpires: true},
+		{name: "no-expire overridden", noExpire: true, definition: "project1,topic1:sub1;dlq=dead1;dlqsub=deadsub1;dlqsubnoexpire=false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// pstest reports a subscription without an expiration policy as
+			// one that never expires, so the requests are inspected instead.
+			policies := make(map[string]*pubsubpb.ExpirationPolicy)
+			record := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				sub := req.(*pubsubpb.Subscription)
+				policies[sub.Name] = sub.ExpirationPolicy
+				return false, nil, nil
+			})
+
+			p, _ := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: record})
+
+			cfg, err := (&Parser{NoExpire: tt.noExpire}).Parse(tt.definition)
+			if err != nil {
+				t.Fatalf("unable to parse %q: %s", tt.definition, err)
+			}
+			if _, err := p.Create(context.Background(), []Config{cfg}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			policy, ok := policies["projects/project1/subscriptions/deadsub1"]
+			if !ok {
+				t.Fatalf("expected the dead-letter subscription to be created")
+			}
+			if neverExpires := policy != nil && policy.Ttl == nil; neverExpires != tt.neverExpires {
+				t.Errorf("expected the dead-letter subscription to never expire to be %t, got policy %v", tt.neverExpires, policy)
+			}
+		})
+	}
+}
+
+func TestCreateDeadLetterSubscriptionRetention(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   string
+		definition string
+		retentions map[string]time.Duration
+		requested  []string
+	}{
+		{
+			name:       "independent from the subscription",
+			definition: "project1,charges:charges-settle;retain=1h;dlq=charges-dead;dlqsub=charges-inspect;dlqsubretain=7d",
+			retentions: map[string]time.Duration{"charges-settle": time.Hour, "charges-inspect": 7 * 24 * time.Hour},
+			requested:  []string{"charges-inspect", "charges-settle"},
+		},
+		{
+			name:       "only the dead-letter subscription",
+			definition: "project1,refunds:refunds-notify;dlq=refunds-dead;dlqsub=refunds-triage;dlqsubretain=3d;dlqsubnoexpire",
+			retentions: map[string]time.Duration{"refunds-notify": 7 * 24 * time.Hour, "refunds-triage": 3 * 24 * time.Hour},
+			requested:  []string{"refunds-notify", "refunds-triage"},
+		},
+		{
+			name:       "shared by two subscriptions",
+			definition: "project1,payouts:payouts-bank;dlq=payouts-dead;dlqsub=payouts-review;dlqsubretain=max:payouts-ledger;dlq=payouts-dead;dlqsub=payouts-review;dlqsubretain=max",
+			retentions: map[string]time.Duration{"payouts-review": 7 * 24 * time.Hour},
+			requested:  []string{"payouts-bank", "payouts-ledger", "payouts-review"},
+		},
+		{
+			name:       "existing dead-letter subscription is used as is",
+			existing:   "project1,disputes-dead:disputes-evidence;retain=2h",
+			definition: "project1,disputes:disputes-open;dlq=disputes-dead;dlqsub=disputes-evidence;dlqsubretain=5d",
+			retentions: map[string]time.Duration{"disputes-evidence": 2 * time.Hour},
+			requested:  []string{"disputes-evidence", "disputes-open"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The retention of an existing subscription is kept, even
+			// though it is requested again.
+			var (
+				mu        sync.Mutex
+				requested []string
+				record    bool
+			)
+			recordCreate := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if record {
+					name := req.(*pubsubpb.Subscription).Name
+					requested = append(requested, name[strings.LastIndex(name, "/")+1:])
+				}
+				return false, nil, nil
+			})
+
+			p, client := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: recordCreate})
+			if tt.existing != "" {
+				if err := create(t, p, tt.existing); err != nil {
+					t.Fatalf("unable to create %q: %s", tt.existing, err)
+				}
+			}
+
+			mu.Lock()
+			record = true
+			mu.Unlock()
+			if err := create(t, p, tt.definition); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			mu.Lock()
+			slices.Sort(requested)
+			if !slices.Equal(requested, tt.requested) {
+				t.Errorf("expected the subscriptions %q to be requested, got %q", tt.requested, requested)
+			}
+			mu.Unlock()
+
+			for id, want := range tt.retentions {
+				cfg, err := client.Subscription(id).Config(context.Background())
+				if err != nil {
+					t.Fatalf("unable to get subscription %q: %s", id, err)
+				}
+				if cfg.RetentionDuration != want {
+					t.Errorf("%s: expected the retention %s, got %s", id, want, cfg.RetentionDuration)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateOnOperation(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	tests := []struct {
+		name       string
+		definition string
+		want       int
+	}{
+		{name: "topics only", definition: "project1,uploads,thumbnails", want: 2},
+		{name: "topics and subscriptions", definition: "project1,uploads:uploads-scan:uploads-index,thumbnails:thumbnails-cdn", want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slowTopics := reactorFunc(func(interface{}) (bool, interface{}, error) {
+				time.Sleep(delay)
+				return false, nil, nil
+			})
+			p, _ := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: slowTopics})
+
+			var (
+				mu        sync.Mutex
+				durations []time.Duration
+			)
+			p.OnOperation = func(d time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				durations = append(durations, d)
+			}
+
+			if err := create(t, p, tt.definition); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(durations) != tt.want {
+				t.Fatalf("expected %d operations, got %d", tt.want, len(durations))
+			}
+
+			// The 2 topics take at least the delay.
+			var slow int
+			for _, d := range durations {
+				if d >= delay {
+					slow++
+				}
+			}
+			if slow < 2 {
+				t.Errorf("expected the topic operations to take at least %s, got %v", delay, durations)
+			}
+		})
+	}
+}
+
+func TestCreateSeedOrphanTopicsOnly(t *testing.T) {
+	seeds := filepath.Join(t.TempDir(), "seeds.jsonl")
+	if err := os.WriteFile(seeds, []byte(`{"data":"order-1"}`+"\n"+`{"data":"order-2"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		orphanOnly bool
+		subs       string
+		want       int
+	}{
+		{name: "orphan", orphanOnly: true, want: 2},
+		{name: "subscribed", orphanOnly: true, subs: ":sub1", want: 0},
+		{name: "disabled", orphanOnly: true, subs: ":sub1;enabled=false", want: 2},
+		{name: "enabled", orphanOnly: true, subs: ":sub1;enabled=true", want: 0},
+		{name: "all topics", subs: ":sub1", want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, srv := newTestServer(t)
+			p.SeedOrphanTopicsOnly = tt.orphanOnly
+
+			if err := create(t, p, "project1,orders[seed="+seeds+"]"+tt.subs); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if n := len(srv.Messages()); n != tt.want {
+				t.Errorf("expected %d seed messages, got %d", tt.want, n)
+			}
+		})
+	}
+}
+
+func TestCreateSkipTopics(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		config   string
+		created  []string
+		skipped  []string
+		err      string
+	}{
+		{
+			name:     "existing topic",
+			existing: []string{"payments"},
+			config:   "project1,payments:payments-audit",
+			created:  []string{"projects/project1/subscriptions/payments-audit"},
+			skipped:  []string{"projects/project1/topics/payments"},
+		},
+		{
+			name:     "several existing topics",
+			existing: []string{"payments", "invoices"},
+			config:   "project1,payments:payments-audit,invoices:invoices-mailer",
+			created:  []string{"projects/project1/subscriptions/payments-audit", "projects/project1/subscriptions/invoices-mailer"},
+			skipped:  []string{"projects/project1/topics/payments", "projects/project1/topics/invoices"},
+		},
+		{
+			name:     "missing topic",
+			existing: []string{"payments"},
+			config:   "project1,payments:payments-audit,invoices:invoices-mailer",
+			err:      `Topic "invoices" does not exist in project "project1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			for _, id := range tt.existing {
+				if _, err := client.CreateTopic(context.Background(), id); err != nil {
+					t.Fatalf("unable to create topic %q: %s", id, err)
+				}
+			}
+
+			var skipped []string
+			p.SkipTopics = true
+			p.OnSkip = func(r Resource) { skipped = append(skipped, r.Name) }
+
+			cfg, err := (&Parser{}).Parse(tt.config)
+			if err != nil {
+				t.Fatalf("unable to parse %q: %s", tt.config, err)
+			}
+
+			resources, err := p.Create(context.Background(), []Config{cfg})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := resourceNames(resources); !slices.Equal(got, tt.created) {
+				t.Errorf("expected created %v, got %v", tt.created, got)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("expected skipped %v, got %v", tt.skipped, skipped)
+			}
+		})
+	}
+}
+
+// resourceNames returns the names of the resources.
+func resourceNames(resources []Resource) []string {
+	var names []string
+	for _, r := range resources {
+		names = append(names, r.Name)
+	}
+
+	return names
+}
+
+func TestCreateSubscriptionUnsupportedFields(t *testing.T) {
+	// rejectNewer rejects the subscription fields that older emulator
+	// versions don't know about.
+	rejectNewer := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+		sub := req.(*pubsubpb.Subscription)
+		if sub.EnableExactlyOnceDelivery || sub.BigqueryConfig != nil {
+			return true, nil, status.Error(codes.Unimplemented, "unknown field")
+		}
+		return false, nil, nil
+	})
+
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{name: "supported fields", config: "project1,shipments:shipments-tracker;ack=20s"},
+		{name: "exactly-once", config: "project1,shipments:shipments-tracker;exactlyonce", err: "the emulator rejected exactly-once delivery, which older emulator versions do not support; upgrade the emulator image"},
+		{name: "bigquery", config: "project1,shipments:shipments-tracker;bqtable=proj.dataset.table", err: "the emulator rejected the BigQuery config, which older"},
+		{name: "several", config: "project1,shipments:shipments-tracker;exactlyonce;bqtable=proj.dataset.table", err: "exactly-once delivery, the BigQuery config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: rejectNewer})
+
+			err := create(t, p, tt.config)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// testLogger collects the warnings of a Provisioner.
+type testLogger struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {}
+func (l *testLogger) Infof(format string, args ...interface{})  {}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestCreateDisabledSubscriptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		created []string
+	}{
+		{
+			name:    "disabled",
+			config:  "project1,jobs:runner1;enabled=false",
+			created: []string{"projects/project1/topics/jobs"},
+		},
+		{
+			name:    "mixed",
+			config:  "project1,jobs:runner1;enabled=false:runner2:runner3;enabled=true",
+			created: []string{"projects/project1/topics/jobs", "projects/project1/subscriptions/runner2", "projects/project1/subscriptions/runner3"},
+		},
+		{
+			name:    "enabled without value",
+			config:  "project1,jobs:runner1;enabled",
+			created: []string{"projects/project1/topics/jobs", "projects/project1/subscriptions/runner1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+
+			cfg, err := (&Parser{}).Parse(tt.config)
+			if err != nil {
+				t.Fatalf("unable to parse %q: %s", tt.config, err)
+			}
+
+			resources, err := p.Create(context.Background(), []Config{cfg})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := resourceNames(resources); !slices.Equal(got, tt.created) {
+				t.Errorf("expected created %v, got %v", tt.created, got)
+			}
+
+			// The diff against the live state doesn't report the disabled
+			// subscriptions as missing.
+			live, err := Live(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, change := range Diff(cfg, live) {
+				if change.Action != ActionNoop {
+					t.Errorf("expected no changes after creating, got %+v", change)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateEnsure(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second string
+		created       []string
+		updated       []string
+		skipped       []string
+	}{
+		{
+			name:    "unchanged",
+			first:   "project1,metrics[retain=1h]:metrics-agg;ack=20s",
+			second:  "project1,metrics[retain=1h]:metrics-agg;ack=20s",
+			skipped: []string{"projects/project1/topics/metrics", "projects/project1/subscriptions/metrics-agg"},
+		},
+		{
+			name:    "subscription changed",
+			first:   "project1,metrics:metrics-agg;ack=20s",
+			second:  "project1,metrics:metrics-agg;ack=40s",
+			updated: []string{"projects/project1/subscriptions/metrics-agg"},
+			skipped: []string{"projects/project1/topics/metrics"},
+		},
+		{
+			name:    "topic changed",
+			first:   "project1,metrics[retain=1h]:metrics-agg",
+			second:  "project1,metrics[retain=2h]:metrics-agg",
+			updated: []string{"projects/project1/topics/metrics"},
+			skipped: []string{"projects/project1/subscriptions/metrics-agg"},
+		},
+		{
+			name:    "subscription added",
+			first:   "project1,metrics:metrics-agg",
+			second:  "project1,metrics:metrics-agg:metrics-archive",
+			created: []string{"projects/project1/subscriptions/metrics-archive"},
+			skipped: []string{"projects/project1/topics/metrics", "projects/project1/subscriptions/metrics-agg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t)
+			p.Ensure = true
+
+			if err := create(t, p, tt.first); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var updated, skipped []string
+			p.OnUpdate = func(r Resource) { updated = append(updated, r.Name) }
+			p.OnSkip = func(r Resource) { skipped = append(skipped, r.Name) }
+
+			cfg, err := (&Parser{}).Parse(tt.second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resources, err := p.Create(context.Background(), []Config{cfg})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := resourceNames(resources); !slices.Equal(got, tt.created) {
+				t.Errorf("expected created %v, got %v", tt.created, got)
+			}
+			if !slices.Equal(updated, tt.updated) {
+				t.Errorf("expected updated %v, got %v", tt.updated, updated)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("expected skipped %v, got %v", tt.skipped, skipped)
+			}
+		})
+	}
+}
+
+func TestCreateFilteredDeadLetterWarning(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		subscription string
+		warning      bool
+	}{
+		{name: "filter and dead-letter topic", config: `project1,payouts:payouts-eu;filter=attributes.region = "eu";dlq=payouts-dead,payouts-dead`, subscription: "payouts-eu", warning: true},
+		{name: "filter only", config: `project1,payouts:payouts-eu;filter=attributes.region = "eu"`, subscription: "payouts-eu"},
+		{name: "dead-letter topic only", config: "project1,payouts:payouts-all;dlq=payouts-dead;maxdelivery=5,payouts-dead", subscription: "payouts-all"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			logger := &testLogger{}
+			p.Logger = logger
+
+			if err := create(t, p, tt.config); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var warned bool
+			for _, w := range logger.warnings {
+				warned = warned || strings.Contains(w, "messages that don't match the filter are acknowledged automatically and are never dead-lettered")
+			}
+			if warned != tt.warning {
+				t.Errorf("expected the filter warning to be %t, got warnings %q", tt.warning, logger.warnings)
+			}
+
+			// The subscription is created with both the filter and the
+			// dead-letter policy.
+			cfg, err := client.Subscription(tt.subscription).Config(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantFilter := strings.Contains(tt.config, "filter="); (cfg.Filter != "") != wantFilter {
+				t.Errorf("expected a filter to be %t, got %q", wantFilter, cfg.Filter)
+			}
+			if wantDLQ := strings.Contains(tt.config, "dlq="); (cfg.DeadLetterPolicy != nil) != wantDLQ {
+				t.Errorf("expected a dead-letter policy to be %t, got %+v", wantDLQ, cfg.DeadLetterPolicy)
+			}
+		})
+	}
+}
+
+func TestCreateRetentionKeywords(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		want       map[string]time.Duration
+	}{
+		{
+			name:       "min and max",
+			definition: "project1,exports:exports-replay;retain=max:exports-audit;retain=min",
+			want:       map[string]time.Duration{"exports-replay": 7 * 24 * time.Hour, "exports-audit": 10 * time.Minute},
+		},
+		{
+			name:       "keyword next to a duration",
+			definition: "project1,imports:imports-retry;retain=36h:imports-dlq;retain=max;retainacked",
+			want:       map[string]time.Duration{"imports-retry": 36 * time.Hour, "imports-dlq": 7 * 24 * time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			if err := create(t, p, tt.definition); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for id, want := range tt.want {
+				cfg, err := client.Subscription(id).Config(context.Background())
+				if err != nil {
+					t.Fatalf("unable to get subscription %q: %s", id, err)
+				}
+				if cfg.RetentionDuration != want {
+					t.Errorf("%s: expected the retention %s, got %s", id, want, cfg.RetentionDuration)
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePushServiceAccount(t *testing.T) {
+	t.Setenv("SHIPPING_PUSHER", "shipping@acme.iam.gserviceaccount.com")
+
+	tests := []struct {
+		name       string
+		definition string
+		account    string
+	}{
+		{name: "without service account", definition: `project1,shipments:courier;push="https://courier.acme.test/push"`},
+		{name: "inline", definition: `project1,shipments:courier;push="https://courier.acme.test/push";pushsa=courier@acme.iam.gserviceaccount.com`, account: "courier@acme.iam.gserviceaccount.com"},
+		{name: "environment variable", definition: `project1,shipments:courier;push="https://courier.acme.test/push";pushsa=$SHIPPING_PUSHER`, account: "shipping@acme.iam.gserviceaccount.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			if err := create(t, p, tt.definition); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := client.Subscription("courier").Config(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var account string
+			if token, ok := cfg.PushConfig.AuthenticationMethod.(*pubsub.OIDCToken); ok {
+				account = token.ServiceAccountEmail
+			}
+			if cfg.PushConfig.Endpoint != "https://courier.acme.test/push" || account != tt.account {
+				t.Errorf("expected a push to %q as %q, got %q as %q", "https://courier.acme.test/push", tt.account, cfg.PushConfig.Endpoint, account)
+			}
+		})
+	}
+}
+
+func TestCreateTopicSchemaEncoding(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		schema     string
+		encoding   pubsub.SchemaEncoding
+	}{
+		{name: "json", definition: "project1,telemetry[schema=reading-v2,encoding=json]", schema: "projects/project1/schemas/reading-v2", encoding: pubsub.EncodingJSON},
+		{name: "binary", definition: "project1,telemetry[schema=reading-v2,encoding=binary]", schema: "projects/project1/schemas/reading-v2", encoding: pubsub.EncodingBinary},
+		{name: "unspecified", definition: "project1,telemetry[schema=reading-v2]", schema: "projects/project1/schemas/reading-v2", encoding: pubsub.EncodingUnspecified},
+		{name: "schema of another project", definition: "project1,telemetry[schema=projects/registry/schemas/reading,encoding=json]", schema: "projects/registry/schemas/reading", encoding: pubsub.EncodingJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			if err := create(t, p, tt.definition); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := client.Topic("telemetry").Config(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.SchemaSettings == nil {
+				t.Fatal("expected schema settings")
+			}
+			if cfg.SchemaSettings.Schema != tt.schema || cfg.SchemaSettings.Encoding != tt.encoding {
+				t.Errorf("expected schema %q with encoding %v, got %q with %v", tt.schema, tt.encoding, cfg.SchemaSettings.Schema, cfg.SchemaSettings.Encoding)
+			}
+		})
+	}
+}
+
+func TestCreateTopicRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		strict  bool
+		regions []string
+		err     string
+	}{
+		{name: "regions", config: "project1,payments[regions=us-east1;us-central1]", regions: []string{"us-east1", "us-central1"}},
+		{name: "no regions", config: "project1,payments"},
+		{name: "strict", config: "project1,payments[regions=us-east1]", strict: true, err: "option regions has no effect: the emulator does not restrict where messages are stored"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			p.Strict = tt.strict
+
+			err := create(t, p, tt.config)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := client.Topic("payments").Config(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if regions := cfg.MessageStoragePolicy.AllowedPersistenceRegions; !slices.Equal(regions, tt.regions) {
+				t.Errorf("expected regions %q, got %q", tt.regions, regions)
+			}
+		})
+	}
+}
+
+func TestCreateSkipExisting(t *testing.T) {
+	tests := []struct {
+		name          string
+		topics        bool
+		subscriptions bool
+		created       []string
+		skipped       []string
+		err           string
+	}{
+		{
+			name: "strict",
+			err:  `Unable to create topic "catalog" for project "project1"`,
+		},
+		{
+			name:   "existing topics",
+			topics: true,
+			err:    `Unable to create subscription "catalog-search" on topic "catalog" for project "project1"`,
+		},
+		{
+			name:          "existing subscriptions",
+			subscriptions: true,
+			err:           `Unable to create topic "catalog" for project "project1"`,
+		},
+		{
+			name:          "existing topics and subscriptions",
+			topics:        true,
+			subscriptions: true,
+			created:       []string{"projects/project1/subscriptions/catalog-index"},
+			skipped:       []string{"projects/project1/topics/catalog", "projects/project1/subscriptions/catalog-search"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+
+			ctx := context.Background()
+			topic, err := client.CreateTopic(ctx, "catalog")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.CreateSubscription(ctx, "catalog-search", pubsub.SubscriptionConfig{Topic: topic}); err != nil {
+				t.Fatal(err)
+			}
+
+			var skipped []string
+			p.SkipExistingTopics = tt.topics
+			p.SkipExistingSubscriptions = tt.subscriptions
+			p.OnSkip = func(r Resource) { skipped = append(skipped, r.Name) }
+
+			cfg, err := (&Parser{}).Parse("project1,catalog:catalog-search:catalog-index")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resources, err := p.Create(ctx, []Config{cfg})
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) || !strings.Contains(err.Error(), "AlreadyExists") {
+					t.Fatalf("expected an AlreadyExists error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := resourceNames(resources); !slices.Equal(got, tt.created) {
+				t.Errorf("expected created %v, got %v", tt.created, got)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("expected skipped %v, got %v", tt.skipped, skipped)
+			}
+		})
+	}
+}
+
+func TestCreateNoExpire(t *testing.T) {
+	tests := []struct {
+		name       string
+		noExpire   bool
+		definition string
+		// expiration maps the subscriptions to their expiration policies in
+		// the create requests: never, a TTL, or empty for none.
+		expiration map[string]string
+	}{
+		{
+			name:       "default",
+			definition: "project1,sensors:ingest:archive;expire=36h,alarms:pager",
+			expiration: map[string]string{"ingest": "", "archive": "36h0m0s", "pager": ""},
+		},
+		{
+			name:       "no-expire",
+			noExpire:   true,
+			definition: "project1,sensors:ingest:archive,alarms:pager",
+			expiration: map[string]string{"ingest": "never", "archive": "never", "pager": "never"},
+		},
+		{
+			name:       "no-expire overridden",
+			noExpire:   true,
+			definition: "project1,sensors:ingest:archive;expire=48h,alarms:pager;expire=never",
+			expiration: map[string]string{"ingest": "never", "archive": "48h0m0s", "pager": "never"},
+		},
+		{
+			name:       "no-expire with defaults",
+			noExpire:   true,
+			definition: "project1,sensors:(ingest,archive);ack=30s",
+			expiration: map[string]string{"ingest": "never", "archive": "never"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			expiration := make(map[string]string)
+			record := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				sub := req.(*pubsubpb.Subscription)
+				id := sub.Name[strings.LastIndexByte(sub.Name, '/')+1:]
+				switch policy := sub.ExpirationPolicy; {
+				case policy == nil:
+					expiration[id] = ""
+				case policy.Ttl == nil:
+					expiration[id] = "never"
+				default:
+					expiration[id] = policy.Ttl.AsDuration().String()
+				}
+
+				return false, nil, nil
+			})
+
+			p, _ := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: record})
+
+			cfg, err := (&Parser{NoExpire: tt.noExpire}).Parse(tt.definition)
+			if err != nil {
+				t.Fatalf("unable to parse %q: %s", tt.definition, err)
+			}
+			if _, err := p.Create(context.Background(), []Config{cfg}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !maps.Equal(expiration, tt.expiration) {
+				t.Errorf("expected the expiration policies %v, got %v", tt.expiration, expiration)
+			}
+		})
+	}
+}
+
+func TestCreateEmptyProject(t *testing.T) {
+	p, client := newTestProvisioner(t)
+
+	cfg, err := (&Parser{AllowEmpty: true}).Parse("project1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources, err := p.Create(context.Background(), []Config{cfg})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(resources) != 0 {
+		t.Errorf("expected nothing to be created, got %v", resourceNames(resources))
+	}
+
+	live, err := Live(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live.Topics) != 0 {
+		t.Errorf("expected no topics, got %+v", live.Topics)
+	}
+}
+
+func TestCreateLabels(t *testing.T) {
+	tests := []struct {
+		name         string
+		global       map[string]string
+		config       string
+		topic        map[string]string
+		subscription map[string]string
+		err          string
+	}{
+		{
+			name:         "global labels",
+			global:       map[string]string{"team": "core", "owner": "me"},
+			config:       "project1,deploys:deploys-slack",
+			topic:        map[string]string{"team": "core", "owner": "me"},
+			subscription: map[string]string{"team": "core", "owner": "me"},
+		},
+		{
+			name:         "resource labels override global labels",
+			global:       map[string]string{"team": "core", "owner": "me"},
+			config:       `project1,deploys[labels=team:release;tier:gold]:deploys-slack;labels="owner:oncall"`,
+			topic:        map[string]string{"team": "release", "owner": "me", "tier": "gold"},
+			subscription: map[string]string{"team": "core", "owner": "oncall"},
+		},
+		{
+			name:         "resource labels only",
+			config:       "project1,deploys[labels=env:ci]:deploys-slack",
+			topic:        map[string]string{"env": "ci"},
+			subscription: map[string]string{},
+		},
+		{
+			name:   "invalid resource label",
+			config: "project1,deploys[labels=Env:ci]:deploys-slack",
+			err:    `invalid label key "Env"`,
+		},
+	}
+
+	// withoutInternal returns the labels without those of pubsubc itself.
+	withoutInternal := func(labels map[string]string) map[string]string {
+		filtered := make(map[string]string)
+		for key, val := range labels {
+			if !strings.HasPrefix(key, "pubsubc-") {
+				filtered[key] = val
+			}
+		}
+		return filtered
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			p.Labels = tt.global
+
+			cfg, err := (&Parser{}).Parse(tt.config)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to parse %q: %s", tt.config, err)
+			}
+
+			ctx := context.Background()
+			if _, err := p.Create(ctx, []Config{cfg}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			topic, err := client.Topic("deploys").Config(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if labels := withoutInternal(topic.Labels); !maps.Equal(labels, tt.topic) {
+				t.Errorf("expected topic labels %v, got %v", tt.topic, labels)
+			}
+
+			sub, err := client.Subscription("deploys-slack").Config(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if labels := withoutInternal(sub.Labels); !maps.Equal(labels, tt.subscription) {
+				t.Errorf("expected subscription labels %v, got %v", tt.subscription, labels)
+			}
+		})
+	}
+}
+
+func TestCreateConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []string
+		projects    int
+		resources   int
+		// wantProjects and wantResources are the maximum number of projects
+		// and resources per project that are created concurrently.
+		wantProjects, wantResources int
+	}{
+		{
+			name:         "defaults",
+			definitions:  []string{"ingest,raw-[1-3]:raw-[1-3]-sink", "serve,hits-[1-2]"},
+			wantProjects: 1, wantResources: 1,
+		},
+		{
+			name:         "projects only",
+			definitions:  []string{"tenant-a,jobs", "tenant-b,jobs", "tenant-c,jobs:jobs-worker"},
+			projects:     2,
+			wantProjects: 2, wantResources: 1,
+		},
+		{
+			name:         "resources only",
+			definitions:  []string{"loadtest,orders-[1-8]:orders-[1-8]-worker"},
+			resources:    4,
+			wantProjects: 1, wantResources: 4,
+		},
+		{
+			name:         "both",
+			definitions:  []string{"eu,events-[1-5]", "us,events-[1-5]", "ap,events-[1-5]"},
+			projects:     2,
+			resources:    3,
+			wantProjects: 2, wantResources: 3,
+		},
+		{
+			name:         "limits above the number of resources",
+			definitions:  []string{"small,audit:audit-archive"},
+			projects:     8,
+			resources:    20,
+			wantProjects: 1, wantResources: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu            sync.Mutex
+				inFlight      = make(map[string]int)
+				maxProjects   int
+				maxResources  int
+				createdTopics int
+				createdSubs   int
+			)
+
+			// The interceptor holds on to every creation for a while, so that
+			// concurrent creations overlap.
+			interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				var name string
+				switch req := req.(type) {
+				case *pubsubpb.Topic:
+					name = req.Name
+				case *pubsubpb.Subscription:
+					name = req.Name
+				default:
+					return invoker(ctx, method, req, reply, cc, opts...)
+				}
+				projectID := strings.Split(name, "/")[1]
+
+				mu.Lock()
+				inFlight[projectID]++
+				maxResources = max(maxResources, inFlight[projectID])
+				var active int
+				for _, n := range inFlight {
+					if n > 0 {
+						active++
+					}
+				}
+				maxProjects = max(maxProjects, active)
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+				err := invoker(ctx, method, req, reply, cc, opts...)
+
+				mu.Lock()
+				inFlight[projectID]--
+				if _, ok := req.(*pubsubpb.Topic); ok {
+					createdTopics++
+				} else {
+					createdSubs++
+				}
+				mu.Unlock()
+
+				return err
+			}
+
+			srv := pstest.NewServer()
+			t.Cleanup(func() { srv.Close() })
+			t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+			clients := &ClientCache{ShareConnection: true, DialOptions: []grpc.DialOption{grpc.WithUnaryInterceptor(interceptor)}}
+			t.Cleanup(func() { clients.Close() })
+
+			var (
+				projects     []Config
+				topics, subs int
+			)
+			for _, definition := range tt.definitions {
+				cfg, err := (&Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				for _, topic := range cfg.Topics {
+					topics++
+					subs += len(topic.Subscriptions)
+				}
+				projects = append(projects, cfg)
+			}
+
+			p := &Provisioner{Clients: clients, ProjectConcurrency: tt.projects, ResourceConcurrency: tt.resources}
+			if _, err := p.Create(context.Background(), projects); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			switch {
+			case createdTopics != topics || createdSubs != subs:
+				t.Errorf("expected %d topic(s) and %d subscription(s), created %d and %d", topics, subs, createdTopics, createdSubs)
+			case maxProjects != tt.wantProjects:
+				t.Errorf("expected at most %d concurrent project(s), got %d", tt.wantProjects, maxProjects)
+			case maxResources != tt.wantResources:
+				t.Errorf("expected at most %d concurrent resource(s) per project, got %d", tt.wantResources, maxResources)
+			}
+		})
+	}
+}
+
+func TestCreateSubscriptionRetention(t *testing.T) {
+	const week = 7 * 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		created string
+		config  string
+		// retention and retainAcked are the live settings afterwards.
+		retention   time.Duration
+		retainAcked bool
+	}{
+		{name: "defaults", config: "project1,audit:audit-replay", retention: week},
+		{name: "retention only", config: "project1,audit:audit-replay;retain=12h", retention: 12 * time.Hour},
+		{name: "retain acked only", config: "project1,audit:audit-replay;retainacked", retention: week, retainAcked: true},
+		{name: "both", config: "project1,audit:audit-replay;retain=90m;retainacked", retention: 90 * time.Minute, retainAcked: true},
+		{
+			name:        "retain acked added",
+			created:     "project1,audit:audit-replay;retain=12h",
+			config:      "project1,audit:audit-replay;retain=12h;retainacked",
+			retention:   12 * time.Hour,
+			retainAcked: true,
+		},
+		{
+			name:        "retention reset to the default",
+			created:     "project1,audit:audit-replay;retain=3h;retainacked",
+			config:      "project1,audit:audit-replay;retainacked",
+			retention:   week,
+			retainAcked: true,
+		},
+		{
+			name:      "retain acked removed",
+			created:   "project1,audit:audit-replay;retain=3h;retainacked",
+			config:    "project1,audit:audit-replay;retain=3h",
+			retention: 3 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			if tt.created != "" {
+				if err := create(t, p, tt.created); err != nil {
+					t.Fatal(err)
+				}
+				p.Ensure = true
+			}
+
+			if err := create(t, p, tt.config); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := client.Subscription("audit-replay").Config(context.Background())
+			switch {
+			case err != nil:
+				t.Fatal(err)
+			case cfg.RetentionDuration != tt.retention:
+				t.Errorf("expected retention %s, got %s", tt.retention, cfg.RetentionDuration)
+			case cfg.RetainAckedMessages != tt.retainAcked:
+				t.Errorf("expected retain acked messages to be %t, got %t", tt.retainAcked, cfg.RetainAckedMessages)
+			}
+
+			// The live subscription is in sync with the config.
+			desired, err := (&Parser{}).Parse(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			live, err := Live(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, change := range Diff(desired, live) {
+				if change.Action != ActionNoop {
+					t.Errorf("expected no changes, got %s", change)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateSkippedAndUpdated(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		config   string
+		setup    func(p *Provisioner)
+		// created, updated and skipped are the IDs of the resources of
+		// every kind.
+		created, updated, skipped []string
+	}{
+		{
+			name:     "ensure unchanged",
+			existing: "project1,metrics:metrics-rollup;ack=30s",
+			config:   "project1,metrics:metrics-rollup;ack=30s",
+			setup:    func(p *Provisioner) { p.Ensure = true },
+			skipped:  []string{"metrics", "metrics-rollup"},
+		},
+		{
+			name:     "ensure changed and new",
+			existing: "project1,metrics:metrics-rollup;ack=30s,alerts",
+			config:   "project1,metrics:metrics-rollup;ack=60s:metrics-export,alerts[retain=1h]:alerts-pager",
+			setup:    func(p *Provisioner) { p.Ensure = true },
+			created:  []string{"metrics-export", "alerts-pager"},
+			updated:  []string{"alerts", "metrics-rollup"},
+			skipped:  []string{"metrics"},
+		},
+		{
+			name:     "skip existing topics",
+			existing: "project1,traces",
+			config:   "project1,traces:traces-sampler,spans",
+			setup:    func(p *Provisioner) { p.SkipExistingTopics = true },
+			created:  []string{"spans", "traces-sampler"},
+			skipped:  []string{"traces"},
+		},
+		{
+			name:     "skip existing subscriptions",
+			existing: "project1,traces:traces-sampler",
+			config:   "project1,traces:traces-sampler:traces-indexer",
+			setup:    func(p *Provisioner) { p.SkipExistingTopics, p.SkipExistingSubscriptions = true, true },
+			created:  []string{"traces-indexer"},
+			skipped:  []string{"traces", "traces-sampler"},
+		},
+		{
+			name:     "skip topics",
+			existing: "project1,logs,audit",
+			config:   "project1,logs:logs-shipper,audit",
+			setup:    func(p *Provisioner) { p.SkipTopics = true },
+			created:  []string{"logs-shipper"},
+			skipped:  []string{"audit", "logs"},
+		},
+		{
+			name:    "nothing existed",
+			config:  "project1,logs:logs-shipper",
+			setup:   func(p *Provisioner) { p.Ensure = true },
+			created: []string{"logs", "logs-shipper"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t)
+			if tt.existing != "" {
+				if err := create(t, p, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var (
+				mu               sync.Mutex
+				updated, skipped []string
+			)
+			p.OnUpdate = func(r Resource) {
+				mu.Lock()
+				defer mu.Unlock()
+				updated = append(updated, resourceID(r))
+			}
+			p.OnSkip = func(r Resource) {
+				mu.Lock()
+				defer mu.Unlock()
+				skipped = append(skipped, resourceID(r))
+			}
+			tt.setup(p)
+
+			cfg, err := (&Parser{}).Parse(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resources, err := p.Create(context.Background(), []Config{cfg})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var created []string
+			for _, r := range resources {
+				created = append(created, resourceID(r))
+			}
+
+			for _, ids := range [][]string{created, updated, skipped, tt.created, tt.updated, tt.skipped} {
+				slices.Sort(ids)
+			}
+			switch {
+			case !slices.Equal(created, tt.created):
+				t.Errorf("expected created %q, got %q", tt.created, created)
+			case !slices.Equal(updated, tt.updated):
+				t.Errorf("expected updated %q, got %q", tt.updated, updated)
+			case !slices.Equal(skipped, tt.skipped):
+				t.Errorf("expected skipped %q, got %q", tt.skipped, skipped)
+			}
+		})
+	}
+}
+
+// resourceID returns the ID of the resource, without its project.
+func resourceID(r Resource) string {
+	return r.Name[strings.LastIndex(r.Name, "/")+1:]
+}
+
+func TestCreateCrossProject(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is created before the definitions.
+		existing    string
+		definitions []string
+		// want maps the subscriptions that must exist to their topic.
+		want map[string]string
+		err  string
+	}{
+		{
+			name:        "topic in the core project",
+			existing:    "core,events",
+			definitions: []string{"team-search,projects/core/topics/events:events-indexer"},
+			want:        map[string]string{"projects/team-search/subscriptions/events-indexer": "projects/core/topics/events"},
+		},
+		{
+			name:        "core project in the same run",
+			definitions: []string{"team-ads,projects/core/topics/impressions:impressions-billing", "core,impressions"},
+			want:        map[string]string{"projects/team-ads/subscriptions/impressions-billing": "projects/core/topics/impressions"},
+		},
+		{
+			name:     "several teams and a local topic",
+			existing: "core,events",
+			definitions: []string{
+				"team-search,projects/core/topics/events:events-indexer,queries:queries-log",
+				"team-ads,projects/core/topics/events:events-attribution",
+			},
+			want: map[string]string{
+				"projects/team-search/subscriptions/events-indexer":  "projects/core/topics/events",
+				"projects/team-search/subscriptions/queries-log":     "projects/team-search/topics/queries",
+				"projects/team-ads/subscriptions/events-attribution": "projects/core/topics/events",
+			},
+		},
+		{
+			name:        "missing topic",
+			existing:    "core,events",
+			definitions: []string{"team-search,projects/core/topics/clicks:clicks-ranker"},
+			err:         `Topic "clicks" does not exist in project "core"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t)
+			if tt.existing != "" {
+				if err := create(t, p, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var projects []Config
+			for _, definition := range tt.definitions {
+				cfg, err := (&Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				projects = append(projects, cfg)
+			}
+
+			ctx := context.Background()
+			created, err := p.Create(ctx, projects)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+				if len(created) != 0 {
+					t.Errorf("expected nothing to be created, got %v", created)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for name, topic := range tt.want {
+				projectID, id := strings.Split(name, "/")[1], strings.Split(name, "/")[3]
+				client, err := p.Clients.Client(ctx, projectID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				cfg, err := client.Subscription(id).Config(ctx)
+				if err != nil {
+					t.Errorf("%s: unable to get the config: %s", name, err)
+					continue
+				}
+				if got := cfg.Topic.String(); got != topic {
+					t.Errorf("%s: expected topic %s, got %s", name, topic, got)
+				}
+			}
+
+			// The topics in the core project are not created in the team
+			// projects.
+			topics := slices.Collect(maps.Values(tt.want))
+			for _, r := range created {
+				if r.Type == "topic" && r.Project != "core" && !slices.Contains(topics, r.Name) {
+					t.Errorf("unexpected topic %s", r.Name)
+				}
+			}
+
+			// Deleting the team projects leaves the core topics alone.
+			var teams []Config
+			for _, project := range projects {
+				if project.ProjectID != "core" {
+					teams = append(teams, project)
+				}
+			}
+			if err := p.Delete(ctx, teams); err != nil {
+				t.Fatalf("unable to delete: %s", err)
+			}
+
+			client, err := p.Clients.Client(ctx, "core")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, topic := range topics {
+				if !strings.HasPrefix(topic, "projects/core/") {
+					continue
+				}
+				if ok, err := client.Topic(strings.TrimPrefix(topic, "projects/core/topics/")).Exists(ctx); err != nil || !ok {
+					t.Errorf("expected %s to still exist, got %t (%v)", topic, ok, err)
+				}
+			}
+		})
+	}
+}
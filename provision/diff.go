@@ -0,0 +1,284 @@
+package provision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The actions that make a live resource match the config.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionNoop   = "no-op"
+)
+
+// Change describes the action that makes a live resource match the config.
+type Change struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+
+	// Detail describes the drift of a resource that needs an update.
+	Detail string `json:"detail,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%-6s %-12s %s", c.Action, c.Kind, c.Name)
+	if c.Detail != "" {
+		s += " (" + c.Detail + ")"
+	}
+
+	return s
+}
+
+// Diff compares the desired config of a project with its live state.
+// The changes are ordered like the config, followed by the deletions of the
+// live resources that are not configured in name order.
+func Diff(desired, live Config) []Change {
+	var changes []Change
+
+	liveTopics := make(map[string]Topic)
+	liveSubscriptions := make(map[string]Subscription)
+	liveSubscriptionTopics := make(map[string]string)
+	for _, t := range live.Topics {
+		liveTopics[t.ID] = t
+		for _, s := range t.Subscriptions {
+			liveSubscriptions[s.ID] = s
+			liveSubscriptionTopics[s.ID] = t.ID
+		}
+	}
+
+	desiredTopics := make(map[string]bool)
+	desiredSubscriptions := make(map[string]bool)
+
+	for _, t := range desired.Topics {
+		desiredTopics[t.ID] = true
+
+		name := fmt.Sprintf("projects/%s/topics/%s", desired.ProjectID, t.ID)
+		switch lt, ok := liveTopics[t.ID]; {
+		case t.external():
+			// Topics in other projects are not managed with the project.
+		case !ok:
+			changes = append(changes, Change{Action: ActionCreate, Kind: "topic", Name: name})
+		case formatTopicOptions(lt) != formatTopicOptions(t):
+			changes = append(changes, Change{Action: ActionUpdate, Kind: "topic", Name: name, Detail: formatTopicOptions(lt) + " -> " + formatTopicOptions(t)})
+		default:
+			changes = append(changes, Change{Action: ActionNoop, Kind: "topic", Name: name})
+		}
+
+		for _, s := range t.Subscriptions {
+			if s.Disabled {
+				continue
+			}
+			desiredSubscriptions[s.ID] = true
+
+			name := fmt.Sprintf("projects/%s/subscriptions/%s", desired.ProjectID, s.ID)
+			desiredSub := normalizeSubscription(desired.ProjectID, s)
+			want := t.ID + ":" + formatSubscription(desiredSub)
+
+			ls, ok := liveSubscriptions[s.ID]
+			if !ok {
+				changes = append(changes, Change{Action: ActionCreate, Kind: "subscription", Name: name})
+				continue
+			}
+
+			liveSub := normalizeSubscription(desired.ProjectID, ls)
+			if have := liveSubscriptionTopics[s.ID] + ":" + formatSubscription(liveSub); have != want {
+				// The detail shows the references of secrets, or redacts them.
+				shown := desiredSub
+				shown.PushServiceAccountRef = s.PushServiceAccountRef
+				detail := liveSubscriptionTopics[s.ID] + ":" + formatSubscription(redactSubscription(liveSub)) + " -> " + t.ID + ":" + formatSubscription(redactSubscription(shown))
+				changes = append(changes, Change{Action: ActionUpdate, Kind: "subscription", Name: name, Detail: detail})
+			} else {
+				changes = append(changes, Change{Action: ActionNoop, Kind: "subscription", Name: name})
+			}
+		}
+	}
+
+	var deletions []Change
+	for id := range liveSubscriptions {
+		if !desiredSubscriptions[id] {
+			deletions = append(deletions, Change{Action: ActionDelete, Kind: "subscription", Name: fmt.Sprintf("projects/%s/subscriptions/%s", desired.ProjectID, id)})
+		}
+	}
+	for id, t := range liveTopics {
+		if !desiredTopics[id] && !t.external() {
+			deletions = append(deletions, Change{Action: ActionDelete, Kind: "topic", Name: fmt.Sprintf("projects/%s/topics/%s", desired.ProjectID, id)})
+		}
+	}
+
+	sort.Slice(deletions, func(i, j int) bool { return deletions[i].Name < deletions[j].Name })
+
+	return append(changes, deletions...)
+}
+
+// Changed returns the part of the desired config that differs from a previous
+// config of the same project: the topics that are new or whose options
+// changed, and the topics with subscriptions that are new or whose options
+// changed, with only those subscriptions. The seed messages of a topic are
+// only part of it if the topic is new or its seed options changed. Resources
+// that are no longer configured are not part of it.
+func Changed(previous, desired Config) Config {
+	previousTopics := make(map[string]Topic)
+	previousSubscriptions := make(map[string]string)
+	for _, t := range previous.Topics {
+		previousTopics[t.ID] = t
+		for _, s := range t.Subscriptions {
+			previousSubscriptions[s.ID] = t.ID + ":" + formatSubscription(s)
+		}
+	}
+
+	changed := Config{ProjectID: desired.ProjectID}
+	for _, t := range desired.Topics {
+		pt, ok := previousTopics[t.ID]
+		topicChanged := !ok || strings.Join(allTopicOptions(pt), ",") != strings.Join(allTopicOptions(t), ",")
+
+		if ok && strings.Join(seedOptions(pt), ",") == strings.Join(seedOptions(t), ",") {
+			t.SeedFile, t.SeedCSVFile = "", ""
+		}
+
+		var subscriptions []Subscription
+		for _, s := range t.Subscriptions {
+			if previousSubscriptions[s.ID] != t.ID+":"+formatSubscription(s) {
+				subscriptions = append(subscriptions, s)
+			}
+		}
+
+		if topicChanged || len(subscriptions) > 0 {
+			t.Subscriptions = subscriptions
+			changed.Topics = append(changed.Topics, t)
+		}
+	}
+
+	return changed
+}
+
+// formatTopicOptions formats the options that configure the topic itself.
+func formatTopicOptions(t Topic) string {
+	return "[" + strings.Join(topicOptions(t), ",") + "]"
+}
+
+// normalizeSubscription strips the options of a subscription that are not
+// stored by the PubSub service or that can't be read back, and replaces
+// explicit defaults by their zero values, so that it can be compared to a
+// live subscription.
+func normalizeSubscription(projectID string, s Subscription) Subscription {
+	s.Disabled = false
+	s.NoExpiration = false
+	s.TransformFile = ""
+	s.IAMFile = ""
+	s.DeadLetterSubscription = ""
+	s.PushServiceAccountRef = ""
+	s.DeadLetterSubscriptionRetention = 0
+	s.DeadLetterSubscriptionNoExpiration = false
+	s.Labels = nil
+	s.MinExtensionPeriod = 0
+	s.MaxExtensionPeriod = 0
+
+	if s.AckDeadline == 10*time.Second {
+		s.AckDeadline = 0
+	}
+	if s.Retention == defaultSubscriptionRetention {
+		s.Retention = 0
+	}
+
+	if s.DeadLetterTopic != "" {
+		if p, t := splitTopicName(projectID, s.DeadLetterTopic); p == projectID {
+			s.DeadLetterTopic = t
+		}
+
+		// The PubSub service defaults to 5 delivery attempts.
+		if s.MaxDeliveryAttempts == 0 {
+			s.MaxDeliveryAttempts = 5
+		}
+	}
+
+	return s
+}
+
+// Plan is the structured form of the resolved config of a project, with the
+// defaults and expansions applied, and of the changes that make the live
+// state of the project match it.
+type Plan struct {
+	ProjectID string      `json:"project"`
+	Topics    []PlanTopic `json:"topics"`
+	Changes   []Change    `json:"changes,omitempty"`
+}
+
+// PlanTopic is a topic of a Plan, with its options by key.
+type PlanTopic struct {
+	ID            string             `json:"id"`
+	Options       map[string]string  `json:"options"`
+	Subscriptions []PlanSubscription `json:"subscriptions"`
+}
+
+// PlanSubscription is a subscription of a PlanTopic, with its options by key.
+type PlanSubscription struct {
+	ID      string            `json:"id"`
+	Options map[string]string `json:"options"`
+}
+
+// NewPlan returns the plan that makes the live state of a project match its
+// desired config.
+func NewPlan(desired, live Config) Plan {
+	plan := Describe(desired)
+	plan.Changes = Diff(desired, live)
+
+	return plan
+}
+
+// Describe returns the structured form of a config, without any changes.
+func Describe(cfg Config) Plan {
+	plan := Plan{
+		ProjectID: cfg.ProjectID,
+		Topics:    make([]PlanTopic, 0, len(cfg.Topics)),
+	}
+
+	for _, t := range cfg.Topics {
+		topic := PlanTopic{
+			ID:            t.ID,
+			Options:       optionMap(allTopicOptions(t)),
+			Subscriptions: make([]PlanSubscription, 0, len(t.Subscriptions)),
+		}
+
+		for _, s := range t.Subscriptions {
+			// The PubSub service defaults the ack deadline to 10 seconds,
+			// and the expiration to 31 days.
+			if s.AckDeadline == 0 {
+				s.AckDeadline = 10 * time.Second
+			}
+			if s.Expiration == 0 && !s.NoExpiration {
+				s.Expiration = 31 * 24 * time.Hour
+			}
+
+			topic.Subscriptions = append(topic.Subscriptions, PlanSubscription{ID: s.ID, Options: optionMap(subscriptionOptions(redactSubscription(s)))})
+		}
+
+		plan.Topics = append(plan.Topics, topic)
+	}
+
+	return plan
+}
+
+// optionMap returns the values of the options by their key. Options without a
+// value, like "ordered", have the value "true".
+func optionMap(options []string) map[string]string {
+	m := make(map[string]string, len(options))
+	for _, option := range options {
+		key, val, ok := strings.Cut(option, "=")
+		if !ok {
+			val = "true"
+		}
+
+		if unquoted, err := unquote(val); err == nil {
+			val = unquoted
+		}
+
+		m[key] = val
+	}
+
+	return m
+}
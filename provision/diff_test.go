@@ -0,0 +1,305 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		desired string
+		live    string
+		want    []string
+	}{
+		{
+			name:    "in sync",
+			desired: "shop,carts:carts-sync;ack=30s",
+			live:    "shop,carts:carts-sync;ack=30s",
+			want:    []string{"no-op topic projects/shop/topics/carts", "no-op subscription projects/shop/subscriptions/carts-sync"},
+		},
+		{
+			name:    "missing",
+			desired: "shop,carts:carts-sync,wishlists",
+			live:    "shop,carts",
+			want:    []string{"no-op topic projects/shop/topics/carts", "create subscription projects/shop/subscriptions/carts-sync", "create topic projects/shop/topics/wishlists"},
+		},
+		{
+			name:    "drifted",
+			desired: "shop,carts[retain=2h]:carts-sync;ack=60s",
+			live:    "shop,carts[retain=1h]:carts-sync;ack=30s",
+			want:    []string{"update topic projects/shop/topics/carts", "update subscription projects/shop/subscriptions/carts-sync"},
+		},
+		{
+			name:    "moved to another topic",
+			desired: "shop,carts,orders:carts-sync",
+			live:    "shop,carts:carts-sync,orders",
+			want:    []string{"no-op topic projects/shop/topics/carts", "no-op topic projects/shop/topics/orders", "update subscription projects/shop/subscriptions/carts-sync"},
+		},
+		{
+			name:    "not configured",
+			desired: "shop,carts",
+			live:    "shop,carts:carts-sync:carts-audit,wishlists",
+			want:    []string{"no-op topic projects/shop/topics/carts", "delete subscription projects/shop/subscriptions/carts-audit", "delete subscription projects/shop/subscriptions/carts-sync", "delete topic projects/shop/topics/wishlists"},
+		},
+		{
+			name:    "topic in another project",
+			desired: "shop,projects/core/topics/prices:prices-shop,carts",
+			live:    "shop,projects/core/topics/prices:prices-shop;ack=20s",
+			want:    []string{"update subscription projects/shop/subscriptions/prices-shop", "create topic projects/shop/topics/carts"},
+		},
+		{
+			name:    "subscription on another project not configured",
+			desired: "shop,carts",
+			live:    "shop,carts,projects/core/topics/prices:prices-shop",
+			want:    []string{"no-op topic projects/shop/topics/carts", "delete subscription projects/shop/subscriptions/prices-shop"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired, err := (&Parser{}).Parse(tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			live, err := (&Parser{}).Parse(tt.live)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, change := range Diff(desired, live) {
+				got = append(got, change.Action+" "+change.Kind+" "+change.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected changes\n%q\ngot\n%q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDiffLive(t *testing.T) {
+	tests := []struct {
+		name    string
+		created string
+		desired string
+		want    []string
+	}{
+		{
+			name:    "created as configured",
+			created: "project1,returns[retain=3h]:returns-refunder;ack=45s;ordered",
+			desired: "project1,returns[retain=3h]:returns-refunder;ack=45s;ordered",
+			want:    []string{"no-op topic projects/project1/topics/returns", "no-op subscription projects/project1/subscriptions/returns-refunder"},
+		},
+		{
+			name:    "ack drifted",
+			created: "project1,returns:returns-refunder;ack=45s",
+			desired: "project1,returns:returns-refunder;ack=90s",
+			want:    []string{"no-op topic projects/project1/topics/returns", "update subscription projects/project1/subscriptions/returns-refunder (returns:returns-refunder;ack=45s -> returns:returns-refunder;ack=1m30s)"},
+		},
+		{
+			// The dead-letter subscription options only apply when the
+			// dead-letter subscription is created.
+			name:    "dead-letter subscription options",
+			created: "project1,returns:returns-refunder;dlq=returns-dead;dlqsub=returns-inspect;dlqsubretain=7d;dlqsubnoexpire",
+			desired: "project1,returns:returns-refunder;dlq=returns-dead;dlqsub=returns-inspect;dlqsubretain=2d",
+			want:    []string{"no-op topic projects/project1/topics/returns", "no-op subscription projects/project1/subscriptions/returns-refunder", "delete subscription projects/project1/subscriptions/returns-inspect", "delete topic projects/project1/topics/returns-dead"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			if err := create(t, p, tt.created); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			desired, err := (&Parser{}).Parse(tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			live, err := Live(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, change := range Diff(desired, live) {
+				got = append(got, strings.Join(strings.Fields(change.String()), " "))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected changes\n%q\ngot\n%q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewPlanJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		created  string
+		defaults []string
+		desired  string
+		want     string
+	}{
+		{
+			name:     "defaults and expansions",
+			defaults: []string{"ack=30s"},
+			desired:  `project1,slot-[1-2]:slot-[1-2]-reader;ack=60s,shipments[retain=2h]:shipments-notifier;ordered;expire=never;push="https://hooks.example.com/s"`,
+			want: `{"project":"project1","topics":[
+				{"id":"slot-1","options":{},"subscriptions":[{"id":"slot-1-reader","options":{"ack":"1m0s","expire":"744h0m0s"}}]},
+				{"id":"slot-2","options":{},"subscriptions":[{"id":"slot-2-reader","options":{"ack":"1m0s","expire":"744h0m0s"}}]},
+				{"id":"shipments","options":{"retain":"2h0m0s"},"subscriptions":[{"id":"shipments-notifier","options":{"ack":"30s","expire":"never","ordered":"true","push":"https://hooks.example.com/s"}}]}
+			],"changes":[
+				{"action":"create","kind":"topic","name":"projects/project1/topics/slot-1"},
+				{"action":"create","kind":"subscription","name":"projects/project1/subscriptions/slot-1-reader"},
+				{"action":"create","kind":"topic","name":"projects/project1/topics/slot-2"},
+				{"action":"create","kind":"subscription","name":"projects/project1/subscriptions/slot-2-reader"},
+				{"action":"create","kind":"topic","name":"projects/project1/topics/shipments"},
+				{"action":"create","kind":"subscription","name":"projects/project1/subscriptions/shipments-notifier"}
+			]}`,
+		},
+		{
+			name:    "drifted",
+			created: "project1,shipments:shipments-tracker;ack=20s",
+			desired: "project1,shipments[retain=2h]:shipments-tracker;ack=30s",
+			want: `{"project":"project1","topics":[
+				{"id":"shipments","options":{"retain":"2h0m0s"},"subscriptions":[{"id":"shipments-tracker","options":{"ack":"30s","expire":"744h0m0s"}}]}
+			],"changes":[
+				{"action":"update","kind":"topic","name":"projects/project1/topics/shipments","detail":"[] -> [retain=2h0m0s]"},
+				{"action":"update","kind":"subscription","name":"projects/project1/subscriptions/shipments-tracker","detail":"shipments:shipments-tracker;ack=20s -> shipments:shipments-tracker;ack=30s"}
+			]}`,
+		},
+		{
+			name:    "in sync",
+			created: "project1,customs:customs-broker;exactlyonce",
+			desired: "project1,customs:customs-broker;exactlyonce",
+			want: `{"project":"project1","topics":[
+				{"id":"customs","options":{},"subscriptions":[{"id":"customs-broker","options":{"ack":"10s","exactlyonce":"true","expire":"744h0m0s"}}]}
+			],"changes":[
+				{"action":"no-op","kind":"topic","name":"projects/project1/topics/customs"},
+				{"action":"no-op","kind":"subscription","name":"projects/project1/subscriptions/customs-broker"}
+			]}`,
+		},
+		{
+			name:    "no topics",
+			desired: "project1",
+			want:    `{"project":"project1","topics":[]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			if tt.created != "" {
+				if err := create(t, p, tt.created); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			desired, err := (&Parser{SubscriptionDefaults: tt.defaults, AllowEmpty: true}).Parse(tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			live, err := Live(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := json.Marshal(NewPlan(desired, live))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got, want interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("invalid JSON %s: %s", tt.want, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %s, got %s", tt.want, b)
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		desired  string
+		want     string
+	}{
+		{
+			name:     "unchanged",
+			previous: "studio,renders:renders-preview:renders-archive,uploads",
+			desired:  "studio,renders:renders-preview:renders-archive,uploads",
+			want:     "studio",
+		},
+		{
+			name:     "single subscription edited",
+			previous: "studio,renders:renders-preview;ack=20s:renders-archive,uploads:uploads-scan",
+			desired:  "studio,renders:renders-preview;ack=40s:renders-archive,uploads:uploads-scan",
+			want:     "studio,renders:renders-preview;ack=40s",
+		},
+		{
+			name:     "new topic and subscription",
+			previous: "studio,renders:renders-preview",
+			desired:  "studio,renders:renders-preview:renders-thumbs,uploads:uploads-scan",
+			want:     "studio,renders:renders-thumbs,uploads:uploads-scan",
+		},
+		{
+			name:     "topic options changed",
+			previous: "studio,renders[retain=1h]:renders-preview",
+			desired:  "studio,renders[retain=6h]:renders-preview",
+			want:     "studio,renders[retain=6h0m0s]",
+		},
+		{
+			name:     "seeds are not published again",
+			previous: "studio,renders[seed=renders.jsonl]:renders-preview;ack=20s",
+			desired:  "studio,renders[seed=renders.jsonl]:renders-preview;ack=40s",
+			want:     "studio,renders:renders-preview;ack=40s",
+		},
+		{
+			name:     "seed file changed",
+			previous: "studio,renders[seed=renders.jsonl]",
+			desired:  "studio,renders[seed=renders-v2.jsonl]",
+			want:     "studio,renders[seed=renders-v2.jsonl]",
+		},
+		{
+			name:     "subscription moved to another topic",
+			previous: "studio,renders:renders-preview,uploads",
+			desired:  "studio,renders,uploads:renders-preview",
+			want:     "studio,uploads:renders-preview",
+		},
+		{
+			name:     "removed resources",
+			previous: "studio,renders:renders-preview:renders-archive,uploads",
+			desired:  "studio,renders:renders-preview",
+			want:     "studio",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous, err := (&Parser{}).Parse(tt.previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desired, err := (&Parser{}).Parse(tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := FormatConfig(Changed(previous, desired)); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package provision
+
+import "time"
+
+// Event describes an action of a Provisioner on a resource, as it happens.
+// The action is "create", "update", "skip" or "delete".
+type Event struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Resource
+}
+
+// EventLogger is a Logger that also receives the Event of every action on a
+// resource. Event may be called concurrently.
+type EventLogger interface {
+	Logger
+	Event(e Event)
+}
+
+// event reports an action on a resource to the Logger, if it is an
+// EventLogger.
+func (p *Provisioner) event(action string, r Resource) {
+	if l, ok := p.Logger.(EventLogger); ok {
+		l.Event(Event{Time: time.Now().UTC(), Action: action, Resource: r})
+	}
+}
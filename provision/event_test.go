@@ -0,0 +1,112 @@
+package provision
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// eventRecorder is a testLogger that records the events it receives.
+type eventRecorder struct {
+	testLogger
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventRecorder) Event(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, e)
+}
+
+func TestEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		config   string
+		ensure   bool
+		delete   bool
+		// want are the events as "action name", sorted.
+		want []string
+	}{
+		{
+			name:   "one event per created resource",
+			config: "project1,invoices:invoices-pdf:invoices-mailer,refunds",
+			want: []string{
+				"create projects/project1/subscriptions/invoices-mailer",
+				"create projects/project1/subscriptions/invoices-pdf",
+				"create projects/project1/topics/invoices",
+				"create projects/project1/topics/refunds",
+			},
+		},
+		{
+			name:     "ensure",
+			existing: "project1,payouts:payouts-ledger;ack=20s,chargebacks",
+			config:   "project1,payouts:payouts-ledger;ack=45s,chargebacks:chargebacks-review",
+			ensure:   true,
+			want: []string{
+				"create projects/project1/subscriptions/chargebacks-review",
+				"skip projects/project1/topics/chargebacks",
+				"skip projects/project1/topics/payouts",
+				"update projects/project1/subscriptions/payouts-ledger",
+			},
+		},
+		{
+			name:     "delete only existing resources",
+			existing: "project1,receipts:receipts-archive",
+			config:   "project1,receipts:receipts-archive,disputes",
+			delete:   true,
+			want: []string{
+				"delete projects/project1/subscriptions/receipts-archive",
+				"delete projects/project1/topics/receipts",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t)
+			if tt.existing != "" {
+				if err := create(t, p, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			logger := &eventRecorder{}
+			p.Logger, p.Ensure = logger, tt.ensure
+
+			var err error
+			if tt.delete {
+				cfg, perr := (&Parser{}).Parse(tt.config)
+				if perr != nil {
+					t.Fatal(perr)
+				}
+				err = p.Delete(context.Background(), []Config{cfg})
+			} else {
+				err = create(t, p, tt.config)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var got []string
+			for _, e := range logger.events {
+				if e.Time.IsZero() || e.Time.Location().String() != "UTC" {
+					t.Errorf("%s %s: expected a UTC time, got %v", e.Action, e.Name, e.Time)
+				}
+				if e.Project != "project1" {
+					t.Errorf("%s %s: expected project project1, got %q", e.Action, e.Name, e.Project)
+				}
+				got = append(got, e.Action+" "+e.Name)
+			}
+			slices.Sort(got)
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the events %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
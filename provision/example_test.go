@@ -0,0 +1,48 @@
+package provision_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func ExampleProvisioner_Create() {
+	ctx := context.Background()
+
+	srv := pstest.NewServer()
+	defer srv.Close()
+
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clients := &provision.ClientCache{Options: []option.ClientOption{option.WithGRPCConn(conn)}}
+	defer clients.Close()
+
+	cfg, err := (&provision.Parser{}).Parse("test,orders:orders-worker;ack=30s,refunds")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := &provision.Provisioner{Clients: clients}
+	created, err := p.Create(ctx, []provision.Config{cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, r := range created {
+		fmt.Println(r.Type, r.Name)
+	}
+
+	// Output:
+	// topic projects/test/topics/orders
+	// topic projects/test/topics/refunds
+	// subscription projects/test/subscriptions/orders-worker
+}
@@ -0,0 +1,106 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+)
+
+// Failure is a topic or subscription that Create failed to create with
+// ContinueOnError.
+type Failure struct {
+	Resource
+	Err error
+
+	// TimedOut is set if the last attempt to create the resource exceeded
+	// the OpTimeout.
+	TimedOut bool
+}
+
+// PartialError is the error of a Create with ContinueOnError that failed to
+// create some of the resources. The others were created.
+type PartialError struct {
+	Failures []Failure
+}
+
+func (e *PartialError) Error() string {
+	timedOut := e.TimedOut()
+	return fmt.Sprintf("Unable to create %d resource(s): %d timed out and %d failed otherwise", len(e.Failures), timedOut, len(e.Failures)-timedOut)
+}
+
+// TimedOut returns the number of failures that timed out.
+func (e *PartialError) TimedOut() int {
+	var n int
+	for _, f := range e.Failures {
+		if f.TimedOut {
+			n++
+		}
+	}
+
+	return n
+}
+
+// ResourceError is the error of a Create without ContinueOnError that stopped
+// at the first topic or subscription that it failed to create.
+type ResourceError struct {
+	Failure
+}
+
+func (e *ResourceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResourceError) Unwrap() error {
+	return e.Err
+}
+
+// stepKey is the context key of the name of the step that an operation is
+// part of.
+type stepKey struct{}
+
+// withStep returns a context for the operations of the step, whose timeouts
+// are tracked.
+func withStep(ctx context.Context, s step) context.Context {
+	return context.WithValue(ctx, stepKey{}, s.name())
+}
+
+// trackTimeout records whether the last operation of the step of the context
+// timed out.
+func (c *creator) trackTimeout(ctx context.Context, err error) {
+	name, ok := ctx.Value(stepKey{}).(string)
+	if !ok {
+		return
+	}
+
+	_, timedOut := err.(*timeoutError)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timedOut == nil {
+		c.timedOut = make(map[string]bool)
+	}
+	c.timedOut[name] = timedOut
+}
+
+// failure returns the failure of a step.
+func (c *creator) failure(s step, err error) Failure {
+	r := topicResource(s.project.ProjectID, s.topic.ID)
+	if s.subscription != nil {
+		r = subscriptionResource(s.project.ProjectID, s.subscription.ID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Failure{Resource: r, Err: err, TimedOut: c.timedOut[s.name()]}
+}
+
+// fail records the failure of a step with ContinueOnError.
+func (c *creator) fail(s step, err error) {
+	f := c.failure(s, err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures = append(c.failures, f)
+}
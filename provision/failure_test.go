@@ -0,0 +1,184 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateContinueOnError(t *testing.T) {
+	const definition = "plant,sensors:sensors-log,cameras:cameras-feed,doors:doors-audit"
+
+	// outcome is what happens to an attempt to create a resource.
+	type outcome int
+	const (
+		succeed outcome = iota
+		hang
+		fail
+	)
+
+	tests := []struct {
+		name            string
+		continueOnError bool
+		retries         int
+		// attempts are the outcomes of the attempts to create the resources
+		// by their fully qualified name. Further attempts succeed.
+		attempts map[string][]outcome
+		created  []string
+		// failures are the failed resources as "name timed-out|failed".
+		failures []string
+		err      string
+	}{
+		{
+			name:            "timeouts and failures",
+			continueOnError: true,
+			attempts: map[string][]outcome{
+				"projects/plant/topics/cameras":            {hang},
+				"projects/plant/subscriptions/doors-audit": {fail},
+			},
+			created: []string{"projects/plant/subscriptions/sensors-log", "projects/plant/topics/doors", "projects/plant/topics/sensors"},
+			failures: []string{
+				"projects/plant/subscriptions/cameras-feed failed",
+				"projects/plant/subscriptions/doors-audit failed",
+				"projects/plant/topics/cameras timed-out",
+			},
+			err: "Unable to create 3 resource(s): 1 timed out and 2 failed otherwise",
+		},
+		{
+			name:            "timed out subscription",
+			continueOnError: true,
+			attempts:        map[string][]outcome{"projects/plant/subscriptions/sensors-log": {hang}},
+			created:         []string{"projects/plant/subscriptions/cameras-feed", "projects/plant/subscriptions/doors-audit", "projects/plant/topics/cameras", "projects/plant/topics/doors", "projects/plant/topics/sensors"},
+			failures:        []string{"projects/plant/subscriptions/sensors-log timed-out"},
+			err:             "Unable to create 1 resource(s): 1 timed out and 0 failed otherwise",
+		},
+		{
+			name:            "recovered after a timeout",
+			continueOnError: true,
+			retries:         1,
+			attempts:        map[string][]outcome{"projects/plant/topics/cameras": {hang}},
+			created:         []string{"projects/plant/subscriptions/cameras-feed", "projects/plant/subscriptions/doors-audit", "projects/plant/subscriptions/sensors-log", "projects/plant/topics/cameras", "projects/plant/topics/doors", "projects/plant/topics/sensors"},
+		},
+		{
+			name:            "failed after a timeout",
+			continueOnError: true,
+			retries:         1,
+			attempts:        map[string][]outcome{"projects/plant/topics/doors": {hang, fail}},
+			created:         []string{"projects/plant/subscriptions/cameras-feed", "projects/plant/subscriptions/sensors-log", "projects/plant/topics/cameras", "projects/plant/topics/sensors"},
+			failures:        []string{"projects/plant/subscriptions/doors-audit failed", "projects/plant/topics/doors failed"},
+			err:             "Unable to create 2 resource(s): 0 timed out and 2 failed otherwise",
+		},
+		{
+			name:     "stops at the first error by default",
+			attempts: map[string][]outcome{"projects/plant/topics/cameras": {hang}},
+			created:  []string{"projects/plant/topics/sensors"},
+			err:      `Unable to create topic "cameras" for project "plant": operation timed out`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				attempts = make(map[string]int)
+			)
+
+			// The interceptor hangs until the operation times out, or fails,
+			// for the attempts of the test.
+			interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				var name string
+				switch req := req.(type) {
+				case *pubsubpb.Topic:
+					name = req.Name
+				case *pubsubpb.Subscription:
+					name = req.Name
+				}
+
+				mu.Lock()
+				n := attempts[name]
+				attempts[name]++
+				mu.Unlock()
+
+				if n < len(tt.attempts[name]) {
+					switch tt.attempts[name][n] {
+					case hang:
+						<-ctx.Done()
+						return status.FromContextError(ctx.Err()).Err()
+					case fail:
+						return status.Error(codes.PermissionDenied, "not allowed")
+					}
+				}
+
+				return invoker(ctx, method, req, reply, cc, opts...)
+			}
+
+			srv := pstest.NewServer()
+			t.Cleanup(func() { srv.Close() })
+			t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+			clients := &ClientCache{ShareConnection: true, DialOptions: []grpc.DialOption{grpc.WithUnaryInterceptor(interceptor)}}
+			t.Cleanup(func() { clients.Close() })
+
+			p := &Provisioner{
+				Clients:         clients,
+				OpTimeout:       100 * time.Millisecond,
+				Retries:         tt.retries,
+				Backoff:         ConstantBackoff{Base: time.Millisecond},
+				ContinueOnError: tt.continueOnError,
+			}
+
+			cfg, err := (&Parser{}).Parse(definition)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			created, err := p.Create(context.Background(), []Config{cfg})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Fatalf("expected an error starting with %q, got %v", tt.err, err)
+			}
+
+			var names []string
+			for _, r := range created {
+				names = append(names, r.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.created) {
+				t.Errorf("expected the created resources %q, got %q", tt.created, names)
+			}
+
+			var partial *PartialError
+			if errors.As(err, &partial) != (tt.failures != nil) {
+				t.Fatalf("expected a partial error to be %t, got %v", tt.failures != nil, err)
+			}
+			if partial == nil {
+				return
+			}
+
+			var failures []string
+			for _, f := range partial.Failures {
+				kind := "failed"
+				if f.TimedOut {
+					kind = "timed-out"
+				}
+				failures = append(failures, f.Name+" "+kind)
+			}
+			slices.Sort(failures)
+			if !slices.Equal(failures, tt.failures) {
+				t.Errorf("expected the failures %q, got %q", tt.failures, failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// The emulator only supports filters on message attributes:
+//
+//	filter  = term { "AND" term } | term { "OR" term }
+//	term    = [ "NOT" | "-" ] factor
+//	factor  = "(" filter ")"
+//	        | "hasPrefix" "(" attr "," string ")"
+//	        | "attributes" ":" key
+//	        | attr ( "=" | "!=" ) string
+//	attr    = "attributes" "." key
+//
+// AND and OR cannot be mixed without parentheses.
+
+// filterToken is a lexical token of a filter expression.
+type filterToken struct {
+	kind string // ident, string, op or eof
+	text string
+	pos  int
+}
+
+// filterParser validates a filter expression against the supported grammar.
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+
+	// attributes contains the attribute keys that the filter references.
+	attributes []string
+}
+
+// validateFilter checks that the filter only uses the syntax that the
+// emulator supports.
+func validateFilter(filter string) error {
+	_, err := parseFilter(filter)
+	return err
+}
+
+// parseFilter validates the filter and returns the attribute keys that it
+// references.
+func parseFilter(filter string) ([]string, error) {
+	tokens, err := lexFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	if err := p.expr(); err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q at position %d, expected AND, OR or the end of the filter", t.text, t.pos)
+	}
+
+	return p.attributes, nil
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+
+	return t
+}
+
+// expect consumes the next token if it has the specified text.
+func (p *filterParser) expect(text, what string) error {
+	if t := p.next(); t.text != text {
+		return unexpected(t, what)
+	}
+
+	return nil
+}
+
+func (p *filterParser) expr() error {
+	if err := p.term(); err != nil {
+		return err
+	}
+
+	var op string
+	for {
+		t := p.peek()
+		if t.kind != "ident" || (t.text != "AND" && t.text != "OR") {
+			return nil
+		}
+
+		if op != "" && t.text != op {
+			return fmt.Errorf("%s at position %d cannot be mixed with %s without parentheses", t.text, t.pos, op)
+		}
+		op = t.text
+
+		p.next()
+		if err := p.term(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *filterParser) term() error {
+	if t := p.peek(); t.text == "NOT" || t.text == "-" {
+		p.next()
+	}
+
+	return p.factor()
+}
+
+func (p *filterParser) factor() error {
+	t := p.next()
+
+	switch {
+	case t.text == "(":
+		if err := p.expr(); err != nil {
+			return err
+		}
+
+		return p.expect(")", "a closing parenthesis")
+
+	case t.text == "hasPrefix":
+		if err := p.expect("(", "an opening parenthesis"); err != nil {
+			return err
+		}
+		if err := p.expect("attributes", "attributes"); err != nil {
+			return err
+		}
+		if err := p.expect(".", "a dot"); err != nil {
+			return err
+		}
+		if err := p.key(); err != nil {
+			return err
+		}
+		if err := p.expect(",", "a comma"); err != nil {
+			return err
+		}
+		if t := p.next(); t.kind != "string" {
+			return unexpected(t, "a string")
+		}
+
+		return p.expect(")", "a closing parenthesis")
+
+	case t.text == "attributes":
+		switch op := p.next(); op.text {
+		case ":":
+			return p.key()
+
+		case ".":
+			if err := p.key(); err != nil {
+				return err
+			}
+
+			if op := p.next(); op.text != "=" && op.text != "!=" {
+				return unexpected(op, "= or !=")
+			}
+
+			if t := p.next(); t.kind != "string" {
+				return unexpected(t, "a string")
+			}
+
+			return nil
+
+		default:
+			return unexpected(op, "a dot or colon")
+		}
+
+	case t.kind == "ident":
+		return fmt.Errorf("unsupported field or function %q at position %d, only attributes and hasPrefix are supported", t.text, t.pos)
+	}
+
+	return unexpected(t, "an attribute expression")
+}
+
+func (p *filterParser) key() error {
+	t := p.next()
+	if t.kind != "ident" && t.kind != "string" {
+		return unexpected(t, "an attribute key")
+	}
+
+	p.attributes = append(p.attributes, t.text)
+	return nil
+}
+
+// unexpected returns an error for an unexpected token.
+func unexpected(t filterToken, what string) error {
+	if t.kind == "eof" {
+		return fmt.Errorf("unexpected end of the filter, expected %s", what)
+	}
+
+	return fmt.Errorf("unexpected %q at position %d, expected %s", t.text, t.pos, what)
+}
+
+// lexFilter splits a filter expression into tokens.
+func lexFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+
+		case c == '"':
+			var b strings.Builder
+
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+
+			tokens = append(tokens, filterToken{kind: "string", text: b.String(), pos: i})
+			i = j + 1
+
+		case c == '!' && i+1 < len(s) && s[i+1] == '=':
+			tokens = append(tokens, filterToken{kind: "op", text: "!=", pos: i})
+			i += 2
+
+		case strings.IndexByte("()=,.:-", c) >= 0:
+			tokens = append(tokens, filterToken{kind: "op", text: string(c), pos: i})
+			i++
+
+		case isIdentRune(rune(c)):
+			j := i
+			for j < len(s) && (isIdentRune(rune(s[j])) || s[j] == '-') {
+				j++
+			}
+
+			tokens = append(tokens, filterToken{kind: "ident", text: s[i:j], pos: i})
+			i = j
+
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+		}
+	}
+
+	return append(tokens, filterToken{kind: "eof", pos: len(s)}), nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,44 @@
+package provision
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter     string
+		attributes []string
+		err        string
+	}{
+		{filter: `attributes.country = "nl"`, attributes: []string{"country"}},
+		{filter: `attributes.country != "nl" AND attributes:priority`, attributes: []string{"country", "priority"}},
+		{filter: `NOT attributes:internal OR -attributes.env = "test"`, attributes: []string{"internal", "env"}},
+		{filter: `hasPrefix(attributes.path, "/api/") AND (attributes.a = "1" OR attributes.b = "2")`, attributes: []string{"path", "a", "b"}},
+		{filter: `attributes."content-type" = "json"`, attributes: []string{"content-type"}},
+		{filter: `attributes.note = "say \"hi\""`, attributes: []string{"note"}},
+		{filter: `attributes.a = "1" AND attributes.b = "2" OR attributes.c = "3"`, err: "OR at position 42 cannot be mixed with AND without parentheses"},
+		{filter: `data = "x"`, err: `unsupported field or function "data" at position 0, only attributes and hasPrefix are supported`},
+		{filter: `attributes.country = nl`, err: `unexpected "nl" at position 21, expected a string`},
+		{filter: `attributes.country > "nl"`, err: `unexpected '>' at position 19`},
+		{filter: `(attributes:vip`, err: "unexpected end of the filter, expected a closing parenthesis"},
+		{filter: `attributes.country = "nl`, err: "unterminated string at position 21"},
+		{filter: `attributes:vip attributes:gold`, err: `unexpected "attributes" at position 15, expected AND, OR or the end of the filter`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			attributes, err := parseFilter(tt.filter)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case !slices.Equal(attributes, tt.attributes):
+				t.Errorf("expected attributes %q, got %q", tt.attributes, attributes)
+			}
+		})
+	}
+}
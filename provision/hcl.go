@@ -0,0 +1,481 @@
+package provision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// ParseHCL parses the google_pubsub_topic and google_pubsub_subscription
+// resources of a Terraform file into a config per project. The project of a
+// resource defaults to the project of the google provider. Subscriptions can
+// refer to their topic by name, by ID, or with a reference like
+// google_pubsub_topic.orders.name. Other blocks, and the attributes that have
+// no equivalent option, are ignored.
+func (p *Parser) ParseHCL(filename string, src []byte) ([]Config, error) {
+	file, diags := hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("Unable to parse %s: %s", filename, diags.Error())
+	}
+
+	blocks := file.Body.(*hclsyntax.Body).Blocks
+	ctx := &hcl.EvalContext{}
+
+	var defaultProject string
+	for _, block := range blocks {
+		if block.Type == "provider" && len(block.Labels) == 1 && block.Labels[0] == "google" {
+			b := p.hclBlock(ctx, "Provider \"google\"", block.Body)
+			defaultProject = b.string("project")
+			if b.error() != nil {
+				return nil, b.error()
+			}
+		}
+	}
+
+	var (
+		projects []*Config
+		byID     = make(map[string]*Config)
+
+		// topics are the values that references to the topics evaluate to.
+		topics = make(map[string]cty.Value)
+	)
+
+	project := func(projectID string) *Config {
+		if cfg, ok := byID[projectID]; ok {
+			return cfg
+		}
+
+		cfg := &Config{ProjectID: projectID}
+		projects = append(projects, cfg)
+		byID[projectID] = cfg
+		return cfg
+	}
+
+	for _, block := range resources(blocks, "google_pubsub_topic") {
+		t, projectID, err := p.hclTopic(ctx, block, defaultProject)
+		if err != nil {
+			return nil, err
+		}
+
+		topics[block.Labels[1]] = cty.ObjectVal(map[string]cty.Value{
+			"name":    cty.StringVal(t.ID),
+			"project": cty.StringVal(projectID),
+			"id":      cty.StringVal(fmt.Sprintf("projects/%s/topics/%s", projectID, t.ID)),
+		})
+
+		cfg := project(projectID)
+		cfg.Topics = append(cfg.Topics, t)
+	}
+
+	if len(topics) > 0 {
+		ctx.Variables = map[string]cty.Value{"google_pubsub_topic": cty.ObjectVal(topics)}
+	}
+
+	for _, block := range resources(blocks, "google_pubsub_subscription") {
+		s, projectID, topicID, err := p.hclSubscription(ctx, block, defaultProject)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg, ok := byID[projectID]
+		index := -1
+		if ok {
+			for i, t := range cfg.Topics {
+				if t.ID == topicID {
+					index = i
+				}
+			}
+		}
+		switch {
+		case index < 0 && (Topic{ID: topicID}).external():
+			cfg = project(projectID)
+			index = len(cfg.Topics)
+			cfg.Topics = append(cfg.Topics, Topic{ID: topicID})
+		case index < 0:
+			return nil, fmt.Errorf("Subscription %q: topic %q is not defined in project %q", s.ID, topicID, projectID)
+		}
+
+		cfg.Topics[index].Subscriptions = append(cfg.Topics[index].Subscriptions, s)
+	}
+
+	configs := make([]Config, len(projects))
+	for i, cfg := range projects {
+		for _, t := range cfg.Topics {
+			p.checkOrderingRegions(t)
+		}
+
+		configs[i] = *cfg
+	}
+
+	return configs, nil
+}
+
+// resources returns the resource blocks of the specified type.
+func resources(blocks hclsyntax.Blocks, resourceType string) []*hclsyntax.Block {
+	var matched []*hclsyntax.Block
+	for _, block := range blocks {
+		if block.Type == "resource" && len(block.Labels) == 2 && block.Labels[0] == resourceType {
+			matched = append(matched, block)
+		}
+	}
+
+	return matched
+}
+
+// hclTopic converts a google_pubsub_topic resource to a topic.
+func (p *Parser) hclTopic(ctx *hcl.EvalContext, block *hclsyntax.Block, defaultProject string) (Topic, string, error) {
+	resource := fmt.Sprintf("Topic %q", block.Labels[1])
+	b := p.hclBlock(ctx, resource, block.Body)
+
+	t := Topic{
+		ID:         b.string("name"),
+		KMSKeyName: b.string("kms_key_name"),
+		Labels:     b.stringMap("labels"),
+	}
+	projectID := b.string("project")
+
+	if value := b.string("message_retention_duration"); value != "" {
+		t.Retention = b.duration("message_retention_duration", value, 10*time.Minute, 31*24*time.Hour)
+	}
+
+	if policy := b.block("message_storage_policy"); policy != nil {
+		t.Regions = policy.stringList("allowed_persistence_regions")
+	}
+
+	if settings := b.block("schema_settings"); settings != nil {
+		t.Schema = settings.string("schema")
+		t.SchemaEncoding = strings.ToLower(settings.string("encoding"))
+	}
+
+	b.warnUnused()
+	if b.error() != nil {
+		return Topic{}, "", b.error()
+	}
+
+	if t.ID == "" {
+		return Topic{}, "", fmt.Errorf("%s: name is required", resource)
+	}
+
+	if err := validateLabels(t.Labels); err != nil {
+		return Topic{}, "", fmt.Errorf("%s: labels: %s", resource, err)
+	}
+
+	if t.SchemaEncoding == "encoding_unspecified" {
+		t.SchemaEncoding = ""
+	}
+	if _, ok := schemaEncodings[t.SchemaEncoding]; !ok {
+		return Topic{}, "", fmt.Errorf("%s: encoding must be JSON or BINARY, got %q", resource, t.SchemaEncoding)
+	}
+
+	if projectID == "" {
+		projectID = defaultProject
+	}
+	if projectID == "" {
+		return Topic{}, "", fmt.Errorf("%s: project is required without a google provider project", resource)
+	}
+
+	return t, projectID, nil
+}
+
+// hclSubscription converts a google_pubsub_subscription resource to a
+// subscription, and returns the project and ID of its topic.
+func (p *Parser) hclSubscription(ctx *hcl.EvalContext, block *hclsyntax.Block, defaultProject string) (Subscription, string, string, error) {
+	resource := fmt.Sprintf("Subscription %q", block.Labels[1])
+	b := p.hclBlock(ctx, resource, block.Body)
+
+	s := Subscription{
+		ID:                  b.string("name"),
+		Filter:              b.string("filter"),
+		Ordered:             b.bool("enable_message_ordering"),
+		ExactlyOnceDelivery: b.bool("enable_exactly_once_delivery"),
+		RetainAcked:         b.bool("retain_acked_messages"),
+		Labels:              b.stringMap("labels"),
+	}
+	projectID := b.string("project")
+	topic := b.string("topic")
+
+	if value := b.string("message_retention_duration"); value != "" {
+		s.Retention = b.duration("message_retention_duration", value, 10*time.Minute, 7*24*time.Hour)
+	}
+
+	if seconds := b.int("ack_deadline_seconds"); seconds > 0 {
+		s.AckDeadline = b.duration("ack_deadline_seconds", fmt.Sprintf("%ds", seconds), 10*time.Second, 600*time.Second)
+	}
+
+	if policy := b.block("expiration_policy"); policy != nil {
+		// An empty TTL means that the subscription never expires.
+		if ttl := policy.string("ttl"); ttl == "" {
+			s.NoExpiration = true
+		} else {
+			s.Expiration = policy.duration("ttl", ttl, 24*time.Hour, 0)
+		}
+	}
+
+	if policy := b.block("retry_policy"); policy != nil {
+		if value := policy.string("minimum_backoff"); value != "" {
+			s.RetryMinBackoff = policy.duration("minimum_backoff", value, 0, 600*time.Second)
+		}
+		if value := policy.string("maximum_backoff"); value != "" {
+			s.RetryMaxBackoff = policy.duration("maximum_backoff", value, 0, 600*time.Second)
+		}
+	}
+
+	if policy := b.block("dead_letter_policy"); policy != nil {
+		s.DeadLetterTopic = policy.string("dead_letter_topic")
+		s.MaxDeliveryAttempts = policy.int("max_delivery_attempts")
+	}
+
+	if push := b.block("push_config"); push != nil {
+		s.PushEndpoint = push.string("push_endpoint")
+		if token := push.block("oidc_token"); token != nil {
+			s.PushServiceAccount = token.string("service_account_email")
+		}
+	}
+
+	if bq := b.block("bigquery_config"); bq != nil {
+		s.BigQueryTable = bq.string("table")
+		s.BigQueryDropUnknownFields = bq.bool("drop_unknown_fields")
+	}
+
+	if gcs := b.block("cloud_storage_config"); gcs != nil {
+		s.CloudStorageBucket = gcs.string("bucket")
+		s.CloudStorageMaxBytes = int64(gcs.int("max_bytes"))
+		if value := gcs.string("max_duration"); value != "" {
+			s.CloudStorageMaxDuration = gcs.duration("max_duration", value, time.Minute, 10*time.Minute)
+		}
+	}
+
+	b.warnUnused()
+	if b.error() != nil {
+		return Subscription{}, "", "", b.error()
+	}
+
+	if s.ID == "" {
+		return Subscription{}, "", "", fmt.Errorf("%s: name is required", resource)
+	}
+	if topic == "" {
+		return Subscription{}, "", "", fmt.Errorf("%s: topic is required", resource)
+	}
+
+	if projectID == "" {
+		projectID = defaultProject
+	}
+	if projectID == "" {
+		return Subscription{}, "", "", fmt.Errorf("%s: project is required without a google provider project", resource)
+	}
+
+	// A topic in another project is referred to by its fully qualified name.
+	topicProject, topicID := splitTopicName(projectID, topic)
+	if topicProject != projectID {
+		topicID = fmt.Sprintf("projects/%s/topics/%s", topicProject, topicID)
+	}
+
+	if err := p.checkSubscription(s); err != nil {
+		return Subscription{}, "", "", err
+	}
+
+	if p.NoExpire && s.Expiration == 0 {
+		s.NoExpiration = true
+	}
+
+	return s, projectID, topicID, nil
+}
+
+// checkSubscription validates the options of a subscription that was not
+// parsed from a definition.
+func (p *Parser) checkSubscription(s Subscription) error {
+	resource := fmt.Sprintf("Subscription %q", s.ID)
+
+	switch {
+	case s.MaxDeliveryAttempts != 0 && (s.MaxDeliveryAttempts < 5 || s.MaxDeliveryAttempts > 100):
+		return fmt.Errorf("%s: max_delivery_attempts must be between 5 and 100, got %d", resource, s.MaxDeliveryAttempts)
+	case s.PushEndpoint != "" && !strings.HasPrefix(s.PushEndpoint, "http://") && !strings.HasPrefix(s.PushEndpoint, "https://"):
+		return fmt.Errorf("%s: push_endpoint must be an http(s) URL, got %q", resource, s.PushEndpoint)
+	case s.BigQueryTable != "" && strings.Count(s.BigQueryTable, ".") != 2:
+		return fmt.Errorf("%s: table must be of the form project.dataset.table, got %q", resource, s.BigQueryTable)
+	case s.BigQueryTable != "" && s.CloudStorageBucket != "":
+		return fmt.Errorf("%s: bigquery_config and cloud_storage_config are mutually exclusive", resource)
+	case s.CloudStorageMaxBytes != 0:
+		if err := checkSize("max_bytes", s.CloudStorageMaxBytes, 1000, 10<<30); err != nil {
+			return fmt.Errorf("%s: %s", resource, err)
+		}
+	case s.RetryMaxBackoff > 0 && s.RetryMinBackoff > s.RetryMaxBackoff:
+		return fmt.Errorf("%s: minimum_backoff %s exceeds maximum_backoff %s", resource, s.RetryMinBackoff, s.RetryMaxBackoff)
+	}
+
+	if s.Filter != "" {
+		if err := validateFilter(s.Filter); err != nil {
+			return fmt.Errorf("%s: filter: %s", resource, err)
+		}
+	}
+
+	if err := validateLabels(s.Labels); err != nil {
+		return fmt.Errorf("%s: labels: %s", resource, err)
+	}
+
+	return nil
+}
+
+// hclBlockReader reads the attributes and nested blocks of an HCL block. It
+// records the first error of the block and its nested blocks, after which it
+// returns zero values.
+type hclBlockReader struct {
+	p        *Parser
+	ctx      *hcl.EvalContext
+	resource string
+	body     *hclsyntax.Body
+
+	// path is the path of a nested block, like "retry_policy.".
+	path string
+
+	used   map[string]bool
+	nested []*hclBlockReader
+	err    *error
+}
+
+func (p *Parser) hclBlock(ctx *hcl.EvalContext, resource string, body *hclsyntax.Body) *hclBlockReader {
+	return &hclBlockReader{p: p, ctx: ctx, resource: resource, body: body, used: make(map[string]bool), err: new(error)}
+}
+
+// error returns the first error of the block or its nested blocks.
+func (b *hclBlockReader) error() error {
+	return *b.err
+}
+
+func (b *hclBlockReader) fail(format string, args ...interface{}) {
+	if *b.err == nil {
+		*b.err = fmt.Errorf(format, args...)
+	}
+}
+
+// value evaluates the named attribute, converts it to the type and decodes it
+// into the target. It returns false if the attribute is not set.
+func (b *hclBlockReader) value(name string, ty cty.Type, target interface{}) bool {
+	b.used[name] = true
+
+	attr, ok := b.body.Attributes[name]
+	if !ok || b.error() != nil {
+		return false
+	}
+
+	val, diags := attr.Expr.Value(b.ctx)
+	if diags.HasErrors() {
+		b.fail("%s: %s%s: %s", b.resource, b.path, name, diags.Error())
+		return false
+	}
+	if val.IsNull() {
+		return false
+	}
+
+	val, err := convert.Convert(val, ty)
+	if err == nil {
+		err = gocty.FromCtyValue(val, target)
+	}
+	if err != nil {
+		b.fail("%s: %s%s: %s", b.resource, b.path, name, err)
+		return false
+	}
+
+	return true
+}
+
+func (b *hclBlockReader) string(name string) string {
+	var s string
+	b.value(name, cty.String, &s)
+	return s
+}
+
+func (b *hclBlockReader) int(name string) int {
+	var n int
+	b.value(name, cty.Number, &n)
+	return n
+}
+
+func (b *hclBlockReader) bool(name string) bool {
+	var v bool
+	b.value(name, cty.Bool, &v)
+	return v
+}
+
+func (b *hclBlockReader) stringList(name string) []string {
+	var list []string
+	b.value(name, cty.List(cty.String), &list)
+	return list
+}
+
+func (b *hclBlockReader) stringMap(name string) map[string]string {
+	var m map[string]string
+	b.value(name, cty.Map(cty.String), &m)
+	return m
+}
+
+// duration parses the duration of the named attribute like Parser.duration.
+func (b *hclBlockReader) duration(name, value string, min, max time.Duration) time.Duration {
+	if b.error() != nil {
+		return 0
+	}
+
+	d, err := b.p.duration(b.resource, b.path+name, value, min, max)
+	if err != nil {
+		b.fail("%s: %s", b.resource, err)
+	}
+
+	return d
+}
+
+// block returns a reader of the first nested block with the specified type, or
+// nil if there is no such nested block.
+func (b *hclBlockReader) block(blockType string) *hclBlockReader {
+	b.used[blockType] = true
+
+	for _, block := range b.body.Blocks {
+		if block.Type == blockType {
+			nested := &hclBlockReader{
+				p:        b.p,
+				ctx:      b.ctx,
+				resource: b.resource,
+				body:     block.Body,
+				path:     b.path + blockType + ".",
+				used:     make(map[string]bool),
+				err:      b.err,
+			}
+			b.nested = append(b.nested, nested)
+
+			return nested
+		}
+	}
+
+	return nil
+}
+
+// warnUnused warns about the attributes and nested blocks of the block that
+// were not read, because they have no equivalent option.
+func (b *hclBlockReader) warnUnused() {
+	var unused []string
+	for name := range b.body.Attributes {
+		if !b.used[name] {
+			unused = append(unused, b.path+name)
+		}
+	}
+	for _, block := range b.body.Blocks {
+		if !b.used[block.Type] {
+			unused = append(unused, b.path+block.Type)
+		}
+	}
+	sort.Strings(unused)
+
+	if len(unused) > 0 && b.p.Logger != nil {
+		b.p.Logger.Warnf("%s: ignoring %s, which pubsubc does not support", b.resource, strings.Join(unused, ", "))
+	}
+
+	for _, nested := range b.nested {
+		nested.warnUnused()
+	}
+}
@@ -0,0 +1,256 @@
+package provision
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHCL(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		want     []Config
+		warnings []string
+		err      string
+	}{
+		{
+			name: "provider project and references",
+			src: `
+provider "google" {
+  project = "shop"
+}
+
+resource "google_pubsub_topic" "orders" {
+  name   = "orders"
+  labels = { team = "checkout" }
+
+  message_retention_duration = "86400s"
+}
+
+resource "google_pubsub_subscription" "orders_mailer" {
+  name                 = "orders-mailer"
+  topic                = google_pubsub_topic.orders.name
+  ack_deadline_seconds = 30
+
+  retry_policy {
+    minimum_backoff = "5s"
+    maximum_backoff = "120s"
+  }
+}
+
+resource "google_pubsub_subscription" "orders_audit" {
+  name                    = "orders-audit"
+  topic                   = google_pubsub_topic.orders.id
+  enable_message_ordering = true
+  filter                  = "attributes.country = \"nl\""
+
+  expiration_policy {
+    ttl = ""
+  }
+}
+`,
+			want: []Config{{ProjectID: "shop", Topics: []Topic{{
+				ID:        "orders",
+				Labels:    map[string]string{"team": "checkout"},
+				Retention: 24 * time.Hour,
+				Subscriptions: []Subscription{
+					{ID: "orders-mailer", AckDeadline: 30 * time.Second, RetryMinBackoff: 5 * time.Second, RetryMaxBackoff: 2 * time.Minute},
+					{ID: "orders-audit", Ordered: true, Filter: `attributes.country = "nl"`, NoExpiration: true},
+				},
+			}}}},
+		},
+		{
+			name: "resources in several projects",
+			src: `
+resource "google_pubsub_topic" "dead" {
+  project = "platform"
+  name    = "dead-letters"
+}
+
+resource "google_pubsub_topic" "clicks" {
+  project = "analytics"
+  name    = "clicks"
+
+  message_storage_policy {
+    allowed_persistence_regions = ["europe-west1", "europe-west4"]
+  }
+}
+
+resource "google_pubsub_subscription" "clicks_bq" {
+  project = "analytics"
+  name    = "clicks-bq"
+  topic   = "clicks"
+
+  dead_letter_policy {
+    dead_letter_topic     = google_pubsub_topic.dead.id
+    max_delivery_attempts = 10
+  }
+}
+
+resource "google_pubsub_subscription" "dead_inspector" {
+  project = "analytics"
+  name    = "dead-inspector"
+  topic   = "projects/platform/topics/dead-letters"
+}
+`,
+			want: []Config{
+				{ProjectID: "platform", Topics: []Topic{{ID: "dead-letters"}}},
+				{ProjectID: "analytics", Topics: []Topic{
+					{ID: "clicks", Regions: []string{"europe-west1", "europe-west4"}, Subscriptions: []Subscription{
+						{ID: "clicks-bq", DeadLetterTopic: "projects/platform/topics/dead-letters", MaxDeliveryAttempts: 10},
+					}},
+					{ID: "projects/platform/topics/dead-letters", Subscriptions: []Subscription{{ID: "dead-inspector"}}},
+				}},
+			},
+		},
+		{
+			name: "unsupported attributes",
+			src: `
+resource "google_pubsub_topic" "events" {
+  project = "billing"
+  name    = "events"
+
+  ingestion_data_source_settings {}
+}
+
+resource "google_pubsub_subscription" "events_push" {
+  project = "billing"
+  name    = "events-push"
+  topic   = "events"
+
+  push_config {
+    push_endpoint = "https://billing.example.com/events"
+    attributes    = { x-goog-version = "v1" }
+
+    oidc_token {
+      service_account_email = "pusher@billing.iam.gserviceaccount.com"
+    }
+  }
+}
+
+resource "google_storage_bucket" "unrelated" {
+  name = "billing-archive"
+}
+`,
+			want: []Config{{ProjectID: "billing", Topics: []Topic{{ID: "events", Subscriptions: []Subscription{{
+				ID:                 "events-push",
+				PushEndpoint:       "https://billing.example.com/events",
+				PushServiceAccount: "pusher@billing.iam.gserviceaccount.com",
+			}}}}}},
+			warnings: []string{
+				`Topic "events": ignoring ingestion_data_source_settings, which pubsubc does not support`,
+				`Subscription "events_push": ignoring push_config.attributes, which pubsubc does not support`,
+			},
+		},
+		{
+			name: "syntax error",
+			src:  `resource "google_pubsub_topic" "broken" {`,
+			err:  "Unable to parse infra.tf:",
+		},
+		{
+			name: "missing project",
+			src:  `resource "google_pubsub_topic" "jobs" { name = "jobs" }`,
+			err:  `Topic "jobs": project is required without a google provider project`,
+		},
+		{
+			name: "undefined topic",
+			src: `
+resource "google_pubsub_subscription" "jobs_worker" {
+  project = "batch"
+  name    = "jobs-worker"
+  topic   = "jobs"
+}
+`,
+			err: `Subscription "jobs-worker": topic "jobs" is not defined in project "batch"`,
+		},
+		{
+			name: "undefined reference",
+			src: `
+resource "google_pubsub_subscription" "jobs_worker" {
+  project = "batch"
+  name    = "jobs-worker"
+  topic   = google_pubsub_topic.jobs.name
+}
+`,
+			err: `Subscription "jobs_worker": topic:`,
+		},
+		{
+			name: "ack deadline out of range",
+			src: `
+resource "google_pubsub_topic" "jobs" {
+  project = "batch"
+  name    = "jobs"
+}
+
+resource "google_pubsub_subscription" "jobs_worker" {
+  project              = "batch"
+  name                 = "jobs-worker"
+  topic                = "jobs"
+  ack_deadline_seconds = 900
+}
+`,
+			err: "ack_deadline_seconds: 15m0s above maximum 10m0s",
+		},
+		{
+			name: "invalid max delivery attempts",
+			src: `
+resource "google_pubsub_topic" "jobs" {
+  project = "batch"
+  name    = "jobs"
+}
+
+resource "google_pubsub_subscription" "jobs_worker" {
+  project = "batch"
+  name    = "jobs-worker"
+  topic   = "jobs"
+
+  dead_letter_policy {
+    dead_letter_topic     = "jobs"
+    max_delivery_attempts = 2
+  }
+}
+`,
+			err: `Subscription "jobs-worker": max_delivery_attempts must be between 5 and 100, got 2`,
+		},
+		{
+			name: "invalid schema encoding",
+			src: `
+resource "google_pubsub_topic" "jobs" {
+  project = "batch"
+  name    = "jobs"
+
+  schema_settings {
+    schema   = "projects/batch/schemas/job"
+    encoding = "XML"
+  }
+}
+`,
+			err: `Topic "jobs": encoding must be JSON or BINARY, got "xml"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &testLogger{}
+			got, err := (&Parser{Logger: logger}).ParseHCL("infra.tf", []byte(tt.src))
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+			if !reflect.DeepEqual(logger.warnings, tt.warnings) {
+				t.Errorf("expected warnings %q, got %q", tt.warnings, logger.warnings)
+			}
+		})
+	}
+}
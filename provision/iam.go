@@ -0,0 +1,53 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// loadPolicy reads an IAM policy from the specified JSON file, in the form
+// that "gcloud pubsub topics get-iam-policy --format=json" prints.
+func loadPolicy(filename string) (*iam.Policy, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read IAM policy file %q: %s", filename, err)
+	}
+
+	policy := &iampb.Policy{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, policy); err != nil {
+		return nil, fmt.Errorf("Unable to parse IAM policy file %q: %s", filename, err)
+	}
+
+	// The etag of an exported policy belongs to the resource that it was
+	// exported from, so the policy replaces the existing one instead.
+	policy.Etag = nil
+
+	return &iam.Policy{InternalProto: policy}, nil
+}
+
+// applyPolicy replaces the IAM policy of the resource with the policy of the
+// specified file. An emulator without IAM support is reported as a no-op.
+func (c *creator) applyPolicy(ctx context.Context, resource string, handle *iam.Handle, filename string) error {
+	policy, err := loadPolicy(filename)
+	if err != nil {
+		return fmt.Errorf("%s: %s", resource, err)
+	}
+
+	c.debugf("    Setting the IAM policy of %s", resource)
+	err = handle.SetPolicy(ctx, policy)
+	if status.Code(err) == codes.Unimplemented {
+		return c.noop(resource, "iamfile", "the emulator does not support IAM policies")
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to set the IAM policy of %s: %s", resource, err)
+	}
+
+	return nil
+}
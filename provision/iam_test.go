@@ -0,0 +1,201 @@
+package provision
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc"
+)
+
+// writePolicy writes an IAM policy file to a temporary directory and returns
+// its path.
+func writePolicy(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// want are the bindings as "role member1 member2".
+		want []string
+		err  string
+	}{
+		{
+			name: "exported policy",
+			content: `{
+  "bindings": [
+    {"role": "roles/pubsub.publisher", "members": ["serviceAccount:api@shop.iam.gserviceaccount.com"]},
+    {"role": "roles/pubsub.subscriber", "members": ["serviceAccount:worker@shop.iam.gserviceaccount.com", "group:oncall@example.com"]}
+  ],
+  "etag": "BwXhqDs3e3U=",
+  "version": 1
+}`,
+			want: []string{
+				"roles/pubsub.publisher serviceAccount:api@shop.iam.gserviceaccount.com",
+				"roles/pubsub.subscriber serviceAccount:worker@shop.iam.gserviceaccount.com group:oncall@example.com",
+			},
+		},
+		{
+			name:    "unknown fields",
+			content: `{"bindings":[{"role":"roles/pubsub.viewer","members":["allUsers"]}],"comment":"exported by hand"}`,
+			want:    []string{"roles/pubsub.viewer allUsers"},
+		},
+		{name: "empty policy", content: `{}`},
+		{name: "invalid JSON", content: `{"bindings":`, err: "Unable to parse IAM policy file"},
+		{name: "wrong type", content: `{"bindings":"roles/pubsub.viewer"}`, err: "Unable to parse IAM policy file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := loadPolicy(writePolicy(t, "policy.json", tt.content))
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if policy.InternalProto.Etag != nil {
+				t.Errorf("expected the etag to be dropped, got %q", policy.InternalProto.Etag)
+			}
+			if got := formatBindings(policy.InternalProto); !slices.Equal(got, tt.want) {
+				t.Errorf("expected the bindings %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := loadPolicy(filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "Unable to read IAM policy file") {
+		t.Errorf("expected an error for a missing file, got %v", err)
+	}
+}
+
+// formatBindings returns the bindings of the policy as "role member1 member2".
+func formatBindings(policy *iampb.Policy) []string {
+	var bindings []string
+	for _, b := range policy.Bindings {
+		bindings = append(bindings, strings.Join(append([]string{b.Role}, b.Members...), " "))
+	}
+
+	return bindings
+}
+
+// iamServer is an IAM policy service that records the policies that are set.
+type iamServer struct {
+	iampb.UnimplementedIAMPolicyServer
+
+	mu       sync.Mutex
+	policies map[string]*iampb.Policy
+}
+
+func (s *iamServer) SetIamPolicy(_ context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policies[req.Resource] = req.Policy
+	return req.Policy, nil
+}
+
+func TestCreatePolicy(t *testing.T) {
+	publishers := `{"bindings":[{"role":"roles/pubsub.publisher","members":["serviceAccount:api@shop.iam.gserviceaccount.com"]}]}`
+	subscribers := `{"bindings":[{"role":"roles/pubsub.subscriber","members":["serviceAccount:mailer@shop.iam.gserviceaccount.com"]}]}`
+
+	tests := []struct {
+		name string
+		// iam serves the IAM policy service next to the emulator.
+		iam    bool
+		strict bool
+		// want are the bindings of the policies that are set by resource.
+		want     map[string][]string
+		warnings int
+		err      string
+	}{
+		{
+			name: "applied",
+			iam:  true,
+			want: map[string][]string{
+				"projects/project1/topics/orders":               {"roles/pubsub.publisher serviceAccount:api@shop.iam.gserviceaccount.com"},
+				"projects/project1/subscriptions/orders-mailer": {"roles/pubsub.subscriber serviceAccount:mailer@shop.iam.gserviceaccount.com"},
+			},
+		},
+		{name: "unsupported", warnings: 2},
+		{name: "unsupported in strict mode", strict: true, err: `Topic "orders": option iamfile has no effect: the emulator does not support IAM policies`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := pstest.NewServer()
+			t.Cleanup(func() { srv.Close() })
+
+			iam := &iamServer{policies: make(map[string]*iampb.Policy)}
+			gsrv := grpc.NewServer()
+			pubsubpb.RegisterPublisherServer(gsrv, &srv.GServer)
+			pubsubpb.RegisterSubscriberServer(gsrv, &srv.GServer)
+			if tt.iam {
+				iampb.RegisterIAMPolicyServer(gsrv, iam)
+			}
+
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go gsrv.Serve(lis)
+			t.Cleanup(gsrv.Stop)
+			t.Setenv("PUBSUB_EMULATOR_HOST", lis.Addr().String())
+
+			clients := &ClientCache{ShareConnection: true}
+			t.Cleanup(func() { clients.Close() })
+
+			logger := &testLogger{}
+			p := &Provisioner{Clients: clients, Logger: logger, Strict: tt.strict}
+
+			definition := "project1,orders[iamfile=" + writePolicy(t, "publishers.json", publishers) + "]:orders-mailer;iamfile=" + writePolicy(t, "subscribers.json", subscribers)
+			err = create(t, p, definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got := make(map[string][]string)
+			for resource, policy := range iam.policies {
+				got[resource] = formatBindings(policy)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("expected policies for %d resource(s), got %v", len(tt.want), got)
+			}
+			for resource, bindings := range tt.want {
+				if !slices.Equal(got[resource], bindings) {
+					t.Errorf("%s: expected the bindings %q, got %q", resource, bindings, got[resource])
+				}
+			}
+
+			if len(logger.warnings) != tt.warnings {
+				t.Errorf("expected %d warning(s), got %q", tt.warnings, logger.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package provision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Ingestion configures the external source that a topic ingests messages
+// from.
+type Ingestion struct {
+	// Source is either "kinesis" for Amazon Kinesis Data Streams, or "gcs"
+	// for Cloud Storage.
+	Source string
+
+	// Settings are the settings of the source by key.
+	Settings map[string]string
+}
+
+// ingestionSources lists the required and optional settings of every
+// ingestion source.
+var ingestionSources = map[string]struct {
+	required []string
+	optional []string
+}{
+	"kinesis": {required: []string{"stream", "consumer", "role", "sa"}},
+	"gcs":     {required: []string{"bucket"}, optional: []string{"format", "delimiter", "match"}},
+}
+
+// parseIngestion parses an ingestion source of the form
+// "source;key1=value1;key2=value2".
+func parseIngestion(value string) (*Ingestion, error) {
+	parts := strings.Split(value, ";")
+
+	source, ok := ingestionSources[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("expected kinesis or gcs, got %q", parts[0])
+	}
+
+	valid := make(map[string]bool)
+	for _, key := range append(source.required, source.optional...) {
+		valid[key] = true
+	}
+
+	ingestion := &Ingestion{Source: parts[0], Settings: make(map[string]string)}
+	for _, part := range parts[1:] {
+		key, val, ok := strings.Cut(part, "=")
+		switch {
+		case !ok || val == "":
+			return nil, fmt.Errorf("expected key=value, got %q", part)
+		case !valid[key]:
+			return nil, fmt.Errorf("%s: unknown setting %q", ingestion.Source, key)
+		}
+
+		ingestion.Settings[key] = val
+	}
+
+	for _, key := range source.required {
+		if ingestion.Settings[key] == "" {
+			return nil, fmt.Errorf("%s: %s is required", ingestion.Source, key)
+		}
+	}
+
+	switch ingestion.Settings["format"] {
+	case "":
+	case "text":
+		// The text format is the default, which the live settings don't
+		// report.
+		delete(ingestion.Settings, "format")
+	case "avro", "pubsubavro":
+		if ingestion.Settings["delimiter"] != "" {
+			return nil, fmt.Errorf("%s: delimiter requires the text format", ingestion.Source)
+		}
+	default:
+		return nil, fmt.Errorf("%s: format must be text, avro or pubsubavro, got %q", ingestion.Source, ingestion.Settings["format"])
+	}
+
+	return ingestion, nil
+}
+
+// String formats the ingestion source in the form that parseIngestion
+// accepts.
+func (i *Ingestion) String() string {
+	keys := make([]string, 0, len(i.Settings))
+	for key := range i.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{i.Source}
+	for _, key := range keys {
+		parts = append(parts, key+"="+i.Settings[key])
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// dataSourceSettings returns the ingestion settings of a topic config.
+func (i *Ingestion) dataSourceSettings() *pubsub.IngestionDataSourceSettings {
+	s := i.Settings
+
+	switch i.Source {
+	case "kinesis":
+		return &pubsub.IngestionDataSourceSettings{Source: &pubsub.IngestionDataSourceAWSKinesis{
+			StreamARN:         s["stream"],
+			ConsumerARN:       s["consumer"],
+			AWSRoleARN:        s["role"],
+			GCPServiceAccount: s["sa"],
+		}}
+
+	default:
+		source := &pubsub.IngestionDataSourceCloudStorage{Bucket: s["bucket"], MatchGlob: s["match"]}
+		switch s["format"] {
+		case "avro":
+			source.InputFormat = &pubsub.IngestionDataSourceCloudStorageAvroFormat{}
+		case "pubsubavro":
+			source.InputFormat = &pubsub.IngestionDataSourceCloudStoragePubSubAvroFormat{}
+		default:
+			source.InputFormat = &pubsub.IngestionDataSourceCloudStorageTextFormat{Delimiter: s["delimiter"]}
+		}
+
+		return &pubsub.IngestionDataSourceSettings{Source: source}
+	}
+}
+
+// ingestionFromSettings returns the ingestion source of live ingestion
+// settings, or nil if the source is not supported.
+func ingestionFromSettings(settings *pubsub.IngestionDataSourceSettings) *Ingestion {
+	if settings == nil {
+		return nil
+	}
+
+	switch source := settings.Source.(type) {
+	case *pubsub.IngestionDataSourceAWSKinesis:
+		return &Ingestion{Source: "kinesis", Settings: map[string]string{
+			"stream":   source.StreamARN,
+			"consumer": source.ConsumerARN,
+			"role":     source.AWSRoleARN,
+			"sa":       source.GCPServiceAccount,
+		}}
+
+	case *pubsub.IngestionDataSourceCloudStorage:
+		ingestion := &Ingestion{Source: "gcs", Settings: map[string]string{"bucket": source.Bucket}}
+		if source.MatchGlob != "" {
+			ingestion.Settings["match"] = source.MatchGlob
+		}
+
+		switch format := source.InputFormat.(type) {
+		case *pubsub.IngestionDataSourceCloudStorageAvroFormat:
+			ingestion.Settings["format"] = "avro"
+		case *pubsub.IngestionDataSourceCloudStoragePubSubAvroFormat:
+			ingestion.Settings["format"] = "pubsubavro"
+		case *pubsub.IngestionDataSourceCloudStorageTextFormat:
+			if format.Delimiter != "" {
+				ingestion.Settings["delimiter"] = format.Delimiter
+			}
+		}
+
+		return ingestion
+	}
+
+	return nil
+}
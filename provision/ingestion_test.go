@@ -0,0 +1,143 @@
+package provision
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+func TestParseIngestion(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   string
+	}{
+		{
+			value: "kinesis;stream=arn:aws:kinesis:eu-west-1:1:stream/clicks;consumer=arn:aws:kinesis:eu-west-1:1:stream/clicks/consumer/c;role=arn:aws:iam::1:role/pubsub;sa=ingest@p.iam.gserviceaccount.com",
+			want:  "kinesis;consumer=arn:aws:kinesis:eu-west-1:1:stream/clicks/consumer/c;role=arn:aws:iam::1:role/pubsub;sa=ingest@p.iam.gserviceaccount.com;stream=arn:aws:kinesis:eu-west-1:1:stream/clicks",
+		},
+		{value: "gcs;bucket=exports", want: "gcs;bucket=exports"},
+		{value: "gcs;match=*.csv;bucket=exports;format=text;delimiter=|", want: "gcs;bucket=exports;delimiter=|;match=*.csv"},
+		{value: "gcs;bucket=exports;format=pubsubavro", want: "gcs;bucket=exports;format=pubsubavro"},
+		{value: "kafka;topic=clicks", err: `expected kinesis or gcs, got "kafka"`},
+		{value: "kinesis;stream=s;consumer=c;role=r", err: "kinesis: sa is required"},
+		{value: "gcs", err: "gcs: bucket is required"},
+		{value: "gcs;bucket", err: `expected key=value, got "bucket"`},
+		{value: "gcs;bucket=exports;prefix=2024/", err: `gcs: unknown setting "prefix"`},
+		{value: "gcs;bucket=exports;format=parquet", err: `gcs: format must be text, avro or pubsubavro, got "parquet"`},
+		{value: "gcs;bucket=exports;format=avro;delimiter=,", err: "gcs: delimiter requires the text format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			ingestion, err := parseIngestion(tt.value)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected the error %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := ingestion.String(); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+
+			// The settings survive a round trip through the topic config.
+			if got := ingestionFromSettings(ingestion.dataSourceSettings()); got == nil || got.String() != tt.want {
+				t.Errorf("expected %q after a round trip, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateIngestion(t *testing.T) {
+	const warning = "option ingest has no effect: the emulator does not ingest messages from external sources"
+
+	tests := []struct {
+		name   string
+		config string
+		strict bool
+		// want is the ingestion source of the topic that is created, or
+		// empty for a topic without one.
+		want string
+		err  string
+	}{
+		{
+			name:   "cloud storage",
+			config: "project1,exports[ingest=gcs;bucket=nightly-exports;format=avro]:exports-loader",
+			want:   "gcs;bucket=nightly-exports;format=avro",
+		},
+		{
+			name:   "kinesis",
+			config: "project1,clicks[ingest=kinesis;stream=arn:s;consumer=arn:c;role=arn:r;sa=kinesis@p.iam.gserviceaccount.com,retain=1h]",
+			want:   "kinesis;consumer=arn:c;role=arn:r;sa=kinesis@p.iam.gserviceaccount.com;stream=arn:s",
+		},
+		{
+			name:   "strict",
+			config: "project1,exports[ingest=gcs;bucket=nightly-exports]",
+			strict: true,
+			err:    `Topic "exports": ` + warning,
+		},
+		{
+			name:   "no ingestion",
+			config: "project1,exports:exports-loader",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *pubsubpb.IngestionDataSourceSettings
+			record := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				got = req.(*pubsubpb.Topic).IngestionDataSourceSettings
+				return false, nil, nil
+			})
+
+			p, client := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateTopic", Reactor: record})
+			logger := &testLogger{}
+			p.Logger, p.Strict = logger, tt.strict
+
+			err := create(t, p, tt.config)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				if got != nil {
+					t.Errorf("expected no topic to be created, got %v", got)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tt.want == "" {
+				if got != nil || len(logger.warnings) > 0 {
+					t.Errorf("expected no ingestion and warnings, got %v and %q", got, logger.warnings)
+				}
+				return
+			}
+
+			if got == nil {
+				t.Fatalf("expected the ingestion settings %q, got none", tt.want)
+			}
+			if len(logger.warnings) != 1 || !strings.HasSuffix(logger.warnings[0], warning) {
+				t.Errorf("expected the warning %q, got %q", warning, logger.warnings)
+			}
+
+			// The live topic reports the same ingestion source.
+			live, err := Live(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ingestion := live.Topics[0].Ingestion; ingestion == nil || ingestion.String() != tt.want {
+				t.Errorf("expected the live ingestion %q, got %v", tt.want, ingestion)
+			}
+		})
+	}
+}
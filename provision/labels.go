@@ -0,0 +1,47 @@
+package provision
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Label keys start with a lowercase letter, and label keys and values consist
+// of at most 63 lowercase letters, digits, underscores and dashes.
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueRegexp = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// validateLabels checks that the labels are valid PubSub labels.
+func validateLabels(labels map[string]string) error {
+	for key, val := range labels {
+		if !labelKeyRegexp.MatchString(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if !labelValueRegexp.MatchString(val) {
+			return fmt.Errorf("invalid value %q of label %q", val, key)
+		}
+	}
+
+	return nil
+}
+
+// CheckLabels returns an error if a key or value of the labels violates the
+// PubSub label rules.
+func CheckLabels(labels map[string]string) error {
+	return validateLabels(labels)
+}
+
+// parseLabels parses labels of the form "key1:value1;key2:value2".
+func parseLabels(value string) (map[string]string, error) {
+	labels, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
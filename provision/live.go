@@ -0,0 +1,218 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// Live reads the topics and subscriptions that currently exist in the project
+// of the client.
+func Live(ctx context.Context, client *pubsub.Client) (Config, error) {
+	projectID := client.Project()
+	project := Config{ProjectID: projectID}
+
+	topics, err := listTopics(ctx, client)
+	if err != nil {
+		return Config{}, err
+	}
+
+	subscriptions, err := listSubscriptions(ctx, client)
+	if err != nil {
+		return Config{}, err
+	}
+
+	index := make(map[string]int)
+	for _, cfg := range topics {
+		retention, _ := cfg.RetentionDuration.(time.Duration)
+
+		topic := Topic{
+			ID:         cfg.ID(),
+			KMSKeyName: cfg.KMSKeyName,
+			Retention:  retention,
+			Regions:    cfg.MessageStoragePolicy.AllowedPersistenceRegions,
+			Ingestion:  ingestionFromSettings(cfg.IngestionDataSourceSettings),
+		}
+
+		if settings := cfg.SchemaSettings; settings != nil && settings.Schema != "" {
+			topic.Schema = strings.TrimPrefix(settings.Schema, "projects/"+projectID+"/schemas/")
+			for name, encoding := range schemaEncodings {
+				if encoding == settings.Encoding {
+					topic.SchemaEncoding = name
+				}
+			}
+		}
+
+		index[cfg.String()] = len(project.Topics)
+		project.Topics = append(project.Topics, topic)
+	}
+
+	for _, cfg := range subscriptions {
+		// Subscriptions on topics in other projects are listed under the
+		// fully qualified name of their topic. Detached ones are skipped.
+		i, ok := index[cfg.Topic.String()]
+		if !ok {
+			name := cfg.Topic.String()
+			if _, topicID := splitTopicName(projectID, name); topicID == name {
+				continue
+			}
+
+			i = len(project.Topics)
+			index[name] = i
+			project.Topics = append(project.Topics, Topic{ID: name})
+		}
+
+		project.Topics[i].Subscriptions = append(project.Topics[i].Subscriptions, subscriptionFromConfig(projectID, cfg))
+	}
+
+	return project, nil
+}
+
+// subscriptionFromConfig converts the config of an existing subscription in
+// the specified project to a subscription definition.
+func subscriptionFromConfig(projectID string, cfg *pubsub.SubscriptionConfig) Subscription {
+	s := Subscription{
+		ID:                        cfg.ID(),
+		ExactlyOnceDelivery:       cfg.EnableExactlyOnceDelivery,
+		Ordered:                   cfg.EnableMessageOrdering,
+		Filter:                    cfg.Filter,
+		BigQueryTable:             cfg.BigQueryConfig.Table,
+		BigQueryDropUnknownFields: cfg.BigQueryConfig.DropUnknownFields,
+		CloudStorageBucket:        cfg.CloudStorageConfig.Bucket,
+		CloudStorageMaxBytes:      cfg.CloudStorageConfig.MaxBytes,
+		RetainAcked:               cfg.RetainAckedMessages,
+	}
+
+	// Leave out the default retention.
+	if cfg.RetentionDuration != defaultSubscriptionRetention {
+		s.Retention = cfg.RetentionDuration
+	}
+
+	s.CloudStorageMaxDuration, _ = cfg.CloudStorageConfig.MaxDuration.(time.Duration)
+	s.PushEndpoint = cfg.PushConfig.Endpoint
+	if token, ok := cfg.PushConfig.AuthenticationMethod.(*pubsub.OIDCToken); ok {
+		s.PushServiceAccount = token.ServiceAccountEmail
+	}
+
+	// Leave out the default expiration policy. Subscriptions that never
+	// expire can't be told apart from the ones without an expiration policy.
+	if d, _ := cfg.ExpirationPolicy.(time.Duration); d > 0 && d != 31*24*time.Hour {
+		s.Expiration = d
+	}
+
+	// Leave out the default ack deadline.
+	if cfg.AckDeadline != 10*time.Second {
+		s.AckDeadline = cfg.AckDeadline
+	}
+
+	if rp := cfg.RetryPolicy; rp != nil {
+		s.RetryMinBackoff, _ = rp.MinimumBackoff.(time.Duration)
+		s.RetryMaxBackoff, _ = rp.MaximumBackoff.(time.Duration)
+	}
+
+	if dlp := cfg.DeadLetterPolicy; dlp != nil {
+		if p, t := splitTopicName(projectID, dlp.DeadLetterTopic); p == projectID {
+			s.DeadLetterTopic = t
+		} else {
+			s.DeadLetterTopic = dlp.DeadLetterTopic
+		}
+		s.MaxDeliveryAttempts = dlp.MaxDeliveryAttempts
+	}
+
+	return s
+}
+
+// listTopics returns the configs of all the topics in the project of the
+// client.
+func listTopics(ctx context.Context, client *pubsub.Client) ([]*pubsub.TopicConfig, error) {
+	var (
+		topics []*pubsub.TopicConfig
+		seen   = newListGuard()
+	)
+
+	for it := client.Topics(ctx); ; {
+		cfg, err := it.NextConfig()
+		if err == iterator.Done {
+			return topics, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list topics for project %q: %s", client.Project(), err)
+		}
+
+		switch seen.add(cfg.String()) {
+		case listRepeated:
+			continue
+		case listCycled:
+			return topics, nil
+		}
+
+		topics = append(topics, cfg)
+	}
+}
+
+// listSubscriptions returns the configs of all the subscriptions in the
+// project of the client.
+func listSubscriptions(ctx context.Context, client *pubsub.Client) ([]*pubsub.SubscriptionConfig, error) {
+	var (
+		subscriptions []*pubsub.SubscriptionConfig
+		seen          = newListGuard()
+	)
+
+	for it := client.Subscriptions(ctx); ; {
+		cfg, err := it.NextConfig()
+		if err == iterator.Done {
+			return subscriptions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list subscriptions for project %q: %s", client.Project(), err)
+		}
+
+		switch seen.add(cfg.String()) {
+		case listRepeated:
+			continue
+		case listCycled:
+			return subscriptions, nil
+		}
+
+		subscriptions = append(subscriptions, cfg)
+	}
+}
+
+// The results of adding a name to a listGuard.
+const (
+	listNew = iota
+	listRepeated
+	listCycled
+)
+
+// listGuard protects a listing against page tokens that don't advance, which
+// makes the iterator return the same entries over and over again.
+type listGuard struct {
+	seen    map[string]bool
+	repeats int
+}
+
+func newListGuard() *listGuard {
+	return &listGuard{seen: make(map[string]bool)}
+}
+
+// add records the name of a listed entry. Entries that were listed before are
+// repeated, and once every entry was repeated, the listing cycled and must
+// stop.
+func (g *listGuard) add(name string) int {
+	if !g.seen[name] {
+		g.seen[name] = true
+		g.repeats = 0
+		return listNew
+	}
+
+	if g.repeats++; g.repeats >= len(g.seen) {
+		return listCycled
+	}
+
+	return listRepeated
+}
@@ -0,0 +1,153 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+func TestListTopicsPagination(t *testing.T) {
+	type page struct {
+		topics []string
+		next   string
+	}
+
+	tests := []struct {
+		name string
+		// pages maps the page tokens to the pages that the server returns.
+		pages    map[string]page
+		expected []string
+	}{
+		{
+			name: "pages",
+			pages: map[string]page{
+				"":   {topics: []string{"alerts", "audit"}, next: "p2"},
+				"p2": {topics: []string{"billing", "builds"}, next: "p3"},
+				"p3": {topics: []string{"clicks"}},
+			},
+			expected: []string{"alerts", "audit", "billing", "builds", "clicks"},
+		},
+		{
+			name: "empty last page",
+			pages: map[string]page{
+				"":   {topics: []string{"alerts"}, next: "p2"},
+				"p2": {},
+			},
+			expected: []string{"alerts"},
+		},
+		{
+			name: "overlapping pages",
+			pages: map[string]page{
+				"":   {topics: []string{"alerts", "audit", "billing"}, next: "p2"},
+				"p2": {topics: []string{"billing", "builds"}},
+			},
+			expected: []string{"alerts", "audit", "billing", "builds"},
+		},
+		{
+			name: "token that doesn't advance",
+			pages: map[string]page{
+				"":     {topics: []string{"alerts", "audit"}, next: "same"},
+				"same": {topics: []string{"alerts", "audit"}, next: "same"},
+			},
+			expected: []string{"alerts", "audit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servePages := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				page := tt.pages[req.(*pubsubpb.ListTopicsRequest).PageToken]
+
+				resp := &pubsubpb.ListTopicsResponse{NextPageToken: page.next}
+				for _, id := range page.topics {
+					resp.Topics = append(resp.Topics, &pubsubpb.Topic{Name: "projects/project1/topics/" + id})
+				}
+				return true, resp, nil
+			})
+
+			_, client := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "ListTopics", Reactor: servePages})
+
+			topics, err := listTopics(context.Background(), client)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var ids []string
+			for _, cfg := range topics {
+				ids = append(ids, cfg.ID())
+			}
+			if !slices.Equal(ids, tt.expected) {
+				t.Errorf("expected topics %q, got %q", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestListManyResources(t *testing.T) {
+	tests := []struct {
+		name          string
+		topics        int
+		subscriptions int
+	}{
+		{name: "empty"},
+		{name: "topics only", topics: 150},
+		{name: "many subscriptions", topics: 40, subscriptions: 260},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newTestProvisioner(t)
+			ctx := context.Background()
+
+			var topic *pubsub.Topic
+			for i := 0; i < tt.topics; i++ {
+				var err error
+				if topic, err = client.CreateTopic(ctx, fmt.Sprintf("stream-%03d", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < tt.subscriptions; i++ {
+				if _, err := client.CreateSubscription(ctx, fmt.Sprintf("consumer-%03d", i), pubsub.SubscriptionConfig{Topic: topic}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			topics, err := listTopics(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subscriptions, err := listSubscriptions(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(topics) != tt.topics || len(subscriptions) != tt.subscriptions {
+				t.Errorf("expected %d topics and %d subscriptions, got %d and %d", tt.topics, tt.subscriptions, len(topics), len(subscriptions))
+			}
+
+			// The live config has every subscription exactly once.
+			live, err := Live(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[string]bool)
+			for _, topic := range live.Topics {
+				for _, s := range topic.Subscriptions {
+					if seen[s.ID] {
+						t.Errorf("expected subscription %q once", s.ID)
+					}
+					seen[s.ID] = true
+				}
+			}
+			if len(seen) != tt.subscriptions {
+				t.Errorf("expected %d live subscriptions, got %d", tt.subscriptions, len(seen))
+			}
+		})
+	}
+}
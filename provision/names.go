@@ -0,0 +1,133 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reservedPrefix is the prefix that PubSub reserves, and sanitizedPrefix the
+// prefix that SanitizeNames puts before it.
+const (
+	reservedPrefix  = "goog"
+	sanitizedPrefix = "x-"
+)
+
+// validateName checks that the ID of a topic or subscription follows the
+// PubSub naming rules, and returns the rule that it violates if it doesn't.
+func validateName(id string) error {
+	switch {
+	case len(id) < 3 || len(id) > 255:
+		return fmt.Errorf("the name must be 3 to 255 characters long, got %d", len(id))
+	case !isLetter(id[0]):
+		return fmt.Errorf("the name must start with a letter")
+	case strings.HasPrefix(id, reservedPrefix):
+		return fmt.Errorf("the name must not start with %s, which is reserved", reservedPrefix)
+	}
+
+	for _, r := range id {
+		if r < 0x80 && (isLetter(byte(r)) || (r >= '0' && r <= '9') || strings.ContainsRune("-_.~+%", r)) {
+			continue
+		}
+
+		return fmt.Errorf("the name must only contain letters, digits and the characters - _ . ~ + %%, got %q", r)
+	}
+
+	return nil
+}
+
+// isLetter reports whether the character is an ASCII letter.
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// CheckNames returns an error if the name of a topic or subscription of the
+// config, including the dead-letter topics and their subscriptions, violates
+// the PubSub naming rules.
+func CheckNames(cfg Config) error {
+	for _, t := range cfg.Topics {
+		_, topicID := splitTopicName(cfg.ProjectID, t.ID)
+		if err := validateName(topicID); err != nil {
+			return fmt.Errorf("Topic %q for project %q: %s", t.ID, cfg.ProjectID, err)
+		}
+
+		for _, s := range t.Subscriptions {
+			if err := validateName(s.ID); err != nil {
+				return fmt.Errorf("Subscription %q for project %q: %s", s.ID, cfg.ProjectID, err)
+			}
+
+			if s.DeadLetterTopic != "" {
+				_, topicID := splitTopicName(cfg.ProjectID, s.DeadLetterTopic)
+				if err := validateName(topicID); err != nil {
+					return fmt.Errorf("Subscription %q for project %q: dead-letter topic %q: %s", s.ID, cfg.ProjectID, s.DeadLetterTopic, err)
+				}
+			}
+
+			if s.DeadLetterSubscription != "" {
+				if err := validateName(s.DeadLetterSubscription); err != nil {
+					return fmt.Errorf("Subscription %q for project %q: dead-letter subscription %q: %s", s.ID, cfg.ProjectID, s.DeadLetterSubscription, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SanitizeNames returns a copy of the config in which the names of topics,
+// subscriptions and dead-letter topics that start with the reserved goog
+// prefix are prefixed with "x-" instead of being rejected. The rename function
+// is called with every name that is renamed, if it is set.
+func (p Config) SanitizeNames(rename func(kind, from, to string)) Config {
+	sanitize := func(kind, id string) string {
+		if !strings.HasPrefix(id, reservedPrefix) {
+			return id
+		}
+
+		sanitized := sanitizedPrefix + id
+		if rename != nil {
+			rename(kind, id, sanitized)
+		}
+
+		return sanitized
+	}
+
+	// sanitizeTopic sanitizes the topic ID of a topic reference, which may
+	// be a fully qualified name.
+	sanitizeTopic := func(kind, ref string) string {
+		projectID, topicID := splitTopicName(p.ProjectID, ref)
+		if sanitized := sanitize(kind, topicID); sanitized != topicID {
+			if ref == topicID {
+				return sanitized
+			}
+
+			return fmt.Sprintf("projects/%s/topics/%s", projectID, sanitized)
+		}
+
+		return ref
+	}
+
+	topics := make([]Topic, len(p.Topics))
+	for i, topic := range p.Topics {
+		topic.ID = sanitizeTopic("topic", topic.ID)
+
+		subscriptions := make([]Subscription, len(topic.Subscriptions))
+		for j, subscription := range topic.Subscriptions {
+			subscription.ID = sanitize("subscription", subscription.ID)
+
+			if subscription.DeadLetterTopic != "" {
+				subscription.DeadLetterTopic = sanitizeTopic("dead-letter topic", subscription.DeadLetterTopic)
+			}
+			if subscription.DeadLetterSubscription != "" {
+				subscription.DeadLetterSubscription = sanitize("dead-letter subscription", subscription.DeadLetterSubscription)
+			}
+
+			subscriptions[j] = subscription
+		}
+
+		topic.Subscriptions = subscriptions
+		topics[i] = topic
+	}
+
+	p.Topics = topics
+	return p
+}
@@ -0,0 +1,180 @@
+package provision
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		id  string
+		err string
+	}{
+		{id: "orders"},
+		{id: "abc"},
+		{id: "Orders.v2~eu+west_1%20-raw"},
+		{id: "a" + strings.Repeat("b", 254)},
+		{id: "ab", err: "the name must be 3 to 255 characters long, got 2"},
+		{id: "", err: "the name must be 3 to 255 characters long, got 0"},
+		{id: "a" + strings.Repeat("b", 255), err: "the name must be 3 to 255 characters long, got 256"},
+		{id: "1orders", err: "the name must start with a letter"},
+		{id: "-orders", err: "the name must start with a letter"},
+		{id: "google-events", err: "the name must not start with goog, which is reserved"},
+		{id: "goo-events"},
+		{id: "Google-events"},
+		{id: "orders/eu", err: `the name must only contain letters, digits and the characters - _ . ~ + %, got '/'`},
+		{id: "orders eu", err: `got ' '`},
+		{id: "bestellungen-größe", err: `got 'ö'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			err := validateName(tt.id)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCheckNames(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{
+			name: "valid",
+			cfg: Config{ProjectID: "logistics", Topics: []Topic{
+				{ID: "parcels", Subscriptions: []Subscription{{ID: "parcels-tracker", DeadLetterTopic: "parcels-dead", DeadLetterSubscription: "parcels-dead-sub"}}},
+				{ID: "projects/core/topics/routes", Subscriptions: []Subscription{{ID: "routes-planner"}}},
+			}},
+		},
+		{
+			name: "topic",
+			cfg:  Config{ProjectID: "logistics", Topics: []Topic{{ID: "goog-parcels"}}},
+			err:  `Topic "goog-parcels" for project "logistics": the name must not start with goog`,
+		},
+		{
+			name: "topic in another project",
+			cfg:  Config{ProjectID: "logistics", Topics: []Topic{{ID: "projects/core/topics/rt"}}},
+			err:  `Topic "projects/core/topics/rt" for project "logistics": the name must be 3 to 255 characters long, got 2`,
+		},
+		{
+			name: "subscription",
+			cfg:  Config{ProjectID: "logistics", Topics: []Topic{{ID: "parcels", Subscriptions: []Subscription{{ID: "9-tracker"}}}}},
+			err:  `Subscription "9-tracker" for project "logistics": the name must start with a letter`,
+		},
+		{
+			name: "dead-letter topic",
+			cfg:  Config{ProjectID: "logistics", Topics: []Topic{{ID: "parcels", Subscriptions: []Subscription{{ID: "parcels-tracker", DeadLetterTopic: "projects/logistics/topics/dead letters"}}}}},
+			err:  `Subscription "parcels-tracker" for project "logistics": dead-letter topic "projects/logistics/topics/dead letters": the name must only contain letters`,
+		},
+		{
+			name: "dead-letter subscription",
+			cfg:  Config{ProjectID: "logistics", Topics: []Topic{{ID: "parcels", Subscriptions: []Subscription{{ID: "parcels-tracker", DeadLetterTopic: "parcels-dead", DeadLetterSubscription: "dl"}}}}},
+			err:  `Subscription "parcels-tracker" for project "logistics": dead-letter subscription "dl": the name must be 3 to 255 characters long, got 2`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckNames(tt.cfg)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("expected an error starting with %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSanitizeNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		prefix  string
+		want    string
+		renamed []string
+	}{
+		{
+			name: "nothing reserved",
+			cfg:  Config{ProjectID: "adtech", Topics: []Topic{{ID: "bids", Subscriptions: []Subscription{{ID: "bids-google-sync"}}}}},
+			want: "adtech,bids:bids-google-sync",
+		},
+		{
+			name: "topic and subscription",
+			cfg:  Config{ProjectID: "adtech", Topics: []Topic{{ID: "google-bids", Subscriptions: []Subscription{{ID: "goog-audit"}, {ID: "bids-ranker"}}}}},
+			want: "adtech,x-google-bids:x-goog-audit:bids-ranker",
+			renamed: []string{
+				"topic google-bids -> x-google-bids",
+				"subscription goog-audit -> x-goog-audit",
+			},
+		},
+		{
+			name: "dead-letter topic and subscription",
+			cfg: Config{ProjectID: "adtech", Topics: []Topic{{ID: "impressions", Subscriptions: []Subscription{
+				{ID: "impressions-billing", DeadLetterTopic: "googdead", DeadLetterSubscription: "googdead-inspect"},
+			}}}},
+			want: "adtech,impressions:impressions-billing;dlq=x-googdead;dlqsub=x-googdead-inspect",
+			renamed: []string{
+				"dead-letter topic googdead -> x-googdead",
+				"dead-letter subscription googdead-inspect -> x-googdead-inspect",
+			},
+		},
+		{
+			name: "fully qualified names",
+			cfg: Config{ProjectID: "adtech", Topics: []Topic{{ID: "projects/shared/topics/goog-clicks", Subscriptions: []Subscription{
+				{ID: "clicks-counter", DeadLetterTopic: "projects/shared/topics/goog-dead"},
+			}}}},
+			want: "adtech,projects/shared/topics/x-goog-clicks:clicks-counter;dlq=projects/shared/topics/x-goog-dead",
+			renamed: []string{
+				"topic goog-clicks -> x-goog-clicks",
+				"dead-letter topic goog-dead -> x-goog-dead",
+			},
+		},
+		{
+			name:   "after a reserved prefix",
+			cfg:    Config{ProjectID: "adtech", Topics: []Topic{{ID: "bids", Subscriptions: []Subscription{{ID: "bids-ranker", DeadLetterTopic: "bids-dead"}}}}},
+			prefix: "goog-",
+			want:   "adtech,x-goog-bids:x-goog-bids-ranker;dlq=x-goog-bids-dead",
+			renamed: []string{
+				"topic goog-bids -> x-goog-bids",
+				"subscription goog-bids-ranker -> x-goog-bids-ranker",
+				"dead-letter topic goog-bids-dead -> x-goog-bids-dead",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg.WithPrefix(tt.prefix)
+			before := FormatConfig(cfg)
+
+			var renamed []string
+			sanitized := cfg.SanitizeNames(func(kind, from, to string) {
+				renamed = append(renamed, kind+" "+from+" -> "+to)
+			})
+
+			if got := FormatConfig(sanitized); got != tt.want {
+				t.Errorf("expected the config %q, got %q", tt.want, got)
+			}
+			if !slices.Equal(renamed, tt.renamed) {
+				t.Errorf("expected the renames %q, got %q", tt.renamed, renamed)
+			}
+			if err := CheckNames(sanitized); err != nil {
+				t.Errorf("expected the sanitized names to be valid, got %s", err)
+			}
+
+			// The config itself is left as is.
+			if got := FormatConfig(cfg); got != before {
+				t.Errorf("expected the config to be unchanged, got %q instead of %q", got, before)
+			}
+		})
+	}
+}
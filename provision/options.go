@@ -0,0 +1,151 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Option describes a topic or subscription option.
+type Option struct {
+	Key string `json:"key"`
+
+	// Value is the format of the value of the option.
+	Value string `json:"value"`
+
+	// Constraints are the valid values of the option and the options that
+	// it requires or excludes, if any.
+	Constraints string `json:"constraints,omitempty"`
+}
+
+// topicOptionInfo and subscriptionOptionInfo describe the valid options of
+// topics and subscriptions, in key order.
+var (
+	topicOptionInfo = []Option{
+		{Key: "encoding", Value: "json|binary", Constraints: "requires schema"},
+		{Key: "iamfile", Value: "<file>", Constraints: "JSON encoded IAM policy"},
+		{Key: "ingest", Value: "kinesis;<key>=<value>;... or gcs;<key>=<value>;...", Constraints: "kinesis requires stream, consumer, role and sa; gcs requires bucket, and takes format=text|avro|pubsubavro, delimiter and match"},
+		{Key: "kms", Value: "projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>"},
+		{Key: "labels", Value: "key1:value1;key2:value2", Constraints: "lowercase keys of at most 63 characters that start with a letter"},
+		{Key: "regions", Value: "region1;region2"},
+		{Key: "retain", Value: "<duration>", Constraints: "10m to 31d"},
+		{Key: "schema", Value: "<schema> or projects/<project>/schemas/<schema>"},
+		{Key: "seed", Value: "<file>", Constraints: "one JSON encoded seed message per line"},
+		{Key: "seedattrs", Value: "key1:value1;key2:value2"},
+		{Key: "seedcount", Value: "<count>", Constraints: "1 to 1000000, requires seed or seedcsv, expands {i} and {ts} in the seed messages"},
+		{Key: "seedcsv", Value: "<file>", Constraints: "a header row, and a row per seed message with the data in the first column"},
+		{Key: "seeddefaultkey", Value: "<ordering key>"},
+		{Key: "seedorderkey", Value: "attr:<attribute> or roundrobin:<count>"},
+	}
+
+	subscriptionOptionInfo = []Option{
+		{Key: "ack", Value: "<duration>", Constraints: "10s to 600s"},
+		{Key: "bqdropunknown", Value: "true|false", Constraints: "requires bqtable"},
+		{Key: "bqtable", Value: "<project>.<dataset>.<table>", Constraints: "excludes gcsbucket"},
+		{Key: "dlq", Value: "<topic> or projects/<project>/topics/<topic>", Constraints: "must differ from the topic of the subscription"},
+		{Key: "dlqsub", Value: "<subscription>", Constraints: "requires dlq, must differ from the subscription"},
+		{Key: "dlqsubnoexpire", Value: "true|false", Constraints: "requires dlqsub"},
+		{Key: "dlqsubretain", Value: "<duration>|min|max", Constraints: "10m to 7d, requires dlqsub"},
+		{Key: "enabled", Value: "true|false"},
+		{Key: "exactlyonce", Value: "true|false"},
+		{Key: "expire", Value: "<duration>|never", Constraints: "at least 1d"},
+		{Key: "filter", Value: "<filter expression>", Constraints: "excludes filterfile"},
+		{Key: "filterfile", Value: "<file>", Constraints: "a filter expression, excludes filter"},
+		{Key: "gcsbucket", Value: "<bucket>", Constraints: "excludes bqtable"},
+		{Key: "gcsmaxbytes", Value: "<size>", Constraints: "1KB to 10GiB, requires gcsbucket"},
+		{Key: "gcsmaxduration", Value: "<duration>", Constraints: "1m to 10m, requires gcsbucket"},
+		{Key: "iamfile", Value: "<file>", Constraints: "JSON encoded IAM policy"},
+		{Key: "labels", Value: `"key1:value1;key2:value2"`, Constraints: "quoted, lowercase keys of at most 63 characters that start with a letter"},
+		{Key: "maxdelivery", Value: "<count>", Constraints: "5 to 100"},
+		{Key: "maxextension", Value: "<duration>", Constraints: "10s to 600s, at least minextension"},
+		{Key: "minextension", Value: "<duration>", Constraints: "10s to 600s"},
+		{Key: "ordered", Value: "true|false"},
+		{Key: "push", Value: `"<http(s) URL>"`, Constraints: "quoted"},
+		{Key: "pushsa", Value: "<email>", Constraints: "requires push"},
+		{Key: "retain", Value: "<duration>|min|max", Constraints: "10m to 7d"},
+		{Key: "retainacked", Value: "true|false"},
+		{Key: "retrymax", Value: "<duration>", Constraints: "0s to 600s, at least retrymin"},
+		{Key: "retrymin", Value: "<duration>", Constraints: "0s to 600s"},
+		{Key: "retrypreset", Value: "aggressive|lenient|<PUBSUB_RETRY_PRESET_<NAME> preset>"},
+		{Key: "transform", Value: "<file>", Constraints: "JavaScript UDF"},
+	}
+)
+
+// topicOptionKeys and subscriptionOptionKeys are the valid option keys of
+// topics and subscriptions.
+var (
+	topicOptionKeys        = optionKeys(topicOptionInfo)
+	subscriptionOptionKeys = optionKeys(subscriptionOptionInfo)
+)
+
+// TopicOptions and SubscriptionOptions return the valid options of topics and
+// subscriptions, in key order.
+func TopicOptions() []Option        { return append([]Option(nil), topicOptionInfo...) }
+func SubscriptionOptions() []Option { return append([]Option(nil), subscriptionOptionInfo...) }
+
+// optionKeys returns the keys of the options.
+func optionKeys(options []Option) []string {
+	keys := make([]string, 0, len(options))
+	for _, option := range options {
+		keys = append(keys, option.Key)
+	}
+
+	return keys
+}
+
+// CheckSubscriptionOption returns an error if the key is not a valid
+// subscription option.
+func CheckSubscriptionOption(key string) error {
+	return checkOptionKey(key, subscriptionOptionKeys)
+}
+
+// checkOptionKey returns an error if the key is not one of the valid keys,
+// which suggests the valid key that is closest to it.
+func checkOptionKey(key string, keys []string) error {
+	var (
+		closest  string
+		distance = -1
+	)
+
+	for _, valid := range keys {
+		if key == valid {
+			return nil
+		}
+
+		if d := levenshtein(key, valid); distance < 0 || d < distance {
+			closest, distance = valid, d
+		}
+	}
+
+	// Only suggest keys that don't need to be retyped altogether.
+	if distance > 0 && distance <= (len(closest)+1)/2 {
+		return fmt.Errorf("unknown option %q, did you mean %q?", key, closest)
+	}
+
+	return fmt.Errorf("unknown option %q, expected one of %s", key, strings.Join(keys, ", "))
+}
+
+// levenshtein returns the number of single character insertions, deletions
+// and substitutions that change a into b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
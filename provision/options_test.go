@@ -0,0 +1,194 @@
+package provision
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "ack", b: "ack", want: 0},
+		{a: "", b: "dlq", want: 3},
+		{a: "retain", b: "", want: 6},
+		{a: "akc", b: "ack", want: 2},
+		{a: "filtr", b: "filter", want: 1},
+		{a: "ordred", b: "ordered", want: 1},
+		{a: "kitten", b: "sitting", want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := levenshtein(tt.a, tt.b); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+			if got := levenshtein(tt.b, tt.a); got != tt.want {
+				t.Errorf("expected %d for the reverse, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseUnknownOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		err        string
+	}{
+		{
+			name:       "swapped subscription key",
+			definition: "project1,invoices:invoices-mailer;akc=30s",
+			err:        `Subscription "invoices-mailer": unknown option "akc", did you mean "ack"?`,
+		},
+		{
+			name:       "subscription key missing a letter",
+			definition: "project1,invoices:invoices-mailer;maxdelivry=10",
+			err:        `did you mean "maxdelivery"?`,
+		},
+		{
+			name:       "misspelled topic key",
+			definition: "project1,audit[retian=24h]:audit-archive",
+			err:        `Topic "audit": unknown option "retian", did you mean "retain"?`,
+		},
+		{
+			name:       "unrelated topic key",
+			definition: "project1,audit[colour=blue]",
+			err:        `Topic "audit": unknown option "colour", expected one of encoding,`,
+		},
+		{
+			name:       "unrelated subscription key",
+			definition: "project1,invoices:invoices-mailer;zz=1",
+			err:        `unknown option "zz", expected one of ack,`,
+		},
+		{
+			name:       "topic key on a subscription",
+			definition: "project1,invoices:invoices-mailer;seedcsv=seeds.csv",
+			err:        `Subscription "invoices-mailer": unknown option "seedcsv"`,
+		},
+		{
+			name:       "valid keys",
+			definition: "project1,audit[retain=24h]:audit-archive;ack=30s;ordered",
+		},
+		{
+			name:       "trailing separator",
+			definition: "project1,audit:audit-archive;ack=30s;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&Parser{}).Parse(tt.definition)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCheckSubscriptionOption(t *testing.T) {
+	for _, option := range SubscriptionOptions() {
+		if err := CheckSubscriptionOption(option.Key); err != nil {
+			t.Errorf("%s: unexpected error: %s", option.Key, err)
+		}
+	}
+
+	for _, option := range TopicOptions() {
+		if err := checkOptionKey(option.Key, topicOptionKeys); err != nil {
+			t.Errorf("%s: unexpected error: %s", option.Key, err)
+		}
+	}
+
+	if err := CheckSubscriptionOption("exactlyone"); err == nil || !strings.Contains(err.Error(), `did you mean "exactlyonce"?`) {
+		t.Errorf("expected a suggestion for exactlyonce, got %v", err)
+	}
+}
+
+// parsedOptionKeys returns the option keys that the switch statements on the
+// key of the specified Parser method in config.go handle.
+func parsedOptionKeys(t *testing.T, method string) []string {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "config.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != method {
+			continue
+		}
+
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			s, ok := n.(*ast.SwitchStmt)
+			if !ok {
+				return true
+			}
+			if tag, ok := s.Tag.(*ast.Ident); !ok || tag.Name != "key" {
+				return true
+			}
+
+			for _, stmt := range s.Body.List {
+				for _, expr := range stmt.(*ast.CaseClause).List {
+					if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						key, err := strconv.Unquote(lit.Value)
+						if err != nil {
+							t.Fatal(err)
+						}
+						keys = append(keys, key)
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+func TestOptionsMatchParser(t *testing.T) {
+	tests := []struct {
+		method  string
+		options []Option
+	}{
+		{method: "parseTopic", options: TopicOptions()},
+		{method: "parseSubscription", options: SubscriptionOptions()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			parsed := parsedOptionKeys(t, tt.method)
+			if len(parsed) == 0 {
+				t.Fatalf("no option keys found in %s", tt.method)
+			}
+
+			keys := optionKeys(tt.options)
+			if !slices.IsSorted(keys) {
+				t.Errorf("expected the options in key order, got %q", keys)
+			}
+			for _, key := range parsed {
+				if !slices.Contains(keys, key) {
+					t.Errorf("option %q is parsed, but not described", key)
+				}
+			}
+			for _, key := range keys {
+				if !slices.Contains(parsed, key) {
+					t.Errorf("option %q is described, but not parsed", key)
+				}
+			}
+		})
+	}
+}
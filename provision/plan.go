@@ -0,0 +1,47 @@
+package provision
+
+import "fmt"
+
+// step is a single topic or subscription to create.
+type step struct {
+	project Config
+	topic   Topic
+
+	// subscription is nil for the step that creates the topic.
+	subscription *Subscription
+}
+
+// name returns the fully qualified name of the resource that the step
+// creates.
+func (s step) name() string {
+	if s.subscription != nil {
+		return fmt.Sprintf("projects/%s/subscriptions/%s", s.project.ProjectID, s.subscription.ID)
+	}
+
+	return s.topic.name(s.project.ProjectID)
+}
+
+// planSteps returns the steps that create the topics of all projects, followed
+// by the steps that create their subscriptions, in the configured order.
+// Subscriptions only depend on topics, including the dead-letter topics in
+// other topics or projects, so every resource is created after the configured
+// resources it depends on. Topics in other projects have no step of their own.
+func planSteps(projects []Config) []step {
+	var steps []step
+	for _, project := range projects {
+		for _, topic := range project.Topics {
+			if !topic.external() {
+				steps = append(steps, step{project: project, topic: topic})
+			}
+		}
+	}
+	for _, project := range projects {
+		for _, topic := range project.Topics {
+			for i := range topic.Subscriptions {
+				steps = append(steps, step{project: project, topic: topic, subscription: &topic.Subscriptions[i]})
+			}
+		}
+	}
+
+	return steps
+}
@@ -0,0 +1,72 @@
+package provision
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlanSteps(t *testing.T) {
+	tests := []struct {
+		name     string
+		projects []string
+		want     []string
+	}{
+		{
+			name:     "single project",
+			projects: []string{"media,uploads:thumbnailer:transcoder,deletions"},
+			want: []string{
+				"projects/media/topics/uploads",
+				"projects/media/topics/deletions",
+				"projects/media/subscriptions/thumbnailer",
+				"projects/media/subscriptions/transcoder",
+			},
+		},
+		{
+			name:     "topics of all projects first",
+			projects: []string{"media,uploads:thumbnailer", "archive,snapshots:snapshot-pruner"},
+			want: []string{
+				"projects/media/topics/uploads",
+				"projects/archive/topics/snapshots",
+				"projects/media/subscriptions/thumbnailer",
+				"projects/archive/subscriptions/snapshot-pruner",
+			},
+		},
+		{
+			name:     "dead-letter topic defined later",
+			projects: []string{"media,uploads:thumbnailer;dlq=uploads-dead,uploads-dead"},
+			want: []string{
+				"projects/media/topics/uploads",
+				"projects/media/topics/uploads-dead",
+				"projects/media/subscriptions/thumbnailer",
+			},
+		},
+		{
+			name:     "topic in another project",
+			projects: []string{"media,projects/core/topics/events:media-events"},
+			want: []string{
+				"projects/media/subscriptions/media-events",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var projects []Config
+			for _, definition := range tt.projects {
+				cfg, err := (&Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatal(err)
+				}
+				projects = append(projects, cfg)
+			}
+
+			var got []string
+			for _, s := range planSteps(projects) {
+				got = append(got, s.name())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected steps\n%q\ngot\n%q", tt.want, got)
+			}
+		})
+	}
+}
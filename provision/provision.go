@@ -0,0 +1,362 @@
+// Package provision creates the topics and subscriptions of PubSub projects,
+// which is the logic behind the pubsubc command. It can be used directly, for
+// example to provision the PubSub emulator or a pstest server from TestMain:
+//
+//	srv := pstest.NewServer()
+//	conn, _ := grpc.Dial(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	client, _ := pubsub.NewClient(ctx, "test", option.WithGRPCConn(conn))
+//
+//	cfg, err := provision.ParseConfig("test,orders:orders-worker;ack=30s")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := provision.Create(ctx, client, cfg); err != nil {
+//		log.Fatal(err)
+//	}
+package provision
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Logger receives the progress messages of a Provisioner.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// Clients hands out the PubSub client for a project.
+type Clients interface {
+	Client(ctx context.Context, projectID string) (*pubsub.Client, error)
+}
+
+// Provisioner creates and deletes the topics and subscriptions of configs.
+type Provisioner struct {
+	Clients Clients
+
+	// Logger receives the progress messages, or is nil to discard them.
+	Logger Logger
+
+	// SkipTopics uses existing topics instead of creating them.
+	SkipTopics bool
+
+	// Ensure only creates or updates the topics and subscriptions whose
+	// config changed since they were created.
+	Ensure bool
+
+	// SkipExistingTopics and SkipExistingSubscriptions use the topics and
+	// subscriptions that already exist, instead of failing.
+	SkipExistingTopics        bool
+	SkipExistingSubscriptions bool
+
+	// Strict fails if a configured option has no effect on the emulator.
+	Strict bool
+
+	// StrictFilters warns about subscription filters that refer to attributes
+	// which are not fields of the schema of their topic.
+	StrictFilters bool
+
+	// CreateDeadLetterProjects creates dead-letter topics in projects that
+	// are not configured.
+	CreateDeadLetterProjects bool
+
+	// ProjectConcurrency is the maximum number of projects whose topics and
+	// subscriptions are created concurrently, and ResourceConcurrency the
+	// maximum number of topics or subscriptions of each of those projects.
+	// Both default to 1, which creates the resources in order.
+	ProjectConcurrency  int
+	ResourceConcurrency int
+
+	// SeedConcurrency is the maximum number of seed messages to publish
+	// concurrently. It defaults to 1.
+	SeedConcurrency int
+
+	// VerifyRetention checks that the seed messages of the topics that retain
+	// messages can be replayed, with a temporary subscription that is seeked
+	// to the start of the retention.
+	VerifyRetention bool
+
+	// VerifyOrder checks that the seed messages with an ordering key are
+	// delivered in order, with a temporary ordered subscription. It waits at
+	// most VerifyOrderTimeout for VerifyOrderCount of them, which defaults to
+	// all of them.
+	VerifyOrder        bool
+	VerifyOrderTimeout time.Duration
+	VerifyOrderCount   int
+
+	// ReceiveSettings configure the subscribers that verify messages. The
+	// MaxOutstandingMessages and NumGoroutines default to 100 and 1, so that
+	// verification doesn't overwhelm a small emulator.
+	ReceiveSettings pubsub.ReceiveSettings
+
+	// SeedBestEffort skips the seed messages that fail to publish, instead of
+	// failing.
+	SeedBestEffort bool
+
+	// SeedOrphanTopicsOnly only publishes the seed messages of topics without
+	// subscriptions, other than disabled ones, so that they are retained
+	// instead of being consumed right away.
+	SeedOrphanTopicsOnly bool
+
+	// OpTimeout bounds every single create operation, if it is set.
+	OpTimeout time.Duration
+
+	// Retries is the number of times that a create operation is retried if it
+	// times out or fails with a transient error. The Backoff defaults to an
+	// exponential backoff with jitter from 250ms up to 5s.
+	Retries int
+	Backoff Backoff
+
+	// ContinueOnError keeps creating the other topics and subscriptions when
+	// one fails to be created, along with the subscriptions of a topic that
+	// failed. Create then returns a *PartialError, which tells the timeouts
+	// apart from the other failures.
+	ContinueOnError bool
+
+	// RollbackOnError deletes the resources that Create created if it fails,
+	// so that the next run starts clean.
+	RollbackOnError bool
+
+	// OnRollback, if set, is called with every created resource that
+	// RollbackOnError deleted.
+	OnRollback func(r Resource)
+
+	// OnSkip and OnUpdate, if set, are called with every existing resource
+	// that Create uses as is or updates instead of creating it. They may be
+	// called concurrently.
+	OnSkip   func(r Resource)
+	OnUpdate func(r Resource)
+
+	// OnOperation, if set, is called with the duration of every create or
+	// update operation, including its retries. It may be called
+	// concurrently.
+	OnOperation func(d time.Duration)
+
+	// Labels are applied to every topic and subscription that is created,
+	// unless the topic or subscription defines a label with the same key.
+	Labels map[string]string
+
+	// RunID is stored in a label of every topic and subscription that is
+	// created, so that DeleteRun can delete them.
+	RunID string
+
+	// SeedState records the seed messages that were published, so that only
+	// new seed messages are published. If nil, all seed messages are.
+	SeedState *SeedState
+}
+
+// Create the topics and subscriptions of the specified configs in dependency
+// order, and publish the seed messages once they all exist. It returns the
+// resources that it created. If it fails with RollbackOnError set, it returns
+// the created resources that it failed to roll back.
+func (p *Provisioner) Create(ctx context.Context, configs []Config) ([]Resource, error) {
+	if err := validateLabels(p.Labels); err != nil {
+		return nil, fmt.Errorf("Labels: %s", err)
+	}
+
+	c := &creator{
+		Provisioner: p,
+		configured:  make(map[string]bool),
+	}
+
+	for _, cfg := range configs {
+		c.configured[cfg.ProjectID] = true
+	}
+
+	ctx, span := startSpan(ctx, "pubsubc.create")
+	err := c.create(ctx, configs)
+	if err == nil && len(c.failures) > 0 {
+		err = &PartialError{Failures: c.failures}
+	}
+	endSpan(span, err)
+
+	if err != nil && p.RollbackOnError && len(c.created) > 0 {
+		return c.rollback(ctx), err
+	}
+
+	return c.created, err
+}
+
+// Delete the topics and subscriptions of the specified configs. Resources that
+// don't exist are skipped.
+func (p *Provisioner) Delete(ctx context.Context, configs []Config) error {
+	for _, cfg := range configs {
+		client, err := p.Clients.Client(ctx, cfg.ProjectID)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range cfg.Topics {
+			for _, s := range t.Subscriptions {
+				p.debugf("    Deleting subscription %q", s.ID)
+				if err := p.deleted(client.Subscription(s.ID).Delete(ctx), subscriptionResource(cfg.ProjectID, s.ID)); err != nil {
+					return fmt.Errorf("Unable to delete subscription %q for project %q: %s", s.ID, cfg.ProjectID, err)
+				}
+			}
+		}
+
+		// Topics in other projects are not deleted with their subscriptions.
+		for _, t := range cfg.Topics {
+			if t.external() {
+				continue
+			}
+
+			p.debugf("  Deleting topic %q", t.ID)
+			if err := p.deleted(client.Topic(t.ID).Delete(ctx), topicResource(cfg.ProjectID, t.ID)); err != nil {
+				return fmt.Errorf("Unable to delete topic %q for project %q: %s", t.ID, cfg.ProjectID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Deletions returns the resources that Delete deletes for the specified
+// configs, in the order that it deletes them.
+func Deletions(configs []Config) []Resource {
+	var resources []Resource
+	for _, cfg := range configs {
+		for _, t := range cfg.Topics {
+			for _, s := range t.Subscriptions {
+				resources = append(resources, subscriptionResource(cfg.ProjectID, s.ID))
+			}
+		}
+
+		for _, t := range cfg.Topics {
+			if !t.external() {
+				resources = append(resources, topicResource(cfg.ProjectID, t.ID))
+			}
+		}
+	}
+
+	return resources
+}
+
+// DeleteRun deletes the topics and subscriptions of the specified projects
+// that were created by the run with the specified ID.
+func (p *Provisioner) DeleteRun(ctx context.Context, projectIDs []string, runID string) error {
+	for _, projectID := range projectIDs {
+		client, err := p.Clients.Client(ctx, projectID)
+		if err != nil {
+			return err
+		}
+
+		subscriptions, err := listSubscriptions(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		for _, cfg := range subscriptions {
+			if cfg.Labels[RunIDLabel] != runID {
+				continue
+			}
+
+			p.debugf("    Deleting subscription %q", cfg.ID())
+			if err := p.deleted(client.Subscription(cfg.ID()).Delete(ctx), subscriptionResource(projectID, cfg.ID())); err != nil {
+				return fmt.Errorf("Unable to delete subscription %q for project %q: %s", cfg.ID(), projectID, err)
+			}
+		}
+
+		topics, err := listTopics(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		for _, cfg := range topics {
+			if cfg.Labels[RunIDLabel] != runID {
+				continue
+			}
+
+			p.debugf("  Deleting topic %q", cfg.ID())
+			if err := p.deleted(client.Topic(cfg.ID()).Delete(ctx), topicResource(projectID, cfg.ID())); err != nil {
+				return fmt.Errorf("Unable to delete topic %q for project %q: %s", cfg.ID(), projectID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleted returns the error of deleting the resource, unless the resource
+// didn't exist, and reports the deletion if it succeeded.
+func (p *Provisioner) deleted(err error, r Resource) error {
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return nil
+	case err != nil:
+		return err
+	}
+
+	p.event("delete", r)
+	return nil
+}
+
+func (p *Provisioner) debugf(format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Debugf(format, args...)
+	}
+}
+
+func (p *Provisioner) infof(format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Infof(format, args...)
+	}
+}
+
+func (p *Provisioner) warnf(format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Warnf(format, args...)
+	}
+}
+
+// Create the topics and subscriptions of the config with the client. The
+// project ID of the config defaults to the project of the client.
+func Create(ctx context.Context, client *pubsub.Client, cfg Config) error {
+	p := &Provisioner{Clients: singleClient{client}}
+	_, err := p.Create(ctx, []Config{withClientProject(client, cfg)})
+	return err
+}
+
+// Ensure creates or updates the topics and subscriptions of the config whose
+// config changed since they were created.
+func Ensure(ctx context.Context, client *pubsub.Client, cfg Config) error {
+	p := &Provisioner{Clients: singleClient{client}, Ensure: true}
+	_, err := p.Create(ctx, []Config{withClientProject(client, cfg)})
+	return err
+}
+
+// Delete the topics and subscriptions of the config with the client.
+func Delete(ctx context.Context, client *pubsub.Client, cfg Config) error {
+	p := &Provisioner{Clients: singleClient{client}}
+	return p.Delete(ctx, []Config{withClientProject(client, cfg)})
+}
+
+// withClientProject defaults the project ID of the config to the project of
+// the client.
+func withClientProject(client *pubsub.Client, cfg Config) Config {
+	if cfg.ProjectID == "" {
+		cfg.ProjectID = client.Project()
+	}
+
+	return cfg
+}
+
+// singleClient hands out a single client for its own project.
+type singleClient struct {
+	client *pubsub.Client
+}
+
+func (s singleClient) Client(ctx context.Context, projectID string) (*pubsub.Client, error) {
+	if projectID != s.client.Project() {
+		return nil, fmt.Errorf("No client for project %q, the client is for project %q", projectID, s.client.Project())
+	}
+
+	return s.client, nil
+}
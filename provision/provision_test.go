@@ -0,0 +1,152 @@
+package provision
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCreateEnsureDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{
+			name:   "project of the client",
+			config: Config{Topics: []Topic{{ID: "builds", Subscriptions: []Subscription{{ID: "builds-notifier"}}}}},
+		},
+		{
+			name:   "same project",
+			config: Config{ProjectID: "project1", Topics: []Topic{{ID: "builds"}, {ID: "deploys", Subscriptions: []Subscription{{ID: "deploys-audit"}}}}},
+		},
+		{
+			name:   "other project",
+			config: Config{ProjectID: "project2", Topics: []Topic{{ID: "builds"}}},
+			err:    `No client for project "project2", the client is for project "project1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newTestProvisioner(t)
+			ctx := context.Background()
+
+			err := Create(ctx, client, tt.config)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Create: unexpected error: %s", err)
+			}
+
+			// Ensure leaves the created resources alone, where Create fails
+			// because they already exist.
+			if err := Ensure(ctx, client, tt.config); err != nil {
+				t.Fatalf("Ensure: unexpected error: %s", err)
+			}
+			if err := Create(ctx, client, tt.config); err == nil || !strings.Contains(err.Error(), "AlreadyExists") {
+				t.Fatalf("Create: expected the resources to exist, got %v", err)
+			}
+
+			if err := Delete(ctx, client, tt.config); err != nil {
+				t.Fatalf("Delete: unexpected error: %s", err)
+			}
+			live, err := Live(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(live.Topics) != 0 {
+				t.Errorf("expected all topics to be deleted, got %+v", live.Topics)
+			}
+		})
+	}
+}
+
+func TestDeleteRun(t *testing.T) {
+	// Every run creates its own resources, and the last one has no run ID.
+	runs := []struct {
+		runID      string
+		definition string
+	}{
+		{runID: "nightly-1", definition: "project1,orders:picking:packing"},
+		{runID: "nightly-2", definition: "project1,returns:refunds"},
+		{definition: "project1,ledger:archive"},
+	}
+
+	tests := []struct {
+		name      string
+		runID     string
+		remaining []string
+	}{
+		{
+			name:      "first run",
+			runID:     "nightly-1",
+			remaining: []string{"subscription archive", "subscription refunds", "topic ledger", "topic returns"},
+		},
+		{
+			name:      "second run",
+			runID:     "nightly-2",
+			remaining: []string{"subscription archive", "subscription packing", "subscription picking", "topic ledger", "topic orders"},
+		},
+		{
+			name:      "unknown run",
+			runID:     "nightly-3",
+			remaining: []string{"subscription archive", "subscription packing", "subscription picking", "subscription refunds", "topic ledger", "topic orders", "topic returns"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			ctx := context.Background()
+
+			for _, run := range runs {
+				p.RunID = run.runID
+				if err := create(t, p, run.definition); err != nil {
+					t.Fatalf("unable to create run %q: %s", run.runID, err)
+				}
+			}
+
+			// The resources of a run are labeled with its ID.
+			cfg, err := client.Subscription("refunds").Config(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runID := cfg.Labels[RunIDLabel]; runID != "nightly-2" {
+				t.Errorf("expected subscription %q to be labeled with run %q, got %q", "refunds", "nightly-2", runID)
+			}
+
+			if err := p.DeleteRun(ctx, []string{"project1"}, tt.runID); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var remaining []string
+			topics := client.Topics(ctx)
+			for {
+				topic, err := topics.Next()
+				if err != nil {
+					break
+				}
+				remaining = append(remaining, "topic "+topic.ID())
+			}
+			subscriptions := client.Subscriptions(ctx)
+			for {
+				sub, err := subscriptions.Next()
+				if err != nil {
+					break
+				}
+				remaining = append(remaining, "subscription "+sub.ID())
+			}
+
+			slices.Sort(remaining)
+			if !slices.Equal(remaining, tt.remaining) {
+				t.Errorf("expected the remaining resources %q, got %q", tt.remaining, remaining)
+			}
+		})
+	}
+}
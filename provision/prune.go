@@ -0,0 +1,138 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PruneScope limits Prunable to the live resources whose ID starts with
+// Prefix, or that have the label LabelKey with the value LabelValue, so that
+// pruning leaves the resources of others alone.
+type PruneScope struct {
+	Prefix     string
+	LabelKey   string
+	LabelValue string
+}
+
+// ParsePruneScope parses a prune scope of the form prefix:<prefix> or
+// label:<key>=<value>.
+func ParsePruneScope(value string) (PruneScope, error) {
+	kind, arg, _ := strings.Cut(value, ":")
+	switch kind {
+	case "prefix":
+		if arg == "" {
+			return PruneScope{}, fmt.Errorf("the prefix must not be empty")
+		}
+
+		return PruneScope{Prefix: arg}, nil
+
+	case "label":
+		key, val, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return PruneScope{}, fmt.Errorf("expected label:<key>=<value>, got %q", value)
+		}
+
+		return PruneScope{LabelKey: key, LabelValue: val}, nil
+	}
+
+	return PruneScope{}, fmt.Errorf("expected prefix:<prefix> or label:<key>=<value>, got %q", value)
+}
+
+// matches reports whether a live resource with the ID and labels is within
+// the scope.
+func (s PruneScope) matches(id string, labels map[string]string) bool {
+	if s.LabelKey != "" {
+		val, ok := labels[s.LabelKey]
+		return ok && val == s.LabelValue
+	}
+
+	return s.Prefix != "" && strings.HasPrefix(id, s.Prefix)
+}
+
+// Prunable returns the live topics and subscriptions of the projects of the
+// configs that are within the scope but not in the configs, with the
+// subscriptions first, in the order that DeleteResources should delete them.
+// The dead-letter topics and subscriptions of the configs are kept.
+func (p *Provisioner) Prunable(ctx context.Context, configs []Config, scope PruneScope) ([]Resource, error) {
+	keep := make(map[string]bool)
+	for _, cfg := range configs {
+		for _, r := range Deletions([]Config{cfg}) {
+			keep[r.Name] = true
+		}
+
+		for _, t := range cfg.Topics {
+			for _, s := range t.Subscriptions {
+				if s.DeadLetterTopic == "" {
+					continue
+				}
+
+				projectID, topicID := splitTopicName(cfg.ProjectID, s.DeadLetterTopic)
+				keep[topicResource(projectID, topicID).Name] = true
+				if s.DeadLetterSubscription != "" {
+					keep[subscriptionResource(projectID, s.DeadLetterSubscription).Name] = true
+				}
+			}
+		}
+	}
+
+	var subscriptions, topics []Resource
+	for _, cfg := range configs {
+		client, err := p.Clients.Client(ctx, cfg.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+
+		liveSubscriptions, err := listSubscriptions(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range liveSubscriptions {
+			if r := subscriptionResource(cfg.ProjectID, s.ID()); !keep[r.Name] && scope.matches(s.ID(), s.Labels) {
+				subscriptions = append(subscriptions, r)
+			}
+		}
+
+		liveTopics, err := listTopics(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range liveTopics {
+			if r := topicResource(cfg.ProjectID, t.ID()); !keep[r.Name] && scope.matches(t.ID(), t.Labels) {
+				topics = append(topics, r)
+			}
+		}
+	}
+
+	return append(subscriptions, topics...), nil
+}
+
+// DeleteResources deletes the topics and subscriptions, in order. Resources
+// that don't exist are skipped.
+func (p *Provisioner) DeleteResources(ctx context.Context, resources []Resource) error {
+	for _, r := range resources {
+		client, err := p.Clients.Client(ctx, r.Project)
+		if err != nil {
+			return err
+		}
+
+		id := r.Name[strings.LastIndexByte(r.Name, '/')+1:]
+		if r.Type == "topic" {
+			p.debugf("  Deleting topic %q", id)
+			if err := p.deleted(client.Topic(id).Delete(ctx), r); err != nil {
+				return fmt.Errorf("Unable to delete topic %q for project %q: %s", id, r.Project, err)
+			}
+
+			continue
+		}
+
+		p.debugf("    Deleting subscription %q", id)
+		if err := p.deleted(client.Subscription(id).Delete(ctx), r); err != nil {
+			return fmt.Errorf("Unable to delete subscription %q for project %q: %s", id, r.Project, err)
+		}
+	}
+
+	return nil
+}
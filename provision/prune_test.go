@@ -0,0 +1,189 @@
+package provision
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePruneScope(t *testing.T) {
+	tests := []struct {
+		value string
+		want  PruneScope
+		err   string
+	}{
+		{value: "prefix:ci-", want: PruneScope{Prefix: "ci-"}},
+		{value: "prefix:pr-42:", want: PruneScope{Prefix: "pr-42:"}},
+		{value: "label:owner=pubsubc", want: PruneScope{LabelKey: "owner", LabelValue: "pubsubc"}},
+		{value: "label:ephemeral=", want: PruneScope{LabelKey: "ephemeral"}},
+		{value: "prefix:", err: "the prefix must not be empty"},
+		{value: "label:owner", err: `expected label:<key>=<value>, got "label:owner"`},
+		{value: "label:=pubsubc", err: `expected label:<key>=<value>, got "label:=pubsubc"`},
+		{value: "ci-", err: `expected prefix:<prefix> or label:<key>=<value>, got "ci-"`},
+		{value: "suffix:-tmp", err: `expected prefix:<prefix> or label:<key>=<value>, got "suffix:-tmp"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			scope, err := ParsePruneScope(tt.value)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected the error %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case scope != tt.want:
+				t.Errorf("expected the scope %+v, got %+v", tt.want, scope)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name   string
+		live   string
+		config string
+		scope  PruneScope
+		// prunable are the IDs of the resources that are pruned.
+		prunable []string
+		// remaining are the formatted live topics and subscriptions after
+		// pruning.
+		remaining string
+	}{
+		{
+			name:      "prefix",
+			live:      "project1,ci-orders:ci-orders-audit:ci-orders-legacy,ci-tmp:ci-tmp-dump,shared-events:ci-events-tap",
+			config:    "project1,ci-orders:ci-orders-audit",
+			scope:     PruneScope{Prefix: "ci-"},
+			prunable:  []string{"ci-orders-legacy", "ci-tmp-dump", "ci-events-tap", "ci-tmp"},
+			remaining: "project1,ci-orders:ci-orders-audit,shared-events",
+		},
+		{
+			name:      "label",
+			live:      `project1,jobs[labels=owner:pubsubc]:jobs-runner;labels="owner:pubsubc":jobs-debug;labels="owner:pubsubc",reports[labels=owner:analytics]:reports-mailer,scratch[labels=owner:pubsubc]`,
+			config:    "project1,jobs:jobs-runner",
+			scope:     PruneScope{LabelKey: "owner", LabelValue: "pubsubc"},
+			prunable:  []string{"jobs-debug", "scratch"},
+			remaining: "project1,jobs:jobs-runner,reports:reports-mailer",
+		},
+		{
+			name:      "label value must match",
+			live:      `project1,jobs:jobs-runner;labels="owner:pubsubc-old",scratch[labels=owner:]`,
+			config:    "project1,jobs",
+			scope:     PruneScope{LabelKey: "owner", LabelValue: "pubsubc"},
+			remaining: "project1,jobs:jobs-runner,scratch",
+		},
+		{
+			name:      "dead-letter resources are kept",
+			live:      "project1,tx-payments:tx-payments-settle;dlq=tx-dead;dlqsub=tx-dead-inspect:tx-payments-old",
+			config:    "project1,tx-payments:tx-payments-settle;dlq=tx-dead;dlqsub=tx-dead-inspect",
+			scope:     PruneScope{Prefix: "tx-"},
+			prunable:  []string{"tx-payments-old"},
+			remaining: "project1,tx-dead:tx-dead-inspect,tx-payments:tx-payments-settle;dlq=tx-dead",
+		},
+		{
+			name:      "in sync",
+			live:      "project1,dev-clicks:dev-clicks-agg",
+			config:    "project1,dev-clicks:dev-clicks-agg",
+			scope:     PruneScope{Prefix: "dev-"},
+			remaining: "project1,dev-clicks:dev-clicks-agg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, client := newTestProvisioner(t)
+			if err := create(t, p, tt.live); err != nil {
+				t.Fatalf("unable to create %q: %s", tt.live, err)
+			}
+
+			cfg, err := (&Parser{}).Parse(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			resources, err := p.Prunable(ctx, []Config{cfg}, tt.scope)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// The subscriptions are pruned before the topics.
+			var got []string
+			for i, r := range resources {
+				if i > 0 && r.Type == "subscription" && resources[i-1].Type == "topic" {
+					t.Errorf("expected the subscriptions before the topics, got %v", resources)
+				}
+				got = append(got, resourceID(r))
+			}
+			gotSorted, wantSorted := slices.Sorted(slices.Values(got)), slices.Sorted(slices.Values(tt.prunable))
+			if !slices.Equal(gotSorted, wantSorted) {
+				t.Fatalf("expected %q to be prunable, got %q", tt.prunable, got)
+			}
+
+			if err := p.DeleteResources(ctx, resources); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			live, err := Live(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := liveIDs(live); got != tt.remaining {
+				t.Errorf("expected the remaining resources %q, got %q", tt.remaining, got)
+			}
+		})
+	}
+}
+
+// liveIDs formats the topics and subscriptions of the live config like a
+// PUBSUB_PROJECT definition, with only the dead-letter topic options.
+func liveIDs(cfg Config) string {
+	topics := slices.Clone(cfg.Topics)
+	slices.SortFunc(topics, func(a, b Topic) int { return strings.Compare(a.ID, b.ID) })
+
+	parts := []string{cfg.ProjectID}
+	for _, topic := range topics {
+		subscriptions := slices.Clone(topic.Subscriptions)
+		slices.SortFunc(subscriptions, func(a, b Subscription) int { return strings.Compare(a.ID, b.ID) })
+
+		part := topic.ID
+		for _, s := range subscriptions {
+			part += ":" + s.ID
+			if s.DeadLetterTopic != "" {
+				part += ";dlq=" + s.DeadLetterTopic[strings.LastIndex(s.DeadLetterTopic, "/")+1:]
+			}
+		}
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func TestDeleteResourcesMissing(t *testing.T) {
+	p, client := newTestProvisioner(t)
+	if err := create(t, p, "project1,exports:exports-s3"); err != nil {
+		t.Fatal(err)
+	}
+
+	resources := []Resource{
+		subscriptionResource("project1", "exports-gone"),
+		subscriptionResource("project1", "exports-s3"),
+		topicResource("project1", "exports-old"),
+		topicResource("project1", "exports"),
+	}
+	if err := p.DeleteResources(context.Background(), resources); err != nil {
+		t.Fatalf("expected the missing resources to be skipped, got %s", err)
+	}
+
+	live, err := Live(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live.Topics) != 0 {
+		t.Errorf("expected every resource to be deleted, got %q", liveIDs(live))
+	}
+}
@@ -0,0 +1,21 @@
+package provision
+
+import "fmt"
+
+// Resource describes a topic or subscription that was created.
+type Resource struct {
+	Type    string `json:"type"`
+	Project string `json:"project"`
+	Name    string `json:"name"`
+}
+
+// topicResource returns the resource of the topic in the specified project.
+func topicResource(projectID, topicID string) Resource {
+	return Resource{Type: "topic", Project: projectID, Name: fmt.Sprintf("projects/%s/topics/%s", projectID, topicID)}
+}
+
+// subscriptionResource returns the resource of the subscription in the
+// specified project.
+func subscriptionResource(projectID, subscriptionID string) Resource {
+	return Resource{Type: "subscription", Project: projectID, Name: fmt.Sprintf("projects/%s/subscriptions/%s", projectID, subscriptionID)}
+}
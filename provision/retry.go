@@ -0,0 +1,58 @@
+package provision
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// retryPreset is a named retry policy.
+type retryPreset struct {
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// retryPresets are the built-in retry presets. A preset can be overridden, or
+// a new one defined, with a PUBSUB_RETRY_PRESET_<NAME>="<min>,<max>"
+// environment variable.
+var retryPresets = map[string]retryPreset{
+	"aggressive": {minBackoff: 1 * time.Second, maxBackoff: 10 * time.Second},
+	"lenient":    {minBackoff: 60 * time.Second, maxBackoff: 600 * time.Second},
+}
+
+// lookupRetryPreset returns the retry preset with the specified name.
+func lookupRetryPreset(name string) (retryPreset, error) {
+	if env := os.Getenv("PUBSUB_RETRY_PRESET_" + strings.ToUpper(name)); env != "" {
+		minValue, maxValue, ok := strings.Cut(env, ",")
+		if !ok {
+			return retryPreset{}, fmt.Errorf("PUBSUB_RETRY_PRESET_%s: expected <min>,<max>, got %q", strings.ToUpper(name), env)
+		}
+
+		min, err := parseDuration("retrymin", minValue, 0, 600*time.Second)
+		if err != nil {
+			return retryPreset{}, fmt.Errorf("PUBSUB_RETRY_PRESET_%s: %s", strings.ToUpper(name), err)
+		}
+
+		max, err := parseDuration("retrymax", maxValue, 0, 600*time.Second)
+		if err != nil {
+			return retryPreset{}, fmt.Errorf("PUBSUB_RETRY_PRESET_%s: %s", strings.ToUpper(name), err)
+		}
+
+		return retryPreset{minBackoff: min, maxBackoff: max}, nil
+	}
+
+	preset, ok := retryPresets[name]
+	if !ok {
+		names := make([]string, 0, len(retryPresets))
+		for name := range retryPresets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return retryPreset{}, fmt.Errorf("retrypreset: unknown preset %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	return preset, nil
+}
@@ -0,0 +1,48 @@
+package provision
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPreset(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		definition string
+		min, max   time.Duration
+		err        string
+	}{
+		{name: "aggressive", definition: "mailer;retrypreset=aggressive", min: time.Second, max: 10 * time.Second},
+		{name: "lenient", definition: "mailer;retrypreset=lenient", min: time.Minute, max: 10 * time.Minute},
+		{name: "retrymin overrides", definition: "mailer;retrypreset=lenient;retrymin=30s", min: 30 * time.Second, max: 10 * time.Minute},
+		{name: "retrymax before preset", definition: "mailer;retrymax=20s;retrypreset=aggressive", min: time.Second, max: 20 * time.Second},
+		{name: "override conflicts", definition: "mailer;retrypreset=aggressive;retrymin=30s", err: "retrymin 30s exceeds retrymax 10s"},
+		{name: "unknown", definition: "mailer;retrypreset=patient", err: `retrypreset: unknown preset "patient", expected one of aggressive, lenient`},
+		{name: "custom", env: map[string]string{"PUBSUB_RETRY_PRESET_PATIENT": "2m,8m"}, definition: "mailer;retrypreset=patient", min: 2 * time.Minute, max: 8 * time.Minute},
+		{name: "redefined", env: map[string]string{"PUBSUB_RETRY_PRESET_AGGRESSIVE": "100ms,1s"}, definition: "mailer;retrypreset=aggressive", min: 100 * time.Millisecond, max: time.Second},
+		{name: "custom without max", env: map[string]string{"PUBSUB_RETRY_PRESET_PATIENT": "2m"}, definition: "mailer;retrypreset=patient", err: `PUBSUB_RETRY_PRESET_PATIENT: expected <min>,<max>, got "2m"`},
+		{name: "custom out of range", env: map[string]string{"PUBSUB_RETRY_PRESET_PATIENT": "2m,20m"}, definition: "mailer;retrypreset=patient", err: "PUBSUB_RETRY_PRESET_PATIENT: retrymax: 20m0s above maximum 10m0s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			s, err := parseSubscription(t, &Parser{}, tt.definition)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case s.RetryMinBackoff != tt.min || s.RetryMaxBackoff != tt.max:
+				t.Errorf("expected a backoff of %s to %s, got %s to %s", tt.min, tt.max, s.RetryMinBackoff, s.RetryMaxBackoff)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// rollback deletes the resources that the run created, in reverse order so
+// that subscriptions are deleted before their topics. It returns the resources
+// that could not be deleted. Resources that existed before the run are never
+// touched, because only the ones that the run created are recorded.
+func (c *creator) rollback(ctx context.Context) []Resource {
+	// The resources are also deleted if the run was cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	c.infof("Rolling back %d created resource(s)", len(c.created))
+
+	var remaining []Resource
+	for i := len(c.created) - 1; i >= 0; i-- {
+		r := c.created[i]
+		if err := c.deleteResource(ctx, r); err != nil {
+			c.warnf("Unable to roll back %s %q: %s", r.Type, r.Name, err)
+			remaining = append([]Resource{r}, remaining...)
+			continue
+		}
+
+		if c.OnRollback != nil {
+			c.OnRollback(r)
+		}
+	}
+
+	return remaining
+}
+
+// deleteResource deletes the specified resource. Resources that don't exist
+// are skipped.
+func (c *creator) deleteResource(ctx context.Context, r Resource) error {
+	client, err := c.Clients.Client(ctx, r.Project)
+	if err != nil {
+		return err
+	}
+
+	id := r.Name[strings.LastIndex(r.Name, "/")+1:]
+
+	switch r.Type {
+	case "topic":
+		c.debugf("  Deleting topic %q", id)
+		err = client.Topic(id).Delete(ctx)
+	case "subscription":
+		c.debugf("    Deleting subscription %q", id)
+		err = client.Subscription(id).Delete(ctx)
+	default:
+		return fmt.Errorf("unknown resource type %q", r.Type)
+	}
+
+	return c.deleted(err, r)
+}
@@ -0,0 +1,162 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		config   string
+		rollback bool
+		// failCreate and failDelete are the names of the resources whose
+		// creation and deletion fail.
+		failCreate string
+		failDelete string
+		// live are the topics and subscriptions that exist afterwards,
+		// remaining the resources that Create returns, and rolledBack the
+		// resources passed to OnRollback.
+		live       []string
+		remaining  []string
+		rolledBack []string
+	}{
+		{
+			name:       "rolled back",
+			config:     "project1,orders:orders-mailer:orders-invoicer",
+			rollback:   true,
+			failCreate: "projects/project1/subscriptions/orders-invoicer",
+			rolledBack: []string{"projects/project1/subscriptions/orders-mailer", "projects/project1/topics/orders"},
+		},
+		{
+			name:       "existing resources are kept",
+			existing:   "project1,orders:orders-mailer",
+			config:     "project1,orders:orders-mailer:orders-shipper,returns:returns-refunder",
+			rollback:   true,
+			failCreate: "projects/project1/subscriptions/returns-refunder",
+			live:       []string{"orders", "orders-mailer"},
+			rolledBack: []string{"projects/project1/subscriptions/orders-shipper", "projects/project1/topics/returns"},
+		},
+		{
+			name:       "without rollback",
+			config:     "project1,orders:orders-mailer:orders-invoicer",
+			failCreate: "projects/project1/subscriptions/orders-invoicer",
+			live:       []string{"orders", "orders-mailer"},
+			remaining:  []string{"projects/project1/topics/orders", "projects/project1/subscriptions/orders-mailer"},
+		},
+		{
+			name:       "failed deletion",
+			config:     "project1,ledger:ledger-audit,payouts:payouts-sender",
+			rollback:   true,
+			failCreate: "projects/project1/subscriptions/payouts-sender",
+			failDelete: "projects/project1/topics/ledger",
+			live:       []string{"ledger"},
+			remaining:  []string{"projects/project1/topics/ledger"},
+			rolledBack: []string{"projects/project1/subscriptions/ledger-audit", "projects/project1/topics/payouts"},
+		},
+		{
+			name:      "succeeded",
+			config:    "project1,ledger:ledger-audit",
+			rollback:  true,
+			live:      []string{"ledger", "ledger-audit"},
+			remaining: []string{"projects/project1/topics/ledger", "projects/project1/subscriptions/ledger-audit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failCreate := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				if req.(*pubsubpb.Subscription).Name == tt.failCreate {
+					return true, nil, status.Error(codes.FailedPrecondition, "quota exceeded")
+				}
+				return false, nil, nil
+			})
+			failDelete := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				if req.(*pubsubpb.DeleteTopicRequest).Topic == tt.failDelete {
+					return true, nil, status.Error(codes.PermissionDenied, "deletion protected")
+				}
+				return false, nil, nil
+			})
+
+			p, client := newTestProvisioner(t,
+				pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: failCreate},
+				pstest.ServerReactorOption{FuncName: "DeleteTopic", Reactor: failDelete},
+			)
+			if tt.existing != "" {
+				if err := create(t, p, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg, err := (&Parser{}).Parse(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logger := &testLogger{}
+			p.Logger, p.RollbackOnError = logger, tt.rollback
+
+			var rolledBack []string
+			p.OnRollback = func(r Resource) { rolledBack = append(rolledBack, r.Name) }
+
+			// Existing resources are adopted, so only the new ones are
+			// created by the run.
+			p.Ensure = tt.existing != ""
+
+			resources, err := p.Create(context.Background(), []Config{cfg})
+			switch {
+			case tt.failCreate == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.failCreate != "" && (err == nil || !strings.Contains(err.Error(), "quota exceeded")):
+				t.Fatalf("expected the creation to fail, got %v", err)
+			}
+
+			var failed *ResourceError
+			if tt.failCreate != "" && (!errors.As(err, &failed) || failed.Name != tt.failCreate) {
+				t.Errorf("expected a resource error for %s, got %#v", tt.failCreate, err)
+			}
+
+			slices.Sort(rolledBack)
+			if !slices.Equal(rolledBack, tt.rolledBack) {
+				t.Errorf("expected the rolled back resources %q, got %q", tt.rolledBack, rolledBack)
+			}
+
+			var remaining []string
+			for _, r := range resources {
+				remaining = append(remaining, r.Name)
+			}
+			if !slices.Equal(remaining, tt.remaining) {
+				t.Errorf("expected the resources %q, got %q", tt.remaining, remaining)
+			}
+
+			if tt.failDelete != "" && (len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], "Unable to roll back topic")) {
+				t.Errorf("expected a warning about the failed rollback, got %q", logger.warnings)
+			}
+
+			live, err := Live(context.Background(), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, topic := range live.Topics {
+				names = append(names, topic.ID)
+				for _, s := range topic.Subscriptions {
+					names = append(names, s.ID)
+				}
+			}
+			if !slices.Equal(names, tt.live) {
+				t.Errorf("expected the live resources %q, got %q", tt.live, names)
+			}
+		})
+	}
+}
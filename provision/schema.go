@@ -0,0 +1,180 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// protoFieldRegexp matches the field declarations of a protocol buffer
+// message, capturing the field names.
+var protoFieldRegexp = regexp.MustCompile(`(?m)^\s*(?:optional\s+|required\s+|repeated\s+)?[\w.]+\s+(\w+)\s*=\s*\d+`)
+
+// checkFilterSchema warns about the attributes that the filter of the
+// subscription refers to, which are not fields of the schema of its topic.
+// This is a best-effort check: attributes are not part of the message data
+// that the schema describes, but mismatches between the two are usually a
+// mistake.
+func (c *creator) checkFilterSchema(ctx context.Context, project Config, t Topic, s Subscription) {
+	if t.Schema == "" || s.Filter == "" {
+		return
+	}
+
+	name := schemaName(project.ProjectID, t.Schema)
+
+	fields, err := c.schemaFields(ctx, name)
+	if err != nil {
+		c.debugf("    Unable to check the filter of subscription %q against schema %q: %s", s.ID, name, err)
+		return
+	}
+
+	keys, err := parseFilter(s.Filter)
+	if err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		if !fields[key] {
+			c.warnf("Subscription %q: filter attribute %q is not a field of schema %q", s.ID, key, name)
+		}
+	}
+}
+
+// schemaFields returns the top-level field names of the schema with the
+// specified fully qualified name.
+func (c *creator) schemaFields(ctx context.Context, name string) (map[string]bool, error) {
+	c.mu.Lock()
+	fields, ok := c.schemas[name]
+	c.mu.Unlock()
+	if ok {
+		return fields, nil
+	}
+
+	cfg, err := c.schema(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fields = make(map[string]bool)
+	switch cfg.Type {
+	case pubsub.SchemaAvro:
+		var record struct {
+			Fields []struct {
+				Name string `json:"name"`
+			} `json:"fields"`
+		}
+		if err := json.Unmarshal([]byte(cfg.Definition), &record); err != nil {
+			return nil, fmt.Errorf("Unable to decode Avro schema: %s", err)
+		}
+
+		for _, field := range record.Fields {
+			fields[field.Name] = true
+		}
+
+	case pubsub.SchemaProtocolBuffer:
+		for _, m := range protoFieldRegexp.FindAllStringSubmatch(cfg.Definition, -1) {
+			fields[m[1]] = true
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported schema type %d", cfg.Type)
+	}
+
+	c.mu.Lock()
+	if c.schemas == nil {
+		c.schemas = make(map[string]map[string]bool)
+	}
+	c.schemas[name] = fields
+	c.mu.Unlock()
+
+	return fields, nil
+}
+
+// schema returns the schema with the specified fully qualified name.
+func (c *creator) schema(ctx context.Context, name string) (*pubsub.SchemaConfig, error) {
+	c.mu.Lock()
+	cfg, ok := c.schemaConfigs[name]
+	c.mu.Unlock()
+	if ok {
+		return cfg, nil
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "schemas" {
+		return nil, fmt.Errorf("expected projects/<project>/schemas/<schema>")
+	}
+
+	// Unlike pubsub.NewClient, the schema client doesn't connect to the
+	// emulator by itself.
+	var opts []option.ClientOption
+	if onEmulator() {
+		opts = []option.ClientOption{
+			option.WithEndpoint(os.Getenv("PUBSUB_EMULATOR_HOST")),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		}
+	}
+
+	client, err := pubsub.NewSchemaClient(ctx, parts[1], opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	cfg, err = client.Schema(ctx, parts[3], pubsub.SchemaViewFull)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.schemaConfigs == nil {
+		c.schemaConfigs = make(map[string]*pubsub.SchemaConfig)
+	}
+	c.schemaConfigs[name] = cfg
+	c.mu.Unlock()
+
+	return cfg, nil
+}
+
+// validateSeeds checks that the data of the seed messages of the topic match
+// its Avro schema, and returns an error for the first seed message that
+// doesn't. Seed messages are only validated in the JSON encoding, and if the
+// schema can be read.
+func (c *creator) validateSeeds(ctx context.Context, project Config, t Topic, seeds []Seed) error {
+	if t.Schema == "" || t.SchemaEncoding == "binary" {
+		return nil
+	}
+
+	name := schemaName(project.ProjectID, t.Schema)
+
+	cfg, err := c.schema(ctx, name)
+	if err != nil {
+		c.debugf("  Unable to validate the seed messages of topic %q against schema %q: %s", t.ID, name, err)
+		return nil
+	}
+	if cfg.Type != pubsub.SchemaAvro {
+		c.debugf("  Skipping the validation of the seed messages of topic %q, which only supports Avro schemas", t.ID)
+		return nil
+	}
+
+	schema, err := parseAvroSchema(cfg.Definition)
+	if err != nil {
+		return fmt.Errorf("Unable to validate the seed messages of topic %q against schema %q: %s", t.ID, name, err)
+	}
+
+	for i, seed := range seeds {
+		if err := schema.validateJSON(seed.Data); err != nil {
+			return fmt.Errorf("Seed %d of topic %q does not match schema %q: %s", i+1, t.ID, name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// createSchema creates a schema in project1 of the emulator in
+// PUBSUB_EMULATOR_HOST.
+func createSchema(t *testing.T, id string, schemaType pubsub.SchemaType, definition string) {
+	t.Helper()
+
+	ctx := context.Background()
+	client, err := pubsub.NewSchemaClient(ctx, "project1",
+		option.WithEndpoint(os.Getenv("PUBSUB_EMULATOR_HOST")),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.CreateSchema(ctx, id, pubsub.SchemaConfig{Type: schemaType, Definition: definition}); err != nil {
+		t.Fatalf("unable to create schema %q: %s", id, err)
+	}
+}
+
+func TestCreateStrictFilters(t *testing.T) {
+	const (
+		avroShipment  = `{"type":"record","name":"Shipment","fields":[{"name":"carrier","type":"string"},{"name":"weight","type":"double"}]}`
+		protoShipment = "syntax = \"proto3\";\nmessage Shipment {\n  string carrier = 1;\n  optional double weight = 2;\n  repeated string parcels = 3;\n}\n"
+	)
+
+	tests := []struct {
+		name     string
+		strict   bool
+		config   string
+		warnings []string
+	}{
+		{
+			name:   "avro fields",
+			strict: true,
+			config: `project1,shipments[schema=shipment-avro]:dhl;filter=attributes.carrier = "dhl"`,
+		},
+		{
+			name:     "avro mismatch",
+			strict:   true,
+			config:   `project1,shipments[schema=shipment-avro]:dutch;filter=attributes.country = "nl"`,
+			warnings: []string{`Subscription "dutch": filter attribute "country" is not a field of schema "projects/project1/schemas/shipment-avro"`},
+		},
+		{
+			name:     "protocol buffer fields",
+			strict:   true,
+			config:   `project1,shipments[schema=shipment-proto]:heavy;filter=hasPrefix(attributes.parcels, "box") AND attributes.currency = "eur"`,
+			warnings: []string{`Subscription "heavy": filter attribute "currency" is not a field of schema "projects/project1/schemas/shipment-proto"`},
+		},
+		{
+			name:   "not strict",
+			config: `project1,shipments[schema=shipment-avro]:dutch;filter=attributes.country = "nl"`,
+		},
+		{
+			name:   "unknown schema",
+			strict: true,
+			config: `project1,shipments[schema=shipment-v9]:dutch;filter=attributes.country = "nl"`,
+		},
+		{
+			name:   "without filter",
+			strict: true,
+			config: "project1,shipments[schema=shipment-avro]:all",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvisioner(t)
+			p.StrictFilters = tt.strict
+
+			logger := &testLogger{}
+			p.Logger = logger
+
+			createSchema(t, "shipment-avro", pubsub.SchemaAvro, avroShipment)
+			createSchema(t, "shipment-proto", pubsub.SchemaProtocolBuffer, protoShipment)
+
+			if err := create(t, p, tt.config); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !slices.Equal(logger.warnings, tt.warnings) {
+				t.Errorf("expected the warnings %q, got %q", tt.warnings, logger.warnings)
+			}
+		})
+	}
+}
+
+func TestCreateSeedSchemaValidation(t *testing.T) {
+	const (
+		avroReading  = `{"type":"record","name":"Reading","fields":[{"name":"sensor","type":"string"},{"name":"celsius","type":"double"},{"name":"battery","type":["null","int"],"default":null}]}`
+		protoReading = "syntax = \"proto3\";\nmessage Reading {\n  string sensor = 1;\n  double celsius = 2;\n}\n"
+	)
+
+	tests := []struct {
+		name    string
+		options string
+		seeds   []string
+		// published is the number of seed messages that must be published.
+		published int
+		err       string
+	}{
+		{
+			name:      "valid seeds",
+			options:   "schema=reading-avro",
+			seeds:     []string{`{"sensor":"greenhouse-1","celsius":21.5,"battery":null}`, `{"sensor":"greenhouse-2","celsius":19,"battery":{"int":87}}`},
+			published: 2,
+		},
+		{
+			name:    "invalid seed",
+			options: "schema=reading-avro",
+			seeds:   []string{`{"sensor":"greenhouse-1","celsius":21.5}`, `{"sensor":"greenhouse-2","celsius":"warm"}`, `{"sensor":"greenhouse-3"}`},
+			err:     `Seed 2 of topic "readings" does not match schema "projects/project1/schemas/reading-avro": field "celsius": expected a number, got "warm" for project "project1"`,
+		},
+		{
+			name:    "invalid JSON",
+			options: "schema=projects/project1/schemas/reading-avro",
+			seeds:   []string{`sensor=greenhouse-1`},
+			err:     `Seed 1 of topic "readings" does not match schema "projects/project1/schemas/reading-avro": invalid JSON`,
+		},
+		{
+			name:      "binary encoding is not validated",
+			options:   "schema=reading-avro,encoding=binary",
+			seeds:     []string{`not json`},
+			published: 1,
+		},
+		{
+			name:      "protocol buffer schemas are not validated",
+			options:   "schema=reading-proto",
+			seeds:     []string{`{"sensor":"greenhouse-1","celsius":"warm"}`},
+			published: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, srv := newTestServer(t)
+			createSchema(t, "reading-avro", pubsub.SchemaAvro, avroReading)
+			createSchema(t, "reading-proto", pubsub.SchemaProtocolBuffer, protoReading)
+
+			var lines []string
+			for _, seed := range tt.seeds {
+				b, err := json.Marshal(map[string]string{"data": seed})
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines = append(lines, string(b))
+			}
+
+			err := create(t, p, "project1,readings["+tt.options+",seed="+writeSeeds(t, lines...)+"]")
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("expected an error starting with %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if published := len(srv.Messages()); published != tt.published {
+				t.Errorf("expected %d published seed message(s), got %d", tt.published, published)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package provision
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Seed is a message that is published to a topic once the topic and its
+// subscriptions are created.
+type Seed struct {
+	Data        string            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+// loadSeeds reads the seed messages from a file with one JSON encoded seed
+// message per line. Empty lines are skipped.
+func loadSeeds(path string) ([]Seed, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open seed file: %s", err)
+	}
+	defer f.Close()
+
+	var seeds []Seed
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		var seed Seed
+		if err := json.Unmarshal(b, &seed); err != nil {
+			return nil, fmt.Errorf("%s:%d: Unable to decode seed message: %s", path, line, err)
+		}
+
+		seeds = append(seeds, seed)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read seed file: %s", err)
+	}
+
+	return seeds, nil
+}
+
+// loadSeedsCSV reads the seed messages from a CSV file with one seed message
+// per row. The first column is the data, and the other columns are attributes
+// that are named by the header row. Empty attribute values are left out. A
+// file with only a data column doesn't need a header, but if the first row is
+// "data" it is skipped as one.
+func loadSeedsCSV(path string) ([]Seed, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open seed file: %s", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: Unable to decode CSV: %s", path, err)
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	var header []string
+	if len(rows[0]) > 1 || strings.EqualFold(strings.TrimSpace(rows[0][0]), "data") {
+		header, rows = rows[0], rows[1:]
+
+		for j, name := range header[1:] {
+			if name == "" {
+				return nil, fmt.Errorf("%s:1: column %d has no attribute name", path, j+2)
+			}
+		}
+	}
+
+	seeds := make([]Seed, 0, len(rows))
+	for i, row := range rows {
+		line := i + 1
+		if header != nil {
+			line++
+		}
+
+		if len(row) > len(header) && len(row) > 1 {
+			return nil, fmt.Errorf("%s:%d: expected at most %d columns, got %d", path, line, len(header), len(row))
+		}
+
+		seed := Seed{Data: row[0]}
+		for j := 1; j < len(row); j++ {
+			if row[j] == "" {
+				continue
+			}
+
+			if seed.Attributes == nil {
+				seed.Attributes = make(map[string]string)
+			}
+			seed.Attributes[header[j]] = row[j]
+		}
+
+		seeds = append(seeds, seed)
+	}
+
+	return seeds, nil
+}
+
+// loadTopicSeeds reads the seed messages of the topic, from the JSON seed
+// file followed by the CSV seed file.
+func loadTopicSeeds(t Topic) ([]Seed, error) {
+	var seeds []Seed
+	if t.SeedFile != "" {
+		s, err := loadSeeds(t.SeedFile)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, s...)
+	}
+
+	if t.SeedCSVFile != "" {
+		s, err := loadSeedsCSV(t.SeedCSVFile)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, s...)
+	}
+
+	return seeds, nil
+}
+
+// maxSeedCount is the maximum seedcount of a topic.
+const maxSeedCount = 1000000
+
+// expandSeeds repeats the seed templates count times, and replaces {i} in
+// their data, attributes and ordering key by the number of the message,
+// starting at 1, and {ts} by the specified time.
+func expandSeeds(templates []Seed, count int, now time.Time) []Seed {
+	ts := now.UTC().Format(time.RFC3339Nano)
+
+	seeds := make([]Seed, 0, len(templates)*count)
+	for n := 0; n < count; n++ {
+		for _, template := range templates {
+			r := strings.NewReplacer("{i}", strconv.Itoa(len(seeds)+1), "{ts}", ts)
+
+			seed := Seed{Data: r.Replace(template.Data), OrderingKey: r.Replace(template.OrderingKey)}
+			if template.Attributes != nil {
+				seed.Attributes = make(map[string]string, len(template.Attributes))
+				for key, val := range template.Attributes {
+					seed.Attributes[key] = r.Replace(val)
+				}
+			}
+
+			seeds = append(seeds, seed)
+		}
+	}
+
+	return seeds
+}
+
+// mergeAttributes returns the default attributes overridden by the message
+// attributes.
+func mergeAttributes(defaults, attrs map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return attrs
+	}
+
+	merged := make(map[string]string, len(defaults)+len(attrs))
+	for key, val := range defaults {
+		merged[key] = val
+	}
+	for key, val := range attrs {
+		merged[key] = val
+	}
+
+	return merged
+}
+
+// validateSeedOrderKey checks an ordering key derivation of the form
+// "attr:<name>" or "roundrobin:<n>".
+func validateSeedOrderKey(value string) error {
+	mode, arg, _ := strings.Cut(value, ":")
+	switch mode {
+	case "attr":
+		if arg == "" {
+			return fmt.Errorf("expected attr:<name>, got %q", value)
+		}
+
+	case "roundrobin":
+		if n, err := strconv.Atoi(arg); err != nil || n < 1 {
+			return fmt.Errorf("expected roundrobin:<n> with n at least 1, got %q", value)
+		}
+
+	default:
+		return fmt.Errorf("expected attr:<name> or roundrobin:<n>, got %q", value)
+	}
+
+	return nil
+}
+
+// orderSeeds sets the ordering keys of the seed messages of the topic that
+// don't have one, by its seedorderkey derivation or else its seeddefaultkey.
+func orderSeeds(t Topic, seeds []Seed) {
+	if t.SeedOrderKey != "" {
+		deriveOrderingKeys(t.SeedOrderKey, seeds)
+	}
+	for i := range seeds {
+		if seeds[i].OrderingKey == "" {
+			seeds[i].OrderingKey = t.SeedDefaultKey
+		}
+	}
+}
+
+// deriveOrderingKeys sets the ordering key of the seed messages that don't
+// have one, according to a validated derivation.
+func deriveOrderingKeys(derivation string, seeds []Seed) {
+	mode, arg, _ := strings.Cut(derivation, ":")
+	n, _ := strconv.Atoi(arg)
+
+	for i := range seeds {
+		if seeds[i].OrderingKey != "" {
+			continue
+		}
+
+		switch mode {
+		case "attr":
+			seeds[i].OrderingKey = seeds[i].Attributes[arg]
+		case "roundrobin":
+			seeds[i].OrderingKey = "key-" + strconv.Itoa(i%n)
+		}
+	}
+}
+
+// seedError describes a seed message that could not be published.
+type seedError struct {
+	index int
+	err   error
+}
+
+// publishSeeds publishes the seed messages to the topic with at most the
+// configured number of publishes in flight. Seed messages that share an
+// ordering key are published one after the other, so that their order is
+// preserved. All publishes are confirmed before publishSeeds returns.
+//
+// When the context is cancelled, no new seed messages are published, but the
+// publishes that are already in flight are flushed and confirmed. The same
+// goes for a failed publish, unless seeding is best-effort, in which case the
+// failed seed messages are skipped.
+//
+// If onConfirm is not nil, it is called with the index of every seed message
+// whose publish is confirmed.
+func (c *creator) publishSeeds(ctx context.Context, topic *pubsub.Topic, seeds []Seed, onConfirm func(index int)) error {
+	concurrency := c.SeedConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Group the seeds into lanes that can be published independently. Each
+	// message without an ordering key is a lane of its own.
+	var lanes [][]int
+	keys := make(map[string]int)
+	for i, seed := range seeds {
+		if seed.OrderingKey == "" {
+			lanes = append(lanes, []int{i})
+			continue
+		}
+
+		if lane, ok := keys[seed.OrderingKey]; ok {
+			lanes[lane] = append(lanes[lane], i)
+			continue
+		}
+
+		keys[seed.OrderingKey] = len(lanes)
+		lanes = append(lanes, []int{i})
+	}
+
+	if len(keys) > 0 {
+		topic.EnableMessageOrdering = true
+	}
+
+	// In-flight publishes use a context that isn't cancelled, so that they
+	// can be flushed on shutdown.
+	flushCtx := context.WithoutCancel(ctx)
+
+	// Unless seeding is best-effort, the first failed publish stops the
+	// publishing of the remaining seed messages.
+	publishCtx, fail := context.WithCancel(ctx)
+	defer fail()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		errs      []seedError
+		confirmed int
+		laneCh    = make(chan []int)
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for lane := range laneCh {
+				for _, index := range lane {
+					if publishCtx.Err() != nil {
+						break
+					}
+
+					seed := seeds[index]
+					result := topic.Publish(flushCtx, &pubsub.Message{
+						Data:        []byte(seed.Data),
+						Attributes:  seed.Attributes,
+						OrderingKey: seed.OrderingKey,
+					})
+
+					_, err := result.Get(flushCtx)
+
+					mu.Lock()
+					if err != nil {
+						errs = append(errs, seedError{index: index, err: err})
+
+						if !c.SeedBestEffort {
+							fail()
+						} else if seed.OrderingKey != "" {
+							// A failed publish pauses its ordering key.
+							topic.ResumePublish(seed.OrderingKey)
+						}
+					} else {
+						confirmed++
+						if onConfirm != nil {
+							onConfirm(index)
+						}
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, lane := range lanes {
+		select {
+		case laneCh <- lane:
+		case <-publishCtx.Done():
+			break dispatch
+		}
+	}
+	close(laneCh)
+
+	wg.Wait()
+	topic.Stop()
+
+	c.debugf("    Published %d of %d seed message(s) to topic %q", confirmed, len(seeds), topic.ID())
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("Seeding of topic %q was interrupted after %d of %d seed message(s) were confirmed: %s", topic.ID(), confirmed, len(seeds), err)
+	}
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].index < errs[j].index })
+
+		if c.SeedBestEffort {
+			for _, e := range errs {
+				c.warnf("Unable to publish seed %d to topic %q, skipping it: %s", e.index+1, topic.ID(), e.err)
+			}
+			c.seedFailures += len(errs)
+
+			return nil
+		}
+
+		var msgs []string
+		for _, e := range errs {
+			msgs = append(msgs, fmt.Sprintf("seed %d: %s", e.index+1, e.err))
+		}
+
+		return fmt.Errorf("Unable to publish %d seed message(s) to topic %q: %s", len(errs), topic.ID(), strings.Join(msgs, "; "))
+	}
+
+	return nil
+}
+
+// SeedState records the identities of the seed messages that were published,
+// so that later runs only publish new seed messages.
+type SeedState struct {
+	path      string
+	published map[string]bool
+
+	mu    sync.Mutex
+	added []string
+}
+
+// LoadSeedState reads the seed state file with one identity per line. A
+// missing file is an empty state.
+func LoadSeedState(path string) (*SeedState, error) {
+	state := &SeedState{path: path, published: make(map[string]bool)}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return state, nil
+	case err != nil:
+		return nil, fmt.Errorf("Unable to read seed state: %s", err)
+	}
+
+	for _, id := range strings.Fields(string(b)) {
+		state.published[id] = true
+	}
+
+	return state, nil
+}
+
+// has returns true if the seed message with the specified identity was
+// published before.
+func (s *SeedState) has(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.published[id]
+}
+
+// add records that the seed message with the specified identity was
+// published.
+func (s *SeedState) add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.published[id] {
+		s.published[id] = true
+		s.added = append(s.added, id)
+	}
+}
+
+// save appends the identities that were added to the state file.
+func (s *SeedState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.added) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to write seed state: %s", err)
+	}
+
+	if _, err := f.WriteString(strings.Join(s.added, "\n") + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("Unable to write seed state: %s", err)
+	}
+	s.added = nil
+
+	return f.Close()
+}
+
+// seedIdentities returns an identity for every seed message of a topic. The
+// identity is derived from the topic and the contents of the message, and
+// from the number of identical messages before it.
+func seedIdentities(topicName string, seeds []Seed) []string {
+	ids := make([]string, len(seeds))
+	occurrences := make(map[string]int)
+	for i, seed := range seeds {
+		b, _ := json.Marshal(seed)
+		content := topicName + "\x00" + string(b)
+
+		ids[i] = digest(fmt.Sprintf("%s\x00%d", content, occurrences[content]))
+		occurrences[content]++
+	}
+
+	return ids
+}
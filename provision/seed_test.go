@@ -0,0 +1,712 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeSeeds writes the seed messages, one JSON object per line, to a file in
+// a temporary directory and returns its path.
+func writeSeeds(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "seeds.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestCreateSeedConcurrency(t *testing.T) {
+	var lines []string
+	for i := 0; i < 12; i++ {
+		lines = append(lines, fmt.Sprintf(`{"data":"reading-%d","orderingKey":"sensor-%d"}`, i, i%3))
+	}
+	for i := 0; i < 6; i++ {
+		lines = append(lines, fmt.Sprintf(`{"data":"alert-%d"}`, i))
+	}
+	seeds := writeSeeds(t, lines...)
+
+	for _, concurrency := range []int{0, 1, 3, 8} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			// The reactor tracks the number of concurrent publish requests.
+			var mu sync.Mutex
+			var inFlight, maxInFlight int
+			track := reactorFunc(func(interface{}) (bool, interface{}, error) {
+				mu.Lock()
+				inFlight++
+				maxInFlight = max(maxInFlight, inFlight)
+				mu.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return false, nil, nil
+			})
+
+			p, _, srv := newTestServer(t, pstest.ServerReactorOption{FuncName: "Publish", Reactor: track})
+			p.SeedConcurrency = concurrency
+
+			if err := create(t, p, "project1,telemetry[seed="+seeds+"]"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			messages := srv.Messages()
+			if len(messages) != len(lines) {
+				t.Fatalf("expected %d seed messages, got %d", len(lines), len(messages))
+			}
+
+			// The messages of every ordering key are published in order.
+			last := make(map[string]int)
+			for _, m := range messages {
+				var n int
+				if _, err := fmt.Sscanf(string(m.Data), "reading-%d", &n); err != nil {
+					continue
+				}
+				if prev, ok := last[m.OrderingKey]; ok && n < prev {
+					t.Errorf("expected the messages of %q in order, got %d after %d", m.OrderingKey, n, prev)
+				}
+				last[m.OrderingKey] = n
+			}
+
+			if limit := max(concurrency, 1); maxInFlight > limit {
+				t.Errorf("expected at most %d concurrent publishes, got %d", limit, maxInFlight)
+			}
+		})
+	}
+}
+
+func TestCreateSeedAttributes(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs string
+		seed  string
+		want  map[string]string
+	}{
+		{name: "no defaults", seed: `{"data":"hello","attributes":{"lang":"en"}}`, want: map[string]string{"lang": "en"}},
+		{name: "defaults only", attrs: "source:pubsubc;env:dev", seed: `{"data":"hello"}`, want: map[string]string{"source": "pubsubc", "env": "dev"}},
+		{name: "merged", attrs: "source:pubsubc", seed: `{"data":"hello","attributes":{"lang":"en"}}`, want: map[string]string{"source": "pubsubc", "lang": "en"}},
+		{name: "seed overrides default", attrs: "source:pubsubc;env:dev", seed: `{"data":"hello","attributes":{"env":"ci"}}`, want: map[string]string{"source": "pubsubc", "env": "ci"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, srv := newTestServer(t)
+
+			options := "seed=" + writeSeeds(t, tt.seed)
+			if tt.attrs != "" {
+				options += ",seedattrs=" + tt.attrs
+			}
+			if err := create(t, p, "project1,greetings["+options+"]"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			messages := srv.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("expected 1 seed message, got %d", len(messages))
+			}
+			if got := messages[0].Attributes; !maps.Equal(got, tt.want) {
+				t.Errorf("expected attributes %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateSeedCancellation(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf(`{"data":"event-%d"}`, i))
+	}
+	seeds := writeSeeds(t, lines...)
+
+	tests := []struct {
+		name        string
+		concurrency int
+		cancelAfter int
+	}{
+		{name: "sequential", concurrency: 1, cancelAfter: 5},
+		{name: "concurrent", concurrency: 4, cancelAfter: 10},
+		{name: "first publish", concurrency: 2, cancelAfter: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// The reactor cancels the run once the publish requests start.
+			var mu sync.Mutex
+			var requests int
+			cancelOnPublish := reactorFunc(func(interface{}) (bool, interface{}, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if requests++; requests == tt.cancelAfter {
+					cancel()
+				}
+				return false, nil, nil
+			})
+
+			p, _, srv := newTestServer(t, pstest.ServerReactorOption{FuncName: "Publish", Reactor: cancelOnPublish})
+			p.SeedConcurrency = tt.concurrency
+
+			cfg, err := (&Parser{}).Parse("project1,clicks[seed=" + seeds + "]")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.Create(ctx, []Config{cfg})
+			if err == nil {
+				t.Fatal("expected the cancellation to interrupt the seeding")
+			}
+
+			var confirmed, total int
+			i := strings.Index(err.Error(), "interrupted after")
+			if i < 0 {
+				t.Fatalf("expected an interrupted seeding, got %v", err)
+			}
+			if _, err := fmt.Sscanf(err.Error()[i:], "interrupted after %d of %d", &confirmed, &total); err != nil {
+				t.Fatal(err)
+			}
+
+			// Every publish that was started is flushed, and is reported as
+			// confirmed.
+			if published := len(srv.Messages()); published != confirmed {
+				t.Errorf("expected the %d confirmed seed messages to be published, got %d", confirmed, published)
+			}
+			if total != len(lines) || confirmed >= total {
+				t.Errorf("expected fewer than %d confirmed seed messages, got %d of %d", len(lines), confirmed, total)
+			}
+		})
+	}
+}
+
+func TestCreateSeedState(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second []string
+		options       string
+		want          []int
+	}{
+		{
+			name:   "unchanged seeds",
+			first:  []string{`{"data":"signup-1"}`, `{"data":"signup-2"}`},
+			second: []string{`{"data":"signup-1"}`, `{"data":"signup-2"}`},
+			want:   []int{2, 0},
+		},
+		{
+			name:   "appended seed",
+			first:  []string{`{"data":"signup-1"}`},
+			second: []string{`{"data":"signup-1"}`, `{"data":"signup-2"}`},
+			want:   []int{1, 1},
+		},
+		{
+			name:   "changed attributes",
+			first:  []string{`{"data":"signup-1","attributes":{"plan":"free"}}`},
+			second: []string{`{"data":"signup-1","attributes":{"plan":"pro"}}`},
+			want:   []int{1, 1},
+		},
+		{
+			name:    "templates with a timestamp",
+			first:   []string{`{"data":"signup-{i} at {ts}"}`},
+			second:  []string{`{"data":"signup-{i} at {ts}"}`},
+			options: ",seedcount=3",
+			want:    []int{3, 0},
+		},
+		{
+			name:    "more templated seeds",
+			first:   []string{`{"data":"signup-{i}","attributes":{"at":"{ts}"}}`},
+			second:  []string{`{"data":"signup-{i}","attributes":{"at":"{ts}"}}`},
+			options: ",seedcount=5",
+			want:    []int{5, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, srv := newTestServer(t)
+			statePath := filepath.Join(t.TempDir(), "seed-state")
+
+			for run, lines := range [][]string{tt.first, tt.second} {
+				state, err := LoadSeedState(statePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.SeedState, p.SkipExistingTopics = state, run > 0
+
+				before := len(srv.Messages())
+				if err := create(t, p, "project1,signups[seed="+writeSeeds(t, lines...)+tt.options+"]"); err != nil {
+					t.Fatalf("run %d: unexpected error: %s", run+1, err)
+				}
+				if published := len(srv.Messages()) - before; published != tt.want[run] {
+					t.Errorf("run %d: expected %d seed messages, got %d", run+1, tt.want[run], published)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateSeedErrors(t *testing.T) {
+	seeds := []string{
+		`{"data":"sku-1001"}`,
+		`{"data":"corrupt-1002"}`,
+		`{"data":"sku-1003"}`,
+		`{"data":"corrupt-1004"}`,
+		`{"data":"sku-1005"}`,
+	}
+
+	tests := []struct {
+		name       string
+		bestEffort bool
+		published  []string
+		err        string
+		warning    string
+	}{
+		{
+			name:      "fail fast",
+			published: []string{"sku-1001"},
+			err:       `Unable to publish 1 seed message(s) to topic "inventory": seed 2:`,
+		},
+		{
+			name:       "best effort",
+			bestEffort: true,
+			published:  []string{"sku-1001", "sku-1003", "sku-1005"},
+			warning:    "Skipped 2 seed message(s) that failed to publish",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The server rejects the corrupt seed messages.
+			rejectCorrupt := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				for _, msg := range req.(*pubsubpb.PublishRequest).Messages {
+					if strings.HasPrefix(string(msg.Data), "corrupt-") {
+						return true, nil, status.Error(codes.InvalidArgument, "invalid message")
+					}
+				}
+
+				return false, nil, nil
+			})
+
+			p, _, srv := newTestServer(t, pstest.ServerReactorOption{FuncName: "Publish", Reactor: rejectCorrupt})
+			p.SeedBestEffort = tt.bestEffort
+
+			logger := &testLogger{}
+			p.Logger = logger
+
+			err := create(t, p, "project1,inventory[seed="+writeSeeds(t, seeds...)+"]")
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var published []string
+			for _, msg := range srv.Messages() {
+				published = append(published, string(msg.Data))
+			}
+			if !slices.Equal(published, tt.published) {
+				t.Errorf("expected the published seeds %q, got %q", tt.published, published)
+			}
+
+			if tt.warning != "" && !slices.Contains(logger.warnings, tt.warning) {
+				t.Errorf("expected the warning %q, got %q", tt.warning, logger.warnings)
+			}
+		})
+	}
+}
+
+func TestCreateSeedCSV(t *testing.T) {
+	type message struct {
+		data       string
+		attributes map[string]string
+	}
+
+	tests := []struct {
+		name     string
+		csv      string
+		expected []message
+		err      string
+	}{
+		{
+			name: "attributes",
+			csv:  "data,warehouse,priority\ncrate-1,rotterdam,high\ncrate-2,antwerp,\n",
+			expected: []message{
+				{data: "crate-1", attributes: map[string]string{"warehouse": "rotterdam", "priority": "high"}},
+				{data: "crate-2", attributes: map[string]string{"warehouse": "antwerp"}},
+			},
+		},
+		{
+			name: "quoting",
+			csv:  "payload,source\n\"{\"\"sku\"\":\"\"A-1\"\",\"\"qty\"\":2}\",\"scanner, dock 4\"\n\"two\nlines\",manual\n",
+			expected: []message{
+				{data: `{"sku":"A-1","qty":2}`, attributes: map[string]string{"source": "scanner, dock 4"}},
+				{data: "two\nlines", attributes: map[string]string{"source": "manual"}},
+			},
+		},
+		{
+			name:     "data header",
+			csv:      "data\npallet-1\npallet-2\n",
+			expected: []message{{data: "pallet-1"}, {data: "pallet-2"}},
+		},
+		{
+			name:     "no header",
+			csv:      "pallet-1\npallet-2\n",
+			expected: []message{{data: "pallet-1"}, {data: "pallet-2"}},
+		},
+		{
+			name: "too many columns",
+			csv:  "data,warehouse\ncrate-1,rotterdam\ncrate-2,antwerp,extra\n",
+			err:  "stock.csv:3: expected at most 2 columns, got 3",
+		},
+		{
+			name: "unnamed attribute",
+			csv:  "data,,priority\ncrate-1,rotterdam,high\n",
+			err:  "stock.csv:1: column 2 has no attribute name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stock.csv")
+			if err := os.WriteFile(path, []byte(tt.csv), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			p, _, srv := newTestServer(t)
+			err := create(t, p, "project1,stock[seedcsv="+path+"]")
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var published []message
+			for _, msg := range srv.Messages() {
+				published = append(published, message{data: string(msg.Data), attributes: msg.Attributes})
+			}
+
+			if len(published) != len(tt.expected) {
+				t.Fatalf("expected %d messages, got %d", len(tt.expected), len(published))
+			}
+			for i, msg := range published {
+				if msg.data != tt.expected[i].data || !maps.Equal(msg.attributes, tt.expected[i].attributes) {
+					t.Errorf("message %d: expected %+v, got %+v", i, tt.expected[i], msg)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateSeedOrderKey(t *testing.T) {
+	seeds := []string{
+		`{"data":"order-1","attributes":{"customer":"c-17"}}`,
+		`{"data":"order-2","attributes":{"customer":"c-42"}}`,
+		`{"data":"order-3","attributes":{"customer":"c-17"}}`,
+		`{"data":"order-4","orderingKey":"manual"}`,
+		`{"data":"order-5"}`,
+	}
+
+	tests := []struct {
+		name    string
+		options string
+		// keys maps the data of the seed messages to their ordering keys.
+		keys map[string]string
+		err  string
+	}{
+		{
+			name:    "attribute",
+			options: "seedorderkey=attr:customer",
+			keys:    map[string]string{"order-1": "c-17", "order-2": "c-42", "order-3": "c-17", "order-4": "manual", "order-5": ""},
+		},
+		{
+			name:    "attribute with default key",
+			options: "seedorderkey=attr:customer,seeddefaultkey=walk-in",
+			keys:    map[string]string{"order-1": "c-17", "order-2": "c-42", "order-3": "c-17", "order-4": "manual", "order-5": "walk-in"},
+		},
+		{
+			name:    "round robin",
+			options: "seedorderkey=roundrobin:3",
+			keys:    map[string]string{"order-1": "key-0", "order-2": "key-1", "order-3": "key-2", "order-4": "manual", "order-5": "key-1"},
+		},
+		{
+			name:    "round robin of one key",
+			options: "seedorderkey=roundrobin:1",
+			keys:    map[string]string{"order-1": "key-0", "order-2": "key-0", "order-3": "key-0", "order-4": "manual", "order-5": "key-0"},
+		},
+		{name: "no round robin keys", options: "seedorderkey=roundrobin:0", err: `expected roundrobin:<n> with n at least 1, got "roundrobin:0"`},
+		{name: "no attribute name", options: "seedorderkey=attr:", err: `expected attr:<name>, got "attr:"`},
+		{name: "unknown derivation", options: "seedorderkey=hash:customer", err: `expected attr:<name> or roundrobin:<n>, got "hash:customer"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definition := "project1,orders[seed=" + writeSeeds(t, seeds...) + "," + tt.options + "]"
+
+			if tt.err != "" {
+				_, err := (&Parser{}).Parse(definition)
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+
+			p, _, srv := newTestServer(t)
+			if err := create(t, p, definition); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			keys := make(map[string]string)
+			for _, msg := range srv.Messages() {
+				keys[string(msg.Data)] = msg.OrderingKey
+			}
+			if !maps.Equal(keys, tt.keys) {
+				t.Errorf("expected the ordering keys %v, got %v", tt.keys, keys)
+			}
+		})
+	}
+}
+
+func TestCreateSeedDefaultKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		seeds   []string
+		options string
+		// order are the data of the received messages by ordering key, in
+		// the order that they are received.
+		order map[string][]string
+		err   string
+	}{
+		{
+			name:    "default key",
+			seeds:   []string{`{"data":"checkout"}`, `{"data":"build"}`, `{"data":"test"}`, `{"data":"package"}`, `{"data":"deploy"}`},
+			options: "seeddefaultkey=pipeline",
+			order:   map[string][]string{"pipeline": {"checkout", "build", "test", "package", "deploy"}},
+		},
+		{
+			name: "explicit keys are kept",
+			seeds: []string{
+				`{"data":"created"}`,
+				`{"data":"paid","orderingKey":"invoice-7"}`,
+				`{"data":"shipped"}`,
+				`{"data":"refunded","orderingKey":"invoice-7"}`,
+				`{"data":"delivered"}`,
+			},
+			options: "seeddefaultkey=order-12",
+			order:   map[string][]string{"order-12": {"created", "shipped", "delivered"}, "invoice-7": {"paid", "refunded"}},
+		},
+		{
+			name:    "derived keys take precedence",
+			seeds:   []string{`{"data":"login","attributes":{"user":"u1"}}`, `{"data":"browse"}`, `{"data":"logout","attributes":{"user":"u1"}}`, `{"data":"bounce"}`},
+			options: "seedorderkey=attr:user,seeddefaultkey=anonymous",
+			order:   map[string][]string{"u1": {"login", "logout"}, "anonymous": {"browse", "bounce"}},
+		},
+		{
+			name:    "empty default key",
+			seeds:   []string{`{"data":"checkout"}`},
+			options: "seeddefaultkey=",
+			err:     `Topic "steps": seeddefaultkey must not be empty`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definition := "project1,steps[seed=" + writeSeeds(t, tt.seeds...) + "," + tt.options + "]:steps-runner;ordered"
+
+			if tt.err != "" {
+				_, err := (&Parser{}).Parse(definition)
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+
+			p, client := newTestProvisioner(t)
+			p.SeedConcurrency = 4
+			if err := create(t, p, definition); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var (
+				mu       sync.Mutex
+				received int
+				order    = make(map[string][]string)
+			)
+			err := client.Subscription("steps-runner").Receive(ctx, func(_ context.Context, msg *pubsub.Message) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				msg.Ack()
+				order[msg.OrderingKey] = append(order[msg.OrderingKey], string(msg.Data))
+				if received++; received == len(tt.seeds) {
+					cancel()
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !maps.EqualFunc(order, tt.order, slices.Equal) {
+				t.Errorf("expected the messages %v, got %v", tt.order, order)
+			}
+		})
+	}
+}
+
+func TestExpandSeeds(t *testing.T) {
+	now := time.Date(2026, 3, 9, 14, 30, 0, 125000000, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		name      string
+		templates []Seed
+		count     int
+		want      []Seed
+	}{
+		{
+			name:      "counter in the data",
+			templates: []Seed{{Data: "msg-{i}"}},
+			count:     3,
+			want:      []Seed{{Data: "msg-1"}, {Data: "msg-2"}, {Data: "msg-3"}},
+		},
+		{
+			name:      "counter over several templates",
+			templates: []Seed{{Data: `{"order":{i},"kind":"placed"}`}, {Data: `{"order":{i},"kind":"paid"}`, OrderingKey: "customer-{i}"}},
+			count:     2,
+			want: []Seed{
+				{Data: `{"order":1,"kind":"placed"}`},
+				{Data: `{"order":2,"kind":"paid"}`, OrderingKey: "customer-2"},
+				{Data: `{"order":3,"kind":"placed"}`},
+				{Data: `{"order":4,"kind":"paid"}`, OrderingKey: "customer-4"},
+			},
+		},
+		{
+			name:      "attributes and timestamp",
+			templates: []Seed{{Data: "tick {i} at {ts}", Attributes: map[string]string{"seq": "{i}", "emitted": "{ts}", "source": "clock"}}},
+			count:     2,
+			want: []Seed{
+				{Data: "tick 1 at 2026-03-09T13:30:00.125Z", Attributes: map[string]string{"seq": "1", "emitted": "2026-03-09T13:30:00.125Z", "source": "clock"}},
+				{Data: "tick 2 at 2026-03-09T13:30:00.125Z", Attributes: map[string]string{"seq": "2", "emitted": "2026-03-09T13:30:00.125Z", "source": "clock"}},
+			},
+		},
+		{
+			name:      "no placeholders",
+			templates: []Seed{{Data: "ping"}},
+			count:     2,
+			want:      []Seed{{Data: "ping"}, {Data: "ping"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expandSeeds(tt.templates, tt.count, now)
+			if !slices.EqualFunc(got, tt.want, func(a, b Seed) bool {
+				return a.Data == b.Data && a.OrderingKey == b.OrderingKey && maps.Equal(a.Attributes, b.Attributes)
+			}) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	// The templates are left as is.
+	templates := []Seed{{Data: "msg-{i}", Attributes: map[string]string{"seq": "{i}"}}}
+	expandSeeds(templates, 2, now)
+	if templates[0].Data != "msg-{i}" || templates[0].Attributes["seq"] != "{i}" {
+		t.Errorf("expected the templates to be unchanged, got %+v", templates)
+	}
+}
+
+func TestCreateSeedCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		seeds   []string
+		options string
+		// want are the data and the attributes of the published messages,
+		// as "data attr=value ...".
+		want []string
+	}{
+		{
+			name:    "counter",
+			seeds:   []string{`{"data":"job-{i}"}`},
+			options: ",seedcount=3",
+			want:    []string{"job-1", "job-2", "job-3"},
+		},
+		{
+			name:    "templated attributes",
+			seeds:   []string{`{"data":"reading","attributes":{"sensor":"s{i}"}}`},
+			options: ",seedcount=2,seedattrs=batch:b{i}",
+			want:    []string{"reading batch=b1 sensor=s1", "reading batch=b2 sensor=s2"},
+		},
+		{
+			name:  "without seedcount",
+			seeds: []string{`{"data":"job-{i}"}`},
+			want:  []string{"job-{i}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, srv := newTestServer(t)
+
+			if err := create(t, p, "project1,jobs[seed="+writeSeeds(t, tt.seeds...)+tt.options+"]"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var got []string
+			for _, msg := range srv.Messages() {
+				parts := []string{string(msg.Data)}
+				for _, key := range slices.Sorted(maps.Keys(msg.Attributes)) {
+					parts = append(parts, key+"="+msg.Attributes[key])
+				}
+				got = append(got, strings.Join(parts, " "))
+			}
+			slices.Sort(got)
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected the messages %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// The timestamp is the time of the expansion.
+	p, _, srv := newTestServer(t)
+	before := time.Now()
+	if err := create(t, p, "project1,ticks[seed="+writeSeeds(t, `{"data":"{ts}"}`)+",seedcount=1]"); err != nil {
+		t.Fatal(err)
+	}
+	messages := srv.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 seed message, got %d", len(messages))
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, string(messages[0].Data)); err != nil || ts.Before(before.Truncate(time.Second)) || ts.After(time.Now()) {
+		t.Errorf("expected the time of the expansion, got %q (%v)", messages[0].Data, err)
+	}
+}
@@ -0,0 +1,69 @@
+package provision
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the units of byte sizes, largest first so that formatSize
+// picks the largest unit that fits.
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GiB", 1 << 30},
+	{"GB", 1000 * 1000 * 1000},
+	{"MiB", 1 << 20},
+	{"MB", 1000 * 1000},
+	{"KiB", 1 << 10},
+	{"KB", 1000},
+}
+
+// parseSize parses the byte size of the named option, like "1MB" or "512KiB".
+// A size without a unit is a number of bytes.
+func parseSize(name, value string) (int64, error) {
+	number, unit := value, int64(1)
+	for _, u := range byteUnits {
+		if n, ok := strings.CutSuffix(value, u.suffix); ok {
+			number, unit = n, u.size
+			break
+		}
+	}
+	number = strings.TrimSuffix(number, "B")
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s: invalid size %q", name, value)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("%s: size %q is too large", name, value)
+	}
+
+	return n * unit, nil
+}
+
+// formatSize formats a byte size in the largest unit that divides it.
+func formatSize(size int64) string {
+	for _, u := range byteUnits {
+		if size%u.size == 0 {
+			return strconv.FormatInt(size/u.size, 10) + u.suffix
+		}
+	}
+
+	return strconv.FormatInt(size, 10) + "B"
+}
+
+// checkSize checks that the byte size of the named option is within the
+// specified bounds.
+func checkSize(name string, size, min, max int64) error {
+	switch {
+	case size < min:
+		return fmt.Errorf("%s: %s below minimum %s", name, formatSize(size), formatSize(min))
+	case size > max:
+		return fmt.Errorf("%s: %s above maximum %s", name, formatSize(size), formatSize(max))
+	}
+
+	return nil
+}
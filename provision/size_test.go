@@ -0,0 +1,44 @@
+package provision
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   string
+	}{
+		{value: "512", want: 512},
+		{value: "512B", want: 512},
+		{value: "4KB", want: 4000},
+		{value: "4KiB", want: 4096},
+		{value: "10MB", want: 10 * 1000 * 1000},
+		{value: "2GiB", want: 2 << 30},
+		{value: "9223372036854775807", want: 1<<63 - 1},
+		{value: "8589934591GiB", want: 8589934591 << 30},
+		{value: "8589934592GiB", err: "too large"},
+		{value: "9223372036854775807KB", err: "too large"},
+		{value: "0MB", err: "invalid size"},
+		{value: "-1KB", err: "invalid size"},
+		{value: "1TB", err: "invalid size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			size, err := parseSize("gcsmaxbytes", tt.value)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case size != tt.want:
+				t.Errorf("expected %d, got %d", tt.want, size)
+			}
+		})
+	}
+}
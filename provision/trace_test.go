@@ -0,0 +1,122 @@
+package provision
+
+import (
+	"slices"
+	"testing"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordSpans makes the tracer record its spans until the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	original := tracer
+	tracer = provider.Tracer("test")
+	t.Cleanup(func() { tracer = original })
+
+	return recorder
+}
+
+func TestCreateSpans(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		failSub    string
+		spans      []string
+		failed     []string
+	}{
+		{
+			name:       "topic and subscriptions",
+			definition: "project1,invoices:billing:audit",
+			spans:      []string{"pubsubc.create", "pubsubc.project project1", "pubsubc.topic invoices", "pubsubc.subscription billing", "pubsubc.subscription audit"},
+		},
+		{
+			name:       "topics without subscriptions",
+			definition: "project1,clicks,views",
+			spans:      []string{"pubsubc.create", "pubsubc.project project1", "pubsubc.topic clicks", "pubsubc.topic views"},
+		},
+		{
+			name:       "failed subscription",
+			definition: "project1,refunds:ledger",
+			failSub:    "projects/project1/subscriptions/ledger",
+			spans:      []string{"pubsubc.create", "pubsubc.project project1", "pubsubc.topic refunds", "pubsubc.subscription ledger"},
+			failed:     []string{"pubsubc.create", "pubsubc.subscription ledger"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := recordSpans(t)
+
+			failSub := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				if sub, ok := req.(interface{ GetName() string }); ok && sub.GetName() == tt.failSub {
+					return true, nil, status.Error(grpccodes.PermissionDenied, "denied")
+				}
+
+				return false, nil, nil
+			})
+
+			p, _ := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: failSub})
+			if err := create(t, p, tt.definition); (err != nil) != (tt.failSub != "") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var spans, failed []string
+			var root, project sdktrace.ReadOnlySpan
+			for _, span := range recorder.Ended() {
+				name := span.Name()
+				for _, attr := range span.Attributes() {
+					if attr.Key == "pubsub.project" && name == "pubsubc.project" ||
+						attr.Key == "pubsub.topic" && name == "pubsubc.topic" ||
+						attr.Key == "pubsub.subscription" {
+						name += " " + attr.Value.AsString()
+					}
+				}
+
+				spans = append(spans, name)
+				if span.Status().Code == codes.Error {
+					failed = append(failed, name)
+				}
+				switch span.Name() {
+				case "pubsubc.create":
+					root = span
+				case "pubsubc.project":
+					project = span
+				}
+			}
+
+			slices.Sort(spans)
+			slices.Sort(tt.spans)
+			if !slices.Equal(spans, tt.spans) {
+				t.Errorf("expected spans %q, got %q", tt.spans, spans)
+			}
+			slices.Sort(failed)
+			if !slices.Equal(failed, tt.failed) {
+				t.Errorf("expected failed spans %q, got %q", tt.failed, failed)
+			}
+
+			// The project span is a child of the create span, and the spans
+			// of the resources are children of the project span.
+			if root == nil || project == nil {
+				t.Fatal("expected a create and a project span")
+			}
+			if project.Parent().SpanID() != root.SpanContext().SpanID() {
+				t.Error("expected the project span to be a child of the create span")
+			}
+			for _, span := range recorder.Ended() {
+				if span != root && span != project && span.Parent().SpanID() != project.SpanContext().SpanID() {
+					t.Errorf("expected span %q to be a child of the project span", span.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package provision
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// functionRegexp matches the name of a JavaScript function declaration.
+var functionRegexp = regexp.MustCompile(`(?m)^\s*function\s+([A-Za-z_$][\w$]*)\s*\(`)
+
+// loadTransform reads a JavaScript UDF from the specified file. The first
+// function that the file declares is the one that transforms the messages.
+func loadTransform(filename string) (pubsub.MessageTransform, error) {
+	code, err := os.ReadFile(filename)
+	if err != nil {
+		return pubsub.MessageTransform{}, fmt.Errorf("Unable to read transform file %q: %s", filename, err)
+	}
+
+	m := functionRegexp.FindSubmatch(code)
+	if m == nil {
+		return pubsub.MessageTransform{}, fmt.Errorf("Transform file %q does not declare a function", filename)
+	}
+
+	return pubsub.MessageTransform{
+		Transform: pubsub.JavaScriptUDF{FunctionName: string(m[1]), Code: string(code)},
+	}, nil
+}
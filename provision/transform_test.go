@@ -0,0 +1,114 @@
+package provision
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateTransform(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, code string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(code), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	redact := write("redact.js", "// Removes the e-mail addresses.\nfunction redact(message, metadata) {\n  return message;\n}\n")
+	arrow := write("arrow.js", "const redact = (message) => message;\n")
+
+	tests := []struct {
+		name string
+		file string
+		// unsupported makes the emulator reject message transforms.
+		unsupported bool
+		// existing creates the topic and subscription before the run, which
+		// skips them.
+		existing bool
+		strict   bool
+		function string
+		warning  string
+		err      string
+	}{
+		{name: "supported", file: redact, function: "redact"},
+		{name: "unsupported", file: redact, unsupported: true, warning: `Subscription "signups-crm": option transform has no effect: the emulator does not support message transforms`},
+		{name: "unsupported in strict mode", file: redact, unsupported: true, strict: true, err: `Subscription "signups-crm": option transform has no effect: the emulator does not support message transforms`},
+		{name: "unsupported and existing", file: redact, unsupported: true, existing: true, warning: `Subscription "signups-crm": option transform has no effect: the emulator does not support message transforms`},
+		{name: "no function", file: arrow, err: "does not declare a function"},
+		{name: "missing file", file: filepath.Join(dir, "missing.js"), err: "Unable to read transform file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejectTransforms := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				if tt.unsupported && len(req.(*pubsubpb.Subscription).MessageTransforms) > 0 {
+					return true, nil, status.Error(codes.Unimplemented, "unknown field message_transforms")
+				}
+				return false, nil, nil
+			})
+
+			p, client := newTestProvisioner(t, pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: rejectTransforms})
+			p.Strict = tt.strict
+			if tt.existing {
+				if err := create(t, p, "project1,signups:signups-crm"); err != nil {
+					t.Fatal(err)
+				}
+				p.SkipExistingTopics, p.SkipExistingSubscriptions = true, true
+			}
+
+			var skipped []string
+			p.OnSkip = func(r Resource) { skipped = append(skipped, r.Name) }
+
+			logger := &testLogger{}
+			p.Logger = logger
+
+			err := create(t, p, "project1,signups:signups-crm;transform="+tt.file)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := client.Subscription("signups-crm").Config(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var function string
+			for _, transform := range cfg.MessageTransforms {
+				if udf, ok := transform.Transform.(pubsub.JavaScriptUDF); ok {
+					function = udf.FunctionName
+				}
+			}
+			if function != tt.function {
+				t.Errorf("expected the transform function %q, got %q", tt.function, function)
+			}
+
+			warned := slices.ContainsFunc(logger.warnings, func(warning string) bool {
+				return strings.HasPrefix(warning, tt.warning)
+			})
+			if tt.warning != "" && !warned {
+				t.Errorf("expected a warning %q, got %q", tt.warning, logger.warnings)
+			}
+
+			if want := tt.existing; slices.Contains(skipped, "projects/project1/subscriptions/signups-crm") != want {
+				t.Errorf("expected the subscription to be skipped to be %t, got the skipped resources %q", want, skipped)
+			}
+		})
+	}
+}
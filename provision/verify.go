@@ -0,0 +1,222 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// verifyTimeout is how long a verification waits for the messages it expects,
+// if the run has no deadline.
+const verifyTimeout = 10 * time.Second
+
+// verifyContext returns the context of a verification that waits for
+// messages, which ends at the deadline of the run, or after verifyTimeout if
+// the run has none.
+func verifyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, verifyTimeout)
+}
+
+// receiveSettings returns the receive settings of the verifying subscribers.
+func (c *creator) receiveSettings() pubsub.ReceiveSettings {
+	settings := c.ReceiveSettings
+	if settings.MaxOutstandingMessages == 0 {
+		settings.MaxOutstandingMessages = 100
+	}
+	if settings.NumGoroutines == 0 {
+		settings.NumGoroutines = 1
+	}
+
+	return settings
+}
+
+// verifierID returns the ID of a temporary subscription that verifies the
+// messages of a topic.
+func verifierID(topicID string) string {
+	return "pubsubc-verify-" + topicID + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// deleteVerifier deletes a temporary subscription that verified messages.
+func (c *creator) deleteVerifier(ctx context.Context, sub *pubsub.Subscription) {
+	if err := sub.Delete(context.WithoutCancel(ctx)); err != nil {
+		c.warnf("Unable to delete subscription %q: %s", sub.ID(), err)
+	}
+}
+
+// verifyRetention checks that the messages that the topic retains can be
+// replayed. It creates a temporary subscription, seeks it to the start of the
+// retention and receives the messages until at least the seed messages of the
+// topic were replayed. The temporary subscription is deleted afterwards.
+// Topics without seed messages have nothing to replay, and are skipped.
+func (c *creator) verifyRetention(ctx context.Context, client *pubsub.Client, topic *pubsub.Topic, t Topic) error {
+	expected := c.seeded[topic.String()]
+	if expected == 0 {
+		c.debugf("  Not verifying the retention of topic %q, because no seed messages were published to it", t.ID)
+		return nil
+	}
+
+	id := verifierID(t.ID)
+
+	c.debugf("  Verifying the retention of topic %q with subscription %q", t.ID, id)
+	sub, err := client.CreateSubscription(ctx, id, pubsub.SubscriptionConfig{Topic: topic})
+	if err != nil {
+		return fmt.Errorf("Unable to create subscription %q to verify the retention of topic %q: %s", id, t.ID, err)
+	}
+	defer c.deleteVerifier(ctx, sub)
+
+	if err := sub.SeekToTime(ctx, time.Now().Add(-t.Retention)); err != nil {
+		return fmt.Errorf("Unable to seek subscription %q to verify the retention of topic %q: %s", id, t.ID, err)
+	}
+
+	sub.ReceiveSettings = c.receiveSettings()
+
+	receiveCtx, cancel := verifyContext(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		replayed int
+	)
+
+	err = sub.Receive(receiveCtx, func(_ context.Context, msg *pubsub.Message) {
+		msg.Ack()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if replayed++; replayed >= expected {
+			cancel()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Unable to receive the retained messages of topic %q: %s", t.ID, err)
+	}
+
+	c.infof("Replayed %d retained message(s) of topic %q", replayed, t.ID)
+
+	if replayed < expected {
+		return fmt.Errorf("Topic %q: replayed %d of %d seed message(s) from the retention", t.ID, replayed, expected)
+	}
+
+	return nil
+}
+
+// hasOrderingKeys returns true if any of the seed messages has an ordering
+// key.
+func hasOrderingKeys(seeds []Seed) bool {
+	for _, seed := range seeds {
+		if seed.OrderingKey != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// orderVerifier creates a temporary ordered subscription on the topic.
+func (c *creator) orderVerifier(ctx context.Context, project Config, topic *pubsub.Topic) (*pubsub.Subscription, error) {
+	client, err := c.Clients.Client(ctx, project.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	id := verifierID(topic.ID())
+
+	c.debugf("  Verifying the message order of topic %q with subscription %q", topic.ID(), id)
+	sub, err := client.CreateSubscription(ctx, id, pubsub.SubscriptionConfig{Topic: topic, EnableMessageOrdering: true})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create subscription %q to verify the message order of topic %q: %s", id, topic.ID(), err)
+	}
+
+	return sub, nil
+}
+
+// verifyOrder receives the seed messages with an ordering key from the order
+// verifier, and checks that the messages of every ordering key arrive in the
+// order they were published in. It waits until the required number of
+// messages arrived in order, or until the timeout expires.
+func (c *creator) verifyOrder(ctx context.Context, sub *pubsub.Subscription, t Topic, seeds []Seed) error {
+	expected := make(map[string][]string)
+	var total int
+	for _, seed := range seeds {
+		if seed.OrderingKey != "" {
+			expected[seed.OrderingKey] = append(expected[seed.OrderingKey], seed.Data)
+			total++
+		}
+	}
+
+	required := c.VerifyOrderCount
+	if required <= 0 || required > total {
+		required = total
+	}
+
+	timeout := c.VerifyOrderTimeout
+	if timeout <= 0 {
+		timeout = verifyTimeout
+	}
+
+	receiveCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		mu         sync.Mutex
+		next       = make(map[string]int)
+		inOrder    int
+		outOfOrder []string
+	)
+
+	sub.ReceiveSettings = c.receiveSettings()
+	err := sub.Receive(receiveCtx, func(_ context.Context, msg *pubsub.Message) {
+		msg.Ack()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		want, ok := expected[msg.OrderingKey]
+		if !ok {
+			return
+		}
+
+		i := next[msg.OrderingKey]
+		switch {
+		// Redeliveries of the previous message don't break the order.
+		case i > 0 && want[i-1] == string(msg.Data):
+			return
+		case i < len(want) && want[i] == string(msg.Data):
+			next[msg.OrderingKey]++
+			inOrder++
+		default:
+			outOfOrder = append(outOfOrder, msg.OrderingKey)
+		}
+
+		if inOrder >= required {
+			cancel()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Unable to receive the seed messages of topic %q: %s", t.ID, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(outOfOrder) > 0 {
+		return fmt.Errorf("Topic %q: %d seed message(s) arrived out of order, with ordering keys %s", t.ID, len(outOfOrder), strings.Join(outOfOrder, ", "))
+	}
+
+	if inOrder < required {
+		return fmt.Errorf("Topic %q: only %d of %d required seed message(s) arrived in order within %s", t.ID, inOrder, required, timeout)
+	}
+
+	c.infof("Verified the order of %d of %d seed message(s) with an ordering key of topic %q", inOrder, total, t.ID)
+	return nil
+}
@@ -0,0 +1,221 @@
+package provision
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+func TestReceiveSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings pubsub.ReceiveSettings
+		expected pubsub.ReceiveSettings
+	}{
+		{
+			name:     "defaults",
+			expected: pubsub.ReceiveSettings{MaxOutstandingMessages: 100, NumGoroutines: 1},
+		},
+		{
+			name:     "outstanding messages",
+			settings: pubsub.ReceiveSettings{MaxOutstandingMessages: 8},
+			expected: pubsub.ReceiveSettings{MaxOutstandingMessages: 8, NumGoroutines: 1},
+		},
+		{
+			name:     "goroutines",
+			settings: pubsub.ReceiveSettings{NumGoroutines: 4},
+			expected: pubsub.ReceiveSettings{MaxOutstandingMessages: 100, NumGoroutines: 4},
+		},
+		{
+			name:     "other settings are kept",
+			settings: pubsub.ReceiveSettings{MaxOutstandingMessages: 1, NumGoroutines: 2, MaxOutstandingBytes: 4096},
+			expected: pubsub.ReceiveSettings{MaxOutstandingMessages: 1, NumGoroutines: 2, MaxOutstandingBytes: 4096},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &creator{Provisioner: &Provisioner{ReceiveSettings: tt.settings}}
+
+			if settings := c.receiveSettings(); settings != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, settings)
+			}
+		})
+	}
+}
+
+func TestCreateVerifyRetention(t *testing.T) {
+	seeds := []string{`{"data":"snapshot-1"}`, `{"data":"snapshot-2"}`, `{"data":"snapshot-3"}`}
+
+	tests := []struct {
+		name      string
+		options   string
+		replays   int
+		verifiers int
+		err       string
+	}{
+		{name: "replayed", options: "retain=1h,seed=", replays: 3, verifiers: 1},
+		{name: "partially replayed", options: "retain=1h,seed=", replays: 1, verifiers: 1, err: `Topic "snapshots": replayed 1 of 3 seed message(s) from the retention`},
+		{name: "not replayed", options: "retain=1h,seed=", verifiers: 1, err: `Topic "snapshots": replayed 0 of 3 seed message(s) from the retention`},
+		{name: "no seeds", options: "retain=1h", verifiers: 0},
+		{name: "no retention", options: "seed=", verifiers: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srv *pstest.Server
+
+			var mu sync.Mutex
+			var verifiers int
+			countVerifiers := reactorFunc(func(interface{}) (bool, interface{}, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				verifiers++
+				return false, nil, nil
+			})
+
+			// The seek of pstest drops the data of the messages it replays,
+			// so replaying is faked by republishing to the topic once the
+			// seek returned.
+			replay := reactorFunc(func(interface{}) (bool, interface{}, error) {
+				go func() {
+					for i := 0; i < tt.replays; i++ {
+						srv.Publish("projects/project1/topics/snapshots", []byte("replayed"), nil)
+					}
+				}()
+
+				return true, nil, nil
+			})
+
+			p, client, srv := newTestServer(t,
+				pstest.ServerReactorOption{FuncName: "CreateSubscription", Reactor: countVerifiers},
+				pstest.ServerReactorOption{FuncName: "Seek", Reactor: replay},
+			)
+			p.VerifyRetention = true
+
+			options := tt.options
+			if strings.HasSuffix(options, "seed=") {
+				options += writeSeeds(t, seeds...)
+			}
+
+			cfg, err := (&Parser{}).Parse("project1,snapshots[" + options + "]")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The verification waits until the deadline of the run.
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			_, err = p.Create(ctx, []Config{cfg})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if verifiers != tt.verifiers {
+				t.Errorf("expected %d verifying subscriptions, got %d", tt.verifiers, verifiers)
+			}
+
+			// The verifying subscription is deleted afterwards.
+			if sub, err := client.Subscriptions(context.Background()).Next(); err == nil {
+				t.Errorf("expected no subscriptions, got %q", sub.ID())
+			}
+		})
+	}
+}
+
+func TestCreateVerifyOrder(t *testing.T) {
+	seeds := []string{
+		`{"data":"depot","orderingKey":"truck-7"}`,
+		`{"data":"loaded","orderingKey":"truck-7"}`,
+		`{"data":"depot","orderingKey":"truck-9"}`,
+		`{"data":"delivered","orderingKey":"truck-9"}`,
+		`{"data":"unordered"}`,
+	}
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		required int
+		// delay is how long the delivery of the last truck-9 seed message is
+		// held back, if it isn't 0, and it is never delivered if it is
+		// negative.
+		delay time.Duration
+		err   string
+	}{
+		{name: "in order", timeout: 3 * time.Second},
+		{name: "delayed within the timeout", timeout: 3 * time.Second, delay: 300 * time.Millisecond},
+		{name: "delayed beyond the timeout", timeout: 300 * time.Millisecond, delay: 2 * time.Second, err: `Topic "fleet": only 3 of 4 required seed message(s) arrived in order within 300ms`},
+		{name: "never delivered", timeout: 400 * time.Millisecond, delay: -1, err: `Topic "fleet": only 3 of 4 required seed message(s) arrived in order within 400ms`},
+		{name: "required count", timeout: 3 * time.Second, required: 3, delay: -1},
+		{name: "required count above the seeds", timeout: 3 * time.Second, required: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const topicName = "projects/project1/topics/fleet"
+
+			var (
+				srv  *pstest.Server
+				wg   sync.WaitGroup
+				mu   sync.Mutex
+				held bool
+			)
+
+			holdBack := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				msg := req.(*pubsubpb.PublishRequest).Messages[0]
+				if tt.delay == 0 || msg.OrderingKey != "truck-9" || string(msg.Data) != "delivered" {
+					return false, nil, nil
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				if held {
+					return false, nil, nil
+				}
+				held = true
+
+				if tt.delay > 0 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+
+						time.Sleep(tt.delay)
+						srv.PublishOrdered(topicName, msg.Data, msg.Attributes, msg.OrderingKey)
+					}()
+				}
+
+				return true, &pubsubpb.PublishResponse{MessageIds: []string{"held-back"}}, nil
+			})
+
+			p, _, srv := newTestServer(t, pstest.ServerReactorOption{FuncName: "Publish", Reactor: holdBack})
+			t.Cleanup(wg.Wait)
+
+			p.VerifyOrder = true
+			p.VerifyOrderTimeout = tt.timeout
+			p.VerifyOrderCount = tt.required
+
+			err := create(t, p, "project1,fleet[seed="+writeSeeds(t, seeds...)+"]")
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// quietLog holds the messages of the log helpers with -quiet-on-success, or
+// is nil if they are printed right away.
+var quietLog *bufferedLog
+
+// bufferedLog holds log messages until they are flushed.
+type bufferedLog struct {
+	mu       sync.Mutex
+	messages []logMessage
+}
+
+// logMessage is a log message and the file it is printed to.
+type logMessage struct {
+	f    *os.File
+	text string
+}
+
+// logf prints a log message to the file, or adds it to quietLog if it is set.
+func logf(f *os.File, format string, params ...interface{}) {
+	text := fmt.Sprintf(format+"\n", params...)
+
+	if l := quietLog; l != nil {
+		l.mu.Lock()
+		l.messages = append(l.messages, logMessage{f: f, text: text})
+		l.mu.Unlock()
+		return
+	}
+
+	fmt.Fprint(f, text)
+}
+
+// flushLogs prints the messages of quietLog in the order they were logged,
+// and stops buffering.
+func flushLogs() {
+	l := quietLog
+	if l == nil {
+		return
+	}
+	quietLog = nil
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, m := range l.messages {
+		fmt.Fprint(m.f, m.text)
+	}
+	l.messages = nil
+}
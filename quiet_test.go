@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuietOnSuccess(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// fail makes the creation of the subscriptions fail.
+		fail   bool
+		exit   int
+		stdout string
+		// output are the lines that stdout followed by stderr must contain,
+		// in order.
+		output []string
+	}{
+		{
+			name:   "success is silent",
+			args:   []string{"-quiet-on-success"},
+			stdout: "pubsubc: create succeeded\n",
+		},
+		{
+			name:   "success with debug logging",
+			args:   []string{"-quiet-on-success", "-debug"},
+			stdout: "pubsubc: create succeeded\n",
+		},
+		{
+			name:   "without the flag",
+			args:   []string{"-debug"},
+			output: []string{`Creating topic "builds"`, `Creating subscription "builds-notifier"`},
+		},
+		{
+			name: "failure prints the logs",
+			args: []string{"-quiet-on-success", "-debug"},
+			fail: true,
+			exit: 1,
+			output: []string{
+				`Creating topic "builds"`,
+				`pubsubc: Unable to create subscription "builds-notifier"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+
+			newTestServer(t, pstest.ServerReactorOption{
+				FuncName: "CreateSubscription",
+				Reactor: reactorFunc(func(interface{}) (bool, interface{}, error) {
+					if tt.fail {
+						return true, nil, status.Error(codes.PermissionDenied, "not allowed")
+					}
+					return false, nil, nil
+				}),
+			})
+			t.Setenv("PUBSUB_PROJECT1", "ci,builds:builds-notifier")
+
+			// Run this test binary as pubsubc, so that the messages are
+			// prefixed the same way.
+			var stdout, stderr strings.Builder
+			cmd := exec.Command(os.Args[0], tt.args...)
+			cmd.Args[0] = "pubsubc"
+			cmd.Env = append(os.Environ(), "PUBSUBC_TEST_MAIN=1")
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			err := cmd.Run()
+
+			var exit int
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exit = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if exit != tt.exit {
+				t.Errorf("expected exit code %d, got %d:\n%s%s", tt.exit, exit, stdout.String(), stderr.String())
+			}
+			if tt.stdout != "" && stdout.String() != tt.stdout {
+				t.Errorf("expected stdout %q, got %q", tt.stdout, stdout.String())
+			}
+			if stderr.Len() > 0 && tt.exit == 0 {
+				t.Errorf("expected no stderr, got %q", stderr.String())
+			}
+
+			rest := stdout.String() + stderr.String()
+			if strings.Contains(rest, "succeeded") != (tt.stdout != "") {
+				t.Errorf("unexpected output:\n%s", rest)
+			}
+			for _, line := range tt.output {
+				i := strings.Index(rest, line)
+				if i < 0 {
+					t.Fatalf("expected the output to contain %q in order, got:\n%s%s", line, stdout.String(), stderr.String())
+				}
+				rest = rest[i+len(line):]
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// randomProjectIDs maps the configured project IDs to their randomized
+// project IDs, with -randomize-projects.
+var randomProjectIDs map[string]string
+
+// randomizeProjects appends a random suffix to the project ID of every
+// project, and updates the references to those projects. A project ID keeps
+// its suffix for the rest of the run.
+func randomizeProjects(projects []provision.Config) []provision.Config {
+	if randomProjectIDs == nil {
+		randomProjectIDs = make(map[string]string)
+	}
+
+	for _, project := range projects {
+		if _, ok := randomProjectIDs[project.ProjectID]; !ok {
+			randomProjectIDs[project.ProjectID] = project.ProjectID + "-" + randomSuffix()
+			infof("Project %q is randomized to %q", project.ProjectID, randomProjectIDs[project.ProjectID])
+		}
+	}
+
+	randomized := make([]provision.Config, len(projects))
+	for i, project := range projects {
+		randomized[i] = project.WithProjectIDs(randomProjectIDs)
+	}
+
+	return randomized
+}
+
+// randomSuffix returns 6 random hexadecimal characters.
+func randomSuffix() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestRandomizeProjects(t *testing.T) {
+	tests := []struct {
+		name     string
+		projects []string
+		// expected are the resources of each configured project, as "topic"
+		// or "topic:subscription>dead-letter topic", in which {project} is
+		// replaced by the randomized project ID.
+		expected map[string][]string
+	}{
+		{
+			name:     "single project",
+			projects: []string{"search,queries:queries-indexer"},
+			expected: map[string][]string{"search": {"queries", "queries:queries-indexer"}},
+		},
+		{
+			name:     "local dead letters",
+			projects: []string{"orders,placed:placed-mailer;dlq=placed-dead,placed-dead"},
+			expected: map[string][]string{"orders": {"placed", "placed-dead", "placed:placed-mailer>projects/{orders}/topics/placed-dead"}},
+		},
+		{
+			name: "dead letters in another project",
+			projects: []string{
+				"orders,placed-dead",
+				"billing,invoices:invoices-ledger;dlq=projects/orders/topics/placed-dead",
+			},
+			expected: map[string][]string{
+				"orders":  {"placed-dead"},
+				"billing": {"invoices", "invoices:invoices-ledger>projects/{orders}/topics/placed-dead"},
+			},
+		},
+	}
+
+	suffix := regexp.MustCompile(`^[a-z]+-[0-9a-f]{6}$`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newTestClients(t)
+			t.Cleanup(func() { randomProjectIDs = nil })
+
+			output := filepath.Join(t.TempDir(), "created.json")
+			setFlags(t, "-randomize-projects", "-output-file", output)
+
+			var projects []provision.Config
+			for _, definition := range tt.projects {
+				cfg, err := (&provision.Parser{}).Parse(definition)
+				if err != nil {
+					t.Fatalf("unable to parse %q: %s", definition, err)
+				}
+				projects = append(projects, cfg)
+			}
+
+			ctx := context.Background()
+			if err := runCreate(ctx, projects); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			b, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out struct {
+				Resources  []provision.Resource `json:"resources"`
+				ProjectIDs map[string]string    `json:"projects"`
+			}
+			if err := json.Unmarshal(b, &out); err != nil {
+				t.Fatalf("invalid output file %s: %s", b, err)
+			}
+
+			for projectID, randomized := range out.ProjectIDs {
+				if !strings.HasPrefix(randomized, projectID+"-") || !suffix.MatchString(randomized) {
+					t.Errorf("expected %q to be randomized with a suffix, got %q", projectID, randomized)
+				}
+			}
+			for _, r := range out.Resources {
+				if !slices.Contains(slices.Collect(maps.Values(out.ProjectIDs)), r.Project) {
+					t.Errorf("expected %s to be created in a randomized project", r.Name)
+				}
+			}
+
+			for projectID, expected := range tt.expected {
+				randomized, ok := out.ProjectIDs[projectID]
+				if !ok {
+					t.Fatalf("expected %q in the output file, got %v", projectID, out.ProjectIDs)
+				}
+
+				client, err := clients.Client(ctx, randomized)
+				if err != nil {
+					t.Fatal(err)
+				}
+				configured, err := clients.Client(ctx, projectID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, e := range expected {
+					for from, to := range out.ProjectIDs {
+						e = strings.ReplaceAll(e, "{"+from+"}", to)
+					}
+					e, dlq, _ := strings.Cut(e, ">")
+					topicID, subID, _ := strings.Cut(e, ":")
+
+					if ok, err := client.Topic(topicID).Exists(ctx); err != nil || !ok {
+						t.Errorf("expected topic %q in %q (%v)", topicID, randomized, err)
+					}
+
+					// Nothing is created in the configured project.
+					if ok, err := configured.Topic(topicID).Exists(ctx); err != nil || ok {
+						t.Errorf("expected no topic %q in %q (%v)", topicID, projectID, err)
+					}
+
+					if subID == "" {
+						continue
+					}
+
+					cfg, err := client.Subscription(subID).Config(ctx)
+					switch {
+					case err != nil:
+						t.Errorf("expected subscription %q in %q: %s", subID, randomized, err)
+					case cfg.Topic.String() != "projects/"+randomized+"/topics/"+topicID:
+						t.Errorf("%s: expected the topic to be %q, got %q", subID, topicID, cfg.Topic)
+					case dlq != "" && (cfg.DeadLetterPolicy == nil || cfg.DeadLetterPolicy.DeadLetterTopic != dlq):
+						t.Errorf("%s: expected dead-letter topic %q, got %+v", subID, dlq, cfg.DeadLetterPolicy)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRandomizeProjectsStable(t *testing.T) {
+	t.Cleanup(func() { randomProjectIDs = nil })
+
+	first := randomizeProjects([]provision.Config{{ProjectID: "ingest"}, {ProjectID: "reports"}})
+	second := randomizeProjects([]provision.Config{{ProjectID: "reports"}, {ProjectID: "ingest"}})
+
+	switch {
+	case first[0].ProjectID == "ingest" || first[1].ProjectID == "reports":
+		t.Fatalf("expected randomized project IDs, got %q and %q", first[0].ProjectID, first[1].ProjectID)
+	case first[0].ProjectID == first[1].ProjectID:
+		t.Fatalf("expected different project IDs, got %q twice", first[0].ProjectID)
+	}
+
+	// A project keeps its suffix for the rest of the run, like the reconciles
+	// of -watch.
+	if second[1].ProjectID != first[0].ProjectID || second[0].ProjectID != first[1].ProjectID {
+		t.Errorf("expected %q and %q again, got %q and %q", first[0].ProjectID, first[1].ProjectID, second[1].ProjectID, second[0].ProjectID)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+var (
+	serveAddr   string
+	idleTimeout time.Duration
+)
+
+// serveFlags registers the flags of the serve command.
+func serveFlags(fs *flag.FlagSet) {
+	fs.StringVar(&serveAddr, "addr", ":8080", "Address to listen on")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 0, "Exit after receiving no requests for this long")
+	createFlags(fs)
+}
+
+// server creates, deletes and resets projects on request. The request body
+// contains one project definition per line, in the same form as the
+// PUBSUB_PROJECT environment variables.
+type server struct {
+	// mu serializes the requests, because they operate on the same emulator.
+	mu      sync.Mutex
+	clients *provision.ClientCache
+
+	// idleMu guards inFlight and idle. The idle timer is nil without an
+	// -idle-timeout, and is stopped while requests are in flight.
+	idleMu   sync.Mutex
+	inFlight int
+	idle     *time.Timer
+}
+
+func runServe(ctx context.Context, _ []provision.Config) error {
+	if err := validateCreateFlags(); err != nil {
+		return err
+	}
+
+	switch {
+	case check:
+		return fmt.Errorf("-check is not supported by serve")
+	case dryRun:
+		return fmt.Errorf("-dry-run is not supported by serve")
+	case printHealthcheck:
+		return fmt.Errorf("-print-healthcheck is not supported by serve")
+	case watch:
+		return fmt.Errorf("-watch is not supported by serve")
+	case randomize:
+		return fmt.Errorf("-randomize-projects is not supported by serve")
+	}
+
+	s := &server{clients: newClients()}
+	defer closeClients(s.clients)
+
+	if idleTimeout > 0 {
+		s.idle = time.NewTimer(idleTimeout)
+		defer s.idle.Stop()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", s.handle(func(ctx context.Context, clients provision.Clients, projects []provision.Config) error {
+		_, err := createProjects(ctx, clients, projects)
+		return err
+	}))
+	mux.HandleFunc("/delete", s.handle(deleteProjects))
+	mux.HandleFunc("/reset", s.handle(func(ctx context.Context, clients provision.Clients, projects []provision.Config) error {
+		if err := deleteProjects(ctx, clients, projects); err != nil {
+			return err
+		}
+
+		_, err := createProjects(ctx, clients, projects)
+		return err
+	}))
+
+	srv := &http.Server{Addr: serveAddr, Handler: mux}
+	go s.shutdownWhenDone(ctx, srv)
+
+	debugf("Listening on %s", serveAddr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// shutdownWhenDone gracefully shuts the HTTP server down once the context is
+// cancelled, or once no request was in flight for the -idle-timeout.
+func (s *server) shutdownWhenDone(ctx context.Context, srv *http.Server) {
+	var idle <-chan time.Time
+	if s.idle != nil {
+		idle = s.idle.C
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-idle:
+		infof("No requests for %s, shutting down", idleTimeout)
+	}
+
+	if err := srv.Shutdown(context.WithoutCancel(ctx)); err != nil {
+		warnf("Unable to shut down the server: %s", err)
+	}
+}
+
+// begin records the start of a request, which stops the idle timer.
+func (s *server) begin() {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	s.inFlight++
+	if s.idle != nil && s.inFlight == 1 {
+		stopTimer(s.idle)
+	}
+}
+
+// end records the end of a request, which restarts the idle timer once no
+// other request is in flight.
+func (s *server) end() {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	s.inFlight--
+	if s.idle != nil && s.inFlight == 0 {
+		stopTimer(s.idle)
+		s.idle.Reset(idleTimeout)
+	}
+}
+
+// stopTimer stops the timer and drains its channel, if it already fired.
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// handle returns an HTTP handler that parses the projects in the request body
+// and passes them to the specified function.
+func (s *server) handle(fn func(context.Context, provision.Clients, []provision.Config) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.begin()
+		defer s.end()
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		projects, err := readProjects(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for i, project := range projects {
+			projects[i] = project.WithPrefix(prefix)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := fn(r.Context(), s.clients, projects); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(w, "OK")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+func TestServeIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		// requests is the number of requests that are sent every interval,
+		// which keep the server alive.
+		requests int
+		interval time.Duration
+		// slow is how long a POST /create request takes, if it isn't 0.
+		slow time.Duration
+		// cancel cancels the context after this long, if it isn't 0.
+		cancel time.Duration
+		min    time.Duration
+		max    time.Duration
+	}{
+		{name: "idle", idleTimeout: 300 * time.Millisecond, min: 300 * time.Millisecond, max: 2 * time.Second},
+		{name: "requests reset the timeout", idleTimeout: 400 * time.Millisecond, requests: 4, interval: 200 * time.Millisecond, min: 1200 * time.Millisecond, max: 3 * time.Second},
+		{name: "slow request outlasts the timeout", idleTimeout: 300 * time.Millisecond, slow: 800 * time.Millisecond, min: 1100 * time.Millisecond, max: 3 * time.Second},
+		{name: "without idle timeout", cancel: 600 * time.Millisecond, min: 600 * time.Millisecond, max: 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := l.Addr().String()
+			l.Close()
+
+			setFlags(t)
+			newTestServer(t, pstest.ServerReactorOption{
+				FuncName: "CreateTopic",
+				Reactor: reactorFunc(func(interface{}) (bool, interface{}, error) {
+					time.Sleep(tt.slow)
+					return false, nil, nil
+				}),
+			})
+
+			fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+			serveFlags(fs)
+			if err := fs.Parse([]string{"-addr=" + addr, "-idle-timeout=" + tt.idleTimeout.String()}); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() { done <- runServe(ctx, nil) }()
+
+			// Wait until the server listens.
+			for {
+				if conn, err := net.Dial("tcp", addr); err == nil {
+					conn.Close()
+					break
+				}
+				if time.Since(start) > 2*time.Second {
+					t.Fatalf("expected the server to listen on %s", addr)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			for i := 0; i < tt.requests; i++ {
+				time.Sleep(tt.interval)
+
+				resp, err := http.Get("http://" + addr + "/create")
+				if err != nil {
+					t.Fatalf("expected the server to be alive after %s: %s", time.Since(start), err)
+				}
+				resp.Body.Close()
+			}
+
+			if tt.slow > 0 {
+				resp, err := http.Post("http://"+addr+"/create", "text/plain", strings.NewReader("project1,uploads"))
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", resp.StatusCode, body)
+				}
+			}
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			case <-time.After(tt.max):
+				t.Fatalf("expected the server to shut down within %s", tt.max)
+			}
+
+			if elapsed := time.Since(start); elapsed < tt.min {
+				t.Errorf("expected the server to run for at least %s, got %s", tt.min, elapsed)
+			}
+		})
+	}
+}
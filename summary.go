@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prep/pubsubc/provision"
+)
+
+// operations aggregates the durations of the create and update operations of
+// the run for -summary-only.
+var operations struct {
+	mu    sync.Mutex
+	count int
+	total time.Duration
+	max   time.Duration
+}
+
+// recordOperation adds the duration of an operation to the operations.
+func recordOperation(d time.Duration) {
+	operations.mu.Lock()
+	defer operations.mu.Unlock()
+
+	operations.count++
+	operations.total += d
+	operations.max = max(operations.max, d)
+}
+
+// rolledBack counts the created resources of each type that
+// -rollback-on-error deleted, for -summary-only.
+var rolledBack struct {
+	mu            sync.Mutex
+	topics        int
+	subscriptions int
+}
+
+// recordRollback counts a resource that was rolled back.
+func recordRollback(r provision.Resource) {
+	rolledBack.mu.Lock()
+	defer rolledBack.mu.Unlock()
+
+	if r.Type == "topic" {
+		rolledBack.topics++
+	} else {
+		rolledBack.subscriptions++
+	}
+}
+
+// summary is the single JSON object that -summary-only prints.
+type summary struct {
+	Projects      int           `json:"projects"`
+	Topics        summaryCounts `json:"topics"`
+	Subscriptions summaryCounts `json:"subscriptions"`
+
+	Operations     int    `json:"operations"`
+	OperationsMS   int64  `json:"operations_total_ms"`
+	MaxOperationMS int64  `json:"operations_max_ms"`
+	ElapsedMS      int64  `json:"elapsed_ms"`
+	TimedOut       int    `json:"timed_out"`
+	Error          string `json:"error,omitempty"`
+}
+
+// summaryCounts are the counts of a resource type in the summary. Resources
+// that -rollback-on-error created and then deleted are rolled back, and those
+// it failed to delete are left behind.
+type summaryCounts struct {
+	Created    int `json:"created"`
+	Updated    int `json:"updated"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+	RolledBack int `json:"rolled_back"`
+	LeftBehind int `json:"left_behind"`
+}
+
+// printSummary prints the summary of a run that created the resources of the
+// projects in the elapsed time, and that ended with the error if it is set.
+// With -rollback-on-error, the created resources of a failed run are the ones
+// that it failed to roll back.
+func printSummary(projects []provision.Config, created []provision.Resource, err error, elapsed time.Duration) error {
+	s := summary{Projects: len(projects), ElapsedMS: elapsed.Milliseconds()}
+
+	count := func(r provision.Resource) *summaryCounts {
+		if r.Type == "topic" {
+			return &s.Topics
+		}
+
+		return &s.Subscriptions
+	}
+
+	for _, r := range created {
+		if err != nil && rollbackOnError {
+			count(r).LeftBehind++
+		} else {
+			count(r).Created++
+		}
+	}
+
+	rolledBack.mu.Lock()
+	s.Topics.RolledBack = rolledBack.topics
+	s.Subscriptions.RolledBack = rolledBack.subscriptions
+	rolledBack.mu.Unlock()
+
+	existing.mu.Lock()
+	for _, r := range existing.updated {
+		count(r).Updated++
+	}
+	for _, r := range existing.skipped {
+		count(r).Skipped++
+	}
+	existing.mu.Unlock()
+
+	if err != nil {
+		s.Error = err.Error()
+
+		var partial *provision.PartialError
+		var failed *provision.ResourceError
+		switch {
+		case errors.As(err, &partial):
+			for _, f := range partial.Failures {
+				count(f.Resource).Failed++
+			}
+			s.TimedOut = partial.TimedOut()
+
+		case errors.As(err, &failed):
+			count(failed.Resource).Failed++
+			if failed.TimedOut {
+				s.TimedOut = 1
+			}
+		}
+	}
+
+	operations.mu.Lock()
+	s.Operations = operations.count
+	s.OperationsMS = operations.total.Milliseconds()
+	s.MaxOperationMS = operations.max.Milliseconds()
+	operations.mu.Unlock()
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("Unable to encode the summary: %s", err)
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
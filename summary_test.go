@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPrintSummary(t *testing.T) {
+	topic := func(project, id string) provision.Resource {
+		return provision.Resource{Type: "topic", Project: project, Name: "projects/" + project + "/topics/" + id}
+	}
+	subscription := func(project, id string) provision.Resource {
+		return provision.Resource{Type: "subscription", Project: project, Name: "projects/" + project + "/subscriptions/" + id}
+	}
+
+	tests := []struct {
+		name       string
+		projects   int
+		created    []provision.Resource
+		updated    []provision.Resource
+		skipped    []provision.Resource
+		operations []time.Duration
+		// rollback sets -rollback-on-error, which rolled back rolledBack.
+		rollback   bool
+		rolledBack []provision.Resource
+		err        error
+		want       string
+	}{
+		{
+			name:       "created",
+			projects:   2,
+			created:    []provision.Resource{topic("web", "clicks"), subscription("web", "clicks-agg"), topic("api", "calls"), subscription("api", "calls-log"), subscription("api", "calls-bill")},
+			operations: []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 5 * time.Millisecond, 12 * time.Millisecond, 3 * time.Millisecond},
+			want: `{"projects":2,"topics":{"created":2,"updated":0,"skipped":0,"failed":0,"rolled_back":0,"left_behind":0},"subscriptions":{"created":3,"updated":0,"skipped":0,"failed":0,"rolled_back":0,"left_behind":0},
+				"operations":5,"operations_total_ms":60,"operations_max_ms":30,"elapsed_ms":1500,"timed_out":0}`,
+		},
+		{
+			name:       "updated and skipped",
+			projects:   1,
+			created:    []provision.Resource{subscription("crm", "leads-score")},
+			updated:    []provision.Resource{subscription("crm", "leads-sync")},
+			skipped:    []provision.Resource{topic("crm", "leads")},
+			operations: []time.Duration{40 * time.Millisecond, 20 * time.Millisecond},
+			want: `{"projects":1,"topics":{"created":0,"updated":0,"skipped":1,"failed":0,"rolled_back":0,"left_behind":0},"subscriptions":{"created":1,"updated":1,"skipped":0,"failed":0,"rolled_back":0,"left_behind":0},
+				"operations":2,"operations_total_ms":60,"operations_max_ms":40,"elapsed_ms":1500,"timed_out":0}`,
+		},
+		{
+			name:       "partial failure",
+			projects:   1,
+			created:    []provision.Resource{topic("ml", "features")},
+			operations: []time.Duration{2 * time.Millisecond, 250 * time.Millisecond, 7 * time.Millisecond},
+			err: &provision.PartialError{Failures: []provision.Failure{
+				{Resource: topic("ml", "labels"), Err: errors.New("deadline exceeded"), TimedOut: true},
+				{Resource: subscription("ml", "features-train"), Err: errors.New("permission denied")},
+			}},
+			want: `{"projects":1,"topics":{"created":1,"updated":0,"skipped":0,"failed":1,"rolled_back":0,"left_behind":0},"subscriptions":{"created":0,"updated":0,"skipped":0,"failed":1,"rolled_back":0,"left_behind":0},
+				"operations":3,"operations_total_ms":259,"operations_max_ms":250,"elapsed_ms":1500,"timed_out":1,
+				"error":"Unable to create 2 resource(s): 1 timed out and 1 failed otherwise"}`,
+		},
+		{
+			name:       "fail-fast error",
+			projects:   2,
+			created:    []provision.Resource{topic("iot", "readings"), subscription("iot", "readings-store")},
+			operations: []time.Duration{4 * time.Millisecond, 6 * time.Millisecond, 100 * time.Millisecond},
+			err: &provision.ResourceError{Failure: provision.Failure{
+				Resource: subscription("iot", "readings-alert"), Err: errors.New("deadline exceeded"), TimedOut: true,
+			}},
+			want: `{"projects":2,"topics":{"created":1,"updated":0,"skipped":0,"failed":0,"rolled_back":0,"left_behind":0},"subscriptions":{"created":1,"updated":0,"skipped":0,"failed":1,"rolled_back":0,"left_behind":0},
+				"operations":3,"operations_total_ms":110,"operations_max_ms":100,"elapsed_ms":1500,"timed_out":1,
+				"error":"deadline exceeded"}`,
+		},
+		{
+			name:       "rolled back",
+			projects:   1,
+			created:    []provision.Resource{topic("shop", "carts")},
+			operations: []time.Duration{8 * time.Millisecond, 9 * time.Millisecond, 10 * time.Millisecond},
+			rollback:   true,
+			rolledBack: []provision.Resource{subscription("shop", "carts-abandoned"), topic("shop", "orders")},
+			err: &provision.ResourceError{Failure: provision.Failure{
+				Resource: subscription("shop", "orders-ship"), Err: errors.New("permission denied"),
+			}},
+			want: `{"projects":1,"topics":{"created":0,"updated":0,"skipped":0,"failed":0,"rolled_back":1,"left_behind":1},"subscriptions":{"created":0,"updated":0,"skipped":0,"failed":1,"rolled_back":1,"left_behind":0},
+				"operations":3,"operations_total_ms":27,"operations_max_ms":10,"elapsed_ms":1500,"timed_out":0,
+				"error":"permission denied"}`,
+		},
+		{
+			name:     "other error",
+			projects: 3,
+			err:      fmt.Errorf("Unable to create the PubSub client: connection refused"),
+			want: `{"projects":3,"topics":{"created":0,"updated":0,"skipped":0,"failed":0,"rolled_back":0,"left_behind":0},"subscriptions":{"created":0,"updated":0,"skipped":0,"failed":0,"rolled_back":0,"left_behind":0},
+				"operations":0,"operations_total_ms":0,"operations_max_ms":0,"elapsed_ms":1500,"timed_out":0,
+				"error":"Unable to create the PubSub client: connection refused"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The other tests that create resources record operations too.
+			operations.count, operations.total, operations.max = 0, 0, 0
+			t.Cleanup(func() { operations.count, operations.total, operations.max = 0, 0, 0 })
+
+			existing.updated, existing.skipped = tt.updated, tt.skipped
+			t.Cleanup(func() { existing.updated, existing.skipped = nil, nil })
+
+			var args []string
+			if tt.rollback {
+				args = append(args, "-rollback-on-error")
+			}
+			setFlags(t, args...)
+			rolledBack.topics, rolledBack.subscriptions = 0, 0
+			t.Cleanup(func() { rolledBack.topics, rolledBack.subscriptions = 0, 0 })
+			for _, r := range tt.rolledBack {
+				recordRollback(r)
+			}
+			for _, d := range tt.operations {
+				recordOperation(d)
+			}
+
+			out := captureStdout(t, func() {
+				if err := printSummary(make([]provision.Config, tt.projects), tt.created, tt.err, 1500*time.Millisecond); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			})
+
+			if strings.Count(out, "\n") != 1 || !strings.HasSuffix(out, "\n") {
+				t.Errorf("expected a single line, got %q", out)
+			}
+			if !jsonEqual(t, out, tt.want) {
+				t.Errorf("expected the summary\n%s\ngot\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestMainSummaryOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// deny is the subscription whose creation is denied.
+		deny          string
+		exit          int
+		topics        summaryCounts
+		subscriptions summaryCounts
+		err           string
+		// logs are the buffered log messages that must follow the summary.
+		logs []string
+	}{
+		{
+			name:          "success prints only the summary",
+			args:          []string{"-summary-only", "-debug"},
+			topics:        summaryCounts{Created: 2},
+			subscriptions: summaryCounts{Created: 3},
+		},
+		{
+			name:          "failure prints the logs",
+			args:          []string{"-summary-only", "-debug", "-continue-on-error"},
+			deny:          "spans-sample",
+			exit:          1,
+			topics:        summaryCounts{Created: 2},
+			subscriptions: summaryCounts{Created: 2, Failed: 1},
+			err:           "Unable to create 1 resource(s): 0 timed out and 1 failed otherwise",
+			logs:          []string{`Creating topic "spans"`, "Continuing after the failure of projects/telemetry/subscriptions/spans-sample"},
+		},
+		{
+			name:          "fail-fast failure counts the failed resource",
+			args:          []string{"-summary-only", "-resource-concurrency=1"},
+			deny:          "spans-sample",
+			exit:          1,
+			topics:        summaryCounts{Created: 2},
+			subscriptions: summaryCounts{Created: 1, Failed: 1},
+			err:           `Unable to create subscription "spans-sample" on topic "spans" for project "telemetry": rpc error: code = PermissionDenied desc = not allowed`,
+		},
+		{
+			name:          "rollback is not counted as created",
+			args:          []string{"-summary-only", "-resource-concurrency=1", "-rollback-on-error"},
+			deny:          "spans-sample",
+			exit:          1,
+			topics:        summaryCounts{RolledBack: 2},
+			subscriptions: summaryCounts{Failed: 1, RolledBack: 1},
+			err:           `Unable to create subscription "spans-sample" on topic "spans" for project "telemetry": rpc error: code = PermissionDenied desc = not allowed`,
+			logs:          []string{"Rolling back 3 created resource(s)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t)
+
+			newTestServer(t, pstest.ServerReactorOption{
+				FuncName: "CreateSubscription",
+				Reactor: reactorFunc(func(req interface{}) (bool, interface{}, error) {
+					if tt.deny != "" && strings.HasSuffix(req.(*pubsubpb.Subscription).Name, "/"+tt.deny) {
+						return true, nil, status.Error(codes.PermissionDenied, "not allowed")
+					}
+					return false, nil, nil
+				}),
+			})
+			t.Setenv("PUBSUB_PROJECT1", "telemetry,spans:spans-export:spans-sample,metrics:metrics-rollup")
+
+			var stdout, stderr strings.Builder
+			cmd := exec.Command(os.Args[0], tt.args...)
+			cmd.Args[0] = "pubsubc"
+			cmd.Env = append(os.Environ(), "PUBSUBC_TEST_MAIN=1")
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			err := cmd.Run()
+
+			var exit int
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exit = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if exit != tt.exit {
+				t.Fatalf("expected exit code %d, got %d:\n%s%s", tt.exit, exit, stdout.String(), stderr.String())
+			}
+
+			// The summary is the first line, and the only one if the run
+			// succeeds, even with -debug.
+			line, logs, _ := strings.Cut(stdout.String(), "\n")
+			if tt.exit == 0 && logs != "" {
+				t.Errorf("expected stdout to be a single line, got %q", stdout.String())
+			}
+
+			var s summary
+			if err := json.Unmarshal([]byte(line), &s); err != nil {
+				t.Fatalf("expected a JSON summary, got %q: %s", line, err)
+			}
+			if s.Projects != 1 || s.Topics != tt.topics || s.Subscriptions != tt.subscriptions {
+				t.Errorf("expected 1 project, topics %+v and subscriptions %+v, got %+v", tt.topics, tt.subscriptions, s)
+			}
+			if created := tt.topics.Created + tt.subscriptions.Created; s.Operations < created {
+				t.Errorf("expected at least %d operations, got %d", created, s.Operations)
+			}
+			if s.MaxOperationMS > s.OperationsMS {
+				t.Errorf("expected the max operation %dms to be within the total %dms", s.MaxOperationMS, s.OperationsMS)
+			}
+			if s.Error != tt.err {
+				t.Errorf("expected the error %q, got %q", tt.err, s.Error)
+			}
+
+			if tt.exit == 0 && stderr.Len() > 0 {
+				t.Errorf("expected no stderr, got %q", stderr.String())
+			}
+			for _, want := range tt.logs {
+				if !strings.Contains(logs, want) {
+					t.Errorf("expected the logs to contain %q, got:\n%s", want, logs)
+				}
+			}
+		})
+	}
+}
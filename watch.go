@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prep/pubsubc/provision"
+)
+
+// watchDebounce is how long the config file must be left alone before it is
+// applied, so that a burst of saves is applied once.
+const watchDebounce = 250 * time.Millisecond
+
+// watchConfig applies the changes to the applied projects with -ensure every
+// time that the -config file changes, until the context is cancelled.
+func watchConfig(ctx context.Context, clients provision.Clients, applied []provision.Config) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Unable to watch config file %q: %s", configFile, err)
+	}
+	defer watcher.Close()
+
+	// Editors often replace the file instead of writing to it, which only
+	// the directory of the file notices.
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		return fmt.Errorf("Unable to watch config file %q: %s", configFile, err)
+	}
+
+	infof("Watching %s for changes", configFile)
+
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	name := filepath.Clean(configFile)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != name || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+
+			debugf("Config file %s changed: %s", configFile, event.Op)
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			warnf("Watching config file %s: %s", configFile, err)
+
+		case <-timer.C:
+			if projects, ok := reconcile(ctx, clients, applied); ok {
+				applied = projects
+			}
+		}
+	}
+}
+
+// reconcile reloads the projects and applies the topics and subscriptions
+// that changed since the applied projects with -ensure. It returns the
+// reloaded projects once they are applied. Failures are logged instead of
+// returned, so that the next change can fix them.
+func reconcile(ctx context.Context, clients provision.Clients, applied []provision.Config) ([]provision.Config, bool) {
+	projects, err := loadProjects()
+	if err != nil {
+		warnf("Unable to reload the projects: %s", err)
+		return nil, false
+	}
+
+	if randomize {
+		projects = randomizeProjects(projects)
+	}
+
+	previous := make(map[string]provision.Config, len(applied))
+	for _, project := range applied {
+		previous[project.ProjectID] = project
+	}
+
+	var changed []provision.Config
+	for _, project := range projects {
+		if prev, ok := previous[project.ProjectID]; ok {
+			project = provision.Changed(prev, project)
+		}
+
+		if len(project.Topics) > 0 {
+			changed = append(changed, project)
+		}
+	}
+
+	if len(changed) == 0 {
+		infof("The projects are unchanged, nothing to reconcile")
+		return projects, true
+	}
+
+	start := time.Now()
+	created, err := createProjects(ctx, clients, changed)
+	if err != nil {
+		warnf("Unable to reconcile the projects: %s", err)
+		return nil, false
+	}
+
+	infof("Reconciled %d changed project(s) in %s, created %d resource(s)", len(changed), time.Since(start).Round(time.Millisecond), len(created))
+	return projects, true
+}
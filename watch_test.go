@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/prep/pubsubc/provision"
+)
+
+func TestWatchConfig(t *testing.T) {
+	const initial = "project1,builds:builds-ci\n"
+
+	// write replaces the content of the config file in place.
+	write := func(content string) func(t *testing.T, path string) {
+		return func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		edits []func(t *testing.T, path string)
+		// pause is the time between the edits.
+		pause    time.Duration
+		expected []string
+	}{
+		{
+			name:     "write",
+			edits:    []func(t *testing.T, path string){write("project1,builds:builds-ci:builds-audit\n")},
+			expected: []string{"builds", "builds-audit", "builds-ci"},
+		},
+		{
+			name: "replace",
+			edits: []func(t *testing.T, path string){func(t *testing.T, path string) {
+				tmp := path + ".swp"
+				if err := os.WriteFile(tmp, []byte("project1,builds:builds-ci,releases\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Rename(tmp, path); err != nil {
+					t.Fatal(err)
+				}
+			}},
+			expected: []string{"builds", "builds-ci", "releases"},
+		},
+		{
+			name: "burst of saves",
+			edits: []func(t *testing.T, path string){
+				write("project1,builds:builds-ci,r1\n"),
+				write("project1,builds:builds-ci,r2\n"),
+				write("project1,builds:builds-ci,r3\n"),
+			},
+			pause:    20 * time.Millisecond,
+			expected: []string{"builds", "builds-ci", "r3"},
+		},
+		{
+			name: "invalid config fixed later",
+			edits: []func(t *testing.T, path string){
+				write("project1,builds:builds-ci;ack=soon\n"),
+				write("project1,builds:builds-ci,previews\n"),
+			},
+			pause:    2 * watchDebounce,
+			expected: []string{"builds", "builds-ci", "previews"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newTestClients(t)
+
+			path := filepath.Join(t.TempDir(), "projects.conf")
+			write(initial)(t, path)
+			setFlags(t, "-config", path, "-watch")
+
+			// live returns the sorted names of the topics and subscriptions.
+			live := func() []string {
+				var names []string
+				if cfg, err := liveProject(context.Background(), clients, "project1"); err == nil {
+					for _, topic := range cfg.Topics {
+						names = append(names, topic.ID)
+						for _, s := range topic.Subscriptions {
+							names = append(names, s.ID)
+						}
+					}
+				}
+				slices.Sort(names)
+				return names
+			}
+
+			// waitFor waits until the live resources are the expected ones.
+			waitFor := func(expected []string) {
+				t.Helper()
+
+				deadline := time.Now().Add(5 * time.Second)
+				for !slices.Equal(live(), expected) {
+					if time.Now().After(deadline) {
+						t.Fatalf("expected %q, got %q", expected, live())
+					}
+					time.Sleep(20 * time.Millisecond)
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- runCreate(ctx, mustLoadProjects(t)) }()
+			t.Cleanup(func() {
+				cancel()
+				if err := <-done; err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			})
+
+			waitFor([]string{"builds", "builds-ci"})
+
+			// Give the watcher time to start watching once the initial
+			// config is applied.
+			time.Sleep(100 * time.Millisecond)
+			for _, edit := range tt.edits {
+				edit(t, path)
+				time.Sleep(tt.pause)
+			}
+
+			waitFor(tt.expected)
+		})
+	}
+}
+
+// mustLoadProjects loads the projects of the flags.
+func mustLoadProjects(t *testing.T) []provision.Config {
+	t.Helper()
+
+	projects, err := loadProjects()
+	if err != nil {
+		t.Fatalf("unable to load the projects: %s", err)
+	}
+
+	return projects
+}
+
+func TestReconcile(t *testing.T) {
+	const initial = "gallery,photos:photos-thumbs;ack=20s:photos-index,albums:albums-share\n"
+
+	tests := []struct {
+		name   string
+		config string
+		// touched are the resources that the reconcile sends requests for.
+		touched []string
+		// updated are the resources that must be updated by the reconcile.
+		updated []string
+	}{
+		{
+			name:    "single subscription edited",
+			config:  "gallery,photos:photos-thumbs;ack=45s:photos-index,albums:albums-share\n",
+			touched: []string{"projects/gallery/subscriptions/photos-thumbs", "projects/gallery/topics/photos"},
+			updated: []string{"projects/gallery/subscriptions/photos-thumbs"},
+		},
+		{
+			name:    "subscription added",
+			config:  "gallery,photos:photos-thumbs;ack=20s:photos-index,albums:albums-share:albums-feed\n",
+			touched: []string{"projects/gallery/subscriptions/albums-feed", "projects/gallery/topics/albums"},
+		},
+		{
+			name:   "unchanged",
+			config: initial,
+		},
+		{
+			name:    "new project",
+			config:  initial + "archive,exports\n",
+			touched: []string{"projects/archive/topics/exports"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				touched []string
+			)
+			record := reactorFunc(func(req interface{}) (bool, interface{}, error) {
+				var name string
+				switch r := req.(type) {
+				case *pubsubpb.Topic:
+					name = r.Name
+				case *pubsubpb.GetTopicRequest:
+					name = r.Topic
+				case *pubsubpb.UpdateTopicRequest:
+					name = r.Topic.Name
+				case *pubsubpb.Subscription:
+					name = r.Name
+				case *pubsubpb.GetSubscriptionRequest:
+					name = r.Subscription
+				case *pubsubpb.UpdateSubscriptionRequest:
+					name = r.Subscription.Name
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				if !slices.Contains(touched, name) {
+					touched = append(touched, name)
+				}
+				return false, nil, nil
+			})
+
+			var opts []pstest.ServerReactorOption
+			for _, funcName := range []string{"CreateTopic", "GetTopic", "UpdateTopic", "CreateSubscription", "GetSubscription", "UpdateSubscription"} {
+				opts = append(opts, pstest.ServerReactorOption{FuncName: funcName, Reactor: record})
+			}
+
+			clients := newTestClients(t, opts...)
+
+			path := filepath.Join(t.TempDir(), "gallery.conf")
+			if err := os.WriteFile(path, []byte(initial), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			setFlags(t, "-config", path, "-ensure")
+			t.Cleanup(func() { existing.updated, existing.skipped = nil, nil })
+
+			ctx := context.Background()
+			applied := mustLoadProjects(t)
+			if _, err := createProjects(ctx, clients, applied); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			touched = nil
+			mu.Unlock()
+			existing.updated = nil
+
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			projects, ok := reconcile(ctx, clients, applied)
+			if !ok {
+				t.Fatal("expected the reconcile to succeed")
+			}
+			if len(projects) != strings.Count(tt.config, "\n") {
+				t.Errorf("expected the reloaded projects to be returned, got %d", len(projects))
+			}
+
+			slices.Sort(touched)
+			if !slices.Equal(touched, tt.touched) {
+				t.Errorf("expected requests for %q, got %q", tt.touched, touched)
+			}
+
+			var updated []string
+			for _, r := range existing.updated {
+				updated = append(updated, r.Name)
+			}
+			if !slices.Equal(updated, tt.updated) {
+				t.Errorf("expected the updates %q, got %q", tt.updated, updated)
+			}
+		})
+	}
+}